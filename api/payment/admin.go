@@ -0,0 +1,101 @@
+package payment
+
+import (
+	"exam-payment-service/internal/payment"
+	"exam-payment-service/pkg/logger"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const defaultListLimit = 100
+
+// WithAdminKeys sets the API keys of the admin routes, which are closed without any
+func WithAdminKeys(keys []string) Option {
+	return func(s *server) {
+		for _, k := range keys {
+			s.adminKeys[k] = true
+		}
+	}
+}
+
+// admin only lets requests with an admin API key through
+func (s server) admin(c *fiber.Ctx) error {
+	if !s.adminKeys[c.Get(HeaderAPIKey)] {
+		return payment.ErrInvalidAPIKey
+	}
+
+	return c.Next()
+}
+
+func (s server) listWebhookEvents(c *fiber.Ctx) error {
+	limit := defaultListLimit
+	if l := c.Query("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 {
+			return payment.ErrInvalidRequest
+		}
+		limit = n
+	}
+
+	events, err := s.payment.ListEvents(c.UserContext(), c.Query("state", payment.EventStateDead), limit)
+	if err != nil {
+		logger.For(c.UserContext(), s.log).Errorw("ListEvents error", "error", payment.SafeError(err))
+		return err
+	}
+
+	return c.Status(200).JSON(events)
+}
+
+func (s server) getWebhookEvent(c *fiber.Ctx) error {
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return payment.ErrEventNotFound
+	}
+
+	event, err := s.payment.GetEvent(c.UserContext(), id)
+	if err != nil {
+		logger.For(c.UserContext(), s.log).Errorw("GetEvent error", "error", payment.SafeError(err), "id", id)
+		return err
+	}
+
+	return c.Status(200).JSON(event)
+}
+
+func (s server) requeueWebhookEvent(c *fiber.Ctx) error {
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return payment.ErrEventNotFound
+	}
+
+	event, err := s.payment.RequeueEvent(c.UserContext(), id)
+	if err != nil {
+		logger.For(c.UserContext(), s.log).Errorw("RequeueEvent error", "error", payment.SafeError(err), "id", id)
+		return err
+	}
+
+	logger.For(c.UserContext(), s.log).Infow("Webhook event requeued", "id", id, "event_id", event.EventID)
+
+	return c.Status(200).JSON(event)
+}
+
+func (s server) resolvePaymentReview(c *fiber.Ctx) error {
+	var b payment.ReviewRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&b); err != nil {
+			logger.For(c.UserContext(), s.log).Warnw("BodyParser error", "error", payment.SafeError(err))
+			return payment.ErrInvalidRequest.Wrap(err)
+		}
+	}
+
+	chargeID := c.Params("chargeID")
+	resolution, err := s.payment.ResolveReview(c.UserContext(), chargeID, b)
+	if err != nil {
+		logger.For(c.UserContext(), s.log).Errorw("ResolveReview error", "error", payment.SafeError(err), "charge_id", chargeID)
+		return err
+	}
+
+	logger.For(c.UserContext(), s.log).Infow("Payment review resolved", "charge_id", chargeID, "merchant_id", resolution.MerchantID, "status", resolution.Status)
+
+	return c.Status(200).JSON(resolution)
+}
@@ -0,0 +1,51 @@
+package payment
+
+import (
+	"exam-payment-service/internal/payment"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+)
+
+// HeaderAPIKey is the header of the API key of requests, payment requests are made for the
+// merchant and in the mode of their key
+const HeaderAPIKey = "X-API-Key"
+
+type Option func(*server)
+
+// WithAPIKeys sets the API keys of each mode of the default merchant, a request is made in its key's mode
+func WithAPIKeys(test []string, live []string) Option {
+	return func(s *server) {
+		for _, k := range test {
+			s.apiKeys[k] = false
+		}
+		for _, k := range live {
+			s.apiKeys[k] = true
+		}
+	}
+}
+
+// authenticate puts the merchant and livemode of the request's API key in its user context.
+// Every request needs a known API key, nothing else in the request can choose the merchant or the mode
+func (s server) authenticate(c *fiber.Ctx) error {
+	key := c.Get(HeaderAPIKey)
+	if key == "" {
+		return payment.ErrInvalidAPIKey
+	}
+
+	merchantID := payment.DefaultMerchantID
+	livemode, ok := s.apiKeys[key]
+	if !ok {
+		var err error
+		merchantID, livemode, err = s.payment.AuthenticateAPIKey(c.UserContext(), key)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Fiber reuses the request buffers once the handler returns
+	ctx := payment.ContextWithMerchant(c.UserContext(), utils.CopyString(merchantID))
+	c.SetUserContext(payment.ContextWithLivemode(ctx, livemode))
+
+	return c.Next()
+}
@@ -0,0 +1,61 @@
+package payment
+
+import (
+	"exam-payment-service/internal/payment"
+	"exam-payment-service/pkg/logger"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func (s server) createCustomer(c *fiber.Ctx) error {
+	var b payment.CustomerRequest
+
+	if err := c.BodyParser(&b); err != nil {
+		logger.For(c.UserContext(), s.log).Warnw("BodyParser error", "error", payment.SafeError(err))
+		return payment.ErrInvalidRequest.Wrap(err)
+	}
+
+	customer, err := s.payment.CreateCustomer(c.UserContext(), b)
+	if err != nil {
+		logger.For(c.UserContext(), s.log).Errorw("CreateCustomer error", "error", payment.SafeError(err), "user_id", b.UserID)
+		return err
+	}
+
+	return c.Status(200).JSON(customer)
+}
+
+func (s server) attachCard(c *fiber.Ctx) error {
+	userID := c.Params("userID", "")
+	if len(userID) == 0 {
+		return payment.ErrInvalidRequest
+	}
+
+	var b payment.CardRequest
+	if err := c.BodyParser(&b); err != nil {
+		logger.For(c.UserContext(), s.log).Warnw("BodyParser error", "error", payment.SafeError(err))
+		return payment.ErrInvalidRequest.Wrap(err)
+	}
+
+	customer, err := s.payment.AttachCard(c.UserContext(), userID, b.CardToken)
+	if err != nil {
+		logger.For(c.UserContext(), s.log).Errorw("AttachCard error", "error", payment.SafeError(err), "user_id", userID)
+		return err
+	}
+
+	return c.Status(200).JSON(customer)
+}
+
+func (s server) listCards(c *fiber.Ctx) error {
+	userID := c.Params("userID", "")
+	if len(userID) == 0 {
+		return payment.ErrInvalidRequest
+	}
+
+	customer, err := s.payment.ListCards(c.UserContext(), userID)
+	if err != nil {
+		logger.For(c.UserContext(), s.log).Errorw("ListCards error", "error", payment.SafeError(err), "user_id", userID)
+		return err
+	}
+
+	return c.Status(200).JSON(customer)
+}
@@ -0,0 +1,37 @@
+package payment
+
+import (
+	"errors"
+	"exam-payment-service/internal/payment"
+	"exam-payment-service/pkg/fiberhelper"
+	"net/http"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// errorHandler renders every error returned by handlers as problem+json
+func errorHandler(c *fiber.Ctx, err error) error {
+	var pErr *payment.Error
+	if errors.As(err, &pErr) {
+		p := fiberhelper.NewProblem(c, pErr.Status, pErr.Code, pErr.Message)
+		p.UpstreamCode = pErr.UpstreamCode
+
+		return fiberhelper.HandleProblemJSONResp(c, p)
+	}
+
+	// Errors from fiber itself, e.g. unknown routes
+	var fErr *fiber.Error
+	if errors.As(err, &fErr) {
+		code := strings.ReplaceAll(strings.ToLower(http.StatusText(fErr.Code)), " ", "_")
+
+		return fiberhelper.HandleProblemJSONResp(c, fiberhelper.NewProblem(c, fErr.Code, code, fErr.Message))
+	}
+
+	return fiberhelper.HandleProblemJSONResp(c, fiberhelper.NewProblem(
+		c,
+		payment.ErrInternal.Status,
+		payment.ErrInternal.Code,
+		payment.ErrInternal.Message,
+	))
+}
@@ -0,0 +1,25 @@
+package payment
+
+import (
+	"exam-payment-service/internal/payment"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+)
+
+// Headers of signed Omise webhooks
+const (
+	HeaderSignature          = "Omise-Signature"
+	HeaderSignatureTimestamp = "Omise-Signature-Timestamp"
+)
+
+// merchant puts the merchant of the webhook in its user context, from the merchantID route param,
+// else the default merchant. The webhook is then verified with that merchant's secret
+func (s server) merchant(c *fiber.Ctx) error {
+	merchantID := c.Params("merchantID", payment.DefaultMerchantID)
+
+	// Fiber reuses the request buffers once the handler returns
+	c.SetUserContext(payment.ContextWithMerchant(c.UserContext(), utils.CopyString(merchantID)))
+
+	return c.Next()
+}
@@ -0,0 +1,55 @@
+package payment
+
+import (
+	"exam-payment-service/internal/payment"
+	"exam-payment-service/pkg/logger"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func (s server) createPaymentLink(c *fiber.Ctx) error {
+	var b payment.PaymentLinkRequest
+
+	if err := c.BodyParser(&b); err != nil {
+		logger.For(c.UserContext(), s.log).Warnw("BodyParser error", "error", payment.SafeError(err))
+		return payment.ErrInvalidRequest.Wrap(err)
+	}
+
+	link, err := s.payment.CreatePaymentLink(c.UserContext(), b)
+	if err != nil {
+		logger.For(c.UserContext(), s.log).Errorw("CreatePaymentLink error", "error", payment.SafeError(err), "amount", b.Amount, "currency", b.Currency)
+		return err
+	}
+
+	return c.Status(200).JSON(link)
+}
+
+func (s server) getPaymentLink(c *fiber.Ctx) error {
+	linkID := c.Params("linkID", "")
+	if len(linkID) == 0 {
+		return payment.ErrInvalidRequest
+	}
+
+	link, err := s.payment.GetPaymentLink(c.UserContext(), linkID)
+	if err != nil {
+		logger.For(c.UserContext(), s.log).Errorw("GetPaymentLink error", "error", payment.SafeError(err), "link_id", linkID)
+		return err
+	}
+
+	return c.Status(200).JSON(link)
+}
+
+func (s server) getPaymentLinkUsage(c *fiber.Ctx) error {
+	linkID := c.Params("linkID", "")
+	if len(linkID) == 0 {
+		return payment.ErrInvalidRequest
+	}
+
+	usage, err := s.payment.GetPaymentLinkUsage(c.UserContext(), linkID)
+	if err != nil {
+		logger.For(c.UserContext(), s.log).Errorw("GetPaymentLinkUsage error", "error", payment.SafeError(err), "link_id", linkID)
+		return err
+	}
+
+	return c.Status(200).JSON(usage)
+}
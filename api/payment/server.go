@@ -1,126 +1,282 @@
 package payment
 
 import (
-	"database/sql"
+	"bytes"
+	"context"
 	"exam-payment-service/internal/payment"
+	"exam-payment-service/pkg/barcode"
 	"exam-payment-service/pkg/fiberhelper"
-	"log"
-	"net/http"
+	"exam-payment-service/pkg/logger"
+	"strconv"
+	"strings"
 
+	"github.com/gofiber/adaptor/v2"
 	"github.com/gofiber/fiber/v2"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
 )
 
-func Start(address string, payment *payment.Payment) {
+// Start listens until ctx is done, requests in progress are finished before it returns
+func Start(ctx context.Context, address string, payment *payment.Payment, log *zap.SugaredLogger, opts ...Option) {
+	f := New(payment, log, opts...)
 
+	go func() {
+		<-ctx.Done()
+		if err := f.Shutdown(); err != nil {
+			log.Errorw("Fiber shutdown error", "error", err)
+		}
+	}()
+
+	log.Infow("Fiber listen", "address", address)
+	if err := f.Listen(address); err != nil {
+		log.Errorw("Fiber listen error", "error", err)
+	}
+}
+
+// New builds the fiber app with every route without listening
+func New(payment *payment.Payment, log *zap.SugaredLogger, opts ...Option) *fiber.App {
 	s := server{
 		payment,
+		log,
+		map[string]bool{},
+		map[string]bool{},
 	}
+	for _, opt := range opts {
+		opt(&s)
+	}
+
+	f := fiber.New(fiber.Config{
+		DisableStartupMessage: true,
+		ErrorHandler:          errorHandler,
+	})
 
-	f := fiber.New()
+	f.Use(fiberhelper.Tracing())
+	f.Use(fiberhelper.RequestID())
+	f.Use(fiberhelper.AccessLog(log))
 
-	p := f.Group("/payments")
+	p := f.Group("/payments", s.authenticate)
 
 	p.Post("/", s.createPayment)
+	p.Get("/charges/:chargeID", s.getPayment)
 	p.Get("/charges/:chargeID/status", s.GetPaymentStatusWithChargeID)
+	p.Post("/charges/:chargeID/capture", s.capturePayment)
+	p.Post("/charges/:chargeID/reverse", s.reversePayment)
+	p.Get("/charges/:chargeID/barcode", s.billPaymentBarcode)
 
-	// TODO: middleware for verify event source (maybe using ip  address)
-	f.Post("/webhook/omise", s.omiseWebhook)
+	f.Get("/payment-methods", s.authenticate, s.paymentMethods)
 
-	if err := f.Listen(address); err != nil {
-		log.Println("Fiber listen error", err)
-	}
+	cu := f.Group("/customers", s.authenticate)
+
+	cu.Post("/", s.createCustomer)
+	cu.Get("/:userID/cards", s.listCards)
+	cu.Post("/:userID/cards", s.attachCard)
+
+	su := f.Group("/subscriptions", s.authenticate)
+
+	su.Post("/", s.createSubscription)
+	su.Get("/:id", s.getSubscription)
+	su.Post("/:id/pause", s.pauseSubscription)
+	su.Post("/:id/resume", s.resumeSubscription)
+	su.Post("/:id/cancel", s.cancelSubscription)
+
+	pl := f.Group("/payment-links", s.authenticate)
+
+	pl.Post("/", s.createPaymentLink)
+	pl.Get("/:linkID", s.getPaymentLink)
+	pl.Get("/:linkID/usage", s.getPaymentLinkUsage)
+
+	// Webhooks of the default merchant are also accepted without merchant ID
+	f.Post("/webhook/omise", s.merchant, s.omiseWebhook)
+	f.Post("/webhook/omise/:merchantID", s.merchant, s.omiseWebhook)
+
+	a := f.Group("/admin", s.admin)
+
+	a.Get("/webhook-events", s.listWebhookEvents)
+	a.Get("/webhook-events/:id", s.getWebhookEvent)
+	a.Post("/webhook-events/:id/requeue", s.requeueWebhookEvent)
+	a.Post("/payments/:chargeID/resolve-review", s.resolvePaymentReview)
+
+	f.Get("/metrics", adaptor.HTTPHandler(promhttp.Handler()))
+
+	// Unknown routes, rendered by the error handler like every other error
+	f.Use(func(c *fiber.Ctx) error {
+		return fiber.ErrNotFound
+	})
+
+	return f
 }
 
+// Size of rendered barcodes, in pixels
+const (
+	barcodeModuleWidth = 2
+	barcodeHeight      = 80
+)
+
 type server struct {
 	payment *payment.Payment
+	log     *zap.SugaredLogger
+	// livemode by API key
+	apiKeys   map[string]bool
+	adminKeys map[string]bool
 }
 
 func (s server) createPayment(c *fiber.Ctx) error {
 	var b payment.PaymentRequest
 
 	if err := c.BodyParser(&b); err != nil {
-		log.Println("BodyParser error", err)
-		return fiberhelper.HandleErrorJSONResp(
-			c,
-			http.StatusBadRequest,
-			"invalid request payload",
-		)
+		logger.For(c.UserContext(), s.log).Warnw("BodyParser error", "error", payment.SafeError(err))
+		return payment.ErrInvalidRequest.Wrap(err)
 	}
 
-	result, err := s.payment.CreatePaymentRequest(c.Context(), b)
+	result, err := s.payment.CreatePaymentRequest(c.UserContext(), b)
 	if err != nil {
-		log.Println("CreatePaymentRequest error", err)
+		logger.For(c.UserContext(), s.log).Errorw("CreatePaymentRequest error", "error", payment.SafeError(err), "amount", b.Amount, "currency", b.Currency, "source_type", b.SourceType)
+		return err
+	}
 
-		code := http.StatusInternalServerError
-		message := "internal server error"
+	return c.Status(200).JSON(result)
+}
 
-		if err == payment.ErrInvalidCurrency || err == payment.ErrInvalidSourceType {
-			code = http.StatusBadRequest
-			message = err.Error()
-		}
+func (s server) GetPaymentStatusWithChargeID(c *fiber.Ctx) error {
+	chargeID := c.Params("chargeID", "")
+	if len(chargeID) == 0 {
+		return payment.ErrInvalidRequest
+	}
 
-		return fiberhelper.HandleErrorJSONResp(
-			c,
-			code,
-			message,
-		)
+	resp, err := s.payment.GetPaymentStatusWithChargeID(c.UserContext(), chargeID)
+	if err != nil {
+		logger.For(c.UserContext(), s.log).Errorw("GetPaymentStatusWithChargeID error", "error", payment.SafeError(err), "charge_id", chargeID)
+		return err
 	}
 
-	return c.Status(200).JSON(result)
+	return c.Status(200).JSON(resp)
 }
 
-func (s server) GetPaymentStatusWithChargeID(c *fiber.Ctx) error {
+func (s server) getPayment(c *fiber.Ctx) error {
 	chargeID := c.Params("chargeID", "")
 	if len(chargeID) == 0 {
-		return fiberhelper.HandleErrorJSONResp(
-			c,
-			http.StatusBadRequest,
-			"require charge id",
-		)
+		return payment.ErrInvalidRequest
 	}
 
-	resp, err := s.payment.GetPaymentStatusWithChargeID(c.Context(), chargeID)
+	resp, err := s.payment.GetPayment(c.UserContext(), chargeID)
 	if err != nil {
+		logger.For(c.UserContext(), s.log).Errorw("GetPayment error", "error", payment.SafeError(err), "charge_id", chargeID)
+		return err
+	}
 
-		log.Println("GetPaymentStatusWithChargeID error", err)
+	return c.Status(200).JSON(resp)
+}
 
-		code := http.StatusInternalServerError
-		message := "internal server error"
+// paymentMethods lists the payment methods of the currency, amount is optional
+func (s server) paymentMethods(c *fiber.Ctx) error {
+	var amount int64
+	if a := c.Query("amount"); a != "" {
+		var err error
+		amount, err = strconv.ParseInt(a, 10, 64)
+		if err != nil {
+			return payment.ErrInvalidRequest.Wrap(err)
+		}
+	}
+	currency := payment.Currency(strings.ToLower(c.Query("currency")))
 
-		if err == sql.ErrNoRows {
-			code = http.StatusBadRequest
-			message = "not found"
+	methods, err := s.payment.PaymentMethods(c.UserContext(), amount, currency)
+	if err != nil {
+		logger.For(c.UserContext(), s.log).Errorw("PaymentMethods error", "error", payment.SafeError(err), "amount", amount, "currency", currency)
+		return err
+	}
+
+	return c.Status(200).JSON(fiber.Map{"paymentMethods": methods})
+}
+
+func (s server) capturePayment(c *fiber.Ctx) error {
+	chargeID := c.Params("chargeID", "")
+	if len(chargeID) == 0 {
+		return payment.ErrInvalidRequest
+	}
+
+	// The body is optional, the full amount is captured without it
+	var b payment.CaptureRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&b); err != nil {
+			logger.For(c.UserContext(), s.log).Warnw("BodyParser error", "error", payment.SafeError(err))
+			return payment.ErrInvalidRequest.Wrap(err)
 		}
+	}
 
-		return fiberhelper.HandleErrorJSONResp(
-			c,
-			code,
-			message,
-		)
+	resp, err := s.payment.CapturePayment(c.UserContext(), chargeID, b.Amount)
+	if err != nil {
+		logger.For(c.UserContext(), s.log).Errorw("CapturePayment error", "error", payment.SafeError(err), "charge_id", chargeID, "amount", b.Amount)
+		return err
 	}
 
 	return c.Status(200).JSON(resp)
 }
 
-func (s server) omiseWebhook(c *fiber.Ctx) error {
-	var b payment.PaymentEvent
+func (s server) reversePayment(c *fiber.Ctx) error {
+	chargeID := c.Params("chargeID", "")
+	if len(chargeID) == 0 {
+		return payment.ErrInvalidRequest
+	}
 
-	if err := c.BodyParser(&b); err != nil {
-		log.Println("BodyParser error", err)
-		return fiberhelper.HandleErrorJSONResp(
-			c,
-			http.StatusBadRequest,
-			"require transaction id",
-		)
+	resp, err := s.payment.ReversePayment(c.UserContext(), chargeID)
+	if err != nil {
+		logger.For(c.UserContext(), s.log).Errorw("ReversePayment error", "error", payment.SafeError(err), "charge_id", chargeID)
+		return err
+	}
+
+	return c.Status(200).JSON(resp)
+}
 
+// billPaymentBarcode renders the barcode of a bill payment as PNG, or SVG with format=svg
+func (s server) billPaymentBarcode(c *fiber.Ctx) error {
+	chargeID := c.Params("chargeID", "")
+	if len(chargeID) == 0 {
+		return payment.ErrInvalidRequest
+	}
+
+	format := c.Query("format", "png")
+	if format != "png" && format != "svg" {
+		return payment.ErrInvalidRequest
+	}
+
+	data, err := s.payment.BillPaymentBarcode(c.UserContext(), chargeID)
+	if err != nil {
+		logger.For(c.UserContext(), s.log).Errorw("BillPaymentBarcode error", "error", payment.SafeError(err), "charge_id", chargeID)
+		return err
+	}
+
+	modules, err := barcode.Code128(data)
+	if err != nil {
+		logger.For(c.UserContext(), s.log).Errorw("Code128 error", "error", payment.SafeError(err), "charge_id", chargeID)
+		return payment.ErrInternal.Wrap(err)
+	}
+
+	var b bytes.Buffer
+	if format == "svg" {
+		c.Type("svg")
+		err = barcode.WriteSVG(&b, modules, barcodeModuleWidth, barcodeHeight)
+	} else {
+		c.Type("png")
+		err = barcode.WritePNG(&b, modules, barcodeModuleWidth, barcodeHeight)
+	}
+	if err != nil {
+		return payment.ErrInternal.Wrap(err)
+	}
+
+	return c.Status(200).Send(b.Bytes())
+}
+
+func (s server) omiseWebhook(c *fiber.Ctx) error {
+	err := s.payment.VerifyEvent(c.UserContext(), c.Body(), c.Get(HeaderSignature), c.Get(HeaderSignatureTimestamp))
+	if err != nil {
+		logger.For(c.UserContext(), s.log).Warnw("VerifyEvent error", "error", payment.SafeError(err))
+		return err
 	}
 
-	if err := s.payment.HookPaymentEvent(c.Context(), b); err != nil {
-		return fiberhelper.HandleErrorJSONResp(
-			c,
-			http.StatusInternalServerError,
-			"internal server error",
-		)
+	// Applied by the event workers, Omise only needs to know the event is stored
+	if err := s.payment.EnqueueEvent(c.UserContext(), c.Body()); err != nil {
+		logger.For(c.UserContext(), s.log).Errorw("EnqueueEvent error", "error", payment.SafeError(err))
+		return err
 	}
 
 	return c.Status(200).JSON(nil)
@@ -0,0 +1,1130 @@
+package payment
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"exam-payment-service/internal/payment"
+	mockOmiseProvider "exam-payment-service/internal/payment/mocks/omiseprovider"
+	"exam-payment-service/internal/webhooksim"
+	"exam-payment-service/pkg/encryption"
+	"exam-payment-service/pkg/fiberhelper"
+	"exam-payment-service/pkg/omiseprovider"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang/mock/gomock"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/omise/omise-go"
+	"github.com/omise/omise-go/operations"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+// newTestDB opens a migrated in-memory SQLite database
+func newTestDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every connection to :memory: opens a new database
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	if err := payment.Migrate(context.Background(), db); err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+type testMerchant struct {
+	merchant  payment.Merchant
+	providers payment.Providers
+}
+
+// newTestPayment builds the payment service with the merchants and their mocked providers
+func newTestPayment(t *testing.T, merchants ...testMerchant) *payment.Payment {
+	db := newTestDB(t)
+
+	var f encryption.KeyFile
+	if _, err := f.Generate(); err != nil {
+		t.Fatal(err)
+	}
+	keyring, err := encryption.NewKeyring(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := payment.NewMerchantStore(db, keyring)
+
+	providers := map[string]payment.Providers{}
+	for _, m := range merchants {
+		if err := store.Put(context.Background(), m.merchant); err != nil {
+			t.Fatal(err)
+		}
+		providers[m.merchant.ID] = m.providers
+	}
+
+	newProviders := func(m payment.Merchant) (payment.Providers, error) {
+		return providers[m.ID], nil
+	}
+
+	p := payment.New(store, newProviders, keyring, db, zap.NewNop().Sugar())
+
+	// Webhook events are applied by the workers
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		p.RunEventWorkers(ctx, payment.QueueConfig{
+			Workers:      2,
+			MaxAttempts:  3,
+			MinBackoff:   10 * time.Millisecond,
+			MaxBackoff:   100 * time.Millisecond,
+			PollInterval: 10 * time.Millisecond,
+		})
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	return p
+}
+
+// testAPIKey is sent by requests without API key header, set it empty to send none
+const testAPIKey = "key_test"
+
+// newTestApp builds the app for the default merchant with the mocked provider in test mode only
+func newTestApp(t *testing.T) (*fiber.App, *mockOmiseProvider.MockOmiseProvider) {
+	ctrl := gomock.NewController(t)
+	op := mockOmiseProvider.NewMockOmiseProvider(ctrl)
+
+	p := newTestPayment(t, testMerchant{
+		payment.Merchant{ID: payment.DefaultMerchantID, Name: "Default", WebhookSecret: testWebhookSecret},
+		payment.Providers{Test: op},
+	})
+
+	return New(p, zap.NewNop().Sugar(), WithAPIKeys([]string{testAPIKey}, nil)), op
+}
+
+func do(t *testing.T, app *fiber.App, method string, target string, body interface{}) (*http.Response, []byte) {
+	return doWithHeader(t, app, method, target, nil, body)
+}
+
+func doWithHeader(t *testing.T, app *fiber.App, method string, target string, header http.Header, body interface{}) (*http.Response, []byte) {
+	var r io.Reader
+	switch b := body.(type) {
+	case nil:
+	case []byte:
+		r = bytes.NewReader(b)
+	case string:
+		r = bytes.NewReader([]byte(b))
+	default:
+		j, err := json.Marshal(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = bytes.NewReader(j)
+	}
+
+	req := httptest.NewRequest(method, target, r)
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	req.Header.Set(HeaderAPIKey, testAPIKey)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return resp, b
+}
+
+func event(t *testing.T, p webhooksim.Params) []byte {
+	b, err := webhooksim.Build(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+// testWebhookSecret is the webhook secret of the test merchants, c2VjcmV0 is "secret"
+const testWebhookSecret = "c2VjcmV0"
+
+// signed returns the headers of the payload signed with testWebhookSecret
+func signed(payload []byte) http.Header {
+	now := time.Now()
+	return http.Header{
+		HeaderSignature:          []string{webhooksim.Sign([]byte("secret"), now, payload)},
+		HeaderSignatureTimestamp: []string{strconv.FormatInt(now.Unix(), 10)},
+	}
+}
+
+// webhook posts the payload signed with testWebhookSecret, as Omise does
+func webhook(t *testing.T, app *fiber.App, target string, payload []byte) (*http.Response, []byte) {
+	return doWithHeader(t, app, http.MethodPost, target, signed(payload), payload)
+}
+
+// assertStatus waits for the queued webhook events to move the payment to the status,
+// failure reasons are not compared
+func assertStatus(t *testing.T, app *fiber.App, header http.Header, chargeID string, status string) {
+	var body []byte
+	ok := assert.Eventually(t, func() bool {
+		var resp *http.Response
+		resp, body = doWithHeader(t, app, http.MethodGet, "/payments/charges/"+chargeID+"/status", header, nil)
+		var s payment.PaymentStatus
+		return resp.StatusCode == http.StatusOK && json.Unmarshal(body, &s) == nil && s.Status == status
+	}, 5*time.Second, 10*time.Millisecond)
+	if !ok {
+		t.Logf("last status %s", body)
+	}
+}
+
+func problemCode(t *testing.T, resp *http.Response, body []byte) string {
+	assert.Equal(t, fiberhelper.MIMEApplicationProblemJSON, resp.Header.Get(fiber.HeaderContentType))
+
+	var p fiberhelper.Problem
+	if err := json.Unmarshal(body, &p); err != nil {
+		t.Fatal(err)
+	}
+
+	return p.Code
+}
+
+func TestPaymentFlow(t *testing.T) {
+	testCases := []struct {
+		name           string
+		finalKey       string
+		finalStatus    string
+		finalAmount    int64
+		expectedStatus string
+	}{
+		{
+			name:           "Successful",
+			finalKey:       "charge.complete",
+			finalStatus:    webhooksim.StatusSuccessful,
+			finalAmount:    20000,
+			expectedStatus: "successful",
+		},
+		{
+			name:           "Failed",
+			finalKey:       "charge.complete",
+			finalStatus:    webhooksim.StatusFailed,
+			finalAmount:    20000,
+			expectedStatus: "failed",
+		},
+		{
+			name:           "Amount differs from the request",
+			finalKey:       "charge.complete",
+			finalStatus:    webhooksim.StatusSuccessful,
+			finalAmount:    2000,
+			expectedStatus: payment.StatusNeedsReview,
+		},
+	}
+
+	t.Parallel()
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			app, op := newTestApp(t)
+
+			op.EXPECT().CreateSource(gomock.Any(), gomock.Any()).Return(omise.Source{ID: "src_test_xxx"}, nil)
+			op.EXPECT().CreateCharge(gomock.Any(), gomock.Any()).Return(omise.Charge{
+				Base:         omise.Base{ID: "chrg_test_xxx"},
+				Status:       omise.ChargePending,
+				AuthorizeURI: "https://pay.omise.co/offsites/xxx/pay",
+			}, nil)
+
+			// Create
+			resp, body := do(t, app, http.MethodPost, "/payments/", payment.PaymentRequest{
+				Amount:     20000,
+				Currency:   payment.CurrencyTHB,
+				ReturnURI:  "https://example.com",
+				SourceType: payment.SourceTypeInternetBankSCB,
+			})
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+			var result payment.PaymentRequestResult
+			assert.NoError(t, json.Unmarshal(body, &result))
+			assert.Equal(t, payment.PaymentRequestResult{
+				ChargeID:     "chrg_test_xxx",
+				SourceID:     "src_test_xxx",
+				AuthorizeURI: "https://pay.omise.co/offsites/xxx/pay",
+				Status:       "pending",
+			}, result)
+
+			// Recorded with the request
+			resp, body = do(t, app, http.MethodGet, "/payments/charges/chrg_test_xxx/status", nil)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.JSONEq(t, `{"status":"pending"}`, string(body))
+
+			// Webhook create
+			resp, _ = webhook(t, app, "/webhook/omise", event(t, webhooksim.Params{
+				Key:      "charge.create",
+				ChargeID: result.ChargeID,
+				Amount:   20000,
+			}))
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+			resp, body = do(t, app, http.MethodGet, "/payments/charges/chrg_test_xxx/status", nil)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.JSONEq(t, `{"status":"pending"}`, string(body))
+
+			// Webhook complete
+			resp, _ = webhook(t, app, "/webhook/omise", event(t, webhooksim.Params{
+				Key:      tc.finalKey,
+				ChargeID: result.ChargeID,
+				Status:   tc.finalStatus,
+				Amount:   tc.finalAmount,
+			}))
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+			assertStatus(t, app, nil, "chrg_test_xxx", tc.expectedStatus)
+
+			// Payments in review keep what Omise reported
+			if tc.expectedStatus == payment.StatusNeedsReview {
+				_, body = do(t, app, http.MethodGet, "/payments/charges/chrg_test_xxx", nil)
+				assert.Contains(t, string(body), `"reportedStatus":"successful"`)
+			}
+
+			// Failed payments have the reason of the webhook
+			if tc.expectedStatus == "failed" {
+				_, body = do(t, app, http.MethodGet, "/payments/charges/chrg_test_xxx/status", nil)
+				assert.Contains(t, string(body), `"failureCode":"payment_rejected"`)
+			}
+		})
+	}
+}
+
+func TestCardPayment(t *testing.T) {
+	app, op := newTestApp(t)
+
+	op.EXPECT().CreateCharge(gomock.Any(), gomock.Any()).Return(omise.Charge{
+		Base:         omise.Base{ID: "chrg_test_xxx"},
+		Amount:       20000,
+		Currency:     "thb",
+		Status:       omise.ChargePending,
+		AuthorizeURI: "https://api.omise.co/payments/paym_test_xxx/authorize",
+		Card:         &omise.Card{Brand: "Visa", LastDigits: "4242"},
+	}, nil)
+
+	// Create, 3-D Secure is done at the authorize URI
+	resp, body := do(t, app, http.MethodPost, "/payments/", payment.PaymentRequest{
+		Amount:    20000,
+		Currency:  payment.CurrencyTHB,
+		ReturnURI: "https://example.com",
+		CardToken: "tokn_test_xxx",
+	})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.JSONEq(t, `{"chargeId":"chrg_test_xxx","sourceId":"","authorizeUri":"https://api.omise.co/payments/paym_test_xxx/authorize","status":"pending"}`, string(body))
+
+	// Webhook complete
+	resp, _ = webhook(t, app, "/webhook/omise", event(t, webhooksim.Params{
+		Key:      "charge.complete",
+		ChargeID: "chrg_test_xxx",
+		Status:   webhooksim.StatusSuccessful,
+		Amount:   20000,
+	}))
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	assertStatus(t, app, nil, "chrg_test_xxx", "successful")
+
+	// Only the brand and last digits of the card are kept
+	resp, body = do(t, app, http.MethodGet, "/payments/charges/chrg_test_xxx", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.JSONEq(t, `{"chargeId":"chrg_test_xxx","sourceId":"","status":"successful","amount":20000,"currency":"thb","livemode":false,"card":{"brand":"Visa","lastDigits":"4242"}}`, string(body))
+}
+
+func TestAuthorizeOnly(t *testing.T) {
+	authorized := omise.Charge{
+		Base:       omise.Base{ID: "chrg_test_xxx"},
+		Amount:     20000,
+		Currency:   "thb",
+		Status:     omise.ChargePending,
+		Authorized: true,
+		Card:       &omise.Card{Brand: "Visa", LastDigits: "4242"},
+	}
+	capture := false
+	request := payment.PaymentRequest{Amount: 20000, Currency: payment.CurrencyTHB, CardToken: "tokn_test_xxx", Capture: &capture}
+
+	testCases := []struct {
+		name           string
+		target         string
+		body           interface{}
+		mock           func(op *mockOmiseProvider.MockOmiseProvider)
+		expectedStatus string
+		expectedBody   string
+	}{
+		{
+			name:   "Partial capture",
+			target: "/payments/charges/chrg_test_xxx/capture",
+			body:   payment.CaptureRequest{Amount: 15000},
+			mock: func(op *mockOmiseProvider.MockOmiseProvider) {
+				op.EXPECT().CaptureCharge(gomock.Any(), gomock.Any()).DoAndReturn(
+					func(ctx context.Context, captureCharge omiseprovider.CaptureCharge) (omise.Charge, error) {
+						assert.Equal(t, "chrg_test_xxx", captureCharge.ChargeID)
+						assert.Equal(t, int64(15000), captureCharge.CaptureAmount)
+						return omise.Charge{Status: omise.ChargeSuccessful, Paid: true}, nil
+					})
+			},
+			expectedStatus: "successful",
+			expectedBody:   `"capturedAmount":15000`,
+		},
+		{
+			name:   "Full capture without body",
+			target: "/payments/charges/chrg_test_xxx/capture",
+			mock: func(op *mockOmiseProvider.MockOmiseProvider) {
+				op.EXPECT().CaptureCharge(gomock.Any(), gomock.Any()).Return(omise.Charge{Status: omise.ChargeSuccessful, Paid: true}, nil)
+			},
+			expectedStatus: "successful",
+			expectedBody:   `"capturedAmount":20000`,
+		},
+		{
+			name:   "Reverse",
+			target: "/payments/charges/chrg_test_xxx/reverse",
+			mock: func(op *mockOmiseProvider.MockOmiseProvider) {
+				op.EXPECT().ReverseCharge(gomock.Any(), gomock.Any()).Return(omise.Charge{Status: omise.ChargeReversed, Reversed: true}, nil)
+			},
+			expectedStatus: "reversed",
+			expectedBody:   `"status":"reversed"`,
+		},
+	}
+
+	t.Parallel()
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			app, op := newTestApp(t)
+
+			op.EXPECT().CreateCharge(gomock.Any(), gomock.Any()).Return(authorized, nil)
+			tc.mock(op)
+
+			resp, body := do(t, app, http.MethodPost, "/payments/", request)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Contains(t, string(body), `"status":"authorized"`)
+
+			// Held until it expires
+			resp, body = do(t, app, http.MethodGet, "/payments/charges/chrg_test_xxx", nil)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Contains(t, string(body), `"authorizationExpiresAt"`)
+
+			resp, body = do(t, app, http.MethodPost, tc.target, tc.body)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Contains(t, string(body), tc.expectedBody)
+
+			assertStatus(t, app, nil, "chrg_test_xxx", tc.expectedStatus)
+
+			// Only once
+			resp, body = do(t, app, http.MethodPost, tc.target, tc.body)
+			assert.Equal(t, http.StatusConflict, resp.StatusCode)
+			assert.Equal(t, "payment_not_authorized", problemCode(t, resp, body))
+		})
+	}
+}
+
+func TestCustomers(t *testing.T) {
+	app, op := newTestApp(t)
+
+	visa := &omise.Card{Base: omise.Base{ID: "card_test_xxx"}, Brand: "Visa", LastDigits: "4242", ExpirationMonth: 12, ExpirationYear: 2030}
+	customer := omise.Customer{
+		Base:        omise.Base{ID: "cust_test_xxx"},
+		DefaultCard: "card_test_xxx",
+		Cards:       &omise.CardList{Data: []*omise.Card{visa}},
+	}
+	op.EXPECT().CreateCustomer(gomock.Any(), gomock.Any()).Return(customer, nil)
+	op.EXPECT().RetrieveCustomer(gomock.Any(), gomock.Any()).Return(customer, nil)
+	op.EXPECT().CreateCharge(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, createCharge omiseprovider.CreateCharge) (omise.Charge, error) {
+			assert.Equal(t, "cust_test_xxx", createCharge.Customer)
+			assert.Empty(t, createCharge.Card)
+			return omise.Charge{Base: omise.Base{ID: "chrg_test_xxx"}, Status: omise.ChargeSuccessful, Card: visa}, nil
+		})
+
+	cards := `[{"id":"card_test_xxx","brand":"Visa","lastDigits":"4242","expirationMonth":12,"expirationYear":2030,"default":true}]`
+
+	resp, body := do(t, app, http.MethodPost, "/customers/", payment.CustomerRequest{UserID: "user_1", CardToken: "tokn_test_xxx"})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.JSONEq(t, `{"userId":"user_1","customerId":"cust_test_xxx","cards":`+cards+`}`, string(body))
+
+	resp, body = do(t, app, http.MethodGet, "/customers/user_1/cards", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.JSONEq(t, `{"userId":"user_1","customerId":"cust_test_xxx","cards":`+cards+`}`, string(body))
+
+	// The default card is charged
+	resp, body = do(t, app, http.MethodPost, "/payments/", payment.PaymentRequest{Amount: 20000, Currency: payment.CurrencyTHB, CustomerID: "cust_test_xxx"})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(body), `"status":"successful"`)
+
+	resp, body = do(t, app, http.MethodGet, "/payments/charges/chrg_test_xxx", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(body), `"customerId":"cust_test_xxx"`)
+
+	// Once per user and mode
+	resp, body = do(t, app, http.MethodPost, "/customers/", payment.CustomerRequest{UserID: "user_1"})
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	assert.Equal(t, "customer_exists", problemCode(t, resp, body))
+}
+
+func TestSubscriptions(t *testing.T) {
+	app, op := newTestApp(t)
+
+	op.EXPECT().CreateCustomer(gomock.Any(), gomock.Any()).Return(omise.Customer{Base: omise.Base{ID: "cust_test_xxx"}}, nil)
+	op.EXPECT().CreateChargeSchedule(gomock.Any(), gomock.Any()).Return(omise.Schedule{Base: omise.Base{ID: "schd_test_xxx"}}, nil)
+	op.EXPECT().DestroySchedule(gomock.Any(), operations.DestroySchedule{ScheduleID: "schd_test_xxx"}).Return(omise.Schedule{}, nil)
+
+	resp, _ := do(t, app, http.MethodPost, "/customers/", payment.CustomerRequest{UserID: "user_1", CardToken: "tokn_test_xxx"})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, body := do(t, app, http.MethodPost, "/subscriptions/", payment.SubscriptionRequest{
+		CustomerID: "cust_test_xxx",
+		Amount:     49900,
+		Currency:   payment.CurrencyTHB,
+		Period:     payment.SubscriptionMonthly,
+		StartDate:  "2030-01-01",
+		EndDate:    "2031-01-01",
+	})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var s payment.Subscription
+	assert.NoError(t, json.Unmarshal(body, &s))
+	assert.Equal(t, "schd_test_xxx", s.ScheduleID)
+	assert.Equal(t, payment.SubscriptionActive, s.Status)
+
+	target := "/subscriptions/" + strconv.FormatInt(s.ID, 10)
+
+	resp, body = do(t, app, http.MethodPost, target+"/pause", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(body), `"status":"paused"`)
+
+	resp, body = do(t, app, http.MethodGet, target, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(body), `"scheduleId":""`)
+
+	// Paused subscriptions have no schedule to destroy
+	resp, body = do(t, app, http.MethodPost, target+"/cancel", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(body), `"status":"canceled"`)
+
+	resp, body = do(t, app, http.MethodPost, target+"/pause", nil)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	assert.Equal(t, "invalid_subscription_status", problemCode(t, resp, body))
+}
+
+func TestPaymentLinks(t *testing.T) {
+	app, op := newTestApp(t)
+
+	op.EXPECT().CreateLink(gomock.Any(), operations.CreateLink{
+		Amount:      49900,
+		Currency:    "thb",
+		Title:       "Premium",
+		Description: "One year of Premium",
+		Multiple:    true,
+	}).Return(omise.Link{Base: omise.Base{ID: "link_test_xxx"}, PaymentURI: "https://link.omise.co/xxx"}, nil)
+
+	resp, body := do(t, app, http.MethodPost, "/payment-links/", payment.PaymentLinkRequest{
+		Amount:      49900,
+		Currency:    payment.CurrencyTHB,
+		Title:       "Premium",
+		Description: "One year of Premium",
+		Multiple:    true,
+	})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(body), `"paymentUri":"https://link.omise.co/xxx"`)
+
+	resp, body = do(t, app, http.MethodGet, "/payment-links/link_test_xxx/usage", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(body), `"charges":0,"byStatus":{},"collected":0`)
+}
+
+func TestBillPaymentBarcode(t *testing.T) {
+	app, op := newTestApp(t)
+
+	op.EXPECT().CreateSource(gomock.Any(), gomock.Any()).Return(omise.Source{ID: "src_test_xxx"}, nil)
+	op.EXPECT().CreateBillPaymentCharge(gomock.Any(), gomock.Any()).Return(omiseprovider.BillPaymentCharge{
+		Charge: omise.Charge{Base: omise.Base{ID: "chrg_test_xxx"}, Status: omise.ChargePending},
+		Source: &omiseprovider.BillPaymentSource{
+			Source: omise.Source{ID: "src_test_xxx"},
+			References: &omiseprovider.BillPaymentReferences{
+				ExpiresAt:        time.Now().Add(24 * time.Hour),
+				ReferenceNumber1: "062111123456789",
+				ReferenceNumber2: "59201700000000",
+				Barcode:          "|010554614953100\r062111123456789\r59201700000000\r2000000",
+			},
+		},
+	}, nil)
+
+	resp, body := do(t, app, http.MethodPost, "/payments/", payment.PaymentRequest{Amount: 2000000, Currency: payment.CurrencyTHB, SourceType: payment.SourceTypeBillPaymentTescoLotus})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(body), `"referenceNumber1":"062111123456789"`)
+
+	resp, body = do(t, app, http.MethodGet, "/payments/charges/chrg_test_xxx/barcode", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "image/png", resp.Header.Get(fiber.HeaderContentType))
+	assert.True(t, bytes.HasPrefix(body, []byte("\x89PNG")))
+
+	resp, body = do(t, app, http.MethodGet, "/payments/charges/chrg_test_xxx/barcode?format=svg", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "image/svg+xml", resp.Header.Get(fiber.HeaderContentType))
+	assert.True(t, bytes.HasPrefix(body, []byte("<svg")))
+
+	resp, body = do(t, app, http.MethodGet, "/payments/charges/chrg_test_xxx/barcode?format=gif", nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, "invalid_request", problemCode(t, resp, body))
+}
+
+func TestPaymentMethods(t *testing.T) {
+	app, op := newTestApp(t)
+
+	op.EXPECT().RetrieveCapability(gomock.Any(), gomock.Any()).Return(omise.Capability{
+		PaymentMethods: []omise.PaymentMethod{
+			{Name: "card", Currencies: []string{"THB"}},
+			{Name: "installment_kbank", Currencies: []string{"THB"}, InstallmentTerms: []int{3, 4, 6, 10}},
+		},
+	}, nil)
+
+	resp, body := do(t, app, http.MethodGet, "/payment-methods?amount=300000&currency=THB", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.JSONEq(t, `{"paymentMethods":[
+		{"sourceType":"card","minAmount":2000,"maxAmount":15000000},
+		{"sourceType":"installment_kbank","minAmount":200000,"maxAmount":15000000,"installmentTerms":[3,4,6,10]}
+	]}`, string(body))
+
+	resp, body = do(t, app, http.MethodGet, "/payment-methods?amount=100000&currency=thb", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.JSONEq(t, `{"paymentMethods":[{"sourceType":"card","minAmount":2000,"maxAmount":15000000}]}`, string(body))
+}
+
+func TestErrors(t *testing.T) {
+	testCases := []struct {
+		name           string
+		method         string
+		target         string
+		header         http.Header
+		body           interface{}
+		mock           func(op *mockOmiseProvider.MockOmiseProvider)
+		expectedStatus int
+		expectedCode   string
+	}{
+		{
+			name:           "Malformed payment request",
+			method:         http.MethodPost,
+			target:         "/payments/",
+			body:           `{"amount":`,
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "invalid_request",
+		},
+		{
+			name:           "Amount lower than charge limit",
+			method:         http.MethodPost,
+			target:         "/payments/",
+			body:           payment.PaymentRequest{Amount: 1000, Currency: payment.CurrencyTHB, SourceType: payment.SourceTypeInternetBankSCB},
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "amount_lower_than_charge_limit",
+		},
+		{
+			name:           "Invalid source type",
+			method:         http.MethodPost,
+			target:         "/payments/",
+			body:           payment.PaymentRequest{Amount: 20000, Currency: payment.CurrencyTHB, SourceType: "cash"},
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "invalid_source_type",
+		},
+		{
+			name:           "Invalid installment term",
+			method:         http.MethodPost,
+			target:         "/payments/",
+			body:           payment.PaymentRequest{Amount: 300000, Currency: payment.CurrencyTHB, SourceType: payment.SourceTypeInstallmentKBank, InstallmentTerm: 5},
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "invalid_installment_term",
+		},
+		{
+			name:           "Negative expiry",
+			method:         http.MethodPost,
+			target:         "/payments/",
+			body:           payment.PaymentRequest{Amount: 20000, Currency: payment.CurrencyTHB, SourceType: payment.SourceTypeInternetBankSCB, ExpiresInSeconds: -1},
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "invalid_request",
+		},
+		{
+			name:           "Invalid payment methods amount",
+			method:         http.MethodGet,
+			target:         "/payment-methods?amount=abc&currency=thb",
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "invalid_request",
+		},
+		{
+			name:           "Invalid payment methods currency",
+			method:         http.MethodGet,
+			target:         "/payment-methods?currency=usd",
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "invalid_currency",
+		},
+		{
+			name:           "Invalid TrueMoney phone number",
+			method:         http.MethodPost,
+			target:         "/payments/",
+			body:           payment.PaymentRequest{Amount: 20000, Currency: payment.CurrencyTHB, SourceType: payment.SourceTypeTrueMoney, PhoneNumber: "021234567"},
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "invalid_phone_number",
+		},
+		{
+			name:   "Rejected by Omise",
+			method: http.MethodPost,
+			target: "/payments/",
+			body:   payment.PaymentRequest{Amount: 20000, Currency: payment.CurrencyTHB, SourceType: payment.SourceTypeInternetBankSCB},
+			mock: func(op *mockOmiseProvider.MockOmiseProvider) {
+				op.EXPECT().CreateSource(gomock.Any(), gomock.Any()).Return(omise.Source{}, &omise.Error{
+					StatusCode: http.StatusBadRequest,
+					Code:       "invalid_amount",
+				})
+			},
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedCode:   "provider_rejected",
+		},
+		{
+			name:   "Omise failure",
+			method: http.MethodPost,
+			target: "/payments/",
+			body:   payment.PaymentRequest{Amount: 20000, Currency: payment.CurrencyTHB, SourceType: payment.SourceTypeInternetBankSCB},
+			mock: func(op *mockOmiseProvider.MockOmiseProvider) {
+				op.EXPECT().CreateSource(gomock.Any(), gomock.Any()).Return(omise.Source{}, errors.New("boom"))
+			},
+			expectedStatus: http.StatusBadGateway,
+			expectedCode:   "provider_failure",
+		},
+		{
+			name:           "Unknown charge",
+			method:         http.MethodGet,
+			target:         "/payments/charges/chrg_test_unknown/status",
+			expectedStatus: http.StatusNotFound,
+			expectedCode:   "payment_not_found",
+		},
+		{
+			name:           "Card token and source type",
+			method:         http.MethodPost,
+			target:         "/payments/",
+			body:           payment.PaymentRequest{Amount: 20000, Currency: payment.CurrencyTHB, SourceType: payment.SourceTypeInternetBankSCB, CardToken: "tokn_test_xxx"},
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "invalid_request",
+		},
+		{
+			name:           "Invalid card token",
+			method:         http.MethodPost,
+			target:         "/payments/",
+			body:           payment.PaymentRequest{Amount: 20000, Currency: payment.CurrencyTHB, CardToken: "4242424242424242"},
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "invalid_card_token",
+		},
+		{
+			name:           "Capture of unknown payment",
+			method:         http.MethodPost,
+			target:         "/payments/charges/chrg_test_unknown/capture",
+			expectedStatus: http.StatusNotFound,
+			expectedCode:   "payment_not_found",
+		},
+		{
+			name:           "Malformed capture",
+			method:         http.MethodPost,
+			target:         "/payments/charges/chrg_test_xxx/capture",
+			body:           `{"amount":`,
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "invalid_request",
+		},
+		{
+			name:           "Unknown customer",
+			method:         http.MethodGet,
+			target:         "/customers/user_unknown/cards",
+			expectedStatus: http.StatusNotFound,
+			expectedCode:   "customer_not_found",
+		},
+		{
+			name:           "Payment with unknown customer",
+			method:         http.MethodPost,
+			target:         "/payments/",
+			body:           payment.PaymentRequest{Amount: 20000, Currency: payment.CurrencyTHB, CustomerID: "cust_test_unknown"},
+			expectedStatus: http.StatusNotFound,
+			expectedCode:   "customer_not_found",
+		},
+		{
+			name:           "Unknown payment",
+			method:         http.MethodGet,
+			target:         "/payments/charges/chrg_test_unknown",
+			expectedStatus: http.StatusNotFound,
+			expectedCode:   "payment_not_found",
+		},
+		{
+			name:           "Unknown subscription",
+			method:         http.MethodPost,
+			target:         "/subscriptions/42/cancel",
+			expectedStatus: http.StatusNotFound,
+			expectedCode:   "subscription_not_found",
+		},
+		{
+			name:           "Unknown payment link",
+			method:         http.MethodGet,
+			target:         "/payment-links/link_test_unknown",
+			expectedStatus: http.StatusNotFound,
+			expectedCode:   "payment_link_not_found",
+		},
+		{
+			name:           "Malformed webhook",
+			method:         http.MethodPost,
+			target:         "/webhook/omise",
+			header:         signed([]byte(`{"key":`)),
+			body:           `{"key":`,
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "invalid_request",
+		},
+		{
+			name:           "Unsigned webhook",
+			method:         http.MethodPost,
+			target:         "/webhook/omise",
+			body:           `{"key":"charge.create"}`,
+			expectedStatus: http.StatusUnauthorized,
+			expectedCode:   "invalid_signature",
+		},
+		{
+			name:           "Missing API key",
+			method:         http.MethodGet,
+			target:         "/payments/charges/chrg_test_xxx/status",
+			header:         http.Header{HeaderAPIKey: []string{""}},
+			expectedStatus: http.StatusUnauthorized,
+			expectedCode:   "invalid_api_key",
+		},
+		{
+			name:           "Unknown API key",
+			method:         http.MethodGet,
+			target:         "/payments/charges/chrg_test_xxx/status",
+			header:         http.Header{HeaderAPIKey: []string{"unknown"}},
+			expectedStatus: http.StatusUnauthorized,
+			expectedCode:   "invalid_api_key",
+		},
+		{
+			name:           "Mode header does not select the mode",
+			method:         http.MethodGet,
+			target:         "/payments/charges/chrg_test_xxx/status",
+			header:         http.Header{HeaderAPIKey: []string{""}, "X-Payment-Mode": []string{payment.ModeLive}},
+			expectedStatus: http.StatusUnauthorized,
+			expectedCode:   "invalid_api_key",
+		},
+		{
+			name:           "Unknown route",
+			method:         http.MethodGet,
+			target:         "/unknown",
+			expectedStatus: http.StatusNotFound,
+			expectedCode:   "not_found",
+		},
+	}
+
+	t.Parallel()
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			app, op := newTestApp(t)
+			if tc.mock != nil {
+				tc.mock(op)
+			}
+
+			resp, body := doWithHeader(t, app, tc.method, tc.target, tc.header, tc.body)
+
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+			assert.Equal(t, tc.expectedCode, problemCode(t, resp, body))
+		})
+	}
+}
+
+func TestModes(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	testOp := mockOmiseProvider.NewMockOmiseProvider(ctrl)
+	liveOp := mockOmiseProvider.NewMockOmiseProvider(ctrl)
+
+	app := New(
+		newTestPayment(t, testMerchant{
+			payment.Merchant{ID: payment.DefaultMerchantID, Name: "Default", WebhookSecret: testWebhookSecret},
+			payment.Providers{Test: testOp, Live: liveOp},
+		}),
+		zap.NewNop().Sugar(),
+		WithAPIKeys([]string{"key_test"}, []string{"key_live"}),
+	)
+
+	live := http.Header{HeaderAPIKey: []string{"key_live"}}
+	test := http.Header{HeaderAPIKey: []string{"key_test"}}
+
+	// Created with the live provider only
+	liveOp.EXPECT().CreateSource(gomock.Any(), gomock.Any()).Return(omise.Source{ID: "src_xxx"}, nil)
+	liveOp.EXPECT().CreateCharge(gomock.Any(), gomock.Any()).Return(omise.Charge{
+		Base:   omise.Base{ID: "chrg_xxx", Live: true},
+		Status: omise.ChargePending,
+	}, nil)
+
+	resp, _ := doWithHeader(t, app, http.MethodPost, "/payments/", live, payment.PaymentRequest{
+		Amount:     20000,
+		Currency:   payment.CurrencyTHB,
+		ReturnURI:  "https://example.com",
+		SourceType: payment.SourceTypeInternetBankSCB,
+	})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Not found with a test API key, whatever the mode header says
+	for _, header := range []http.Header{test, {HeaderAPIKey: []string{"key_test"}, "X-Payment-Mode": []string{payment.ModeLive}}} {
+		resp, body := doWithHeader(t, app, http.MethodGet, "/payments/charges/chrg_xxx/status", header, nil)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+		assert.Equal(t, "payment_not_found", problemCode(t, resp, body))
+	}
+
+	resp, body := doWithHeader(t, app, http.MethodGet, "/payments/charges/chrg_xxx/status", live, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.JSONEq(t, `{"status":"pending"}`, string(body))
+
+	// A test mode event for the live charge is dead-lettered, see TestAdminWebhookEvents
+	resp, _ = webhook(t, app, "/webhook/omise", event(t, webhooksim.Params{
+		Key:      "charge.complete",
+		ChargeID: "chrg_xxx",
+		Status:   webhooksim.StatusFailed,
+		Amount:   20000,
+	}))
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, _ = webhook(t, app, "/webhook/omise", event(t, webhooksim.Params{
+		Key:      "charge.complete",
+		ChargeID: "chrg_xxx",
+		Status:   webhooksim.StatusSuccessful,
+		Amount:   20000,
+		Livemode: true,
+	}))
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	assertStatus(t, app, live, "chrg_xxx", "successful")
+}
+
+func TestMerchants(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	opA := mockOmiseProvider.NewMockOmiseProvider(ctrl)
+	opB := mockOmiseProvider.NewMockOmiseProvider(ctrl)
+
+	p := newTestPayment(t,
+		testMerchant{payment.Merchant{ID: "brand_a", Name: "Brand A", ChargeLimitMin: 50000, WebhookSecret: testWebhookSecret}, payment.Providers{Test: opA}},
+		testMerchant{payment.Merchant{ID: "brand_b", Name: "Brand B", WebhookSecret: testWebhookSecret}, payment.Providers{Test: opB}},
+		testMerchant{payment.Merchant{ID: "brand_c", Name: "Brand C"}, payment.Providers{}},
+	)
+	app := New(p, zap.NewNop().Sugar(), WithAPIKeys([]string{testAPIKey}, nil))
+
+	// Requests are made for the merchant of their API key
+	brandA := http.Header{HeaderAPIKey: []string{apiKey(t, p, "brand_a")}}
+	brandB := http.Header{HeaderAPIKey: []string{apiKey(t, p, "brand_b")}}
+	request := payment.PaymentRequest{
+		Amount:     20000,
+		Currency:   payment.CurrencyTHB,
+		ReturnURI:  "https://example.com",
+		SourceType: payment.SourceTypeInternetBankSCB,
+	}
+
+	// Limits of the merchant
+	resp, body := doWithHeader(t, app, http.MethodPost, "/payments/", brandA, request)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, "amount_lower_than_charge_limit", problemCode(t, resp, body))
+
+	// Created with the merchant's provider only
+	opB.EXPECT().CreateSource(gomock.Any(), gomock.Any()).Return(omise.Source{ID: "src_test_b"}, nil)
+	opB.EXPECT().CreateCharge(gomock.Any(), gomock.Any()).Return(omise.Charge{
+		Base:   omise.Base{ID: "chrg_test_b"},
+		Status: omise.ChargePending,
+	}, nil)
+
+	resp, _ = doWithHeader(t, app, http.MethodPost, "/payments/", brandB, request)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Not found for another merchant, whatever the merchant header says
+	for _, header := range []http.Header{brandA, {HeaderAPIKey: brandA[HeaderAPIKey], "X-Merchant-ID": []string{"brand_b"}}} {
+		resp, body = doWithHeader(t, app, http.MethodGet, "/payments/charges/chrg_test_b/status", header, nil)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+		assert.Equal(t, "payment_not_found", problemCode(t, resp, body))
+	}
+
+	resp, body = doWithHeader(t, app, http.MethodGet, "/payments/charges/chrg_test_b/status", brandB, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.JSONEq(t, `{"status":"pending"}`, string(body))
+
+	complete := event(t, webhooksim.Params{
+		Key:      "charge.complete",
+		ChargeID: "chrg_test_b",
+		Status:   webhooksim.StatusSuccessful,
+		Amount:   20000,
+	})
+
+	// Webhooks are verified against the merchant of the route
+	resp, body = do(t, app, http.MethodPost, "/webhook/omise/brand_b", complete)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Equal(t, "invalid_signature", problemCode(t, resp, body))
+
+	// Rejected, signed or not, for a merchant without webhook secret
+	resp, body = webhook(t, app, "/webhook/omise/brand_c", complete)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Equal(t, "webhook_secret_not_set", problemCode(t, resp, body))
+
+	// Queued, then dead-lettered as the payment belongs to brand_b
+	resp, _ = webhook(t, app, "/webhook/omise/brand_a", complete)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, body = do(t, app, http.MethodPost, "/webhook/omise/unknown", complete)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Equal(t, "merchant_not_found", problemCode(t, resp, body))
+
+	resp, _ = webhook(t, app, "/webhook/omise/brand_b", complete)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	assertStatus(t, app, brandB, "chrg_test_b", "successful")
+
+	// Revoked keys are rejected
+	assert.NoError(t, p.RevokeAPIKey(context.Background(), brandB[HeaderAPIKey][0]))
+	resp, body = doWithHeader(t, app, http.MethodGet, "/payments/charges/chrg_test_b/status", brandB, nil)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Equal(t, "invalid_api_key", problemCode(t, resp, body))
+}
+
+// apiKey binds a new test mode API key to the merchant
+func apiKey(t *testing.T, p *payment.Payment, merchantID string) string {
+	key, err := payment.NewAPIKey(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.PutAPIKey(context.Background(), merchantID, false, key); err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
+
+func TestAdminWebhookEvents(t *testing.T) {
+	app := New(newTestPayment(t, testMerchant{
+		payment.Merchant{ID: payment.DefaultMerchantID, Name: "Default", WebhookSecret: testWebhookSecret},
+		payment.Providers{},
+	}), zap.NewNop().Sugar(), WithAPIKeys(nil, []string{"key_live"}), WithAdminKeys([]string{"key_admin"}))
+
+	admin := http.Header{HeaderAPIKey: []string{"key_admin"}}
+
+	for _, header := range []http.Header{{HeaderAPIKey: []string{""}}, {HeaderAPIKey: []string{"key_live"}}} {
+		resp, body := doWithHeader(t, app, http.MethodGet, "/admin/webhook-events", header, nil)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		assert.Equal(t, "invalid_api_key", problemCode(t, resp, body))
+	}
+
+	// A live charge, then an event of the test mode for it
+	resp, _ := webhook(t, app, "/webhook/omise", event(t, webhooksim.Params{
+		Key:      "charge.create",
+		ChargeID: "chrg_xxx",
+		Livemode: true,
+	}))
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assertStatus(t, app, http.Header{HeaderAPIKey: []string{"key_live"}}, "chrg_xxx", "pending")
+
+	resp, _ = webhook(t, app, "/webhook/omise", event(t, webhooksim.Params{
+		Key:      "charge.complete",
+		ChargeID: "chrg_xxx",
+		Status:   webhooksim.StatusSuccessful,
+	}))
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var dead []payment.QueuedEvent
+	assert.Eventually(t, func() bool {
+		_, body := doWithHeader(t, app, http.MethodGet, "/admin/webhook-events?state=dead", admin, nil)
+		dead = nil
+		return json.Unmarshal(body, &dead) == nil && len(dead) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	if len(dead) != 1 {
+		t.FailNow()
+	}
+	assert.Equal(t, "charge.complete", dead[0].Key)
+	assert.Equal(t, "chrg_xxx", dead[0].ChargeID)
+	assert.Equal(t, 1, dead[0].Attempts)
+	assert.Contains(t, dead[0].LastError, "livemode")
+	assert.Empty(t, dead[0].Payload)
+
+	target := "/admin/webhook-events/" + strconv.FormatInt(dead[0].ID, 10)
+
+	resp, body := doWithHeader(t, app, http.MethodGet, target, admin, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var detail payment.QueuedEvent
+	assert.NoError(t, json.Unmarshal(body, &detail))
+	assert.Contains(t, string(detail.Payload), dead[0].EventID)
+
+	resp, body = doWithHeader(t, app, http.MethodPost, target+"/requeue", admin, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, json.Unmarshal(body, &detail))
+	assert.Equal(t, payment.EventStatePending, detail.State)
+	assert.Equal(t, 0, detail.Attempts)
+
+	testCases := []struct {
+		name           string
+		method         string
+		target         string
+		expectedStatus int
+		expectedCode   string
+	}{
+		{
+			name:           "Unknown state",
+			method:         http.MethodGet,
+			target:         "/admin/webhook-events?state=done",
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "invalid_request",
+		},
+		{
+			name:           "Invalid limit",
+			method:         http.MethodGet,
+			target:         "/admin/webhook-events?limit=0",
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "invalid_request",
+		},
+		{
+			name:           "Unknown event",
+			method:         http.MethodGet,
+			target:         "/admin/webhook-events/999",
+			expectedStatus: http.StatusNotFound,
+			expectedCode:   "event_not_found",
+		},
+		{
+			name:           "Requeue unknown event",
+			method:         http.MethodPost,
+			target:         "/admin/webhook-events/xxx/requeue",
+			expectedStatus: http.StatusNotFound,
+			expectedCode:   "event_not_found",
+		},
+		{
+			name:           "Resolve review of unknown payment",
+			method:         http.MethodPost,
+			target:         "/admin/payments/chrg_test_unknown/resolve-review",
+			expectedStatus: http.StatusNotFound,
+			expectedCode:   "payment_not_found",
+		},
+		{
+			name:           "Resolve review of payment not in review",
+			method:         http.MethodPost,
+			target:         "/admin/payments/chrg_xxx/resolve-review",
+			expectedStatus: http.StatusConflict,
+			expectedCode:   "payment_not_in_review",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, body := doWithHeader(t, app, tc.method, tc.target, admin, nil)
+
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+			assert.Equal(t, tc.expectedCode, problemCode(t, resp, body))
+		})
+	}
+}
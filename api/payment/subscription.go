@@ -0,0 +1,59 @@
+package payment
+
+import (
+	"context"
+	"exam-payment-service/internal/payment"
+	"exam-payment-service/pkg/logger"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func (s server) createSubscription(c *fiber.Ctx) error {
+	var b payment.SubscriptionRequest
+
+	if err := c.BodyParser(&b); err != nil {
+		logger.For(c.UserContext(), s.log).Warnw("BodyParser error", "error", payment.SafeError(err))
+		return payment.ErrInvalidRequest.Wrap(err)
+	}
+
+	subscription, err := s.payment.CreateSubscription(c.UserContext(), b)
+	if err != nil {
+		logger.For(c.UserContext(), s.log).Errorw("CreateSubscription error", "error", payment.SafeError(err), "customer_id", b.CustomerID)
+		return err
+	}
+
+	return c.Status(200).JSON(subscription)
+}
+
+func (s server) getSubscription(c *fiber.Ctx) error {
+	return s.subscriptionAction(c, "GetSubscription", s.payment.GetSubscription)
+}
+
+func (s server) pauseSubscription(c *fiber.Ctx) error {
+	return s.subscriptionAction(c, "PauseSubscription", s.payment.PauseSubscription)
+}
+
+func (s server) resumeSubscription(c *fiber.Ctx) error {
+	return s.subscriptionAction(c, "ResumeSubscription", s.payment.ResumeSubscription)
+}
+
+func (s server) cancelSubscription(c *fiber.Ctx) error {
+	return s.subscriptionAction(c, "CancelSubscription", s.payment.CancelSubscription)
+}
+
+// subscriptionAction renders the subscription returned by action for the subscription of the path
+func (s server) subscriptionAction(c *fiber.Ctx, name string, action func(ctx context.Context, id int64) (payment.Subscription, error)) error {
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return payment.ErrSubscriptionNotFound
+	}
+
+	subscription, err := action(c.UserContext(), id)
+	if err != nil {
+		logger.For(c.UserContext(), s.log).Errorw(name+" error", "error", payment.SafeError(err), "subscription_id", id)
+		return err
+	}
+
+	return c.Status(200).JSON(subscription)
+}
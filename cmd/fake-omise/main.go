@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/base64"
+	"exam-payment-service/internal/fakeomise"
+	"exam-payment-service/pkg/logger"
+	"os"
+)
+
+func main() {
+	var (
+		port       string = os.Getenv("PORT")
+		webhookURL string = os.Getenv("WEBHOOK_URL")
+		publicURL  string = os.Getenv("PUBLIC_URL")
+		secret     string = os.Getenv("WEBHOOK_SECRET")
+	)
+
+	// Logger
+	log := logger.New()
+	defer log.Sync()
+
+	if publicURL == "" {
+		publicURL = "http://localhost:" + port
+	}
+
+	// Base64 encoded as Omise shows it, the payment server verifies the signatures with the same secret
+	webhookSecret, err := base64.StdEncoding.DecodeString(secret)
+	if err != nil {
+		panic(err)
+	}
+
+	s := fakeomise.New(fakeomise.Config{
+		WebhookURL:    webhookURL,
+		PublicURL:     publicURL,
+		WebhookSecret: webhookSecret,
+		Log:           log,
+	})
+
+	log.Infow("Fake Omise listen", "port", port, "webhook_url", webhookURL)
+	if err := s.Listen(":" + port); err != nil {
+		log.Errorw("Fiber listen error", "error", err)
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"exam-payment-service/internal/payment"
+	"exam-payment-service/pkg/encryption"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const usage = `Usage: merchant <command> [flags]
+
+Commands:
+  put         create a merchant or update the fields given as flags
+  list        list merchants, without their secrets
+  api-key     create an API key of a merchant and mode, printed once
+  revoke-key  revoke an API key
+
+Secrets are encrypted with the master key file of the payment server, see -master-key-file.
+ENCRYPTION_KEY decrypts values written before master keys.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "put":
+		err = put(os.Args[2:])
+	case "list":
+		err = list(os.Args[2:])
+	case "api-key":
+		err = apiKey(os.Args[2:])
+	case "revoke-key":
+		err = revokeKey(os.Args[2:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fail(err)
+	}
+}
+
+func put(args []string) error {
+	fs := flag.NewFlagSet("put", flag.ExitOnError)
+	var (
+		dbPath         = fs.String("db", "./payment.db", "database of the payment server")
+		masterKeyFile  = fs.String("master-key-file", "./master-key.json", "master key file of the payment server")
+		id             = fs.String("id", "", "merchant ID, used in /webhook/omise/:merchantID")
+		name           = fs.String("name", "", "merchant name")
+		testPublicKey  = fs.String("test-public-key", "", "Omise test mode public key")
+		testSecretKey  = fs.String("test-secret-key", "", "Omise test mode secret key")
+		livePublicKey  = fs.String("live-public-key", "", "Omise live mode public key")
+		liveSecretKey  = fs.String("live-secret-key", "", "Omise live mode secret key")
+		webhookSecret  = fs.String("webhook-secret", "", "base64 webhook secret of the Omise account")
+		chargeLimitMin = fs.Int64("charge-limit-min", 0, "minimum charge amount, 0 for the default")
+		chargeLimitMax = fs.Int64("charge-limit-max", 0, "maximum charge amount, 0 for the default")
+		sourceTypes    = fs.String("source-types", "", "comma separated enabled source types, empty for all")
+	)
+	_ = fs.Parse(args)
+
+	if *id == "" {
+		return errors.New("-id is required")
+	}
+
+	ctx := context.Background()
+	merchants, closeDB, err := openMerchants(*dbPath, *masterKeyFile)
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+
+	m, err := merchants.Get(ctx, *id)
+	if errors.Is(err, payment.ErrMerchantNotFound) {
+		m = payment.Merchant{ID: *id, Name: *id}
+	} else if err != nil {
+		return err
+	}
+
+	// Only the given flags change an existing merchant
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "name":
+			m.Name = *name
+		case "test-public-key":
+			m.TestKeys.PublicKey = *testPublicKey
+		case "test-secret-key":
+			m.TestKeys.SecretKey = *testSecretKey
+		case "live-public-key":
+			m.LiveKeys.PublicKey = *livePublicKey
+		case "live-secret-key":
+			m.LiveKeys.SecretKey = *liveSecretKey
+		case "webhook-secret":
+			m.WebhookSecret = *webhookSecret
+		case "charge-limit-min":
+			m.ChargeLimitMin = *chargeLimitMin
+		case "charge-limit-max":
+			m.ChargeLimitMax = *chargeLimitMax
+		case "source-types":
+			m.SourceTypes = nil
+			for _, st := range strings.Split(*sourceTypes, ",") {
+				if st = strings.TrimSpace(st); st != "" {
+					m.SourceTypes = append(m.SourceTypes, payment.SourceType(st))
+				}
+			}
+		}
+	})
+
+	for _, st := range m.SourceTypes {
+		if !st.Validate() && st != payment.SourceTypeCard {
+			return fmt.Errorf("unsupported source type %q", st)
+		}
+	}
+
+	return merchants.Put(ctx, m)
+}
+
+func list(args []string) error {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	dbPath := fs.String("db", "./payment.db", "database of the payment server")
+	masterKeyFile := fs.String("master-key-file", "./master-key.json", "master key file of the payment server")
+	_ = fs.Parse(args)
+
+	merchants, closeDB, err := openMerchants(*dbPath, *masterKeyFile)
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+
+	list, err := merchants.List(context.Background())
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tMODES\tCHARGE LIMITS\tSOURCE TYPES\tWEBHOOK SECRET")
+	for _, m := range list {
+		var modes []string
+		if m.TestKeys.SecretKey != "" {
+			modes = append(modes, payment.ModeTest)
+		}
+		if m.LiveKeys.SecretKey != "" {
+			modes = append(modes, payment.ModeLive)
+		}
+
+		sourceTypes := "all"
+		if len(m.SourceTypes) > 0 {
+			s := make([]string, len(m.SourceTypes))
+			for i, st := range m.SourceTypes {
+				s[i] = string(st)
+			}
+			sourceTypes = strings.Join(s, ",")
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s-%s\t%s\t%t\n",
+			m.ID, m.Name, strings.Join(modes, ","), limit(m.ChargeLimitMin), limit(m.ChargeLimitMax), sourceTypes, m.WebhookSecret != "")
+	}
+
+	return w.Flush()
+}
+
+func apiKey(args []string) error {
+	fs := flag.NewFlagSet("api-key", flag.ExitOnError)
+	dbPath := fs.String("db", "./payment.db", "database of the payment server")
+	masterKeyFile := fs.String("master-key-file", "./master-key.json", "master key file of the payment server")
+	id := fs.String("id", "", "merchant ID")
+	mode := fs.String("mode", payment.ModeTest, "mode of the requests made with the key, test or live")
+	_ = fs.Parse(args)
+
+	if *id == "" {
+		return errors.New("-id is required")
+	}
+	livemode, err := payment.ParseMode(*mode)
+	if err != nil {
+		return err
+	}
+
+	merchants, closeDB, err := openMerchants(*dbPath, *masterKeyFile)
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+
+	key, err := payment.NewAPIKey(livemode)
+	if err != nil {
+		return err
+	}
+	if err := merchants.PutAPIKey(context.Background(), *id, livemode, key); err != nil {
+		return err
+	}
+
+	// Only the hash is stored, the key cannot be shown again
+	fmt.Println(key)
+
+	return nil
+}
+
+func revokeKey(args []string) error {
+	fs := flag.NewFlagSet("revoke-key", flag.ExitOnError)
+	dbPath := fs.String("db", "./payment.db", "database of the payment server")
+	masterKeyFile := fs.String("master-key-file", "./master-key.json", "master key file of the payment server")
+	key := fs.String("key", "", "API key to revoke")
+	_ = fs.Parse(args)
+
+	if *key == "" {
+		return errors.New("-key is required")
+	}
+
+	merchants, closeDB, err := openMerchants(*dbPath, *masterKeyFile)
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+
+	return merchants.RevokeAPIKey(context.Background(), *key)
+}
+
+func limit(amount int64) string {
+	if amount == 0 {
+		return "default"
+	}
+
+	return strconv.FormatInt(amount, 10)
+}
+
+func openMerchants(dbPath string, masterKeyFile string) (*payment.MerchantStore, func(), error) {
+	var kOptions []encryption.KeyringOption
+	if encryptionKey := os.Getenv("ENCRYPTION_KEY"); encryptionKey != "" {
+		key, err := encryption.ParseKey(encryptionKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		legacy, err := encryption.NewAESGCM(key)
+		if err != nil {
+			return nil, nil, err
+		}
+		kOptions = append(kOptions, encryption.WithLegacyCipher(legacy))
+	}
+
+	keyring, err := encryption.LoadKeyring(masterKeyFile, kOptions...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := payment.Migrate(context.Background(), db); err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+
+	return payment.NewMerchantStore(db, keyring), func() { db.Close() }, nil
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
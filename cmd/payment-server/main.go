@@ -1,57 +1,310 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	paymentServer "exam-payment-service/api/payment"
 	"exam-payment-service/internal/payment"
+	"exam-payment-service/pkg/encryption"
+	"exam-payment-service/pkg/logger"
 	"exam-payment-service/pkg/omiseprovider"
+	"exam-payment-service/pkg/tracing"
+	"fmt"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
+	"github.com/XSAM/otelsql"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/omise/omise-go"
+	"github.com/prometheus/client_golang/prometheus"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
 )
 
 func main() {
 	var (
-		port           string = os.Getenv("PORT")
-		omisePublicKey string = os.Getenv("OMISE_PUBLIC_KEY")
-		omiseSecretKey string = os.Getenv("OMISE_SECRET_KEY")
+		port               string = os.Getenv("PORT")
+		omisePublicKey     string = os.Getenv("OMISE_PUBLIC_KEY")
+		omiseSecretKey     string = os.Getenv("OMISE_SECRET_KEY")
+		omiseTestPublicKey string = os.Getenv("OMISE_TEST_PUBLIC_KEY")
+		omiseTestSecretKey string = os.Getenv("OMISE_TEST_SECRET_KEY")
+		omiseLivePublicKey string = os.Getenv("OMISE_LIVE_PUBLIC_KEY")
+		omiseLiveSecretKey string = os.Getenv("OMISE_LIVE_SECRET_KEY")
+		omiseAPIURL        string = os.Getenv("OMISE_API_URL")
+		omiseWebhookSecret string = os.Getenv("OMISE_WEBHOOK_SECRET")
+		masterKeyFile      string = os.Getenv("MASTER_KEY_FILE")
+		generateMasterKey  string = os.Getenv("GENERATE_MASTER_KEY")
+		encryptionKey      string = os.Getenv("ENCRYPTION_KEY")
+		apiKeysTest        string = os.Getenv("API_KEYS_TEST")
+		apiKeysLive        string = os.Getenv("API_KEYS_LIVE")
+		traceExporter      string = os.Getenv("TRACE_EXPORTER")
+		traceFile          string = os.Getenv("TRACE_FILE")
+		omiseTimeout       string = os.Getenv("OMISE_TIMEOUT")
+		omiseRetries       string = os.Getenv("OMISE_MAX_RETRIES")
+		alertURL           string = os.Getenv("ALERT_WEBHOOK_URL")
+		adminAPIKeys       string = os.Getenv("ADMIN_API_KEYS")
+		webhookWorkers     string = os.Getenv("WEBHOOK_WORKERS")
+		webhookAttempts    string = os.Getenv("WEBHOOK_MAX_ATTEMPTS")
+		expiryWarning      string = os.Getenv("AUTHORIZATION_EXPIRY_WARNING")
+		capabilityRefresh  string = os.Getenv("CAPABILITY_REFRESH_INTERVAL")
 	)
 
-	// Omise client
-	oc, err := omise.NewClient(omisePublicKey, omiseSecretKey)
+	// A single key pair is used for the mode of its secret key
+	if omiseSecretKey != "" {
+		if strings.HasPrefix(omiseSecretKey, "skey_test_") {
+			omiseTestPublicKey, omiseTestSecretKey = omisePublicKey, omiseSecretKey
+		} else {
+			omiseLivePublicKey, omiseLiveSecretKey = omisePublicKey, omiseSecretKey
+		}
+	}
+
+	// Logger
+	log := logger.New()
+	defer log.Sync()
+
+	// Tracing
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		ServiceName: "payment-server",
+		Exporter:    traceExporter,
+		File:        traceFile,
+	})
 	if err != nil {
 		panic(err)
 	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			log.Errorw("Tracing shutdown error", "error", err)
+		}
+	}()
 
 	// Omise provider
-	op := omiseprovider.New(oc)
+	var opOptions []omiseprovider.Option
+	if omiseTimeout != "" {
+		timeout, err := time.ParseDuration(omiseTimeout)
+		if err != nil {
+			panic(err)
+		}
+		opOptions = append(opOptions, omiseprovider.WithTimeout(timeout))
+	}
+	if omiseRetries != "" {
+		retries, err := strconv.Atoi(omiseRetries)
+		if err != nil {
+			panic(err)
+		}
+		opOptions = append(opOptions, omiseprovider.WithRetry(retries, 200*time.Millisecond))
+	}
+
+	newClient := func(pkey, skey string) *omise.Client {
+		oc, err := omise.NewClient(pkey, skey)
+		if err != nil {
+			panic(err)
+		}
+		if omiseAPIURL != "" {
+			// e.g. cmd/fake-omise for running offline
+			oc.Endpoints[omiseprovider.EndpointAPI] = omiseAPIURL
+		}
+		return oc
+	}
+
+	// Providers of each merchant, a mode without keys is not served
+	newProviders := func(m payment.Merchant) (payment.Providers, error) {
+		var providers payment.Providers
+		if m.TestKeys.SecretKey != "" {
+			providers.Test = omiseprovider.New(newClient(m.TestKeys.PublicKey, m.TestKeys.SecretKey), opOptions...)
+		}
+		if m.LiveKeys.SecretKey != "" {
+			providers.Live = omiseprovider.New(newClient(m.LiveKeys.PublicKey, m.LiveKeys.SecretKey), opOptions...)
+		}
+		return providers, nil
+	}
+
+	// Envelope encryption of secrets and sensitive payment fields. A missing master key file is only
+	// generated when asked, a wrong path would otherwise leave every encrypted value unreadable
+	if masterKeyFile == "" {
+		masterKeyFile = "./master-key.json"
+	}
+	if _, err := encryption.ReadKeyFile(masterKeyFile); errors.Is(err, os.ErrNotExist) {
+		if generateMasterKey != "1" {
+			panic(fmt.Errorf("master key file %s does not exist, set GENERATE_MASTER_KEY=1 to generate it", masterKeyFile))
+		}
+
+		var f encryption.KeyFile
+		id, err := f.Generate()
+		if err != nil {
+			panic(err)
+		}
+		if err := f.Write(masterKeyFile); err != nil {
+			panic(err)
+		}
+		log.Warnw("Generated master key file, back it up as encrypted data is lost without it", "path", masterKeyFile, "key_id", id)
+	}
+
+	var kOptions []encryption.KeyringOption
+	if encryptionKey != "" {
+		// Values encrypted before master keys, re-encrypt them with cmd/rotate-keys
+		key, err := encryption.ParseKey(encryptionKey)
+		if err != nil {
+			panic(err)
+		}
+		legacy, err := encryption.NewAESGCM(key)
+		if err != nil {
+			panic(err)
+		}
+		kOptions = append(kOptions, encryption.WithLegacyCipher(legacy))
+	}
+
+	keyring, err := encryption.LoadKeyring(masterKeyFile, kOptions...)
+	if err != nil {
+		panic(err)
+	}
 
 	// Database
-	db, err := sql.Open("sqlite3", "./payment.db")
+	driverName, err := otelsql.Register("sqlite3", semconv.DBSystemSqlite.Value.AsString())
 	if err != nil {
 		panic(err)
 	}
-	defer db.Close()
 
-	// Prepare table
-	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS payments (
-			charge_id 	varchar(100) NOT NULL PRIMARY KEY,
-			source_id 	varchar(100),
-			txn_id 	varchar(100),
-			status 		varchar(20),
-			UNIQUE (charge_id)
-		)
-	`,
-	)
+	db, err := sql.Open(driverName, "./payment.db")
 	if err != nil {
 		panic(err)
 	}
+	defer db.Close()
+
+	// Prepare tables
+	if err := payment.Migrate(context.Background(), db); err != nil {
+		panic(err)
+	}
+
+	// Merchants, the keys in the environment are the default merchant's
+	merchants := payment.NewMerchantStore(db, keyring)
+	if omiseTestSecretKey != "" || omiseLiveSecretKey != "" {
+		if err := putDefaultMerchant(context.Background(), merchants, payment.Merchant{
+			TestKeys:      payment.OmiseKeys{PublicKey: omiseTestPublicKey, SecretKey: omiseTestSecretKey},
+			LiveKeys:      payment.OmiseKeys{PublicKey: omiseLivePublicKey, SecretKey: omiseLiveSecretKey},
+			WebhookSecret: omiseWebhookSecret,
+		}); err != nil {
+			panic(err)
+		}
+	}
 
 	// Payment
-	p := payment.New(op, db)
+	var pOptions []payment.Option
+	if alertURL != "" {
+		pOptions = append(pOptions, payment.WithNotifier(payment.NewWebhookNotifier(alertURL)))
+	}
 
-	// Payment server
-	paymentServer.Start(":"+port, p)
+	p := payment.New(merchants, newProviders, keyring, db, log, pOptions...)
+
+	// Background loops and the server stop on SIGINT or SIGTERM, the loops are waited for
+	// before the database is closed so none is cut off in the middle of a transaction
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var loops sync.WaitGroup
+	run := func(loop func(ctx context.Context)) {
+		loops.Add(1)
+		go func() {
+			defer loops.Done()
+			loop(ctx)
+		}()
+	}
+
+	// Webhook events are applied in the background, events left pending on exit are processed on the next start
+	queueConfig := payment.DefaultQueueConfig
+	if webhookWorkers != "" {
+		queueConfig.Workers, err = strconv.Atoi(webhookWorkers)
+		if err != nil {
+			panic(err)
+		}
+	}
+	if webhookAttempts != "" {
+		queueConfig.MaxAttempts, err = strconv.Atoi(webhookAttempts)
+		if err != nil {
+			panic(err)
+		}
+	}
+	run(func(ctx context.Context) { p.RunEventWorkers(ctx, queueConfig) })
+
+	// Authorized payments are flagged before their authorization expires
+	monitorConfig := payment.DefaultAuthorizationMonitorConfig
+	if expiryWarning != "" {
+		monitorConfig.Warning, err = time.ParseDuration(expiryWarning)
+		if err != nil {
+			panic(err)
+		}
+	}
+	run(func(ctx context.Context) { p.RunAuthorizationMonitor(ctx, monitorConfig) })
+
+	// Bill payments not paid at the counter in time are expired
+	run(func(ctx context.Context) { p.RunBillPaymentExpiry(ctx, payment.BillPaymentExpiryInterval) })
+
+	// Other payments still pending past their expiresInSeconds are expired once Omise confirms
+	run(func(ctx context.Context) { p.RunPaymentExpiry(ctx, payment.PaymentExpiryInterval) })
+
+	// Cached Omise capabilities behind the payment methods are kept up to date
+	refreshInterval := payment.CapabilityRefreshInterval
+	if capabilityRefresh != "" {
+		refreshInterval, err = time.ParseDuration(capabilityRefresh)
+		if err != nil {
+			panic(err)
+		}
+	}
+	run(func(ctx context.Context) { p.RunCapabilityRefresh(ctx, refreshInterval) })
+
+	// Metrics
+	prometheus.MustRegister(payment.NewStatusCollector(db))
+
+	// Payment server, requests are made for the merchant and in the mode of their API key and rejected
+	// without one. The keys in the environment are the default merchant's, see cmd/merchant for the others
+	if apiKeysTest == "" && apiKeysLive == "" {
+		log.Warnw("No API keys are set, payment requests of the default merchant are rejected until API_KEYS_TEST or API_KEYS_LIVE is set")
+	}
+	if (omiseTestSecretKey != "" || omiseLiveSecretKey != "") && omiseWebhookSecret == "" {
+		log.Warnw("OMISE_WEBHOOK_SECRET is not set, webhooks of the default merchant are rejected")
+	}
+
+	paymentServer.Start(
+		ctx, ":"+port, p, log,
+		paymentServer.WithAPIKeys(splitList(apiKeysTest), splitList(apiKeysLive)),
+		paymentServer.WithAdminKeys(splitList(adminAPIKeys)),
+	)
+
+	// Also when the server failed to listen
+	stop()
+	loops.Wait()
+	log.Infow("Payment server stopped")
+}
+
+// putDefaultMerchant sets the Omise keys of the default merchant, keeping its limits and source types
+func putDefaultMerchant(ctx context.Context, merchants *payment.MerchantStore, keys payment.Merchant) error {
+	m, err := merchants.Get(ctx, payment.DefaultMerchantID)
+	if err != nil && !errors.Is(err, payment.ErrMerchantNotFound) {
+		return err
+	}
+
+	m.ID = payment.DefaultMerchantID
+	if m.Name == "" {
+		m.Name = "Default"
+	}
+	m.TestKeys = keys.TestKeys
+	m.LiveKeys = keys.LiveKeys
+	m.WebhookSecret = keys.WebhookSecret
+
+	return merchants.Put(ctx, m)
+}
+
+// splitList splits a comma separated env value
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
 }
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"exam-payment-service/internal/payment"
+	"exam-payment-service/pkg/encryption"
+	"flag"
+	"fmt"
+	"os"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func main() {
+	var (
+		dbPath        = flag.String("db", "./payment.db", "database of the payment server")
+		masterKeyFile = flag.String("master-key-file", "./master-key.json", "master key file of the payment server")
+		newKey        = flag.Bool("new-key", false, "generate a master key and make it the primary one before re-encrypting, creates the file if missing")
+		batchSize     = flag.Int("batch-size", 500, "rows re-encrypted per transaction")
+	)
+	flag.Parse()
+
+	if *batchSize < 1 {
+		fail(errors.New("-batch-size must be positive"))
+	}
+
+	f, err := encryption.ReadKeyFile(*masterKeyFile)
+	if err != nil && !(*newKey && errors.Is(err, os.ErrNotExist)) {
+		fail(err)
+	}
+
+	if *newKey {
+		id, err := f.Generate()
+		if err != nil {
+			fail(err)
+		}
+		// Saved first, re-encrypted rows are unreadable without the new key
+		if err := f.Write(*masterKeyFile); err != nil {
+			fail(err)
+		}
+		fmt.Printf("generated master key %s, restart the payment server to encrypt new values with it\n", id)
+	}
+
+	var kOptions []encryption.KeyringOption
+	if encryptionKey := os.Getenv("ENCRYPTION_KEY"); encryptionKey != "" {
+		key, err := encryption.ParseKey(encryptionKey)
+		if err != nil {
+			fail(err)
+		}
+		legacy, err := encryption.NewAESGCM(key)
+		if err != nil {
+			fail(err)
+		}
+		kOptions = append(kOptions, encryption.WithLegacyCipher(legacy))
+	}
+
+	keyring, err := encryption.NewKeyring(f, kOptions...)
+	if err != nil {
+		fail(err)
+	}
+
+	db, err := sql.Open("sqlite3", *dbPath)
+	if err != nil {
+		fail(err)
+	}
+	defer db.Close()
+
+	if err := payment.Migrate(context.Background(), db); err != nil {
+		fail(err)
+	}
+
+	total, err := payment.RotateKeys(context.Background(), db, keyring, *batchSize, func(table, column string, n int) {
+		fmt.Printf("%s.%s: %d re-encrypted\n", table, column, n)
+	})
+	if err != nil {
+		fail(err)
+	}
+
+	fmt.Printf("%d values re-encrypted with master key %s\n", total, keyring.Primary())
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
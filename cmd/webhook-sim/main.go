@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/base64"
+	"exam-payment-service/internal/webhooksim"
+	"flag"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+func main() {
+	var (
+		url         = flag.String("url", "http://localhost:8080/webhook/omise", "webhook endpoint")
+		key         = flag.String("key", "charge.complete", "event key, one of "+strings.Join(webhooksim.Keys(), ", "))
+		chargeID    = flag.String("charge", "", "charge ID, generated when empty")
+		scheduleID  = flag.String("schedule", "", "schedule ID of schedule events, generated when empty")
+		customerID  = flag.String("customer", "", "customer ID of schedule events, generated when empty")
+		status      = flag.String("status", "", "charge or schedule status, defaults to the usual one for the key")
+		amount      = flag.Int64("amount", 2000, "charge amount in satang")
+		currency    = flag.String("currency", "THB", "charge currency")
+		sourceType  = flag.String("source-type", "internet_banking_scb", "source type")
+		failureCode = flag.String("failure-code", "", "failure code of a failed charge")
+		livemode    = flag.Bool("livemode", false, "send a live mode event")
+		secret      = flag.String("secret", "", "base64 webhook secret the payloads are signed with, the payment server rejects unsigned webhooks")
+		replay      = flag.String("replay", "", "JSONL file of recorded events to send instead of building one")
+		shuffle     = flag.Bool("shuffle", false, "send the replayed events in random order")
+		seed        = flag.Int64("seed", 0, "shuffle seed, random when 0")
+		interval    = flag.Duration("interval", 0, "delay between replayed events")
+		dryRun      = flag.Bool("dry-run", false, "print the payloads as JSONL instead of sending them")
+	)
+	flag.Parse()
+
+	var events [][]byte
+	if *replay != "" {
+		f, err := os.Open(*replay)
+		if err != nil {
+			fail(err)
+		}
+		events, err = webhooksim.ReadJSONL(f)
+		f.Close()
+		if err != nil {
+			fail(fmt.Errorf("%s: %w", *replay, err))
+		}
+
+		if *shuffle {
+			if *seed == 0 {
+				*seed = time.Now().UnixNano()
+			}
+			fmt.Fprintf(os.Stderr, "shuffle seed %d\n", *seed)
+			webhooksim.Shuffle(events, rand.New(rand.NewSource(*seed)))
+		}
+	} else {
+		event, err := webhooksim.Build(webhooksim.Params{
+			Key:         *key,
+			ChargeID:    *chargeID,
+			ScheduleID:  *scheduleID,
+			CustomerID:  *customerID,
+			Status:      *status,
+			Amount:      *amount,
+			Currency:    *currency,
+			SourceType:  *sourceType,
+			FailureCode: *failureCode,
+			Livemode:    *livemode,
+		})
+		if err != nil {
+			fail(err)
+		}
+		events = [][]byte{event}
+	}
+
+	if *dryRun {
+		for _, e := range events {
+			fmt.Println(string(e))
+		}
+		return
+	}
+
+	sender := webhooksim.Sender{
+		Client: &http.Client{Timeout: 10 * time.Second},
+		URL:    *url,
+	}
+	if *secret != "" {
+		s, err := base64.StdEncoding.DecodeString(*secret)
+		if err != nil {
+			fail(fmt.Errorf("secret: %w", err))
+		}
+		sender.Secret = s
+	}
+
+	failed := false
+	for i, e := range events {
+		if i > 0 && *interval > 0 {
+			time.Sleep(*interval)
+		}
+
+		code, err := sender.Send(e)
+		if err != nil {
+			fail(err)
+		}
+
+		fmt.Printf("%d/%d %d %s\n", i+1, len(events), code, http.StatusText(code))
+		if code >= 300 {
+			failed = true
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
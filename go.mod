@@ -4,9 +4,22 @@ go 1.16
 
 require (
 	github.com/DATA-DOG/go-sqlmock v1.5.0
-	github.com/gofiber/fiber/v2 v2.12.0
+	github.com/XSAM/otelsql v0.10.0
+	github.com/gofiber/adaptor/v2 v2.1.8
+	github.com/gofiber/fiber/v2 v2.20.1
 	github.com/golang/mock v1.5.0
 	github.com/mattn/go-sqlite3 v1.14.7
 	github.com/omise/omise-go v1.0.7
+	github.com/prometheus/client_golang v1.11.0
+	github.com/sony/gobreaker v0.5.0
 	github.com/stretchr/testify v1.7.0
+	go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp v0.28.0
+	go.opentelemetry.io/otel v1.3.0
+	go.opentelemetry.io/otel/exporters/otlp/otlptrace v1.3.0
+	go.opentelemetry.io/otel/exporters/stdout/stdouttrace v1.3.0
+	go.opentelemetry.io/otel/sdk v1.3.0
+	go.opentelemetry.io/otel/trace v1.3.0
+	go.opentelemetry.io/proto/otlp v0.11.0
+	go.uber.org/zap v1.19.1
+	google.golang.org/protobuf v1.27.1
 )
@@ -0,0 +1,233 @@
+package fakeomise
+
+import (
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// declinedLastDigits are the last digits of the Omise test card 4111 1111 1114 0011,
+// charges of it fail with insufficient_fund
+const declinedLastDigits = "0011"
+
+// authorizationPeriod is how long an uncaptured card charge can be captured
+const authorizationPeriod = 7 * 24 * time.Hour
+
+const (
+	sourceTypeBillPayment = "bill_payment_tesco_lotus"
+	// billPaymentTaxID is the tax ID of Omise printed on bills
+	billPaymentTaxID = "010554614953100"
+)
+
+func (s *Server) createToken(c *fiber.Ctx) error {
+	var b struct {
+		Card struct {
+			Name            string `json:"name"`
+			Number          string `json:"number"`
+			ExpirationMonth int    `json:"expiration_month"`
+			ExpirationYear  int    `json:"expiration_year"`
+			SecurityCode    string `json:"security_code"`
+		} `json:"card"`
+	}
+	if err := c.BodyParser(&b); err != nil {
+		return omiseError(c, http.StatusBadRequest, "bad_request", err.Error())
+	}
+
+	number := strings.ReplaceAll(b.Card.Number, " ", "")
+	brand := cardBrand(number)
+	if brand == "" || b.Card.ExpirationMonth < 1 || b.Card.ExpirationMonth > 12 || b.Card.ExpirationYear == 0 {
+		return omiseError(c, http.StatusBadRequest, "invalid_card", "number, expiration month and expiration year are invalid")
+	}
+
+	now := time.Now().UTC()
+	t := token{
+		Object: "token",
+		ID:     newID("tokn"),
+		Card: card{
+			Object:          "card",
+			ID:              newID("card"),
+			Country:         "th",
+			Brand:           brand,
+			LastDigits:      number[len(number)-4:],
+			Name:            b.Card.Name,
+			ExpirationMonth: b.Card.ExpirationMonth,
+			ExpirationYear:  b.Card.ExpirationYear,
+			Fingerprint:     newID("fp"),
+			CreatedAt:       now,
+		},
+		CreatedAt: now,
+	}
+	t.Location = "/tokens/" + t.ID
+
+	s.store.putToken(t)
+
+	return c.JSON(t)
+}
+
+// cardBrand returns the brand of a card number, empty when it is not a card number
+func cardBrand(number string) string {
+	if len(number) < 12 || len(number) > 19 {
+		return ""
+	}
+	if _, err := strconv.ParseUint(number, 10, 64); err != nil {
+		return ""
+	}
+
+	switch {
+	case strings.HasPrefix(number, "4"):
+		return "Visa"
+	case strings.HasPrefix(number, "5"):
+		return "MasterCard"
+	case strings.HasPrefix(number, "35"):
+		return "JCB"
+	}
+
+	return ""
+}
+
+// chargeCard returns the card a charge is made with, the given card of the customer or its default
+// card, otherwise the card of the token
+func (s *Server) chargeCard(customerID string, cardID string) (card, error) {
+	if customerID == "" {
+		return s.store.useToken(cardID)
+	}
+
+	cust, ok := s.store.customer(customerID)
+	if !ok {
+		return card{}, errNotFound
+	}
+
+	if cardID == "" && cust.DefaultCard != nil {
+		cardID = *cust.DefaultCard
+	}
+	for _, cd := range cust.cards {
+		if cd.ID == cardID {
+			return cd, nil
+		}
+	}
+
+	return card{}, errNotFound
+}
+
+// authorizeCard charges the card right away, as Omise does for cards without 3-D Secure.
+// Charges that are not captured stay pending until they are captured or reversed
+func authorizeCard(ch *charge, cd card, now time.Time) {
+	ch.Card = &cd
+
+	if cd.LastDigits == declinedLastDigits {
+		code, message := "insufficient_fund", "insufficient funds in the account or the card has reached the credit limit"
+		ch.Status = OutcomeFailed
+		ch.FailureCode = &code
+		ch.FailureMessage = &message
+		return
+	}
+
+	ch.Authorized = true
+	if !ch.Capture {
+		ch.ExpiresAt = now.Add(authorizationPeriod)
+		return
+	}
+
+	txn := newID("trxn")
+	ch.Status = OutcomeSuccessful
+	ch.Paid = true
+	ch.Transaction = &txn
+	ch.PaidAt = &now
+}
+
+func (s *Server) captureCharge(c *fiber.Ctx) error {
+	var b struct {
+		CaptureAmount int64 `json:"capture_amount"`
+	}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&b); err != nil {
+			return omiseError(c, http.StatusBadRequest, "bad_request", err.Error())
+		}
+	}
+
+	ch, err := s.store.updateCharge(c.Params("chargeID"), func(ch *charge) error {
+		if !ch.Authorized || ch.Paid || ch.Status != "pending" {
+			return errors.New("charge is not capturable")
+		}
+		if b.CaptureAmount < 0 || b.CaptureAmount > ch.Amount {
+			return errors.New("capture amount is invalid")
+		}
+
+		now := time.Now().UTC()
+		txn := newID("trxn")
+		ch.Status = OutcomeSuccessful
+		ch.Paid = true
+		ch.Transaction = &txn
+		ch.PaidAt = &now
+
+		return nil
+	})
+	if err == errNotFound {
+		return omiseError(c, http.StatusNotFound, "not_found", "charge was not found")
+	}
+	if err != nil {
+		return omiseError(c, http.StatusBadRequest, "failed_capture", err.Error())
+	}
+
+	s.fire("charge.capture", ch)
+
+	s.logger().Infow("Charge captured", "charge_id", ch.ID, "capture_amount", b.CaptureAmount)
+
+	return c.JSON(ch)
+}
+
+func (s *Server) reverseCharge(c *fiber.Ctx) error {
+	ch, err := s.store.updateCharge(c.Params("chargeID"), func(ch *charge) error {
+		if !ch.Authorized || ch.Paid || ch.Status != "pending" {
+			return errors.New("charge is not reversible")
+		}
+
+		now := time.Now().UTC()
+		ch.Status = "reversed"
+		ch.Reversed = true
+		ch.ReversedAt = &now
+
+		return nil
+	})
+	if err == errNotFound {
+		return omiseError(c, http.StatusNotFound, "not_found", "charge was not found")
+	}
+	if err != nil {
+		return omiseError(c, http.StatusBadRequest, "failed_reverse", err.Error())
+	}
+
+	s.fire("charge.reverse", ch)
+
+	s.logger().Infow("Charge reversed", "charge_id", ch.ID)
+
+	return c.JSON(ch)
+}
+
+// newReferences returns the references printed on the bill of a bill payment
+func newReferences(amount int64, expiresAt time.Time) *references {
+	ref1, ref2 := newDigits(15), newDigits(14)
+
+	return &references{
+		ExpiresAt:        expiresAt,
+		ReferenceNumber1: ref1,
+		ReferenceNumber2: ref2,
+		Barcode:          "|" + billPaymentTaxID + "\r" + ref1 + "\r" + ref2 + "\r" + strconv.FormatInt(amount, 10),
+		OmiseTaxID:       billPaymentTaxID,
+	}
+}
+
+func newDigits(n int) string {
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		d, _ := rand.Int(rand.Reader, big.NewInt(10))
+		b.WriteString(d.String())
+	}
+
+	return b.String()
+}
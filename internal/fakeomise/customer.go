@@ -0,0 +1,146 @@
+package fakeomise
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type customerParams struct {
+	Email       string                 `json:"email"`
+	Description string                 `json:"description"`
+	Card        string                 `json:"card"`
+	DefaultCard string                 `json:"default_card"`
+	Metadata    map[string]interface{} `json:"metadata"`
+}
+
+func (s *Server) createCustomer(c *fiber.Ctx) error {
+	var b customerParams
+	if err := c.BodyParser(&b); err != nil {
+		return omiseError(c, http.StatusBadRequest, "bad_request", err.Error())
+	}
+
+	cust := customer{
+		Object:    "customer",
+		ID:        newID("cust"),
+		Metadata:  map[string]interface{}{},
+		CreatedAt: time.Now().UTC(),
+	}
+	cust.Location = "/customers/" + cust.ID
+
+	cd, err := s.tokenCard(b.Card)
+	if err != nil {
+		return customerError(c, err)
+	}
+	if err := applyCustomer(&cust, b, cd); err != nil {
+		return customerError(c, err)
+	}
+
+	s.store.putCustomer(cust)
+
+	return c.JSON(withCards(cust))
+}
+
+func (s *Server) retrieveCustomer(c *fiber.Ctx) error {
+	cust, ok := s.store.customer(c.Params("customerID"))
+	if !ok {
+		return omiseError(c, http.StatusNotFound, "not_found", "customer was not found")
+	}
+
+	return c.JSON(withCards(cust))
+}
+
+func (s *Server) updateCustomer(c *fiber.Ctx) error {
+	var b customerParams
+	if err := c.BodyParser(&b); err != nil {
+		return omiseError(c, http.StatusBadRequest, "bad_request", err.Error())
+	}
+
+	cd, err := s.tokenCard(b.Card)
+	if err != nil {
+		return customerError(c, err)
+	}
+
+	cust, err := s.store.updateCustomer(c.Params("customerID"), func(cust *customer) error {
+		return applyCustomer(cust, b, cd)
+	})
+	if err == errNotFound {
+		return omiseError(c, http.StatusNotFound, "not_found", "customer was not found")
+	}
+	if err != nil {
+		return customerError(c, err)
+	}
+
+	return c.JSON(withCards(cust))
+}
+
+// tokenCard returns the card of a token given to a customer, nil without token
+func (s *Server) tokenCard(tokenID string) (*card, error) {
+	if tokenID == "" {
+		return nil, nil
+	}
+
+	cd, err := s.store.useToken(tokenID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cd, nil
+}
+
+// applyCustomer sets the given fields of a customer and adds the card of its token, the first
+// card becomes the default one
+func applyCustomer(cust *customer, b customerParams, cd *card) error {
+	if b.Email != "" {
+		cust.Email = b.Email
+	}
+	if b.Description != "" {
+		cust.Description = b.Description
+	}
+	if len(b.Metadata) > 0 {
+		metadata := map[string]interface{}{}
+		for k, v := range cust.Metadata {
+			metadata[k] = v
+		}
+		for k, v := range b.Metadata {
+			metadata[k] = v
+		}
+		cust.Metadata = metadata
+	}
+
+	if cd != nil {
+		cust.cards = append(append([]card{}, cust.cards...), *cd)
+		if cust.DefaultCard == nil {
+			cust.DefaultCard = &cd.ID
+		}
+	}
+
+	if b.DefaultCard != "" {
+		for _, cd := range cust.cards {
+			if cd.ID == b.DefaultCard {
+				id := cd.ID
+				cust.DefaultCard = &id
+				return nil
+			}
+		}
+		return errNotFound
+	}
+
+	return nil
+}
+
+func withCards(cust customer) customer {
+	cards := append([]card{}, cust.cards...)
+	cust.Cards = newList("/customers/"+cust.ID+"/cards", cards, len(cards))
+
+	return cust
+}
+
+func customerError(c *fiber.Ctx, err error) error {
+	if err == errNotFound {
+		return omiseError(c, http.StatusNotFound, "not_found", "card was not found")
+	}
+
+	return omiseError(c, http.StatusBadRequest, "invalid_card", err.Error())
+}
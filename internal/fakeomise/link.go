@@ -0,0 +1,126 @@
+package fakeomise
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// testCard pays links scripted without a card token
+func testCard() card {
+	return card{
+		Object:          "card",
+		ID:              newID("card"),
+		Country:         "th",
+		Brand:           "Visa",
+		LastDigits:      "4242",
+		Name:            "Fake Customer",
+		ExpirationMonth: 12,
+		ExpirationYear:  time.Now().Year() + 1,
+		Fingerprint:     newID("fp"),
+		CreatedAt:       time.Now().UTC(),
+	}
+}
+
+func (s *Server) createLink(c *fiber.Ctx) error {
+	var b struct {
+		Amount      int64  `json:"amount"`
+		Currency    string `json:"currency"`
+		Title       string `json:"title"`
+		Description string `json:"description"`
+		Multiple    bool   `json:"multiple"`
+	}
+	if err := c.BodyParser(&b); err != nil {
+		return omiseError(c, http.StatusBadRequest, "bad_request", err.Error())
+	}
+
+	if b.Amount <= 0 || b.Currency == "" || b.Title == "" {
+		return omiseError(c, http.StatusBadRequest, "invalid_link", "amount, currency and title are required")
+	}
+
+	l := link{
+		Object:      "link",
+		ID:          newID("link"),
+		Amount:      b.Amount,
+		Currency:    strings.ToUpper(b.Currency),
+		Multiple:    b.Multiple,
+		Title:       b.Title,
+		Description: b.Description,
+		CreatedAt:   time.Now().UTC(),
+	}
+	l.Location = "/links/" + l.ID
+	l.PaymentURI = s.cfg.PublicURL + "/links/" + l.ID
+	l.Charges = newList(l.Location+"/charges", []interface{}{}, 0)
+
+	s.store.putLink(l)
+
+	return c.JSON(l)
+}
+
+// scriptLinkPay pays a link as a customer would, with the card of the given token or a test card
+func (s *Server) scriptLinkPay(c *fiber.Ctx) error {
+	var b struct {
+		Card string `json:"card"`
+	}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&b); err != nil {
+			return omiseError(c, http.StatusBadRequest, "bad_request", err.Error())
+		}
+	}
+
+	cd := testCard()
+	if b.Card != "" {
+		var err error
+		if cd, err = s.store.useToken(b.Card); err != nil {
+			return customerError(c, err)
+		}
+	}
+
+	l, err := s.store.updateLink(c.Params("linkID"), func(l *link) error {
+		if l.Used && !l.Multiple {
+			return errors.New("link was already used")
+		}
+
+		l.Used = true
+
+		return nil
+	})
+	if err == errNotFound {
+		return omiseError(c, http.StatusNotFound, "not_found", "link was not found")
+	}
+	if err != nil {
+		return omiseError(c, http.StatusBadRequest, "invalid_link", err.Error())
+	}
+
+	ch := s.chargeNow(l.Amount, l.Currency, cd, func(ch *charge) {
+		description := l.Title
+		ch.Description = &description
+		ch.Link = &l.ID
+	})
+
+	return c.JSON(ch)
+}
+
+// fakeCapability lists the payment methods of a Thai test account
+var fakeCapability = capability{
+	Object:   "capability",
+	Location: "/capability",
+	Banks:    []string{"bbl", "kbank", "scb"},
+	Country:  "TH",
+	PaymentMethods: []paymentMethod{
+		{Object: "payment_method", Name: "card", Currencies: []string{"THB", "USD"}, CardBrands: []string{"Visa", "MasterCard", "JCB"}},
+		{Object: "payment_method", Name: "internet_banking_scb", Currencies: []string{"THB"}},
+		{Object: "payment_method", Name: "installment_bbl", Currencies: []string{"THB"}, InstallmentTerms: []int{4, 6, 8, 9, 10}},
+		{Object: "payment_method", Name: "installment_kbank", Currencies: []string{"THB"}, InstallmentTerms: []int{3, 4, 6, 10}},
+		{Object: "payment_method", Name: "truemoney", Currencies: []string{"THB"}},
+		{Object: "payment_method", Name: "promptpay", Currencies: []string{"THB"}},
+		{Object: "payment_method", Name: "bill_payment_tesco_lotus", Currencies: []string{"THB"}},
+	},
+}
+
+func (s *Server) retrieveCapability(c *fiber.Ctx) error {
+	return c.JSON(fakeCapability)
+}
@@ -0,0 +1,223 @@
+package fakeomise
+
+import "time"
+
+// Objects as serialized by the Omise API
+
+type source struct {
+	Object       string `json:"object"`
+	ID           string `json:"id"`
+	Livemode     bool   `json:"livemode"`
+	Location     string `json:"location"`
+	Type         string `json:"type"`
+	Flow         string `json:"flow"`
+	Amount       int64  `json:"amount"`
+	Currency     string `json:"currency"`
+	ChargeStatus string `json:"charge_status"`
+	// References are set on bill payment sources once charged
+	References *references `json:"references"`
+	CreatedAt  time.Time   `json:"created_at"`
+}
+
+type references struct {
+	ExpiresAt        time.Time `json:"expires_at"`
+	ReferenceNumber1 string    `json:"reference_number_1"`
+	ReferenceNumber2 string    `json:"reference_number_2"`
+	Barcode          string    `json:"barcode"`
+	OmiseTaxID       string    `json:"omise_tax_id"`
+}
+
+type card struct {
+	Object          string    `json:"object"`
+	ID              string    `json:"id"`
+	Livemode        bool      `json:"livemode"`
+	Location        *string   `json:"location"`
+	Country         string    `json:"country"`
+	Brand           string    `json:"brand"`
+	LastDigits      string    `json:"last_digits"`
+	Name            string    `json:"name"`
+	ExpirationMonth int       `json:"expiration_month"`
+	ExpirationYear  int       `json:"expiration_year"`
+	Fingerprint     string    `json:"fingerprint"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+type token struct {
+	Object    string    `json:"object"`
+	ID        string    `json:"id"`
+	Livemode  bool      `json:"livemode"`
+	Location  string    `json:"location"`
+	Used      bool      `json:"used"`
+	Card      card      `json:"card"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type charge struct {
+	Object         string                 `json:"object"`
+	ID             string                 `json:"id"`
+	Livemode       bool                   `json:"livemode"`
+	Location       string                 `json:"location"`
+	Amount         int64                  `json:"amount"`
+	Currency       string                 `json:"currency"`
+	Description    *string                `json:"description"`
+	Status         string                 `json:"status"`
+	Capture        bool                   `json:"capture"`
+	Authorized     bool                   `json:"authorized"`
+	Paid           bool                   `json:"paid"`
+	Expired        bool                   `json:"expired"`
+	Reversed       bool                   `json:"reversed"`
+	Transaction    *string                `json:"transaction"`
+	Card           *card                  `json:"card"`
+	Source         *source                `json:"source"`
+	Customer       *string                `json:"customer"`
+	Schedule       *string                `json:"schedule"`
+	Link           *string                `json:"link"`
+	ReturnURI      string                 `json:"return_uri"`
+	AuthorizeURI   string                 `json:"authorize_uri"`
+	FailureCode    *string                `json:"failure_code"`
+	FailureMessage *string                `json:"failure_message"`
+	RefundedAmount int64                  `json:"refunded_amount"`
+	Refunds        list                   `json:"refunds"`
+	Metadata       map[string]interface{} `json:"metadata"`
+	CreatedAt      time.Time              `json:"created_at"`
+	PaidAt         *time.Time             `json:"paid_at"`
+	ExpiresAt      time.Time              `json:"expires_at"`
+	ExpiredAt      *time.Time             `json:"expired_at"`
+	ReversedAt     *time.Time             `json:"reversed_at"`
+}
+
+type refund struct {
+	Object      string    `json:"object"`
+	ID          string    `json:"id"`
+	Livemode    bool      `json:"livemode"`
+	Location    string    `json:"location"`
+	Amount      int64     `json:"amount"`
+	Currency    string    `json:"currency"`
+	Charge      string    `json:"charge"`
+	Transaction string    `json:"transaction"`
+	Voided      bool      `json:"voided"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type customer struct {
+	Object      string                 `json:"object"`
+	ID          string                 `json:"id"`
+	Livemode    bool                   `json:"livemode"`
+	Location    string                 `json:"location"`
+	DefaultCard *string                `json:"default_card"`
+	Email       string                 `json:"email"`
+	Description string                 `json:"description"`
+	Metadata    map[string]interface{} `json:"metadata"`
+	Cards       list                   `json:"cards"`
+	CreatedAt   time.Time              `json:"created_at"`
+
+	// cards are listed in Cards when the customer is returned
+	cards []card
+}
+
+type schedule struct {
+	Object          string         `json:"object"`
+	ID              string         `json:"id"`
+	Livemode        bool           `json:"livemode"`
+	Location        string         `json:"location"`
+	Status          string         `json:"status"`
+	Every           int            `json:"every"`
+	Period          string         `json:"period"`
+	On              scheduleOn     `json:"on"`
+	InWords         string         `json:"in_words"`
+	StartDate       string         `json:"start_date"`
+	EndDate         string         `json:"end_date"`
+	Charge          scheduleCharge `json:"charge"`
+	Occurrences     list           `json:"occurrences"`
+	NextOccurrences []string       `json:"next_occurrences"`
+	CreatedAt       time.Time      `json:"created_at"`
+}
+
+type scheduleOn struct {
+	Weekdays       []string `json:"weekdays,omitempty"`
+	DaysOfMonth    []int    `json:"days_of_month,omitempty"`
+	WeekdayOfMonth string   `json:"weekday_of_month,omitempty"`
+}
+
+type scheduleCharge struct {
+	Amount      int64   `json:"amount"`
+	Currency    string  `json:"currency"`
+	Description *string `json:"description"`
+	Customer    string  `json:"customer"`
+	Card        *string `json:"card"`
+}
+
+type link struct {
+	Object      string    `json:"object"`
+	ID          string    `json:"id"`
+	Livemode    bool      `json:"livemode"`
+	Location    string    `json:"location"`
+	Amount      int64     `json:"amount"`
+	Currency    string    `json:"currency"`
+	Used        bool      `json:"used"`
+	Multiple    bool      `json:"multiple"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	PaymentURI  string    `json:"payment_uri"`
+	Charges     list      `json:"charges"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type capability struct {
+	Object                   string          `json:"object"`
+	Location                 string          `json:"location"`
+	Banks                    []string        `json:"banks"`
+	Country                  string          `json:"country"`
+	PaymentMethods           []paymentMethod `json:"payment_methods"`
+	ZeroInterestInstallments bool            `json:"zero_interest_installments"`
+}
+
+type paymentMethod struct {
+	Object           string   `json:"object"`
+	Name             string   `json:"name"`
+	Currencies       []string `json:"currencies"`
+	CardBrands       []string `json:"card_brands"`
+	InstallmentTerms []int    `json:"installment_terms"`
+}
+
+type event struct {
+	Object    string      `json:"object"`
+	ID        string      `json:"id"`
+	Livemode  bool        `json:"livemode"`
+	Location  string      `json:"location"`
+	Key       string      `json:"key"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+type list struct {
+	Object   string      `json:"object"`
+	Data     interface{} `json:"data"`
+	Limit    int         `json:"limit"`
+	Offset   int         `json:"offset"`
+	Total    int         `json:"total"`
+	Location string      `json:"location"`
+	Order    string      `json:"order"`
+	From     time.Time   `json:"from"`
+	To       time.Time   `json:"to"`
+}
+
+func newList(location string, data interface{}, total int) list {
+	return list{
+		Object:   "list",
+		Data:     data,
+		Limit:    20,
+		Total:    total,
+		Location: location,
+		Order:    "chronological",
+		From:     time.Unix(0, 0).UTC(),
+		To:       time.Now().UTC(),
+	}
+}
+
+type apiError struct {
+	Object   string `json:"object"`
+	Location string `json:"location"`
+	Code     string `json:"code"`
+	Message  string `json:"message"`
+}
@@ -0,0 +1,214 @@
+package fakeomise
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const dateLayout = "2006-01-02"
+
+// scheduleStatusKeys are the events fired when a schedule is scripted to a status
+var scheduleStatusKeys = map[string]string{
+	"expiring":  "schedule.expiring",
+	"expired":   "schedule.expire",
+	"suspended": "schedule.suspend",
+}
+
+func (s *Server) createSchedule(c *fiber.Ctx) error {
+	var b struct {
+		Every     int        `json:"every"`
+		Period    string     `json:"period"`
+		StartDate string     `json:"start_date"`
+		EndDate   string     `json:"end_date"`
+		On        scheduleOn `json:"on"`
+		Charge    struct {
+			Customer    string  `json:"customer"`
+			Amount      int64   `json:"amount"`
+			Currency    string  `json:"currency"`
+			Card        *string `json:"card"`
+			Description *string `json:"description"`
+		} `json:"charge"`
+	}
+	if err := c.BodyParser(&b); err != nil {
+		return omiseError(c, http.StatusBadRequest, "bad_request", err.Error())
+	}
+
+	now := time.Now().UTC()
+	if b.StartDate == "" {
+		b.StartDate = now.Format(dateLayout)
+	}
+	start, errStart := time.Parse(dateLayout, b.StartDate)
+	end, errEnd := time.Parse(dateLayout, b.EndDate)
+	if b.Every <= 0 || (b.Period != "day" && b.Period != "week" && b.Period != "month") || errStart != nil || errEnd != nil || !end.After(start) {
+		return omiseError(c, http.StatusBadRequest, "invalid_schedule", "every, period, start date and end date are invalid")
+	}
+	if b.Charge.Amount <= 0 {
+		return omiseError(c, http.StatusBadRequest, "invalid_schedule", "charge amount is invalid")
+	}
+	if _, ok := s.store.customer(b.Charge.Customer); !ok {
+		return omiseError(c, http.StatusNotFound, "not_found", "customer was not found")
+	}
+
+	currency := b.Charge.Currency
+	if currency == "" {
+		currency = "thb"
+	}
+
+	sc := schedule{
+		Object:    "schedule",
+		ID:        newID("schd"),
+		Status:    "active",
+		Every:     b.Every,
+		Period:    b.Period,
+		On:        b.On,
+		InWords:   fmt.Sprintf("Every %d %s(s)", b.Every, b.Period),
+		StartDate: b.StartDate,
+		EndDate:   b.EndDate,
+		Charge: scheduleCharge{
+			Amount:      b.Charge.Amount,
+			Currency:    currency,
+			Description: b.Charge.Description,
+			Customer:    b.Charge.Customer,
+			Card:        b.Charge.Card,
+		},
+		NextOccurrences: []string{b.StartDate},
+		CreatedAt:       now,
+	}
+	sc.Location = "/schedules/" + sc.ID
+	sc.Occurrences = newList(sc.Location+"/occurrences", []interface{}{}, 0)
+
+	s.store.putSchedule(sc)
+	s.fire("schedule.create", sc)
+
+	return c.JSON(sc)
+}
+
+func (s *Server) retrieveSchedule(c *fiber.Ctx) error {
+	sc, ok := s.store.schedule(c.Params("scheduleID"))
+	if !ok {
+		return omiseError(c, http.StatusNotFound, "not_found", "schedule was not found")
+	}
+
+	return c.JSON(sc)
+}
+
+func (s *Server) destroySchedule(c *fiber.Ctx) error {
+	sc, err := s.store.updateSchedule(c.Params("scheduleID"), func(sc *schedule) error {
+		if sc.Status == "deleted" || sc.Status == "expired" {
+			return fmt.Errorf("schedule is already %s", sc.Status)
+		}
+
+		sc.Status = "deleted"
+		sc.NextOccurrences = []string{}
+
+		return nil
+	})
+	if err == errNotFound {
+		return omiseError(c, http.StatusNotFound, "not_found", "schedule was not found")
+	}
+	if err != nil {
+		return omiseError(c, http.StatusBadRequest, "invalid_schedule", err.Error())
+	}
+
+	s.fire("schedule.destroy", sc)
+
+	return c.JSON(sc)
+}
+
+// scriptScheduleCharge charges an occurrence of an active schedule now, with the card of the
+// schedule or the default card of its customer
+func (s *Server) scriptScheduleCharge(c *fiber.Ctx) error {
+	sc, ok := s.store.schedule(c.Params("scheduleID"))
+	if !ok {
+		return omiseError(c, http.StatusNotFound, "not_found", "schedule was not found")
+	}
+	if sc.Status != "active" && sc.Status != "expiring" {
+		return omiseError(c, http.StatusBadRequest, "invalid_schedule", "schedule is "+sc.Status)
+	}
+
+	var cardID string
+	if sc.Charge.Card != nil {
+		cardID = *sc.Charge.Card
+	}
+	cd, err := s.chargeCard(sc.Charge.Customer, cardID)
+	if err != nil {
+		return omiseError(c, http.StatusNotFound, "not_found", "card was not found")
+	}
+
+	ch := s.chargeNow(sc.Charge.Amount, sc.Charge.Currency, cd, func(ch *charge) {
+		ch.Description = sc.Charge.Description
+		ch.Customer = &sc.Charge.Customer
+		ch.Schedule = &sc.ID
+	})
+
+	return c.JSON(ch)
+}
+
+func (s *Server) scriptScheduleStatus(c *fiber.Ctx) error {
+	var b struct {
+		Status string `json:"status"`
+	}
+	if err := c.BodyParser(&b); err != nil {
+		return omiseError(c, http.StatusBadRequest, "bad_request", err.Error())
+	}
+
+	key, ok := scheduleStatusKeys[b.Status]
+	if !ok {
+		return omiseError(c, http.StatusBadRequest, "invalid_schedule", fmt.Sprintf("unknown status %q", b.Status))
+	}
+
+	sc, err := s.store.updateSchedule(c.Params("scheduleID"), func(sc *schedule) error {
+		if sc.Status != "active" && sc.Status != "expiring" {
+			return errors.New("schedule is " + sc.Status)
+		}
+
+		sc.Status = b.Status
+		if b.Status != "expiring" {
+			sc.NextOccurrences = []string{}
+		}
+
+		return nil
+	})
+	if err == errNotFound {
+		return omiseError(c, http.StatusNotFound, "not_found", "schedule was not found")
+	}
+	if err != nil {
+		return omiseError(c, http.StatusBadRequest, "invalid_schedule", err.Error())
+	}
+
+	s.fire(key, sc)
+
+	return c.JSON(sc)
+}
+
+// chargeNow creates a card charge that is captured right away, set fills in what the charge
+// was made for
+func (s *Server) chargeNow(amount int64, currency string, cd card, set func(ch *charge)) charge {
+	now := time.Now().UTC()
+	ch := charge{
+		Object:    "charge",
+		ID:        newID("chrg"),
+		Amount:    amount,
+		Currency:  strings.ToUpper(currency),
+		Status:    "pending",
+		Capture:   true,
+		Metadata:  map[string]interface{}{},
+		CreatedAt: now,
+		ExpiresAt: now.Add(24 * time.Hour),
+	}
+	ch.Location = "/charges/" + ch.ID
+	set(&ch)
+	authorizeCard(&ch, cd, now)
+
+	s.store.putCharge(ch)
+	ch, _ = s.store.charge(ch.ID)
+
+	s.fire("charge.create", ch)
+
+	return ch
+}
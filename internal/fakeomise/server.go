@@ -0,0 +1,530 @@
+package fakeomise
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"exam-payment-service/internal/webhooksim"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+var (
+	errNotFound  = errors.New("not found")
+	errTokenUsed = errors.New("token was already used")
+)
+
+// Charge outcomes that can be scripted
+const (
+	OutcomeSuccessful = "successful"
+	OutcomeFailed     = "failed"
+	OutcomeExpired    = "expired"
+)
+
+type Config struct {
+	// WebhookURL receives the events, no webhooks are sent when empty
+	WebhookURL string
+	// PublicURL is the base URL of this server as seen by browsers, used in authorize URIs
+	PublicURL string
+	// WebhookSecret signs the webhooks with the Omise-Signature headers, they are unsigned when empty
+	WebhookSecret []byte
+	Log           *zap.SugaredLogger
+}
+
+// Server implements the parts of the Omise API used by the payment server for tokens, sources,
+// charges, refunds, customers, schedules, links, the capability and events, plus the offsite
+// authorize page
+type Server struct {
+	cfg    Config
+	store  *store
+	app    *fiber.App
+	client *http.Client
+}
+
+func New(cfg Config) *Server {
+	s := &Server{
+		cfg:    cfg,
+		store:  newStore(),
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+
+	// Immutable as IDs taken from params are kept as map keys
+	f := fiber.New(fiber.Config{
+		DisableStartupMessage: true,
+		Immutable:             true,
+	})
+
+	f.Post("/sources", s.authenticate, s.createSource)
+	f.Get("/sources/:sourceID", s.authenticate, s.retrieveSource)
+	f.Post("/charges", s.authenticate, s.createCharge)
+	f.Get("/charges", s.authenticate, s.listCharges)
+	f.Get("/charges/:chargeID", s.authenticate, s.retrieveCharge)
+	f.Post("/charges/:chargeID/capture", s.authenticate, s.captureCharge)
+	f.Post("/charges/:chargeID/reverse", s.authenticate, s.reverseCharge)
+	f.Post("/charges/:chargeID/refunds", s.authenticate, s.createRefund)
+	f.Get("/charges/:chargeID/refunds", s.authenticate, s.listRefunds)
+	f.Post("/tokens", s.authenticate, s.createToken)
+	f.Post("/customers", s.authenticate, s.createCustomer)
+	f.Get("/customers/:customerID", s.authenticate, s.retrieveCustomer)
+	f.Patch("/customers/:customerID", s.authenticate, s.updateCustomer)
+	f.Post("/schedules", s.authenticate, s.createSchedule)
+	f.Get("/schedules/:scheduleID", s.authenticate, s.retrieveSchedule)
+	f.Delete("/schedules/:scheduleID", s.authenticate, s.destroySchedule)
+	f.Post("/links", s.authenticate, s.createLink)
+	f.Get("/capability", s.authenticate, s.retrieveCapability)
+	f.Get("/events", s.authenticate, s.listEvents)
+	f.Get("/events/:eventID", s.authenticate, s.retrieveEvent)
+
+	// Offsite authorize flow, opened by the customer's browser
+	f.Get("/offsites/:chargeID/pay", s.offsitePage)
+	f.Get("/offsites/:chargeID/complete", s.offsiteComplete)
+
+	// Scripting for tests
+	f.Post("/_fake/charges/:chargeID/complete", s.scriptComplete)
+	f.Post("/_fake/schedules/:scheduleID/charge", s.scriptScheduleCharge)
+	f.Post("/_fake/schedules/:scheduleID/status", s.scriptScheduleStatus)
+	f.Post("/_fake/links/:linkID/pay", s.scriptLinkPay)
+
+	s.app = f
+
+	return s
+}
+
+func (s *Server) App() *fiber.App {
+	return s.app
+}
+
+func (s *Server) Listen(address string) error {
+	return s.app.Listen(address)
+}
+
+func omiseError(c *fiber.Ctx, status int, code string, message string) error {
+	return c.Status(status).JSON(apiError{
+		Object:   "error",
+		Location: "https://www.omise.co/api-errors#" + code,
+		Code:     code,
+		Message:  message,
+	})
+}
+
+// authenticate requires the public key for creating sources and tokens and the secret key otherwise
+func (s *Server) authenticate(c *fiber.Ctx) error {
+	key, _, ok := basicAuth(c)
+
+	prefix := "skey_"
+	if c.Method() == http.MethodPost && (c.Path() == "/sources" || c.Path() == "/tokens") {
+		prefix = "pkey_"
+	}
+
+	if !ok || !strings.HasPrefix(key, prefix) {
+		return omiseError(c, http.StatusUnauthorized, "authentication_failure", "authentication failed")
+	}
+
+	return c.Next()
+}
+
+func basicAuth(c *fiber.Ctx) (string, string, bool) {
+	r := http.Request{Header: http.Header{}}
+	r.Header.Set("Authorization", c.Get(fiber.HeaderAuthorization))
+
+	return r.BasicAuth()
+}
+
+func (s *Server) createSource(c *fiber.Ctx) error {
+	var b struct {
+		Type     string `json:"type"`
+		Amount   int64  `json:"amount"`
+		Currency string `json:"currency"`
+	}
+	if err := c.BodyParser(&b); err != nil {
+		return omiseError(c, http.StatusBadRequest, "bad_request", err.Error())
+	}
+
+	if b.Type == "" || b.Amount <= 0 || b.Currency == "" {
+		return omiseError(c, http.StatusBadRequest, "invalid_source", "type, amount and currency are required")
+	}
+
+	src := source{
+		Object:       "source",
+		ID:           newID("src"),
+		Type:         b.Type,
+		Flow:         "redirect",
+		Amount:       b.Amount,
+		Currency:     strings.ToUpper(b.Currency),
+		ChargeStatus: "unknown",
+		CreatedAt:    time.Now().UTC(),
+	}
+	src.Location = "/sources/" + src.ID
+
+	s.store.putSource(src)
+
+	return c.JSON(src)
+}
+
+func (s *Server) retrieveSource(c *fiber.Ctx) error {
+	src, ok := s.store.source(c.Params("sourceID"))
+	if !ok {
+		return omiseError(c, http.StatusNotFound, "not_found", "source was not found")
+	}
+
+	return c.JSON(src)
+}
+
+func (s *Server) createCharge(c *fiber.Ctx) error {
+	var b struct {
+		Amount      int64                  `json:"amount"`
+		Currency    string                 `json:"currency"`
+		Source      string                 `json:"source"`
+		Card        string                 `json:"card"`
+		Customer    string                 `json:"customer"`
+		Capture     *bool                  `json:"capture"`
+		ReturnURI   string                 `json:"return_uri"`
+		Description *string                `json:"description"`
+		Metadata    map[string]interface{} `json:"metadata"`
+		ExpiresAt   *time.Time             `json:"expires_at"`
+	}
+	if err := c.BodyParser(&b); err != nil {
+		return omiseError(c, http.StatusBadRequest, "bad_request", err.Error())
+	}
+
+	now := time.Now().UTC()
+	ch := charge{
+		Object:      "charge",
+		ID:          newID("chrg"),
+		Amount:      b.Amount,
+		Currency:    strings.ToUpper(b.Currency),
+		Description: b.Description,
+		Status:      "pending",
+		Capture:     b.Capture == nil || *b.Capture,
+		ReturnURI:   b.ReturnURI,
+		Metadata:    b.Metadata,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(24 * time.Hour),
+	}
+	if b.ExpiresAt != nil {
+		ch.ExpiresAt = b.ExpiresAt.UTC()
+	}
+
+	if b.Source == "" && (b.Card != "" || b.Customer != "") {
+		if b.Amount <= 0 || b.Currency == "" {
+			return omiseError(c, http.StatusBadRequest, "invalid_charge", "amount and currency are required")
+		}
+
+		cd, err := s.chargeCard(b.Customer, b.Card)
+		if err == errNotFound {
+			return omiseError(c, http.StatusNotFound, "not_found", "card was not found")
+		}
+		if err != nil {
+			return omiseError(c, http.StatusBadRequest, "invalid_card", err.Error())
+		}
+		if b.Customer != "" {
+			ch.Customer = &b.Customer
+		}
+		authorizeCard(&ch, cd, now)
+	} else {
+		src, ok := s.store.source(b.Source)
+		if !ok {
+			return omiseError(c, http.StatusNotFound, "not_found", "source was not found")
+		}
+
+		if b.Amount != src.Amount || !strings.EqualFold(b.Currency, src.Currency) {
+			return omiseError(c, http.StatusBadRequest, "invalid_charge", "amount and currency must match the source")
+		}
+
+		src.ChargeStatus = "pending"
+		if src.Type == sourceTypeBillPayment {
+			// Paid at the counter with the printed references, there is nothing to authorize
+			src.Flow = "offline"
+			src.References = newReferences(src.Amount, ch.ExpiresAt)
+		} else {
+			ch.AuthorizeURI = s.cfg.PublicURL + "/offsites/" + ch.ID + "/pay"
+		}
+		s.store.putSource(src)
+
+		ch.Source = &src
+	}
+
+	ch.Location = "/charges/" + ch.ID
+	if ch.Metadata == nil {
+		ch.Metadata = map[string]interface{}{}
+	}
+
+	s.store.putCharge(ch)
+	ch, _ = s.store.charge(ch.ID)
+
+	s.fire("charge.create", ch)
+
+	return c.JSON(ch)
+}
+
+func (s *Server) listCharges(c *fiber.Ctx) error {
+	charges := s.store.listCharges()
+
+	return c.JSON(newList("/charges", charges, len(charges)))
+}
+
+func (s *Server) retrieveCharge(c *fiber.Ctx) error {
+	ch, ok := s.store.charge(c.Params("chargeID"))
+	if !ok {
+		return omiseError(c, http.StatusNotFound, "not_found", "charge was not found")
+	}
+
+	return c.JSON(ch)
+}
+
+func (s *Server) createRefund(c *fiber.Ctx) error {
+	var b struct {
+		Amount int64 `json:"amount"`
+	}
+	if err := c.BodyParser(&b); err != nil {
+		return omiseError(c, http.StatusBadRequest, "bad_request", err.Error())
+	}
+
+	var r refund
+	ch, err := s.store.updateCharge(c.Params("chargeID"), func(ch *charge) error {
+		if ch.Status != OutcomeSuccessful {
+			return errors.New("charge is not refundable")
+		}
+		if b.Amount <= 0 || b.Amount > ch.Amount-ch.RefundedAmount {
+			return errors.New("refund amount is invalid")
+		}
+
+		ch.RefundedAmount += b.Amount
+
+		r = refund{
+			Object:      "refund",
+			ID:          newID("rfnd"),
+			Amount:      b.Amount,
+			Currency:    ch.Currency,
+			Charge:      ch.ID,
+			Transaction: newID("trxn"),
+			CreatedAt:   time.Now().UTC(),
+		}
+		r.Location = "/charges/" + ch.ID + "/refunds/" + r.ID
+
+		return nil
+	})
+	if err == errNotFound {
+		return omiseError(c, http.StatusNotFound, "not_found", "charge was not found")
+	}
+	if err != nil {
+		return omiseError(c, http.StatusBadRequest, "invalid_refund", err.Error())
+	}
+
+	s.store.addRefund(r)
+	s.fire("refund.create", r)
+
+	s.logger().Infow("Refund created", "charge_id", ch.ID, "refund_id", r.ID, "amount", r.Amount)
+
+	return c.JSON(r)
+}
+
+func (s *Server) listRefunds(c *fiber.Ctx) error {
+	chargeID := c.Params("chargeID")
+	if _, ok := s.store.charge(chargeID); !ok {
+		return omiseError(c, http.StatusNotFound, "not_found", "charge was not found")
+	}
+
+	refunds := s.store.listRefunds(chargeID)
+
+	return c.JSON(newList("/charges/"+chargeID+"/refunds", refunds, len(refunds)))
+}
+
+func (s *Server) listEvents(c *fiber.Ctx) error {
+	events := s.store.listEvents()
+
+	return c.JSON(newList("/events", events, len(events)))
+}
+
+func (s *Server) retrieveEvent(c *fiber.Ctx) error {
+	e, ok := s.store.event(c.Params("eventID"))
+	if !ok {
+		return omiseError(c, http.StatusNotFound, "not_found", "event was not found")
+	}
+
+	return c.JSON(e)
+}
+
+var offsiteTemplate = template.Must(template.New("offsite").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Fake Omise offsite payment</title></head>
+<body>
+<h1>Pay {{.Amount}} {{.Currency}}</h1>
+<p>Charge {{.ID}}</p>
+<ul>
+<li><a href="/offsites/{{.ID}}/complete?status=successful">Authorize</a></li>
+<li><a href="/offsites/{{.ID}}/complete?status=failed">Reject</a></li>
+<li><a href="/offsites/{{.ID}}/complete?status=expired">Let it expire</a></li>
+</ul>
+</body>
+</html>
+`))
+
+func (s *Server) offsitePage(c *fiber.Ctx) error {
+	ch, ok := s.store.charge(c.Params("chargeID"))
+	if !ok {
+		return c.Status(http.StatusNotFound).SendString("charge not found")
+	}
+
+	var buf bytes.Buffer
+	if err := offsiteTemplate.Execute(&buf, ch); err != nil {
+		return err
+	}
+
+	c.Type("html")
+	return c.Send(buf.Bytes())
+}
+
+func (s *Server) offsiteComplete(c *fiber.Ctx) error {
+	ch, err := s.complete(c.Params("chargeID"), c.Query("status"), "")
+	if err != nil {
+		return c.Status(http.StatusBadRequest).SendString(err.Error())
+	}
+
+	if ch.ReturnURI == "" {
+		return c.SendString("payment " + ch.Status)
+	}
+
+	return c.Redirect(ch.ReturnURI, http.StatusFound)
+}
+
+func (s *Server) scriptComplete(c *fiber.Ctx) error {
+	var b struct {
+		Status      string `json:"status"`
+		FailureCode string `json:"failureCode"`
+	}
+	if err := c.BodyParser(&b); err != nil {
+		return omiseError(c, http.StatusBadRequest, "bad_request", err.Error())
+	}
+
+	ch, err := s.complete(c.Params("chargeID"), b.Status, b.FailureCode)
+	if err == errNotFound {
+		return omiseError(c, http.StatusNotFound, "not_found", "charge was not found")
+	}
+	if err != nil {
+		return omiseError(c, http.StatusBadRequest, "invalid_charge", err.Error())
+	}
+
+	return c.JSON(ch)
+}
+
+// complete moves a pending charge to outcome and fires the matching webhook
+func (s *Server) complete(chargeID string, outcome string, failureCode string) (charge, error) {
+	ch, err := s.store.updateCharge(chargeID, func(ch *charge) error {
+		if ch.Status != "pending" {
+			return fmt.Errorf("charge is already %s", ch.Status)
+		}
+
+		now := time.Now().UTC()
+
+		switch outcome {
+		case OutcomeSuccessful:
+			txn := newID("trxn")
+			ch.Status = OutcomeSuccessful
+			ch.Authorized = true
+			ch.Paid = true
+			ch.Transaction = &txn
+			ch.PaidAt = &now
+		case OutcomeFailed:
+			if failureCode == "" {
+				failureCode = "payment_rejected"
+			}
+			message := "the payment was rejected"
+			ch.Status = OutcomeFailed
+			ch.FailureCode = &failureCode
+			ch.FailureMessage = &message
+		case OutcomeExpired:
+			ch.Status = OutcomeExpired
+			ch.Expired = true
+			ch.ExpiredAt = &now
+		default:
+			return fmt.Errorf("unknown outcome %q", outcome)
+		}
+
+		// Copy on write, earlier snapshots of the charge share the source
+		if ch.Source != nil {
+			src := *ch.Source
+			src.ChargeStatus = ch.Status
+			ch.Source = &src
+		}
+
+		return nil
+	})
+	if err != nil {
+		return charge{}, err
+	}
+
+	key := "charge.complete"
+	if outcome == OutcomeExpired {
+		key = "charge.expire"
+	}
+	s.fire(key, ch)
+
+	s.logger().Infow("Charge completed", "charge_id", ch.ID, "status", ch.Status)
+
+	return ch, nil
+}
+
+// fire records an event and delivers it to the webhook URL in the background
+func (s *Server) fire(key string, data interface{}) event {
+	e := event{
+		Object:    "event",
+		ID:        newID("evnt"),
+		Key:       key,
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	}
+	e.Location = "/events/" + e.ID
+
+	s.store.addEvent(e)
+
+	if s.cfg.WebhookURL != "" {
+		go s.deliver(e)
+	}
+
+	return e
+}
+
+func (s *Server) deliver(e event) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		s.logger().Errorw("Webhook marshal error", "error", err, "event_id", e.ID)
+		return
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.cfg.WebhookURL, bytes.NewReader(b))
+	if err != nil {
+		s.logger().Errorw("Webhook request error", "error", err, "event_id", e.ID)
+		return
+	}
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	if len(s.cfg.WebhookSecret) > 0 {
+		now := time.Now()
+		req.Header.Set(webhooksim.HeaderSignatureTimestamp, strconv.FormatInt(now.Unix(), 10))
+		req.Header.Set(webhooksim.HeaderSignature, webhooksim.Sign(s.cfg.WebhookSecret, now, b))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		s.logger().Errorw("Webhook delivery error", "error", err, "event_id", e.ID, "key", e.Key)
+		return
+	}
+	resp.Body.Close()
+
+	s.logger().Infow("Webhook delivered", "event_id", e.ID, "key", e.Key, "status", resp.StatusCode)
+}
+
+func (s *Server) logger() *zap.SugaredLogger {
+	if s.cfg.Log == nil {
+		return zap.NewNop().Sugar()
+	}
+
+	return s.cfg.Log
+}
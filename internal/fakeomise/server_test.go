@@ -0,0 +1,483 @@
+package fakeomise
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"exam-payment-service/internal/webhooksim"
+	"exam-payment-service/pkg/omiseprovider"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/omise/omise-go"
+	"github.com/omise/omise-go/operations"
+	"github.com/stretchr/testify/assert"
+)
+
+var testWebhookSecret = []byte("secret")
+
+// webhookRecorder records the events with a valid signature
+type webhookRecorder struct {
+	mu     sync.Mutex
+	events []event
+}
+
+func (w *webhookRecorder) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	payload, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	ts, err := strconv.ParseInt(r.Header.Get(webhooksim.HeaderSignatureTimestamp), 10, 64)
+	if err != nil || r.Header.Get(webhooksim.HeaderSignature) != webhooksim.Sign(testWebhookSecret, time.Unix(ts, 0), payload) {
+		rw.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	var e event
+	if err := json.Unmarshal(payload, &e); err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	w.mu.Lock()
+	w.events = append(w.events, e)
+	w.mu.Unlock()
+}
+
+func (w *webhookRecorder) keys() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	keys := make([]string, len(w.events))
+	for i, e := range w.events {
+		keys[i] = e.Key
+	}
+
+	return keys
+}
+
+func startFake(t *testing.T) (string, *webhookRecorder) {
+	webhook := &webhookRecorder{}
+	webhookSrv := httptest.NewServer(webhook)
+	t.Cleanup(webhookSrv.Close)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := "http://" + ln.Addr().String()
+
+	s := New(Config{WebhookURL: webhookSrv.URL, PublicURL: url, WebhookSecret: testWebhookSecret})
+	go func() {
+		_ = s.App().Listener(ln)
+	}()
+	// Shutdown waits for keep-alive connections, closing the listener is enough here
+	t.Cleanup(func() {
+		_ = ln.Close()
+	})
+
+	return url, webhook
+}
+
+// endpointVault is where omise-go creates tokens
+const endpointVault = "https://vault.omise.co"
+
+func newClient(t *testing.T, url string) *omise.Client {
+	oc, err := omise.NewClient("pkey_test_fake", "skey_test_fake")
+	if err != nil {
+		t.Fatal(err)
+	}
+	oc.Endpoints[omiseprovider.EndpointAPI] = url
+	oc.Endpoints[endpointVault] = url
+
+	return oc
+}
+
+func createToken(t *testing.T, oc *omise.Client, number string) string {
+	token := &omise.Token{}
+	err := oc.Do(token, &operations.CreateToken{
+		Name:            "Somchai Prasert",
+		Number:          number,
+		ExpirationMonth: 12,
+		ExpirationYear:  time.Now().Year() + 1,
+		SecurityCode:    "123",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return token.ID
+}
+
+func post(t *testing.T, url string, body interface{}) *http.Response {
+	b, _ := json.Marshal(body)
+	resp, err := http.Post(url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		resp.Body.Close()
+	})
+
+	return resp
+}
+
+func decode(t *testing.T, resp *http.Response, v interface{}) {
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func waitForKeys(t *testing.T, webhook *webhookRecorder, expectedKeys ...string) {
+	assert.Eventually(t, func() bool {
+		return len(webhook.keys()) == len(expectedKeys)
+	}, time.Second, 10*time.Millisecond)
+	assert.ElementsMatch(t, expectedKeys, webhook.keys())
+}
+
+func TestChargeFlow(t *testing.T) {
+	ctx := context.Background()
+
+	testCases := []struct {
+		name           string
+		outcome        string
+		expectedStatus omise.ChargeStatus
+		expectedKeys   []string
+	}{
+		{
+			name:           "Successful",
+			outcome:        OutcomeSuccessful,
+			expectedStatus: omise.ChargeSuccessful,
+			expectedKeys:   []string{"charge.create", "charge.complete"},
+		},
+		{
+			name:           "Failed",
+			outcome:        OutcomeFailed,
+			expectedStatus: omise.ChargeFailed,
+			expectedKeys:   []string{"charge.create", "charge.complete"},
+		},
+		{
+			name:           "Expired",
+			outcome:        OutcomeExpired,
+			expectedStatus: omise.ChargeStatus("expired"),
+			expectedKeys:   []string{"charge.create", "charge.expire"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			url, webhook := startFake(t)
+
+			oc, err := omise.NewClient("pkey_test_fake", "skey_test_fake")
+			if err != nil {
+				t.Fatal(err)
+			}
+			oc.Endpoints[omiseprovider.EndpointAPI] = url
+			op := omiseprovider.New(oc)
+
+			source, err := op.CreateSource(ctx, operations.CreateSource{
+				Amount:   2000,
+				Currency: "thb",
+				Type:     "internet_banking_scb",
+			})
+			assert.NoError(t, err)
+
+			charge, err := op.CreateCharge(ctx, omiseprovider.CreateCharge{CreateCharge: operations.CreateCharge{
+				Amount:    2000,
+				Currency:  "thb",
+				ReturnURI: "https://example.com",
+				Source:    source.ID,
+			}})
+			assert.NoError(t, err)
+			assert.Equal(t, omise.ChargePending, charge.Status)
+			assert.Equal(t, url+"/offsites/"+charge.ID+"/pay", charge.AuthorizeURI)
+
+			body, _ := json.Marshal(map[string]string{"status": tc.outcome})
+			resp, err := http.Post(url+"/_fake/charges/"+charge.ID+"/complete", "application/json", bytes.NewReader(body))
+			assert.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+			charge, err = op.RetrieveCharge(ctx, operations.RetrieveCharge{ChargeID: charge.ID})
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedStatus, charge.Status)
+
+			assert.Eventually(t, func() bool {
+				return len(webhook.keys()) == len(tc.expectedKeys)
+			}, time.Second, 10*time.Millisecond)
+			assert.ElementsMatch(t, tc.expectedKeys, webhook.keys())
+		})
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	url, _ := startFake(t)
+
+	oc, err := omise.NewClient("pkey_test_fake", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	oc.Endpoints[omiseprovider.EndpointAPI] = url
+	op := omiseprovider.New(oc)
+
+	_, err = op.CreateCharge(context.Background(), omiseprovider.CreateCharge{CreateCharge: operations.CreateCharge{Amount: 2000, Currency: "thb"}})
+
+	oErr, ok := err.(*omise.Error)
+	if assert.True(t, ok) {
+		assert.Equal(t, "authentication_failure", oErr.Code)
+	}
+}
+
+// authorizedCharges are the calls of the provider on authorized charges
+type authorizedCharges interface {
+	CaptureCharge(ctx context.Context, captureCharge omiseprovider.CaptureCharge) (omise.Charge, error)
+	ReverseCharge(ctx context.Context, reverseCharge operations.ReverseCharge) (omise.Charge, error)
+}
+
+func TestCardCharge(t *testing.T) {
+	ctx := context.Background()
+
+	testCases := []struct {
+		name           string
+		number         string
+		capture        bool
+		then           func(op authorizedCharges, chargeID string) (omise.Charge, error)
+		expectedStatus omise.ChargeStatus
+		expectedKeys   []string
+	}{
+		{
+			name:           "Captured",
+			number:         "4242424242424242",
+			capture:        true,
+			expectedStatus: omise.ChargeSuccessful,
+			expectedKeys:   []string{"charge.create"},
+		},
+		{
+			name:           "Declined",
+			number:         "4111111111140011",
+			capture:        true,
+			expectedStatus: omise.ChargeFailed,
+			expectedKeys:   []string{"charge.create"},
+		},
+		{
+			name:   "Authorized then captured",
+			number: "4242424242424242",
+			then: func(op authorizedCharges, chargeID string) (omise.Charge, error) {
+				return op.CaptureCharge(ctx, omiseprovider.CaptureCharge{
+					CaptureCharge: operations.CaptureCharge{ChargeID: chargeID},
+					CaptureAmount: 1000,
+				})
+			},
+			expectedStatus: omise.ChargeSuccessful,
+			expectedKeys:   []string{"charge.create", "charge.capture"},
+		},
+		{
+			name:   "Authorized then reversed",
+			number: "5555555555554444",
+			then: func(op authorizedCharges, chargeID string) (omise.Charge, error) {
+				return op.ReverseCharge(ctx, operations.ReverseCharge{ChargeID: chargeID})
+			},
+			expectedStatus: omise.ChargeReversed,
+			expectedKeys:   []string{"charge.create", "charge.reverse"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			url, webhook := startFake(t)
+			oc := newClient(t, url)
+			op := omiseprovider.New(oc)
+
+			charge, err := op.CreateCharge(ctx, omiseprovider.CreateCharge{CreateCharge: operations.CreateCharge{
+				Amount:      2000,
+				Currency:    "thb",
+				Card:        createToken(t, oc, tc.number),
+				DontCapture: !tc.capture,
+			}})
+			assert.NoError(t, err)
+			if assert.NotNil(t, charge.Card) {
+				assert.Equal(t, tc.number[len(tc.number)-4:], charge.Card.LastDigits)
+			}
+
+			if tc.then != nil {
+				assert.Equal(t, omise.ChargePending, charge.Status)
+				assert.True(t, charge.Authorized)
+
+				charge, err = tc.then(op, charge.ID)
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.expectedStatus, charge.Status)
+
+			waitForKeys(t, webhook, tc.expectedKeys...)
+		})
+	}
+}
+
+func TestTokenUsedOnce(t *testing.T) {
+	url, _ := startFake(t)
+	oc := newClient(t, url)
+	op := omiseprovider.New(oc)
+
+	token := createToken(t, oc, "4242424242424242")
+	_, err := op.CreateCustomer(context.Background(), operations.CreateCustomer{Card: token})
+	assert.NoError(t, err)
+
+	_, err = op.CreateCharge(context.Background(), omiseprovider.CreateCharge{CreateCharge: operations.CreateCharge{Amount: 2000, Currency: "thb", Card: token}})
+
+	oErr, ok := err.(*omise.Error)
+	if assert.True(t, ok) {
+		assert.Equal(t, "invalid_card", oErr.Code)
+	}
+}
+
+func TestBillPaymentCharge(t *testing.T) {
+	ctx := context.Background()
+	url, _ := startFake(t)
+	op := omiseprovider.New(newClient(t, url))
+
+	source, err := op.CreateSource(ctx, operations.CreateSource{Amount: 20000, Currency: "thb", Type: "bill_payment_tesco_lotus"})
+	assert.NoError(t, err)
+
+	charge, err := op.CreateBillPaymentCharge(ctx, operations.CreateCharge{Amount: 20000, Currency: "thb", Source: source.ID})
+	assert.NoError(t, err)
+	assert.Empty(t, charge.AuthorizeURI)
+	if assert.NotNil(t, charge.Source) && assert.NotNil(t, charge.Source.References) {
+		refs := charge.Source.References
+		assert.Len(t, refs.ReferenceNumber1, 15)
+		assert.Len(t, refs.ReferenceNumber2, 14)
+		assert.Equal(t, "|"+billPaymentTaxID+"\r"+refs.ReferenceNumber1+"\r"+refs.ReferenceNumber2+"\r20000", refs.Barcode)
+		assert.WithinDuration(t, time.Now().Add(24*time.Hour), refs.ExpiresAt, time.Minute)
+	}
+}
+
+func TestChargeExpiresAt(t *testing.T) {
+	ctx := context.Background()
+	url, _ := startFake(t)
+	op := omiseprovider.New(newClient(t, url))
+
+	source, err := op.CreateSource(ctx, operations.CreateSource{Amount: 2000, Currency: "thb", Type: "internet_banking_scb"})
+	assert.NoError(t, err)
+
+	expiresAt := time.Now().Add(10 * time.Minute).UTC().Truncate(time.Second)
+	created, err := op.CreateCharge(ctx, omiseprovider.CreateCharge{
+		CreateCharge: operations.CreateCharge{Amount: 2000, Currency: "thb", Source: source.ID},
+		ExpiresAt:    &expiresAt,
+	})
+	assert.NoError(t, err)
+
+	// omise.Charge has no expiry
+	req, _ := http.NewRequest(http.MethodGet, url+"/charges/"+created.ID, nil)
+	req.SetBasicAuth("skey_test_fake", "")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var ch charge
+	decode(t, resp, &ch)
+	assert.True(t, expiresAt.Equal(ch.ExpiresAt), ch.ExpiresAt)
+}
+
+func TestCustomerSchedule(t *testing.T) {
+	ctx := context.Background()
+	url, webhook := startFake(t)
+	oc := newClient(t, url)
+	op := omiseprovider.New(oc)
+
+	customer, err := op.CreateCustomer(ctx, operations.CreateCustomer{Email: "somchai@example.com", Card: createToken(t, oc, "4242424242424242")})
+	assert.NoError(t, err)
+
+	customer, err = op.UpdateCustomer(ctx, operations.UpdateCustomer{CustomerID: customer.ID, Card: createToken(t, oc, "5555555555554444")})
+	assert.NoError(t, err)
+
+	customer, err = op.RetrieveCustomer(ctx, operations.RetrieveCustomer{CustomerID: customer.ID})
+	assert.NoError(t, err)
+	if assert.NotNil(t, customer.Cards) && assert.Len(t, customer.Cards.Data, 2) {
+		assert.Equal(t, customer.Cards.Data[0].ID, customer.DefaultCard)
+	}
+
+	sched, err := op.CreateChargeSchedule(ctx, operations.CreateChargeSchedule{
+		Every:       1,
+		Period:      "month",
+		DaysOfMonth: []int{1},
+		StartDate:   time.Now().Format(dateLayout),
+		EndDate:     time.Now().AddDate(1, 0, 0).Format(dateLayout),
+		Customer:    customer.ID,
+		Amount:      20000,
+		Currency:    "thb",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "active", string(sched.Status))
+
+	resp := post(t, url+"/_fake/schedules/"+sched.ID+"/charge", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var ch charge
+	decode(t, resp, &ch)
+	assert.Equal(t, OutcomeSuccessful, ch.Status)
+	if assert.NotNil(t, ch.Schedule) {
+		assert.Equal(t, sched.ID, *ch.Schedule)
+	}
+	if assert.NotNil(t, ch.Card) {
+		assert.Equal(t, customer.DefaultCard, ch.Card.ID)
+	}
+
+	resp = post(t, url+"/_fake/schedules/"+sched.ID+"/status", map[string]string{"status": "expiring"})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	sched, err = op.DestroySchedule(ctx, operations.DestroySchedule{ScheduleID: sched.ID})
+	assert.NoError(t, err)
+	assert.Equal(t, "deleted", string(sched.Status))
+
+	waitForKeys(t, webhook, "schedule.create", "charge.create", "schedule.expiring", "schedule.destroy")
+}
+
+func TestLink(t *testing.T) {
+	ctx := context.Background()
+	url, webhook := startFake(t)
+	op := omiseprovider.New(newClient(t, url))
+
+	link, err := op.CreateLink(ctx, operations.CreateLink{Amount: 20000, Currency: "thb", Title: "Gift card"})
+	assert.NoError(t, err)
+	assert.Equal(t, url+"/links/"+link.ID, link.PaymentURI)
+
+	resp := post(t, url+"/_fake/links/"+link.ID+"/pay", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var ch charge
+	decode(t, resp, &ch)
+	assert.Equal(t, OutcomeSuccessful, ch.Status)
+	if assert.NotNil(t, ch.Link) {
+		assert.Equal(t, link.ID, *ch.Link)
+	}
+
+	// Links that are not multiple are paid once
+	resp = post(t, url+"/_fake/links/"+link.ID+"/pay", nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	waitForKeys(t, webhook, "charge.create")
+}
+
+func TestCapability(t *testing.T) {
+	url, _ := startFake(t)
+	op := omiseprovider.New(newClient(t, url))
+
+	capability, err := op.RetrieveCapability(context.Background(), operations.RetrieveCapability{})
+	assert.NoError(t, err)
+
+	var names []string
+	for _, m := range capability.PaymentMethods {
+		names = append(names, m.Name)
+	}
+	assert.Contains(t, names, "card")
+	assert.Contains(t, names, "bill_payment_tesco_lotus")
+}
@@ -0,0 +1,275 @@
+package fakeomise
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+)
+
+// store keeps every object in memory, objects are stored and returned by value
+type store struct {
+	mu      sync.Mutex
+	sources map[string]source
+	charges map[string]charge
+	refunds map[string][]refund
+	events  []event
+
+	tokens    map[string]token
+	customers map[string]customer
+	schedules map[string]schedule
+	links     map[string]link
+}
+
+func newStore() *store {
+	return &store{
+		sources: map[string]source{},
+		charges: map[string]charge{},
+		refunds: map[string][]refund{},
+
+		tokens:    map[string]token{},
+		customers: map[string]customer{},
+		schedules: map[string]schedule{},
+		links:     map[string]link{},
+	}
+}
+
+func newID(prefix string) string {
+	b := make([]byte, 10)
+	_, _ = rand.Read(b)
+
+	return prefix + "_test_" + hex.EncodeToString(b)
+}
+
+func (s *store) putSource(src source) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sources[src.ID] = src
+}
+
+func (s *store) source(id string) (source, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	src, ok := s.sources[id]
+	return src, ok
+}
+
+func (s *store) putCharge(ch charge) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.charges[ch.ID] = ch
+}
+
+// charge returns the charge with its refunds list filled in
+func (s *store) charge(id string) (charge, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ch, ok := s.charges[id]
+	if !ok {
+		return charge{}, false
+	}
+
+	refunds := append([]refund{}, s.refunds[id]...)
+	ch.Refunds = newList("/charges/"+id+"/refunds", refunds, len(refunds))
+
+	return ch, true
+}
+
+func (s *store) listCharges() []charge {
+	s.mu.Lock()
+	ids := make([]string, 0, len(s.charges))
+	for id := range s.charges {
+		ids = append(ids, id)
+	}
+	s.mu.Unlock()
+
+	charges := make([]charge, 0, len(ids))
+	for _, id := range ids {
+		if ch, ok := s.charge(id); ok {
+			charges = append(charges, ch)
+		}
+	}
+
+	return charges
+}
+
+// updateCharge applies fn to the stored charge atomically
+func (s *store) updateCharge(id string, fn func(ch *charge) error) (charge, error) {
+	s.mu.Lock()
+	ch, ok := s.charges[id]
+	if !ok {
+		s.mu.Unlock()
+		return charge{}, errNotFound
+	}
+
+	if err := fn(&ch); err != nil {
+		s.mu.Unlock()
+		return charge{}, err
+	}
+	s.charges[id] = ch
+	s.mu.Unlock()
+
+	ch, _ = s.charge(id)
+	return ch, nil
+}
+
+func (s *store) addRefund(r refund) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.refunds[r.Charge] = append(s.refunds[r.Charge], r)
+}
+
+func (s *store) listRefunds(chargeID string) []refund {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]refund{}, s.refunds[chargeID]...)
+}
+
+func (s *store) addEvent(e event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.events = append(s.events, e)
+}
+
+func (s *store) event(id string) (event, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, e := range s.events {
+		if e.ID == id {
+			return e, true
+		}
+	}
+
+	return event{}, false
+}
+
+func (s *store) listEvents() []event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]event{}, s.events...)
+}
+
+// useToken marks a token used and returns its card, a token is used once
+func (s *store) useToken(id string) (card, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.tokens[id]
+	if !ok {
+		return card{}, errNotFound
+	}
+	if t.Used {
+		return card{}, errTokenUsed
+	}
+
+	t.Used = true
+	s.tokens[id] = t
+
+	return t.Card, nil
+}
+
+func (s *store) putToken(t token) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tokens[t.ID] = t
+}
+
+func (s *store) putCustomer(c customer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.customers[c.ID] = c
+}
+
+func (s *store) customer(id string) (customer, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.customers[id]
+	return c, ok
+}
+
+// updateCustomer applies fn to the stored customer atomically
+func (s *store) updateCustomer(id string, fn func(c *customer) error) (customer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.customers[id]
+	if !ok {
+		return customer{}, errNotFound
+	}
+
+	if err := fn(&c); err != nil {
+		return customer{}, err
+	}
+	s.customers[id] = c
+
+	return c, nil
+}
+
+func (s *store) putSchedule(sc schedule) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.schedules[sc.ID] = sc
+}
+
+func (s *store) schedule(id string) (schedule, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sc, ok := s.schedules[id]
+	return sc, ok
+}
+
+// updateSchedule applies fn to the stored schedule atomically
+func (s *store) updateSchedule(id string, fn func(sc *schedule) error) (schedule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sc, ok := s.schedules[id]
+	if !ok {
+		return schedule{}, errNotFound
+	}
+
+	if err := fn(&sc); err != nil {
+		return schedule{}, err
+	}
+	s.schedules[id] = sc
+
+	return sc, nil
+}
+
+func (s *store) putLink(l link) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.links[l.ID] = l
+}
+
+// updateLink applies fn to the stored link atomically
+func (s *store) updateLink(id string, fn func(l *link) error) (link, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	l, ok := s.links[id]
+	if !ok {
+		return link{}, errNotFound
+	}
+
+	if err := fn(&l); err != nil {
+		return link{}, err
+	}
+	s.links[id] = l
+
+	return l, nil
+}
@@ -0,0 +1,104 @@
+package payment
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"time"
+)
+
+// NewAPIKey returns a random API key of the mode, bind it to a merchant with PutAPIKey
+func NewAPIKey(livemode bool) (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	prefix := "key_test_"
+	if livemode {
+		prefix = "key_live_"
+	}
+
+	return prefix + hex.EncodeToString(b), nil
+}
+
+func hashAPIKey(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(h[:])
+}
+
+// PutAPIKey binds the API key to the merchant and mode, requests with it are made for them
+func (s *MerchantStore) PutAPIKey(ctx context.Context, merchantID string, livemode bool, key string) error {
+	if key == "" {
+		return ErrInvalidAPIKey
+	}
+
+	var exists bool
+	err := s.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM merchants WHERE id = ?)", merchantID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrMerchantNotFound
+	}
+
+	_, err = s.db.ExecContext(
+		ctx,
+		"INSERT INTO api_keys (key_hash, merchant_id, livemode, created_at) VALUES (?, ?, ?, ?)",
+		hashAPIKey(key), merchantID, livemode, time.Now().UTC(),
+	)
+
+	return err
+}
+
+// RevokeAPIKey deletes the API key, requests with it are rejected from then on
+func (s *MerchantStore) RevokeAPIKey(ctx context.Context, key string) error {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM api_keys WHERE key_hash = ?", hashAPIKey(key))
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return ErrInvalidAPIKey
+	}
+
+	return nil
+}
+
+// AuthenticateAPIKey returns the merchant and mode the API key is bound to
+func (s *MerchantStore) AuthenticateAPIKey(ctx context.Context, key string) (merchantID string, livemode bool, err error) {
+	if key == "" {
+		return "", false, ErrInvalidAPIKey
+	}
+
+	err = s.db.QueryRowContext(
+		ctx,
+		"SELECT merchant_id, livemode FROM api_keys WHERE key_hash = ?",
+		hashAPIKey(key),
+	).Scan(&merchantID, &livemode)
+	if err == sql.ErrNoRows {
+		return "", false, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return "", false, ErrInternal.Wrap(err)
+	}
+
+	return merchantID, livemode, nil
+}
+
+// AuthenticateAPIKey returns the merchant and mode of a merchant's API key
+func (p Payment) AuthenticateAPIKey(ctx context.Context, key string) (merchantID string, livemode bool, err error) {
+	return p.merchants.AuthenticateAPIKey(ctx, key)
+}
+
+// PutAPIKey binds the API key to the merchant and mode, see MerchantStore.PutAPIKey
+func (p Payment) PutAPIKey(ctx context.Context, merchantID string, livemode bool, key string) error {
+	return p.merchants.PutAPIKey(ctx, merchantID, livemode, key)
+}
+
+// RevokeAPIKey deletes the API key, see MerchantStore.RevokeAPIKey
+func (p Payment) RevokeAPIKey(ctx context.Context, key string) error {
+	return p.merchants.RevokeAPIKey(ctx, key)
+}
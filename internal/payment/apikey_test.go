@@ -0,0 +1,51 @@
+package payment
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIKeys(t *testing.T) {
+	s, db := newTestMerchantStore(t)
+	ctx := context.Background()
+
+	assert.NoError(t, s.Put(ctx, Merchant{ID: "brand_a", Name: "Brand A"}))
+
+	live, err := NewAPIKey(true)
+	assert.NoError(t, err)
+	assert.Regexp(t, "^key_live_[0-9a-f]{48}$", live)
+	test, err := NewAPIKey(false)
+	assert.NoError(t, err)
+	assert.Regexp(t, "^key_test_[0-9a-f]{48}$", test)
+
+	assert.NoError(t, s.PutAPIKey(ctx, "brand_a", true, live))
+	assert.NoError(t, s.PutAPIKey(ctx, "brand_a", false, test))
+	assert.Equal(t, ErrMerchantNotFound, s.PutAPIKey(ctx, "unknown", false, "key_test_unknown"))
+
+	// Only the hash is stored
+	var stored int
+	assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM api_keys WHERE key_hash IN (?, ?)", live, test).Scan(&stored))
+	assert.Zero(t, stored)
+
+	merchantID, livemode, err := s.AuthenticateAPIKey(ctx, live)
+	assert.NoError(t, err)
+	assert.Equal(t, "brand_a", merchantID)
+	assert.True(t, livemode)
+
+	merchantID, livemode, err = s.AuthenticateAPIKey(ctx, test)
+	assert.NoError(t, err)
+	assert.Equal(t, "brand_a", merchantID)
+	assert.False(t, livemode)
+
+	for _, key := range []string{"", "key_test_unknown", live + "x"} {
+		_, _, err := s.AuthenticateAPIKey(ctx, key)
+		assert.Equal(t, ErrInvalidAPIKey, err, key)
+	}
+
+	assert.NoError(t, s.RevokeAPIKey(ctx, live))
+	assert.Equal(t, ErrInvalidAPIKey, s.RevokeAPIKey(ctx, live))
+	_, _, err = s.AuthenticateAPIKey(ctx, live)
+	assert.Equal(t, ErrInvalidAPIKey, err)
+}
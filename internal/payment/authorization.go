@@ -0,0 +1,233 @@
+package payment
+
+import (
+	"context"
+	"database/sql"
+	"exam-payment-service/pkg/logger"
+	"exam-payment-service/pkg/omiseprovider"
+	"time"
+
+	"github.com/omise/omise-go"
+	"github.com/omise/omise-go/operations"
+)
+
+// StatusAuthorized is set on charges made with capture false once the card is authorized,
+// Omise reports them as pending until they are captured or reversed
+const StatusAuthorized = "authorized"
+
+// authorizationPeriod is how long Omise holds an authorized amount of a charge without expiry
+const authorizationPeriod = 7 * 24 * time.Hour
+
+// AuthorizationMonitorConfig sets how often authorized payments are checked and how long
+// before their authorization expires they are flagged
+type AuthorizationMonitorConfig struct {
+	Interval time.Duration
+	Warning  time.Duration
+}
+
+var DefaultAuthorizationMonitorConfig = AuthorizationMonitorConfig{
+	Interval: 10 * time.Minute,
+	Warning:  24 * time.Hour,
+}
+
+// ExpiringAuthorization is an authorized payment whose held amount is released soon unless captured
+type ExpiringAuthorization struct {
+	ChargeID   string    `json:"chargeId"`
+	MerchantID string    `json:"merchantId"`
+	Livemode   bool      `json:"livemode"`
+	Amount     int64     `json:"amount"`
+	Currency   string    `json:"currency"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+}
+
+// CaptureRequest captures Amount of an authorized payment, the full amount without it
+type CaptureRequest struct {
+	Amount int64 `json:"amount"`
+}
+
+// chargeStatus returns the status of a payment for a charge, authorized charges waiting
+// for capture are StatusAuthorized instead of pending
+func chargeStatus(status string, capture, authorized, paid bool) string {
+	if status == string(omise.ChargePending) && !capture && authorized && !paid {
+		return StatusAuthorized
+	}
+
+	return status
+}
+
+// recordAuthorization sets when the authorization of the charge expires, the first time only
+func (p Payment) recordAuthorization(ctx context.Context, chargeID string, expiresAt time.Time) error {
+	_, err := p.db.ExecContext(
+		ctx,
+		"UPDATE payments SET authorization_expires_at = ? WHERE charge_id = ? AND authorization_expires_at IS NULL",
+		expiresAt.UTC(), chargeID,
+	)
+
+	return err
+}
+
+// authorizedPayment returns the amount of an authorized payment of the context's merchant
+// and the provider to capture or reverse it with
+func (p Payment) authorizedPayment(ctx context.Context, chargeID string) (int64, omiseProvider, error) {
+	var (
+		status string
+		amount sql.NullInt64
+	)
+	livemode := LivemodeFromContext(ctx)
+	err := p.db.QueryRowContext(
+		ctx,
+		"SELECT status, amount FROM payments WHERE charge_id = ? AND merchant_id = ? AND livemode = ?",
+		chargeID, MerchantFromContext(ctx), livemode,
+	).Scan(&status, &amount)
+	if err == sql.ErrNoRows {
+		return 0, nil, ErrPaymentNotFound
+	}
+	if err != nil {
+		return 0, nil, ErrInternal.Wrap(err)
+	}
+
+	if status != StatusAuthorized {
+		return 0, nil, ErrPaymentNotAuthorized
+	}
+
+	oc, err := p.provider(ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return amount.Int64, oc, nil
+}
+
+// CapturePayment captures an authorized payment of the context's merchant. A partial amount
+// releases the rest of the authorization, zero captures the full amount
+func (p Payment) CapturePayment(ctx context.Context, chargeID string, amount int64) (PaymentDetail, error) {
+	authorized, oc, err := p.authorizedPayment(ctx, chargeID)
+	if err != nil {
+		return PaymentDetail{}, err
+	}
+
+	if amount < 0 || (authorized > 0 && amount > authorized) {
+		return PaymentDetail{}, ErrInvalidCaptureAmount
+	}
+
+	charge, err := oc.CaptureCharge(ctx, omiseprovider.CaptureCharge{
+		CaptureCharge: operations.CaptureCharge{ChargeID: chargeID},
+		CaptureAmount: amount,
+	})
+	if err != nil {
+		return PaymentDetail{}, wrapOmiseError(err)
+	}
+
+	captured := amount
+	if captured == 0 {
+		captured = authorized
+	}
+
+	_, err = p.db.ExecContext(
+		ctx,
+		"UPDATE payments SET txn_id = ?, status = ?, captured_amount = ? WHERE charge_id = ?",
+		charge.Transaction, string(charge.Status), captured, chargeID,
+	)
+	if err != nil {
+		return PaymentDetail{}, ErrInternal.Wrap(err)
+	}
+
+	observeStatusTransition(StatusAuthorized, string(charge.Status))
+	logger.For(ctx, p.log).Infow("Payment captured", "charge_id", chargeID, "amount", captured)
+
+	return p.GetPayment(ctx, chargeID)
+}
+
+// ReversePayment releases the authorized amount of a payment of the context's merchant
+func (p Payment) ReversePayment(ctx context.Context, chargeID string) (PaymentDetail, error) {
+	_, oc, err := p.authorizedPayment(ctx, chargeID)
+	if err != nil {
+		return PaymentDetail{}, err
+	}
+
+	charge, err := oc.ReverseCharge(ctx, operations.ReverseCharge{ChargeID: chargeID})
+	if err != nil {
+		return PaymentDetail{}, wrapOmiseError(err)
+	}
+
+	_, err = p.db.ExecContext(ctx, "UPDATE payments SET status = ? WHERE charge_id = ?", string(charge.Status), chargeID)
+	if err != nil {
+		return PaymentDetail{}, ErrInternal.Wrap(err)
+	}
+
+	observeStatusTransition(StatusAuthorized, string(charge.Status))
+	logger.For(ctx, p.log).Infow("Payment reversed", "charge_id", chargeID)
+
+	return p.GetPayment(ctx, chargeID)
+}
+
+// FlagExpiringAuthorizations alerts about authorized payments whose authorization expires
+// before the given time, each payment is flagged once
+func (p Payment) FlagExpiringAuthorizations(ctx context.Context, before time.Time) (int, error) {
+	rows, err := p.db.QueryContext(
+		ctx,
+		`SELECT charge_id, merchant_id, livemode, COALESCE(amount, 0), COALESCE(currency, ''), authorization_expires_at
+		FROM payments WHERE status = ? AND expiry_flagged_at IS NULL AND authorization_expires_at <= ?
+		ORDER BY authorization_expires_at`,
+		StatusAuthorized, before.UTC(),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	var expiring []ExpiringAuthorization
+	for rows.Next() {
+		var a ExpiringAuthorization
+		if err := rows.Scan(&a.ChargeID, &a.MerchantID, &a.Livemode, &a.Amount, &a.Currency, &a.ExpiresAt); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		expiring = append(expiring, a)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for i, a := range expiring {
+		_, err := p.db.ExecContext(
+			ctx,
+			"UPDATE payments SET expiry_flagged_at = ? WHERE charge_id = ?",
+			time.Now().UTC(), a.ChargeID,
+		)
+		if err != nil {
+			return i, err
+		}
+
+		expiringAuthorizationsTotal.Inc()
+
+		// The payment is flagged, a failed alert must not flag it again
+		if err := p.notifier.NotifyExpiringAuthorization(ctx, a); err != nil {
+			logger.For(ctx, p.log).Errorw("NotifyExpiringAuthorization error", "error", SafeError(err), "charge_id", a.ChargeID)
+		}
+	}
+
+	return len(expiring), nil
+}
+
+// RunAuthorizationMonitor flags expiring authorizations on every interval until ctx is done
+func (p Payment) RunAuthorizationMonitor(ctx context.Context, cfg AuthorizationMonitorConfig) {
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := p.FlagExpiringAuthorizations(ctx, time.Now().Add(cfg.Warning))
+		if err != nil && ctx.Err() == nil {
+			logger.For(ctx, p.log).Errorw("FlagExpiringAuthorizations error", "error", SafeError(err))
+		}
+		if n > 0 {
+			logger.For(ctx, p.log).Infow("Expiring authorizations flagged", "count", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
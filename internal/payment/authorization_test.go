@@ -0,0 +1,216 @@
+package payment
+
+import (
+	"context"
+	"database/sql"
+	mockOmiseProvider "exam-payment-service/internal/payment/mocks/omiseprovider"
+	"exam-payment-service/pkg/omiseprovider"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/omise/omise-go"
+	"github.com/omise/omise-go/operations"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+// newTestMockedPayment returns the payment service of the default merchant with a mocked test mode provider
+func newTestMockedPayment(t *testing.T) (*Payment, *sql.DB, *mockOmiseProvider.MockOmiseProvider, *recordingNotifier) {
+	ctrl := gomock.NewController(t)
+	op := mockOmiseProvider.NewMockOmiseProvider(ctrl)
+
+	s, db := newTestMerchantStore(t)
+	if err := s.Put(context.Background(), Merchant{ID: DefaultMerchantID, Name: "Default"}); err != nil {
+		t.Fatal(err)
+	}
+
+	notifier := &recordingNotifier{}
+	newProviders := func(m Merchant) (Providers, error) {
+		return Providers{Test: op}, nil
+	}
+	p := New(s, newProviders, newTestKeyring(t), db, zap.NewNop().Sugar(), WithNotifier(notifier))
+
+	return p, db, op, notifier
+}
+
+// newTestAuthorization also adds an authorized payment of 20000
+func newTestAuthorization(t *testing.T) (*Payment, *sql.DB, *mockOmiseProvider.MockOmiseProvider, *recordingNotifier) {
+	p, db, op, notifier := newTestMockedPayment(t)
+
+	_, err := db.Exec(
+		"INSERT INTO payments (charge_id, source_id, txn_id, status, amount, currency, livemode, authorization_expires_at) VALUES ('chrg_test_a', '', '', ?, 20000, 'thb', false, ?)",
+		StatusAuthorized, time.Now().UTC().Add(authorizationPeriod),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return p, db, op, notifier
+}
+
+func TestChargeStatus(t *testing.T) {
+	testCases := []struct {
+		name       string
+		status     string
+		capture    bool
+		authorized bool
+		paid       bool
+		expected   string
+	}{
+		{"Authorized without capture", "pending", false, true, false, StatusAuthorized},
+		{"Waiting for 3-D Secure", "pending", false, false, false, "pending"},
+		{"Captured", "successful", false, true, true, "successful"},
+		{"Pending with capture", "pending", true, true, false, "pending"},
+		{"Reversed", "reversed", false, true, false, "reversed"},
+	}
+
+	t.Parallel()
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expected, chargeStatus(tc.status, tc.capture, tc.authorized, tc.paid), tc.name)
+	}
+}
+
+func TestCapturePayment(t *testing.T) {
+	testCases := []struct {
+		name             string
+		chargeID         string
+		amount           int64
+		status           string
+		mock             func(op *mockOmiseProvider.MockOmiseProvider)
+		expectedError    error
+		expectedStatus   string
+		expectedCaptured int64
+	}{
+		{
+			name:     "Full",
+			chargeID: "chrg_test_a",
+			mock: func(op *mockOmiseProvider.MockOmiseProvider) {
+				op.EXPECT().CaptureCharge(gomock.Any(), omiseprovider.CaptureCharge{
+					CaptureCharge: operations.CaptureCharge{ChargeID: "chrg_test_a"},
+				}).Return(omise.Charge{Status: omise.ChargeSuccessful, Transaction: "trxn_test_a"}, nil)
+			},
+			expectedStatus:   "successful",
+			expectedCaptured: 20000,
+		},
+		{
+			name:     "Partial",
+			chargeID: "chrg_test_a",
+			amount:   15000,
+			mock: func(op *mockOmiseProvider.MockOmiseProvider) {
+				op.EXPECT().CaptureCharge(gomock.Any(), omiseprovider.CaptureCharge{
+					CaptureCharge: operations.CaptureCharge{ChargeID: "chrg_test_a"},
+					CaptureAmount: 15000,
+				}).Return(omise.Charge{Status: omise.ChargeSuccessful, Transaction: "trxn_test_a"}, nil)
+			},
+			expectedStatus:   "successful",
+			expectedCaptured: 15000,
+		},
+		{
+			name:           "More than authorized",
+			chargeID:       "chrg_test_a",
+			amount:         20001,
+			expectedError:  ErrInvalidCaptureAmount,
+			expectedStatus: StatusAuthorized,
+		},
+		{
+			name:           "Already captured",
+			chargeID:       "chrg_test_a",
+			status:         "successful",
+			expectedError:  ErrPaymentNotAuthorized,
+			expectedStatus: "successful",
+		},
+		{
+			name:          "Unknown payment",
+			chargeID:      "chrg_test_unknown",
+			expectedError: ErrPaymentNotFound,
+		},
+		{
+			name:     "Rejected by Omise",
+			chargeID: "chrg_test_a",
+			mock: func(op *mockOmiseProvider.MockOmiseProvider) {
+				op.EXPECT().CaptureCharge(gomock.Any(), gomock.Any()).Return(omise.Charge{}, &omise.Error{StatusCode: 400, Code: "failed_capture"})
+			},
+			expectedError:  ErrProviderRejected,
+			expectedStatus: StatusAuthorized,
+		},
+	}
+
+	t.Parallel()
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			p, db, op, _ := newTestAuthorization(t)
+			if tc.status != "" {
+				_, err := db.Exec("UPDATE payments SET status = ?", tc.status)
+				assert.NoError(t, err)
+			}
+			if tc.mock != nil {
+				tc.mock(op)
+			}
+
+			d, err := p.CapturePayment(ctx, tc.chargeID, tc.amount)
+
+			assert.ErrorIs(t, err, tc.expectedError)
+			if tc.expectedError == nil {
+				assert.Equal(t, tc.expectedStatus, d.Status)
+				assert.Equal(t, tc.expectedCaptured, d.CapturedAmount)
+			}
+
+			if tc.expectedStatus != "" {
+				var status string
+				assert.NoError(t, db.QueryRow("SELECT status FROM payments WHERE charge_id = ?", tc.chargeID).Scan(&status))
+				assert.Equal(t, tc.expectedStatus, status)
+			}
+		})
+	}
+}
+
+func TestReversePayment(t *testing.T) {
+	ctx := context.Background()
+	p, _, op, _ := newTestAuthorization(t)
+
+	op.EXPECT().ReverseCharge(gomock.Any(), operations.ReverseCharge{ChargeID: "chrg_test_a"}).
+		Return(omise.Charge{Status: omise.ChargeReversed, Reversed: true}, nil)
+
+	d, err := p.ReversePayment(ctx, "chrg_test_a")
+	assert.NoError(t, err)
+	assert.Equal(t, "reversed", d.Status)
+
+	// Only once
+	_, err = p.ReversePayment(ctx, "chrg_test_a")
+	assert.Equal(t, ErrPaymentNotAuthorized, err)
+}
+
+func TestFlagExpiringAuthorizations(t *testing.T) {
+	ctx := context.Background()
+	p, db, _, notifier := newTestAuthorization(t)
+
+	_, err := db.Exec(
+		"INSERT INTO payments (charge_id, source_id, txn_id, status, amount, currency, livemode, authorization_expires_at) VALUES ('chrg_test_b', '', '', ?, 5000, 'thb', false, ?)",
+		StatusAuthorized, time.Now().UTC().Add(time.Hour),
+	)
+	assert.NoError(t, err)
+
+	// Only the authorization expiring within a day
+	n, err := p.FlagExpiringAuthorizations(ctx, time.Now().Add(24*time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	if assert.Len(t, notifier.expiring, 1) {
+		assert.Equal(t, "chrg_test_b", notifier.expiring[0].ChargeID)
+		assert.Equal(t, int64(5000), notifier.expiring[0].Amount)
+	}
+
+	// Flagged once
+	n, err = p.FlagExpiringAuthorizations(ctx, time.Now().Add(24*time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	// Captured payments are not flagged
+	_, err = db.Exec("UPDATE payments SET status = 'successful' WHERE charge_id = 'chrg_test_a'")
+	assert.NoError(t, err)
+
+	n, err = p.FlagExpiringAuthorizations(ctx, time.Now().Add(2*authorizationPeriod))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+}
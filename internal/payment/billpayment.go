@@ -0,0 +1,105 @@
+package payment
+
+import (
+	"context"
+	"database/sql"
+	"exam-payment-service/pkg/logger"
+	"exam-payment-service/pkg/omiseprovider"
+	"time"
+)
+
+// SourceTypeBillPaymentTescoLotus is paid at the counter with the barcode of the bill, until it expires
+var SourceTypeBillPaymentTescoLotus SourceType = "bill_payment_tesco_lotus"
+
+// StatusExpired is set on payments that were not paid in time
+const StatusExpired = "expired"
+
+// BillPaymentExpiryInterval is how often expired bill payments are marked
+var BillPaymentExpiryInterval = 5 * time.Minute
+
+// BillPayment is what the customer takes to the counter, Barcode is the data of the Code 128 barcode,
+// rendered by BillPaymentBarcode
+type BillPayment struct {
+	ReferenceNumber1 string    `json:"referenceNumber1"`
+	ReferenceNumber2 string    `json:"referenceNumber2"`
+	Barcode          string    `json:"barcode"`
+	ExpiresAt        time.Time `json:"expiresAt"`
+}
+
+func (s SourceType) billPayment() bool {
+	return s == SourceTypeBillPaymentTescoLotus
+}
+
+func newBillPayment(source *omiseprovider.BillPaymentSource) *BillPayment {
+	if source == nil || source.References == nil {
+		return nil
+	}
+
+	return &BillPayment{
+		ReferenceNumber1: source.References.ReferenceNumber1,
+		ReferenceNumber2: source.References.ReferenceNumber2,
+		Barcode:          source.References.Barcode,
+		ExpiresAt:        source.References.ExpiresAt,
+	}
+}
+
+func (p Payment) recordBillPayment(ctx context.Context, chargeID string, b *BillPayment) error {
+	_, err := p.db.ExecContext(
+		ctx,
+		"UPDATE payments SET bill_reference_1 = ?, bill_reference_2 = ?, barcode = ?, expires_at = ? WHERE charge_id = ?",
+		b.ReferenceNumber1, b.ReferenceNumber2, b.Barcode, b.ExpiresAt.UTC(), chargeID,
+	)
+
+	return err
+}
+
+// BillPaymentBarcode returns the barcode data of a bill payment of the context's merchant and mode
+func (p Payment) BillPaymentBarcode(ctx context.Context, chargeID string) (string, error) {
+	var barcode string
+	err := p.db.QueryRowContext(
+		ctx,
+		"SELECT barcode FROM payments WHERE charge_id = ? AND merchant_id = ? AND livemode = ?",
+		chargeID, MerchantFromContext(ctx), LivemodeFromContext(ctx),
+	).Scan(&barcode)
+	if err == sql.ErrNoRows {
+		return "", ErrPaymentNotFound
+	}
+	if err != nil {
+		return "", ErrInternal.Wrap(err)
+	}
+
+	if barcode == "" {
+		return "", ErrBarcodeNotFound
+	}
+
+	return barcode, nil
+}
+
+// ExpireBillPayments checks with Omise the pending bill payments that expired before the given
+// time, like ExpirePayments. A payment made at the counter just before it expired gets its status
+// even when its webhook is still queued
+func (p Payment) ExpireBillPayments(ctx context.Context, before time.Time) (int, error) {
+	return p.expireOverdue(ctx, before, true)
+}
+
+// RunBillPaymentExpiry expires overdue bill payments on every interval until ctx is done
+func (p Payment) RunBillPaymentExpiry(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := p.ExpireBillPayments(ctx, time.Now())
+		if err != nil && ctx.Err() == nil {
+			logger.For(ctx, p.log).Errorw("ExpireBillPayments error", "error", SafeError(err))
+		}
+		if n > 0 {
+			logger.For(ctx, p.log).Infow("Bill payments expired", "count", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package payment
+
+import (
+	"context"
+	"exam-payment-service/pkg/omiseprovider"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/omise/omise-go"
+	"github.com/omise/omise-go/operations"
+	"github.com/stretchr/testify/assert"
+)
+
+const testBarcode = "|010554614953100\r062111123456789\r59201700000000\r2000000"
+
+func TestBillPayment(t *testing.T) {
+	ctx := context.Background()
+	p, db, op, _ := newTestMockedPayment(t)
+
+	expiresAt := time.Now().UTC().Add(24 * time.Hour).Truncate(time.Second)
+	op.EXPECT().CreateSource(gomock.Any(), operations.CreateSource{Amount: 2000000, Currency: "thb", Type: "bill_payment_tesco_lotus"}).
+		Return(omise.Source{ID: "src_test_a"}, nil)
+	op.EXPECT().CreateBillPaymentCharge(gomock.Any(), operations.CreateCharge{Amount: 2000000, Currency: "thb", Source: "src_test_a"}).
+		Return(omiseprovider.BillPaymentCharge{
+			Charge: omise.Charge{Base: omise.Base{ID: "chrg_test_a"}, Status: omise.ChargePending},
+			Source: &omiseprovider.BillPaymentSource{
+				Source: omise.Source{ID: "src_test_a"},
+				References: &omiseprovider.BillPaymentReferences{
+					ExpiresAt:        expiresAt,
+					ReferenceNumber1: "062111123456789",
+					ReferenceNumber2: "59201700000000",
+					Barcode:          testBarcode,
+				},
+			},
+		}, nil)
+
+	result, err := p.CreatePaymentRequest(ctx, PaymentRequest{Amount: 2000000, Currency: CurrencyTHB, SourceType: SourceTypeBillPaymentTescoLotus})
+	assert.NoError(t, err)
+	assert.Equal(t, &BillPayment{
+		ReferenceNumber1: "062111123456789",
+		ReferenceNumber2: "59201700000000",
+		Barcode:          testBarcode,
+		ExpiresAt:        expiresAt,
+	}, result.BillPayment)
+
+	barcode, err := p.BillPaymentBarcode(ctx, "chrg_test_a")
+	assert.NoError(t, err)
+	assert.Equal(t, testBarcode, barcode)
+
+	// Not expired yet
+	n, err := p.ExpireBillPayments(ctx, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	// Left pending until Omise expires the charge
+	charge := func(id string, status omise.ChargeStatus) omise.Charge {
+		return omise.Charge{Base: omise.Base{ID: id}, Status: status, Amount: 2000000, Currency: "thb", Capture: true}
+	}
+	gomock.InOrder(
+		op.EXPECT().RetrieveCharge(gomock.Any(), operations.RetrieveCharge{ChargeID: "chrg_test_a"}).
+			Return(charge("chrg_test_a", omise.ChargePending), nil),
+		op.EXPECT().RetrieveCharge(gomock.Any(), operations.RetrieveCharge{ChargeID: "chrg_test_a"}).
+			Return(charge("chrg_test_a", omise.ChargeStatus(StatusExpired)), nil),
+	)
+
+	n, err = p.ExpireBillPayments(ctx, expiresAt)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	status, err := p.GetPaymentStatusWithChargeID(ctx, "chrg_test_a")
+	assert.NoError(t, err)
+	assert.Equal(t, string(omise.ChargePending), status.Status)
+
+	n, err = p.ExpireBillPayments(ctx, expiresAt)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	status, err = p.GetPaymentStatusWithChargeID(ctx, "chrg_test_a")
+	assert.NoError(t, err)
+	assert.Equal(t, StatusExpired, status.Status)
+
+	// Paid at the counter just before it expired, its webhook still queued
+	_, err = db.Exec("INSERT INTO payments (charge_id, source_id, txn_id, status, amount, currency, livemode, barcode, expires_at) VALUES ('chrg_test_c', '', '', 'pending', 2000000, 'thb', false, ?, ?)", testBarcode, expiresAt)
+	assert.NoError(t, err)
+	paid := charge("chrg_test_c", omise.ChargeSuccessful)
+	paid.Paid, paid.Transaction = true, "trxn_test_c"
+	op.EXPECT().RetrieveCharge(gomock.Any(), operations.RetrieveCharge{ChargeID: "chrg_test_c"}).Return(paid, nil)
+
+	n, err = p.ExpireBillPayments(ctx, expiresAt)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	status, err = p.GetPaymentStatusWithChargeID(ctx, "chrg_test_c")
+	assert.NoError(t, err)
+	assert.Equal(t, string(omise.ChargeSuccessful), status.Status)
+
+	// Other payments have no barcode and never expire this way
+	_, err = db.Exec("INSERT INTO payments (charge_id, source_id, txn_id, status, livemode, expires_at) VALUES ('chrg_test_b', '', '', 'pending', false, ?)", expiresAt)
+	assert.NoError(t, err)
+
+	_, err = p.BillPaymentBarcode(ctx, "chrg_test_b")
+	assert.Equal(t, ErrBarcodeNotFound, err)
+
+	n, err = p.ExpireBillPayments(ctx, expiresAt)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	_, err = p.BillPaymentBarcode(ContextWithLivemode(ctx, true), "chrg_test_a")
+	assert.Equal(t, ErrPaymentNotFound, err)
+}
@@ -0,0 +1,196 @@
+package payment
+
+import (
+	"context"
+	"exam-payment-service/pkg/logger"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/omise/omise-go"
+	"github.com/omise/omise-go/operations"
+)
+
+// CapabilityRefreshInterval is how often the cached Omise capabilities are fetched again
+var CapabilityRefreshInterval = 15 * time.Minute
+
+// PaymentMethod is a source type a payment of the amount can be made with, InstallmentTerms
+// are only set for installments
+type PaymentMethod struct {
+	SourceType       SourceType `json:"sourceType"`
+	MinAmount        int64      `json:"minAmount"`
+	MaxAmount        int64      `json:"maxAmount"`
+	InstallmentTerms []int64    `json:"installmentTerms,omitempty"`
+}
+
+type capabilityKey struct {
+	merchantID string
+	livemode   bool
+}
+
+// capabilityCache keeps the Omise capability of each merchant and mode, fetched on first use
+// and refreshed by RunCapabilityRefresh
+type capabilityCache struct {
+	mu      sync.Mutex
+	entries map[capabilityKey]omise.Capability
+}
+
+func newCapabilityCache() *capabilityCache {
+	return &capabilityCache{entries: map[capabilityKey]omise.Capability{}}
+}
+
+func (c *capabilityCache) get(key capabilityKey) (omise.Capability, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	capability, ok := c.entries[key]
+	return capability, ok
+}
+
+func (c *capabilityCache) put(key capabilityKey, capability omise.Capability) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[key] = capability
+}
+
+func (c *capabilityCache) keys() []capabilityKey {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	keys := make([]capabilityKey, 0, len(c.entries))
+	for key := range c.entries {
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+// fetchCapability retrieves the capability of a merchant and mode and caches it
+func (p Payment) fetchCapability(ctx context.Context, key capabilityKey) (omise.Capability, error) {
+	ctx = ContextWithLivemode(ContextWithMerchant(ctx, key.merchantID), key.livemode)
+
+	oc, err := p.provider(ctx)
+	if err != nil {
+		return omise.Capability{}, err
+	}
+
+	capability, err := oc.RetrieveCapability(ctx, operations.RetrieveCapability{})
+	if err != nil {
+		return omise.Capability{}, wrapOmiseError(err)
+	}
+
+	p.capabilities.put(key, capability)
+
+	return capability, nil
+}
+
+// capability returns the capability of the context's merchant and mode, cached
+func (p Payment) capability(ctx context.Context) (omise.Capability, error) {
+	key := capabilityKey{MerchantFromContext(ctx), LivemodeFromContext(ctx)}
+	if capability, ok := p.capabilities.get(key); ok {
+		return capability, nil
+	}
+
+	return p.fetchCapability(ctx, key)
+}
+
+// RefreshCapabilities fetches every cached capability again, a capability that fails to refresh
+// is kept until the next refresh
+func (p Payment) RefreshCapabilities(ctx context.Context) {
+	for _, key := range p.capabilities.keys() {
+		if _, err := p.fetchCapability(ctx, key); err != nil && ctx.Err() == nil {
+			logger.For(ctx, p.log).Errorw("RefreshCapabilities error", "error", SafeError(err), "merchant_id", key.merchantID, "livemode", key.livemode)
+		}
+	}
+}
+
+// RunCapabilityRefresh refreshes the cached capabilities on every interval until ctx is done
+func (p Payment) RunCapabilityRefresh(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.RefreshCapabilities(ctx)
+		}
+	}
+}
+
+// PaymentMethods returns the source types of the registry the context's merchant enabled and
+// its Omise account supports for the currency, with the limits of each. With an amount, only
+// the source types and installment terms the amount can be paid with are returned
+func (p Payment) PaymentMethods(ctx context.Context, amount int64, currency Currency) ([]PaymentMethod, error) {
+	if amount < 0 {
+		return nil, ErrInvalidRequest
+	}
+
+	if !currency.Validate() {
+		return nil, ErrInvalidCurrency
+	}
+
+	m, err := p.merchants.Get(ctx, MerchantFromContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	capability, err := p.capability(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	supported := map[string]omise.PaymentMethod{}
+	for _, pm := range capability.PaymentMethods {
+		for _, c := range pm.Currencies {
+			if strings.EqualFold(c, string(currency)) {
+				supported[pm.Name] = pm
+			}
+		}
+	}
+
+	min, max := m.chargeLimits(currency)
+	methods := []PaymentMethod{}
+	for _, s := range append([]SourceType{SourceTypeCard}, sourceTypes...) {
+		pm, ok := supported[string(s)]
+		if !ok || !m.sourceTypeEnabled(s) {
+			continue
+		}
+
+		method := PaymentMethod{SourceType: s, MinAmount: min, MaxAmount: max}
+		if plan, ok := installmentPlans[s]; ok {
+			if method.MinAmount < InstallmentMinAmount {
+				method.MinAmount = InstallmentMinAmount
+			}
+			method.InstallmentTerms = installmentTerms(plan, pm.InstallmentTerms, amount)
+			if len(method.InstallmentTerms) == 0 {
+				continue
+			}
+		}
+
+		if amount > 0 && (amount < method.MinAmount || (method.MaxAmount > 0 && amount > method.MaxAmount)) {
+			continue
+		}
+
+		methods = append(methods, method)
+	}
+
+	return methods, nil
+}
+
+// installmentTerms returns the terms of the plan Omise offers, with an amount only those whose
+// monthly payment is at least the plan's minimum
+func installmentTerms(plan installmentPlan, offered []int, amount int64) []int64 {
+	var terms []int64
+	for _, term := range plan.Terms {
+		for _, o := range offered {
+			if int64(o) == term && (amount == 0 || amount >= plan.MinMonthly*term) {
+				terms = append(terms, term)
+			}
+		}
+	}
+
+	return terms
+}
@@ -0,0 +1,147 @@
+package payment
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/omise/omise-go"
+	"github.com/stretchr/testify/assert"
+)
+
+var testCapability = omise.Capability{
+	PaymentMethods: []omise.PaymentMethod{
+		{Name: "card", Currencies: []string{"THB", "USD"}},
+		{Name: "internet_banking_scb", Currencies: []string{"THB"}},
+		{Name: "installment_kbank", Currencies: []string{"THB"}, InstallmentTerms: []int{3, 4, 6, 10}},
+		{Name: "installment_bbl", Currencies: []string{"THB"}, InstallmentTerms: []int{4, 6}},
+		{Name: "truemoney", Currencies: []string{"THB"}},
+		{Name: "alipay", Currencies: []string{"USD"}},
+		{Name: "promptpay", Currencies: []string{"THB"}},
+	},
+}
+
+func TestPaymentMethods(t *testing.T) {
+	ctx := context.Background()
+	p, _, op, _ := newTestMockedPayment(t)
+
+	// Fetched once, then cached
+	op.EXPECT().RetrieveCapability(gomock.Any(), gomock.Any()).Return(testCapability, nil)
+
+	testCases := []struct {
+		name            string
+		amount          int64
+		currency        Currency
+		expectedMethods []PaymentMethod
+		expectedError   error
+	}{
+		{
+			name:     "Without amount",
+			currency: CurrencyTHB,
+			expectedMethods: []PaymentMethod{
+				{SourceType: SourceTypeCard, MinAmount: ChargeLimitTHBMin, MaxAmount: ChargeLimitTHBMax},
+				{SourceType: SourceTypeInternetBankSCB, MinAmount: ChargeLimitTHBMin, MaxAmount: ChargeLimitTHBMax},
+				{SourceType: SourceTypeInstallmentBBL, MinAmount: InstallmentMinAmount, MaxAmount: ChargeLimitTHBMax, InstallmentTerms: []int64{4, 6}},
+				{SourceType: SourceTypeInstallmentKBank, MinAmount: InstallmentMinAmount, MaxAmount: ChargeLimitTHBMax, InstallmentTerms: []int64{3, 4, 6, 10}},
+				{SourceType: SourceTypeTrueMoney, MinAmount: ChargeLimitTHBMin, MaxAmount: ChargeLimitTHBMax},
+			},
+		},
+		{
+			name:     "Lower than installment minimum",
+			amount:   100000,
+			currency: CurrencyTHB,
+			expectedMethods: []PaymentMethod{
+				{SourceType: SourceTypeCard, MinAmount: ChargeLimitTHBMin, MaxAmount: ChargeLimitTHBMax},
+				{SourceType: SourceTypeInternetBankSCB, MinAmount: ChargeLimitTHBMin, MaxAmount: ChargeLimitTHBMax},
+				{SourceType: SourceTypeTrueMoney, MinAmount: ChargeLimitTHBMin, MaxAmount: ChargeLimitTHBMax},
+			},
+		},
+		{
+			name:     "Terms with monthly payment too low left out",
+			amount:   200000,
+			currency: CurrencyTHB,
+			expectedMethods: []PaymentMethod{
+				{SourceType: SourceTypeCard, MinAmount: ChargeLimitTHBMin, MaxAmount: ChargeLimitTHBMax},
+				{SourceType: SourceTypeInternetBankSCB, MinAmount: ChargeLimitTHBMin, MaxAmount: ChargeLimitTHBMax},
+				{SourceType: SourceTypeInstallmentBBL, MinAmount: InstallmentMinAmount, MaxAmount: ChargeLimitTHBMax, InstallmentTerms: []int64{4}},
+				{SourceType: SourceTypeInstallmentKBank, MinAmount: InstallmentMinAmount, MaxAmount: ChargeLimitTHBMax, InstallmentTerms: []int64{3, 4, 6}},
+				{SourceType: SourceTypeTrueMoney, MinAmount: ChargeLimitTHBMin, MaxAmount: ChargeLimitTHBMax},
+			},
+		},
+		{
+			name:            "Higher than charge limit",
+			amount:          ChargeLimitTHBMax + 1,
+			currency:        CurrencyTHB,
+			expectedMethods: []PaymentMethod{},
+		},
+		{
+			name:          "Invalid currency",
+			currency:      "usd",
+			expectedError: ErrInvalidCurrency,
+		},
+		{
+			name:          "Negative amount",
+			amount:        -1,
+			currency:      CurrencyTHB,
+			expectedError: ErrInvalidRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			methods, err := p.PaymentMethods(ctx, tc.amount, tc.currency)
+			assert.Equal(t, tc.expectedError, err)
+			assert.Equal(t, tc.expectedMethods, methods)
+		})
+	}
+}
+
+func TestPaymentMethodsOfMerchant(t *testing.T) {
+	ctx := context.Background()
+	p, _, op, _ := newTestMockedPayment(t)
+
+	err := p.merchants.Put(ctx, Merchant{ID: DefaultMerchantID, Name: "Default", SourceTypes: []SourceType{SourceTypeCard, SourceTypeTrueMoney}})
+	assert.NoError(t, err)
+
+	op.EXPECT().RetrieveCapability(gomock.Any(), gomock.Any()).Return(testCapability, nil)
+
+	methods, err := p.PaymentMethods(ctx, 50000, CurrencyTHB)
+	assert.NoError(t, err)
+	assert.Equal(t, []PaymentMethod{
+		{SourceType: SourceTypeCard, MinAmount: ChargeLimitTHBMin, MaxAmount: ChargeLimitTHBMax},
+		{SourceType: SourceTypeTrueMoney, MinAmount: ChargeLimitTHBMin, MaxAmount: ChargeLimitTHBMax},
+	}, methods)
+}
+
+func TestRefreshCapabilities(t *testing.T) {
+	ctx := context.Background()
+	p, _, op, _ := newTestMockedPayment(t)
+
+	// Nothing is cached yet
+	p.RefreshCapabilities(ctx)
+
+	gomock.InOrder(
+		op.EXPECT().RetrieveCapability(gomock.Any(), gomock.Any()).Return(testCapability, nil),
+		op.EXPECT().RetrieveCapability(gomock.Any(), gomock.Any()).Return(omise.Capability{
+			PaymentMethods: []omise.PaymentMethod{{Name: "card", Currencies: []string{"THB"}}},
+		}, nil),
+		op.EXPECT().RetrieveCapability(gomock.Any(), gomock.Any()).Return(omise.Capability{}, &omise.ErrTransport{}),
+	)
+
+	methods, err := p.PaymentMethods(ctx, 0, CurrencyTHB)
+	assert.NoError(t, err)
+	assert.Len(t, methods, 5)
+
+	p.RefreshCapabilities(ctx)
+
+	methods, err = p.PaymentMethods(ctx, 0, CurrencyTHB)
+	assert.NoError(t, err)
+	assert.Equal(t, []PaymentMethod{{SourceType: SourceTypeCard, MinAmount: ChargeLimitTHBMin, MaxAmount: ChargeLimitTHBMax}}, methods)
+
+	// A failed refresh keeps what was cached
+	p.RefreshCapabilities(ctx)
+
+	methods, err = p.PaymentMethods(ctx, 0, CurrencyTHB)
+	assert.NoError(t, err)
+	assert.Len(t, methods, 1)
+}
@@ -0,0 +1,201 @@
+package payment
+
+import (
+	"context"
+	"database/sql"
+	"exam-payment-service/pkg/logger"
+	"strings"
+	"time"
+
+	"github.com/omise/omise-go"
+	"github.com/omise/omise-go/operations"
+)
+
+// CustomerRequest makes a user of the merchant an Omise customer, CardToken is saved as its default card
+type CustomerRequest struct {
+	UserID      string `json:"userId"`
+	Email       string `json:"email"`
+	Description string `json:"description"`
+	CardToken   string `json:"cardToken"`
+}
+
+// Customer is a user of the merchant and its Omise customer, CustomerID is what payments are made with
+type Customer struct {
+	UserID     string      `json:"userId"`
+	CustomerID string      `json:"customerId"`
+	Cards      []SavedCard `json:"cards"`
+}
+
+// CardRequest saves the card of a token for the customer
+type CardRequest struct {
+	CardToken string `json:"cardToken"`
+}
+
+// SavedCard is a card of a customer, Default is charged by payments with the customer
+type SavedCard struct {
+	ID              string `json:"id"`
+	Brand           string `json:"brand"`
+	LastDigits      string `json:"lastDigits"`
+	ExpirationMonth int    `json:"expirationMonth"`
+	ExpirationYear  int    `json:"expirationYear"`
+	Default         bool   `json:"default"`
+}
+
+func savedCards(customer omise.Customer) []SavedCard {
+	cards := []SavedCard{}
+	if customer.Cards == nil {
+		return cards
+	}
+
+	for _, c := range customer.Cards.Data {
+		cards = append(cards, SavedCard{
+			ID:              c.ID,
+			Brand:           c.Brand,
+			LastDigits:      c.LastDigits,
+			ExpirationMonth: int(c.ExpirationMonth),
+			ExpirationYear:  c.ExpirationYear,
+			Default:         c.ID == customer.DefaultCard,
+		})
+	}
+
+	return cards
+}
+
+// provider returns the provider of the context's merchant and mode
+func (p Payment) provider(ctx context.Context) (omiseProvider, error) {
+	m, err := p.merchants.Get(ctx, MerchantFromContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	providers, err := p.providers.get(m)
+	if err != nil {
+		return nil, ErrInternal.Wrap(err)
+	}
+
+	return providers.forMode(LivemodeFromContext(ctx))
+}
+
+// omiseCustomerID returns the Omise customer of a user of the context's merchant and mode
+func (p Payment) omiseCustomerID(ctx context.Context, userID string) (string, error) {
+	var customerID string
+	err := p.db.QueryRowContext(
+		ctx,
+		"SELECT omise_customer_id FROM customers WHERE merchant_id = ? AND livemode = ? AND user_id = ?",
+		MerchantFromContext(ctx), LivemodeFromContext(ctx), userID,
+	).Scan(&customerID)
+	if err == sql.ErrNoRows {
+		return "", ErrCustomerNotFound
+	}
+	if err != nil {
+		return "", ErrInternal.Wrap(err)
+	}
+
+	return customerID, nil
+}
+
+// customerExists reports whether the Omise customer belongs to the context's merchant and mode
+func (p Payment) customerExists(ctx context.Context, customerID string) (bool, error) {
+	var n int
+	err := p.db.QueryRowContext(
+		ctx,
+		"SELECT COUNT(*) FROM customers WHERE merchant_id = ? AND livemode = ? AND omise_customer_id = ?",
+		MerchantFromContext(ctx), LivemodeFromContext(ctx), customerID,
+	).Scan(&n)
+
+	return n > 0, err
+}
+
+// CreateCustomer creates the Omise customer of a user of the context's merchant
+func (p Payment) CreateCustomer(ctx context.Context, cr CustomerRequest) (Customer, error) {
+	if cr.UserID == "" {
+		return Customer{}, ErrInvalidRequest
+	}
+	if cr.CardToken != "" && !strings.HasPrefix(cr.CardToken, "tokn_") {
+		return Customer{}, ErrInvalidCardToken
+	}
+
+	if _, err := p.omiseCustomerID(ctx, cr.UserID); err == nil {
+		return Customer{}, ErrCustomerExists
+	} else if err != ErrCustomerNotFound {
+		return Customer{}, err
+	}
+
+	oc, err := p.provider(ctx)
+	if err != nil {
+		return Customer{}, err
+	}
+
+	customer, err := oc.CreateCustomer(ctx, operations.CreateCustomer{
+		Email:       cr.Email,
+		Description: cr.Description,
+		Card:        cr.CardToken,
+		Metadata:    map[string]interface{}{"user_id": cr.UserID},
+	})
+	if err != nil {
+		return Customer{}, wrapOmiseError(err)
+	}
+
+	// A user created twice at once keeps the first customer
+	res, err := p.db.ExecContext(
+		ctx,
+		"INSERT INTO customers (merchant_id, livemode, user_id, omise_customer_id, created_at) VALUES (?, ?, ?, ?, ?) ON CONFLICT DO NOTHING",
+		MerchantFromContext(ctx), LivemodeFromContext(ctx), cr.UserID, customer.ID, time.Now().UTC(),
+	)
+	if err != nil {
+		return Customer{}, ErrInternal.Wrap(err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		logger.For(ctx, p.log).Warnw("CreateCustomer duplicate", "user_id", cr.UserID, "customer_id", customer.ID)
+		return Customer{}, ErrCustomerExists
+	}
+
+	logger.For(ctx, p.log).Infow("Customer created", "user_id", cr.UserID, "customer_id", customer.ID)
+
+	return Customer{UserID: cr.UserID, CustomerID: customer.ID, Cards: savedCards(customer)}, nil
+}
+
+// AttachCard saves the card of a token for a user of the context's merchant, the first card is the default
+func (p Payment) AttachCard(ctx context.Context, userID string, cardToken string) (Customer, error) {
+	if !strings.HasPrefix(cardToken, "tokn_") {
+		return Customer{}, ErrInvalidCardToken
+	}
+
+	customerID, err := p.omiseCustomerID(ctx, userID)
+	if err != nil {
+		return Customer{}, err
+	}
+
+	oc, err := p.provider(ctx)
+	if err != nil {
+		return Customer{}, err
+	}
+
+	customer, err := oc.UpdateCustomer(ctx, operations.UpdateCustomer{CustomerID: customerID, Card: cardToken})
+	if err != nil {
+		return Customer{}, wrapOmiseError(err)
+	}
+
+	return Customer{UserID: userID, CustomerID: customerID, Cards: savedCards(customer)}, nil
+}
+
+// ListCards returns the saved cards of a user of the context's merchant
+func (p Payment) ListCards(ctx context.Context, userID string) (Customer, error) {
+	customerID, err := p.omiseCustomerID(ctx, userID)
+	if err != nil {
+		return Customer{}, err
+	}
+
+	oc, err := p.provider(ctx)
+	if err != nil {
+		return Customer{}, err
+	}
+
+	// The customer has its default card, which the card list lacks
+	customer, err := oc.RetrieveCustomer(ctx, operations.RetrieveCustomer{CustomerID: customerID})
+	if err != nil {
+		return Customer{}, wrapOmiseError(err)
+	}
+
+	return Customer{UserID: userID, CustomerID: customerID, Cards: savedCards(customer)}, nil
+}
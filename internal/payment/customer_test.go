@@ -0,0 +1,123 @@
+package payment
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/omise/omise-go"
+	"github.com/omise/omise-go/operations"
+	"github.com/stretchr/testify/assert"
+)
+
+func customerWithCards(id string, cards ...*omise.Card) omise.Customer {
+	c := omise.Customer{Base: omise.Base{ID: id}, Cards: &omise.CardList{Data: cards}}
+	if len(cards) > 0 {
+		c.DefaultCard = cards[0].ID
+	}
+	return c
+}
+
+func TestCreateCustomer(t *testing.T) {
+	ctx := context.Background()
+	p, _, op, _ := newTestMockedPayment(t)
+
+	visa := &omise.Card{Base: omise.Base{ID: "card_test_a"}, Brand: "Visa", LastDigits: "4242", ExpirationMonth: 12, ExpirationYear: 2030}
+	op.EXPECT().CreateCustomer(gomock.Any(), operations.CreateCustomer{
+		Email:    "buyer@example.com",
+		Card:     "tokn_test_a",
+		Metadata: map[string]interface{}{"user_id": "user_1"},
+	}).Return(customerWithCards("cust_test_a", visa), nil)
+
+	c, err := p.CreateCustomer(ctx, CustomerRequest{UserID: "user_1", Email: "buyer@example.com", CardToken: "tokn_test_a"})
+	assert.NoError(t, err)
+	assert.Equal(t, Customer{
+		UserID:     "user_1",
+		CustomerID: "cust_test_a",
+		Cards: []SavedCard{
+			{ID: "card_test_a", Brand: "Visa", LastDigits: "4242", ExpirationMonth: 12, ExpirationYear: 2030, Default: true},
+		},
+	}, c)
+
+	testCases := []struct {
+		name          string
+		ctx           context.Context
+		request       CustomerRequest
+		expectedError error
+	}{
+		{
+			name:          "Already a customer",
+			ctx:           ctx,
+			request:       CustomerRequest{UserID: "user_1"},
+			expectedError: ErrCustomerExists,
+		},
+		{
+			name:          "Without user ID",
+			ctx:           ctx,
+			request:       CustomerRequest{Email: "buyer@example.com"},
+			expectedError: ErrInvalidRequest,
+		},
+		{
+			name:          "Not a card token",
+			ctx:           ctx,
+			request:       CustomerRequest{UserID: "user_2", CardToken: "4242424242424242"},
+			expectedError: ErrInvalidCardToken,
+		},
+		{
+			name:          "Live mode not configured",
+			ctx:           ContextWithLivemode(ctx, true),
+			request:       CustomerRequest{UserID: "user_1"},
+			expectedError: ErrModeNotConfigured,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := p.CreateCustomer(tc.ctx, tc.request)
+
+			assert.Equal(t, tc.expectedError, err)
+		})
+	}
+
+	// The customer is the merchant's, in its mode
+	exists, err := p.customerExists(ctx, "cust_test_a")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	exists, err = p.customerExists(ContextWithMerchant(ctx, "brand_a"), "cust_test_a")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestCustomerCards(t *testing.T) {
+	ctx := context.Background()
+	p, db, op, _ := newTestMockedPayment(t)
+
+	_, err := db.Exec("INSERT INTO customers (merchant_id, livemode, user_id, omise_customer_id, created_at) VALUES (?, false, 'user_1', 'cust_test_a', CURRENT_TIMESTAMP)", DefaultMerchantID)
+	assert.NoError(t, err)
+
+	visa := &omise.Card{Base: omise.Base{ID: "card_test_a"}, Brand: "Visa", LastDigits: "4242"}
+	mastercard := &omise.Card{Base: omise.Base{ID: "card_test_b"}, Brand: "MasterCard", LastDigits: "5454"}
+
+	op.EXPECT().UpdateCustomer(gomock.Any(), operations.UpdateCustomer{CustomerID: "cust_test_a", Card: "tokn_test_b"}).
+		Return(customerWithCards("cust_test_a", visa, mastercard), nil)
+	op.EXPECT().RetrieveCustomer(gomock.Any(), operations.RetrieveCustomer{CustomerID: "cust_test_a"}).
+		Return(customerWithCards("cust_test_a", visa, mastercard), nil)
+
+	c, err := p.AttachCard(ctx, "user_1", "tokn_test_b")
+	assert.NoError(t, err)
+	assert.Len(t, c.Cards, 2)
+
+	c, err = p.ListCards(ctx, "user_1")
+	assert.NoError(t, err)
+	assert.Equal(t, []SavedCard{
+		{ID: "card_test_a", Brand: "Visa", LastDigits: "4242", Default: true},
+		{ID: "card_test_b", Brand: "MasterCard", LastDigits: "5454"},
+	}, c.Cards)
+
+	_, err = p.ListCards(ctx, "user_2")
+	assert.Equal(t, ErrCustomerNotFound, err)
+
+	_, err = p.AttachCard(ctx, "user_1", "card_test_a")
+	assert.Equal(t, ErrInvalidCardToken, err)
+}
@@ -0,0 +1,266 @@
+package payment
+
+import (
+	"context"
+	"database/sql"
+	"exam-payment-service/pkg/logger"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/omise/omise-go"
+)
+
+// StatusNeedsReview is set on payments whose charge does not match the payment request,
+// it is kept until someone resolves the review, see ResolveReview. The status Omise reports
+// meanwhile is kept in reported_status
+const StatusNeedsReview = "needs_review"
+
+// reviewStatuses are the statuses a review can be resolved to
+var reviewStatuses = map[string]bool{
+	string(omise.ChargePending):    true,
+	StatusAuthorized:               true,
+	string(omise.ChargeSuccessful): true,
+	string(omise.ChargeFailed):     true,
+	string(omise.ChargeReversed):   true,
+	StatusExpired:                  true,
+}
+
+// Discrepancy between a payment request and the charge reported in an event
+type Discrepancy struct {
+	ChargeID   string     `json:"chargeId"`
+	EventID    string     `json:"eventId"`
+	Key        string     `json:"key"`
+	Mismatches []Mismatch `json:"mismatches"`
+}
+
+type Mismatch struct {
+	Field    string `json:"field"`
+	Expected string `json:"expected"`
+	Actual   string `json:"actual"`
+}
+
+// intent is what the payment was requested for, fields are invalid for payments
+// recorded before intents were stored. A livemode mismatch also rejects the event
+type intent struct {
+	Amount   sql.NullInt64
+	Currency sql.NullString
+	Livemode sql.NullBool
+}
+
+// compare returns the fields of the charge that differ from the intent
+func (i intent) compare(charge EventCharge) []Mismatch {
+	var mismatches []Mismatch
+
+	if i.Amount.Valid && i.Amount.Int64 != charge.Amount {
+		mismatches = append(mismatches, Mismatch{
+			Field:    "amount",
+			Expected: strconv.FormatInt(i.Amount.Int64, 10),
+			Actual:   strconv.FormatInt(charge.Amount, 10),
+		})
+	}
+
+	if i.Currency.Valid && !strings.EqualFold(i.Currency.String, charge.Currency) {
+		mismatches = append(mismatches, Mismatch{
+			Field:    "currency",
+			Expected: strings.ToLower(i.Currency.String),
+			Actual:   strings.ToLower(charge.Currency),
+		})
+	}
+
+	if i.Livemode.Valid && i.Livemode.Bool != charge.Livemode {
+		mismatches = append(mismatches, Mismatch{
+			Field:    "livemode",
+			Expected: strconv.FormatBool(i.Livemode.Bool),
+			Actual:   strconv.FormatBool(charge.Livemode),
+		})
+	}
+
+	return mismatches
+}
+
+// livemodeMismatch reports whether the mismatches include the mode
+func livemodeMismatch(mismatches []Mismatch) bool {
+	for _, m := range mismatches {
+		if m.Field == "livemode" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// flagForReview records the discrepancy, moves the payment to needs_review with the status and
+// transaction Omise reported, and alerts
+func (p Payment) flagForReview(ctx context.Context, d Discrepancy, reportedStatus string, txnID string) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := insertDiscrepancy(ctx, tx, d); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		"UPDATE payments SET status = ?, reported_status = ?, txn_id = ? WHERE charge_id = ?",
+		StatusNeedsReview, reportedStatus, txnID, d.ChargeID,
+	)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	p.notifyDiscrepancy(ctx, d)
+
+	return nil
+}
+
+// recordRejected records the discrepancy of an event that is rejected without touching the
+// payment, e.g. an event of the other mode, and alerts
+func (p Payment) recordRejected(ctx context.Context, d Discrepancy) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := insertDiscrepancy(ctx, tx, d); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	p.notifyDiscrepancy(ctx, d)
+
+	return nil
+}
+
+func insertDiscrepancy(ctx context.Context, tx *sql.Tx, d Discrepancy) error {
+	now := time.Now().UTC()
+	for _, m := range d.Mismatches {
+		_, err := tx.ExecContext(
+			ctx,
+			"INSERT INTO payment_discrepancies (charge_id, event_id, field, expected, actual, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+			d.ChargeID, d.EventID, m.Field, m.Expected, m.Actual, now,
+		)
+		if err != nil {
+			return err
+		}
+
+		discrepanciesTotal.WithLabelValues(m.Field).Inc()
+	}
+
+	return nil
+}
+
+// notifyDiscrepancy alerts about a stored discrepancy, a failed alert must not fail the webhook
+func (p Payment) notifyDiscrepancy(ctx context.Context, d Discrepancy) {
+	if err := p.notifier.NotifyDiscrepancy(ctx, d); err != nil {
+		logger.For(ctx, p.log).Errorw("NotifyDiscrepancy error", "error", SafeError(err), "charge_id", d.ChargeID, "event_id", d.EventID)
+	}
+}
+
+// recordReported keeps the status and transaction Omise reports for a payment in review
+func (p Payment) recordReported(ctx context.Context, chargeID string, reportedStatus string, txnID string) error {
+	_, err := p.db.ExecContext(
+		ctx,
+		"UPDATE payments SET reported_status = ?, txn_id = ? WHERE charge_id = ? AND status = ?",
+		reportedStatus, txnID, chargeID, StatusNeedsReview,
+	)
+
+	return err
+}
+
+// ReviewRequest resolves a review to Status, to the status Omise last reported without it
+type ReviewRequest struct {
+	Status string `json:"status"`
+}
+
+// ReviewResolution is a resolved review, Status is the status the payment was moved to
+type ReviewResolution struct {
+	ChargeID   string    `json:"chargeId"`
+	MerchantID string    `json:"merchantId"`
+	Status     string    `json:"status"`
+	ResolvedAt time.Time `json:"resolvedAt"`
+}
+
+// ResolveReview moves a payment out of needs_review and marks its discrepancies resolved
+func (p Payment) ResolveReview(ctx context.Context, chargeID string, req ReviewRequest) (ReviewResolution, error) {
+	if req.Status != "" && !reviewStatuses[req.Status] {
+		return ReviewResolution{}, ErrInvalidRequest
+	}
+
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return ReviewResolution{}, ErrInternal.Wrap(err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	r := ReviewResolution{ChargeID: chargeID}
+	var prevStatus, reportedStatus string
+	err = tx.QueryRowContext(
+		ctx,
+		"SELECT status, reported_status, merchant_id FROM payments WHERE charge_id = ?",
+		chargeID,
+	).Scan(&prevStatus, &reportedStatus, &r.MerchantID)
+	if err == sql.ErrNoRows {
+		return ReviewResolution{}, ErrPaymentNotFound
+	}
+	if err != nil {
+		return ReviewResolution{}, ErrInternal.Wrap(err)
+	}
+	if prevStatus != StatusNeedsReview {
+		return ReviewResolution{}, ErrPaymentNotInReview
+	}
+
+	// Payments flagged before reported statuses were kept need the status from the reviewer
+	r.Status = req.Status
+	if r.Status == "" {
+		r.Status = reportedStatus
+	}
+	if r.Status == "" {
+		return ReviewResolution{}, ErrReviewStatusRequired
+	}
+
+	r.ResolvedAt = time.Now().UTC()
+	res, err := tx.ExecContext(
+		ctx,
+		"UPDATE payments SET status = ? WHERE charge_id = ? AND status = ?",
+		r.Status, chargeID, StatusNeedsReview,
+	)
+	if err != nil {
+		return ReviewResolution{}, ErrInternal.Wrap(err)
+	}
+	// Resolved concurrently
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return ReviewResolution{}, ErrPaymentNotInReview
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		"UPDATE payment_discrepancies SET resolved_at = ? WHERE charge_id = ? AND resolved_at IS NULL",
+		r.ResolvedAt, chargeID,
+	)
+	if err != nil {
+		return ReviewResolution{}, ErrInternal.Wrap(err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return ReviewResolution{}, ErrInternal.Wrap(err)
+	}
+
+	observeStatusTransition(StatusNeedsReview, r.Status)
+
+	return r, nil
+}
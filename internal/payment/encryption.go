@@ -0,0 +1,145 @@
+package payment
+
+import (
+	"context"
+	"database/sql"
+	"exam-payment-service/pkg/encryption"
+	"fmt"
+)
+
+// encryptedColumn is a text column holding ciphertexts, empty values are not encrypted
+type encryptedColumn struct {
+	table  string
+	column string
+}
+
+// encryptedColumns are re-encrypted by RotateKeys, every column holding secrets or customer PII belongs here
+var encryptedColumns = []encryptedColumn{
+	{"merchants", "test_secret_key"},
+	{"merchants", "live_secret_key"},
+	{"merchants", "webhook_secret"},
+	// Return URIs may carry the tokens of the merchant's order pages
+	{"payments", "return_uri"},
+	// Raw events hold the whole charge, return URI included
+	{"webhook_events", "payload"},
+}
+
+// encryptString keeps empty values empty so unset columns stay recognizable
+func encryptString(c encryption.Cipher, s string) (string, error) {
+	if s == "" {
+		return "", nil
+	}
+
+	return c.Encrypt([]byte(s))
+}
+
+func decryptString(c encryption.Cipher, s string) (string, error) {
+	if s == "" {
+		return "", nil
+	}
+
+	b, err := c.Decrypt(s)
+	return string(b), err
+}
+
+// RotateKeys re-encrypts with the primary master key every value encrypted with another key,
+// batchSize rows at a time each in a transaction. progress is called after each batch with the
+// values re-encrypted so far in the column. It returns the number of re-encrypted values
+func RotateKeys(ctx context.Context, db *sql.DB, keyring *encryption.Keyring, batchSize int, progress func(table, column string, n int)) (int, error) {
+	total := 0
+	for _, c := range encryptedColumns {
+		n, err := rotateColumn(ctx, db, keyring, batchSize, c, progress)
+		total += n
+		if err != nil {
+			return total, fmt.Errorf("%s.%s: %w", c.table, c.column, err)
+		}
+	}
+
+	return total, nil
+}
+
+func rotateColumn(ctx context.Context, db *sql.DB, keyring *encryption.Keyring, batchSize int, c encryptedColumn, progress func(table, column string, n int)) (int, error) {
+	var (
+		rotated int
+		after   int64
+	)
+	for {
+		// Pages by rowid, rows already re-encrypted are skipped rather than selected again
+		rows, err := db.QueryContext(
+			ctx,
+			fmt.Sprintf("SELECT rowid, %s FROM %s WHERE rowid > ? AND %s <> '' ORDER BY rowid LIMIT ?", c.column, c.table, c.column),
+			after, batchSize,
+		)
+		if err != nil {
+			return rotated, err
+		}
+
+		type row struct {
+			rowid int64
+			value string
+		}
+		var batch []row
+		for rows.Next() {
+			var r row
+			if err := rows.Scan(&r.rowid, &r.value); err != nil {
+				rows.Close()
+				return rotated, err
+			}
+			batch = append(batch, r)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return rotated, err
+		}
+
+		if len(batch) == 0 {
+			return rotated, nil
+		}
+		after = batch[len(batch)-1].rowid
+
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return rotated, err
+		}
+
+		n := 0
+		for _, r := range batch {
+			if keyring.Current(r.value) {
+				continue
+			}
+
+			plaintext, err := keyring.Decrypt(r.value)
+			if err != nil {
+				_ = tx.Rollback()
+				return rotated, fmt.Errorf("rowid %d: %w", r.rowid, err)
+			}
+
+			value, err := keyring.Encrypt(plaintext)
+			if err != nil {
+				_ = tx.Rollback()
+				return rotated, err
+			}
+
+			// Only if unchanged since read, a concurrent write is already current
+			_, err = tx.ExecContext(
+				ctx,
+				fmt.Sprintf("UPDATE %s SET %s = ? WHERE rowid = ? AND %s = ?", c.table, c.column, c.column),
+				value, r.rowid, r.value,
+			)
+			if err != nil {
+				_ = tx.Rollback()
+				return rotated, err
+			}
+			n++
+		}
+
+		if err := tx.Commit(); err != nil {
+			return rotated, err
+		}
+
+		rotated += n
+		if progress != nil {
+			progress(c.table, c.column, rotated)
+		}
+	}
+}
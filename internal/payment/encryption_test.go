@@ -0,0 +1,86 @@
+package payment
+
+import (
+	"bytes"
+	"context"
+	"exam-payment-service/pkg/encryption"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRotateKeys(t *testing.T) {
+	ctx := context.Background()
+	_, db := newTestMerchantStore(t)
+
+	legacy, err := encryption.NewAESGCM(bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var f encryption.KeyFile
+	if _, err := f.Generate(); err != nil {
+		t.Fatal(err)
+	}
+	k1, err := encryption.NewKeyring(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Merchant written before master keys, payments with the first master key
+	assert.NoError(t, NewMerchantStore(db, legacy).Put(ctx, Merchant{ID: "brand_a", Name: "Brand A", TestKeys: OmiseKeys{SecretKey: "skey_test_a"}}))
+	for i := 0; i < 5; i++ {
+		returnURI, err := k1.Encrypt([]byte(fmt.Sprintf("https://example.com/orders/%d?token=xxx", i)))
+		assert.NoError(t, err)
+		_, err = db.Exec("INSERT INTO payments (charge_id, source_id, txn_id, status, return_uri) VALUES (?, '', '', 'pending', ?)", fmt.Sprintf("chrg_%d", i), returnURI)
+		assert.NoError(t, err)
+	}
+	// Unset columns are left alone
+	_, err = db.Exec("INSERT INTO payments (charge_id, source_id, txn_id, status) VALUES ('chrg_empty', '', '', 'pending')")
+	assert.NoError(t, err)
+
+	if _, err := f.Generate(); err != nil {
+		t.Fatal(err)
+	}
+	k2, err := encryption.NewKeyring(f, encryption.WithLegacyCipher(legacy))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	batches := 0
+	n, err := RotateKeys(ctx, db, k2, 2, func(table, column string, n int) { batches++ })
+	assert.NoError(t, err)
+	assert.Equal(t, 6, n)
+	assert.Equal(t, 4, batches)
+
+	m, err := NewMerchantStore(db, k2).Get(ctx, "brand_a")
+	assert.NoError(t, err)
+	assert.Equal(t, "skey_test_a", m.TestKeys.SecretKey)
+
+	var testSecretKey string
+	assert.NoError(t, db.QueryRow("SELECT test_secret_key FROM merchants WHERE id = 'brand_a'").Scan(&testSecretKey))
+	assert.True(t, k2.Current(testSecretKey))
+
+	rows, err := db.Query("SELECT charge_id, return_uri FROM payments WHERE return_uri <> '' ORDER BY charge_id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	i := 0
+	for rows.Next() {
+		var chargeID, returnURI string
+		assert.NoError(t, rows.Scan(&chargeID, &returnURI))
+		assert.True(t, k2.Current(returnURI))
+
+		plaintext, err := k2.Decrypt(returnURI)
+		assert.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("https://example.com/orders/%d?token=xxx", i), string(plaintext))
+		i++
+	}
+	assert.Equal(t, 5, i)
+
+	// Nothing left to re-encrypt
+	n, err = RotateKeys(ctx, db, k2, 2, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+}
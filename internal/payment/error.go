@@ -1,10 +1,304 @@
 package payment
 
-import "errors"
+import (
+	"context"
+	"errors"
+	"exam-payment-service/pkg/omiseprovider"
+	"net"
+	"net/http"
+
+	"github.com/omise/omise-go"
+)
+
+// Error is a domain error carrying a machine-readable code, the HTTP status
+// it maps to and a message that is safe to return to clients
+type Error struct {
+	Code    string
+	Status  int
+	Message string
+
+	// UpstreamCode is the error code returned by Omise, if any
+	UpstreamCode string
+
+	// Err is the underlying cause, never returned to clients
+	Err error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is reports errors with the same code as equal, so wrapped copies match their sentinel
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// Wrap returns a copy of e caused by err
+func (e *Error) Wrap(err error) *Error {
+	c := *e
+	c.Err = err
+	return &c
+}
 
 var (
-	ErrAmountLowerThanChargeLimit = errors.New("amount is lower than charge limit")
-	ErrChargeLimitExceeded        = errors.New("charge limit exceeded")
-	ErrInvalidCurrency            = errors.New("invalid currency")
-	ErrInvalidSourceType          = errors.New("invalid source type")
+	ErrAmountLowerThanChargeLimit = &Error{
+		Code:    "amount_lower_than_charge_limit",
+		Status:  http.StatusBadRequest,
+		Message: "amount is lower than charge limit",
+	}
+	ErrChargeLimitExceeded = &Error{
+		Code:    "charge_limit_exceeded",
+		Status:  http.StatusBadRequest,
+		Message: "charge limit exceeded",
+	}
+	ErrInvalidCurrency = &Error{
+		Code:    "invalid_currency",
+		Status:  http.StatusBadRequest,
+		Message: "invalid currency",
+	}
+	ErrInvalidSourceType = &Error{
+		Code:    "invalid_source_type",
+		Status:  http.StatusBadRequest,
+		Message: "invalid source type",
+	}
+	ErrInvalidInstallmentTerm = &Error{
+		Code:    "invalid_installment_term",
+		Status:  http.StatusBadRequest,
+		Message: "installment term is not offered by the bank, or given without an installment source type",
+	}
+	ErrAmountLowerThanInstallmentMinimum = &Error{
+		Code:    "amount_lower_than_installment_minimum",
+		Status:  http.StatusBadRequest,
+		Message: "amount lower than the installment minimum of the bank and term",
+	}
+	ErrInvalidPhoneNumber = &Error{
+		Code:    "invalid_phone_number",
+		Status:  http.StatusBadRequest,
+		Message: "phone number must be a Thai mobile number, and is only taken by truemoney",
+	}
+	ErrInvalidCardToken = &Error{
+		Code:    "invalid_card_token",
+		Status:  http.StatusBadRequest,
+		Message: "card token must be an Omise token",
+	}
+	ErrInvalidRequest = &Error{
+		Code:    "invalid_request",
+		Status:  http.StatusBadRequest,
+		Message: "invalid request payload",
+	}
+	ErrPaymentNotFound = &Error{
+		Code:    "payment_not_found",
+		Status:  http.StatusNotFound,
+		Message: "payment not found",
+	}
+	ErrInvalidAPIKey = &Error{
+		Code:    "invalid_api_key",
+		Status:  http.StatusUnauthorized,
+		Message: "invalid API key",
+	}
+	ErrInvalidMode = &Error{
+		Code:    "invalid_mode",
+		Status:  http.StatusBadRequest,
+		Message: "mode must be test or live",
+	}
+	ErrModeNotConfigured = &Error{
+		Code:    "mode_not_configured",
+		Status:  http.StatusBadRequest,
+		Message: "no Omise keys are configured for this mode",
+	}
+	ErrLivemodeMismatch = &Error{
+		Code:    "livemode_mismatch",
+		Status:  http.StatusConflict,
+		Message: "event livemode does not match the payment",
+	}
+	ErrMerchantNotFound = &Error{
+		Code:    "merchant_not_found",
+		Status:  http.StatusNotFound,
+		Message: "merchant not found",
+	}
+	ErrMerchantMismatch = &Error{
+		Code:    "merchant_mismatch",
+		Status:  http.StatusConflict,
+		Message: "payment belongs to another merchant",
+	}
+	ErrSourceTypeNotEnabled = &Error{
+		Code:    "source_type_not_enabled",
+		Status:  http.StatusBadRequest,
+		Message: "source type is not enabled for this merchant",
+	}
+	ErrInvalidSignature = &Error{
+		Code:    "invalid_signature",
+		Status:  http.StatusUnauthorized,
+		Message: "invalid webhook signature",
+	}
+	ErrWebhookSecretNotSet = &Error{
+		Code:    "webhook_secret_not_set",
+		Status:  http.StatusUnauthorized,
+		Message: "merchant has no webhook secret, webhooks cannot be verified",
+	}
+	ErrEventNotFound = &Error{
+		Code:    "event_not_found",
+		Status:  http.StatusNotFound,
+		Message: "webhook event not found",
+	}
+	ErrEventNotDead = &Error{
+		Code:    "event_not_dead",
+		Status:  http.StatusConflict,
+		Message: "only dead-lettered webhook events can be requeued",
+	}
+	ErrPaymentNotInReview = &Error{
+		Code:    "payment_not_in_review",
+		Status:  http.StatusConflict,
+		Message: "payment does not need review",
+	}
+	ErrReviewStatusRequired = &Error{
+		Code:    "review_status_required",
+		Status:  http.StatusBadRequest,
+		Message: "no status was reported for the payment, the review needs one",
+	}
+	ErrPaymentNotAuthorized = &Error{
+		Code:    "payment_not_authorized",
+		Status:  http.StatusConflict,
+		Message: "only authorized payments can be captured or reversed",
+	}
+	ErrInvalidCaptureAmount = &Error{
+		Code:    "invalid_capture_amount",
+		Status:  http.StatusBadRequest,
+		Message: "capture amount must not exceed the authorized amount",
+	}
+	ErrCustomerNotFound = &Error{
+		Code:    "customer_not_found",
+		Status:  http.StatusNotFound,
+		Message: "customer not found",
+	}
+	ErrCustomerExists = &Error{
+		Code:    "customer_exists",
+		Status:  http.StatusConflict,
+		Message: "the user already is a customer",
+	}
+	ErrInvalidSchedule = &Error{
+		Code:    "invalid_schedule",
+		Status:  http.StatusBadRequest,
+		Message: "period must be daily, weekly or monthly and the end date after the start date",
+	}
+	ErrSubscriptionNotFound = &Error{
+		Code:    "subscription_not_found",
+		Status:  http.StatusNotFound,
+		Message: "subscription not found",
+	}
+	ErrInvalidSubscriptionStatus = &Error{
+		Code:    "invalid_subscription_status",
+		Status:  http.StatusConflict,
+		Message: "the subscription cannot be changed in its status",
+	}
+	ErrBarcodeNotFound = &Error{
+		Code:    "barcode_not_found",
+		Status:  http.StatusNotFound,
+		Message: "the payment has no barcode",
+	}
+	ErrPaymentLinkNotFound = &Error{
+		Code:    "payment_link_not_found",
+		Status:  http.StatusNotFound,
+		Message: "payment link not found",
+	}
+	ErrProviderRejected = &Error{
+		Code:    "provider_rejected",
+		Status:  http.StatusUnprocessableEntity,
+		Message: "payment provider rejected the request",
+	}
+	ErrProviderFailure = &Error{
+		Code:    "provider_failure",
+		Status:  http.StatusBadGateway,
+		Message: "payment provider error",
+	}
+	ErrProviderUnavailable = &Error{
+		Code:    "provider_unavailable",
+		Status:  http.StatusServiceUnavailable,
+		Message: "payment provider is temporarily unavailable",
+	}
+	ErrProviderTimeout = &Error{
+		Code:    "provider_timeout",
+		Status:  http.StatusGatewayTimeout,
+		Message: "payment provider did not respond in time",
+	}
+	ErrInternal = &Error{
+		Code:    "internal_error",
+		Status:  http.StatusInternalServerError,
+		Message: "internal server error",
+	}
 )
+
+// wrapOmiseError converts errors from the Omise provider into domain errors,
+// keeping the upstream error code
+func wrapOmiseError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var oErr *omise.Error
+	if errors.As(err, &oErr) {
+		e := ErrProviderFailure
+		switch oErr.StatusCode {
+		case http.StatusBadRequest, http.StatusNotFound, http.StatusUnprocessableEntity:
+			e = ErrProviderRejected
+		}
+
+		wrapped := e.Wrap(err)
+		wrapped.UpstreamCode = oErr.Code
+
+		return wrapped
+	}
+
+	if errors.Is(err, omiseprovider.ErrUnavailable) {
+		return ErrProviderUnavailable.Wrap(err)
+	}
+
+	var nErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &nErr) && nErr.Timeout()) {
+		return ErrProviderTimeout.Wrap(err)
+	}
+
+	return ErrProviderFailure.Wrap(err)
+}
+
+// SafeError returns what of err can be logged. Messages of Omise errors may carry card or
+// customer data, so only their codes are kept
+func SafeError(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	var e *Error
+	if errors.As(err, &e) {
+		s := e.Code
+		if e.UpstreamCode != "" {
+			s += " (" + e.UpstreamCode + ")"
+		}
+		if e.Err != nil {
+			s += ": " + SafeError(e.Err)
+		}
+		return s
+	}
+
+	var oErr *omise.Error
+	if errors.As(err, &oErr) {
+		return "omise error " + oErr.Code
+	}
+
+	var tErr omise.ErrTransport
+	var tErrPtr *omise.ErrTransport
+	if errors.As(err, &tErr) || errors.As(err, &tErrPtr) {
+		return "omise transport error"
+	}
+
+	return err.Error()
+}
@@ -0,0 +1,112 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"exam-payment-service/pkg/omiseprovider"
+	"net/http"
+	"testing"
+
+	"github.com/omise/omise-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWrapOmiseError(t *testing.T) {
+	testCases := []struct {
+		name                 string
+		err                  error
+		expectedError        error
+		expectedStatus       int
+		expectedUpstreamCode string
+	}{
+		{
+			name:                 "Rejected by Omise",
+			err:                  &omise.Error{StatusCode: http.StatusBadRequest, Code: "invalid_amount"},
+			expectedError:        ErrProviderRejected,
+			expectedStatus:       http.StatusUnprocessableEntity,
+			expectedUpstreamCode: "invalid_amount",
+		},
+		{
+			name:                 "Omise failure",
+			err:                  &omise.Error{StatusCode: http.StatusUnauthorized, Code: "authentication_failure"},
+			expectedError:        ErrProviderFailure,
+			expectedStatus:       http.StatusBadGateway,
+			expectedUpstreamCode: "authentication_failure",
+		},
+		{
+			name:           "Circuit breaker open",
+			err:            omiseprovider.ErrUnavailable,
+			expectedError:  ErrProviderUnavailable,
+			expectedStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name:           "Deadline exceeded",
+			err:            context.DeadlineExceeded,
+			expectedError:  ErrProviderTimeout,
+			expectedStatus: http.StatusGatewayTimeout,
+		},
+		{
+			name:           "Network error",
+			err:            errors.New("connection reset by peer"),
+			expectedError:  ErrProviderFailure,
+			expectedStatus: http.StatusBadGateway,
+		},
+	}
+
+	t.Parallel()
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := wrapOmiseError(tc.err)
+
+			var pErr *Error
+			if !errors.As(err, &pErr) {
+				t.Fatalf("expected *Error, got %T", err)
+			}
+
+			assert.True(t, errors.Is(err, tc.expectedError))
+			assert.True(t, errors.Is(err, tc.err))
+			assert.Equal(t, tc.expectedStatus, pErr.Status)
+			assert.Equal(t, tc.expectedUpstreamCode, pErr.UpstreamCode)
+		})
+	}
+}
+
+func TestSafeError(t *testing.T) {
+	testCases := []struct {
+		name     string
+		err      error
+		expected string
+	}{
+		{
+			name:     "Omise error",
+			err:      wrapOmiseError(&omise.Error{StatusCode: http.StatusBadRequest, Code: "invalid_card", Message: "card 4242424242424242 was declined"}),
+			expected: "provider_rejected (invalid_card): omise error invalid_card",
+		},
+		{
+			name:     "Omise transport error",
+			err:      wrapOmiseError(&omise.ErrTransport{Err: errors.New("bad response"), Buffer: []byte(`{"email":"john@example.com"}`)}),
+			expected: "provider_failure: omise transport error",
+		},
+		{
+			name:     "Database error",
+			err:      ErrInternal.Wrap(errors.New("database is locked")),
+			expected: "internal_error: database is locked",
+		},
+		{
+			name:     "Domain error",
+			err:      ErrLivemodeMismatch,
+			expected: "livemode_mismatch",
+		},
+		{
+			name:     "Nil",
+			expected: "",
+		},
+	}
+
+	t.Parallel()
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, SafeError(tc.err))
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package payment
+
+import (
+	"time"
+
+	"github.com/omise/omise-go"
+)
+
+// PaymentEvent is an Omise event as delivered to the webhook.
+// Data is the charge for charge.* events, for other objects only the fields they share
+// with a charge are decoded, check Data.Object
+type PaymentEvent struct {
+	Object            string            `json:"object"`
+	ID                string            `json:"id"`
+	Livemode          bool              `json:"livemode"`
+	Location          string            `json:"location"`
+	Key               string            `json:"key"`
+	CreatedAt         time.Time         `json:"created_at"`
+	WebhookDeliveries []WebhookDelivery `json:"webhook_deliveries"`
+	Data              EventCharge       `json:"data"`
+}
+
+type WebhookDelivery struct {
+	Object string `json:"object"`
+	ID     string `json:"id"`
+	URI    string `json:"uri"`
+	Status int    `json:"status"`
+}
+
+// EventCharge is the charge object of the event, nullable fields are pointers.
+// Status is a string as Omise sends statuses omise.ChargeStatus has no constant for, e.g. expired
+type EventCharge struct {
+	Object   string `json:"object"`
+	ID       string `json:"id"`
+	Location string `json:"location"`
+	Livemode bool   `json:"livemode"`
+	Status   string `json:"status"`
+
+	Amount          int64       `json:"amount"`
+	Currency        string      `json:"currency"`
+	Net             int64       `json:"net"`
+	Fee             int64       `json:"fee"`
+	FeeVat          int64       `json:"fee_vat"`
+	Interest        int64       `json:"interest"`
+	InterestVat     int64       `json:"interest_vat"`
+	FundingAmount   int64       `json:"funding_amount"`
+	FundingCurrency string      `json:"funding_currency"`
+	RefundedAmount  int64       `json:"refunded_amount"`
+	PlatformFee     PlatformFee `json:"platform_fee"`
+
+	Authorized               bool `json:"authorized"`
+	Capturable               bool `json:"capturable"`
+	Capture                  bool `json:"capture"`
+	Disputable               bool `json:"disputable"`
+	Expired                  bool `json:"expired"`
+	Paid                     bool `json:"paid"`
+	Refundable               bool `json:"refundable"`
+	Reversed                 bool `json:"reversed"`
+	Reversible               bool `json:"reversible"`
+	Voided                   bool `json:"voided"`
+	ZeroInterestInstallments bool `json:"zero_interest_installments"`
+
+	// Transaction is empty until the charge is paid
+	Transaction    string  `json:"transaction"`
+	FailureCode    *string `json:"failure_code"`
+	FailureMessage *string `json:"failure_message"`
+
+	Description *string                `json:"description"`
+	Metadata    map[string]interface{} `json:"metadata"`
+	IP          *string                `json:"ip"`
+	ReturnURI   string                 `json:"return_uri"`
+	// AuthorizeURI is empty for charges that need no redirect
+	AuthorizeURI string `json:"authorize_uri"`
+
+	// Exactly one of Card and Source is set, depending on how the customer pays
+	Card   *omise.Card   `json:"card"`
+	Source *ChargeSource `json:"source"`
+
+	// IDs of the related objects, if any
+	Customer *string `json:"customer"`
+	Link     *string `json:"link"`
+	Schedule *string `json:"schedule"`
+
+	Dispute *omise.Dispute    `json:"dispute"`
+	Refunds *omise.RefundList `json:"refunds"`
+
+	CreatedAt  time.Time  `json:"created_at"`
+	PaidAt     *time.Time `json:"paid_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	ExpiredAt  *time.Time `json:"expired_at"`
+	ReversedAt *time.Time `json:"reversed_at"`
+}
+
+// SourceID returns the ID of the charge source, empty for card charges
+func (c EventCharge) SourceID() string {
+	if c.Source == nil {
+		return ""
+	}
+
+	return c.Source.ID
+}
+
+type PlatformFee struct {
+	Fixed      *int64   `json:"fixed"`
+	Amount     *int64   `json:"amount"`
+	Percentage *float64 `json:"percentage"`
+}
+
+// ChargeSource is the source embedded in a charge, with the fields omise.Source lacks
+type ChargeSource struct {
+	omise.Source
+
+	ChargeStatus string    `json:"charge_status"`
+	CreatedAt    time.Time `json:"created_at"`
+
+	Bank                     *string           `json:"bank"`
+	Barcode                  *string           `json:"barcode"`
+	Email                    *string           `json:"email"`
+	Name                     *string           `json:"name"`
+	MobileNumber             *string           `json:"mobile_number"`
+	PhoneNumber              *string           `json:"phone_number"`
+	StoreID                  *string           `json:"store_id"`
+	StoreName                *string           `json:"store_name"`
+	TerminalID               *string           `json:"terminal_id"`
+	InstallmentTerm          *int              `json:"installment_term"`
+	ZeroInterestInstallments *bool             `json:"zero_interest_installments"`
+	ReceiptAmount            *int64            `json:"receipt_amount"`
+	References               *SourceReferences `json:"references"`
+	ScannableCode            *ScannableCode    `json:"scannable_code"`
+}
+
+// SourceReferences are the payment references of offline sources such as bill payments
+type SourceReferences struct {
+	ExpiresAt        *time.Time `json:"expires_at"`
+	DeviceID         *string    `json:"device_id"`
+	CustomerAmount   *int64     `json:"customer_amount"`
+	CustomerCurrency *string    `json:"customer_currency"`
+	ReferenceNumber1 *string    `json:"reference_number_1"`
+	ReferenceNumber2 *string    `json:"reference_number_2"`
+	Barcode          *string    `json:"barcode"`
+	OmiseTaxID       *string    `json:"omise_tax_id"`
+}
+
+// ScannableCode is the QR code or barcode of sources such as PromptPay
+type ScannableCode struct {
+	Object string              `json:"object"`
+	Type   string              `json:"type"`
+	Image  *ScannableCodeImage `json:"image"`
+}
+
+type ScannableCodeImage struct {
+	omise.Document
+	Kind        string `json:"kind"`
+	DownloadURI string `json:"download_uri"`
+}
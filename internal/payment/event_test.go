@@ -0,0 +1,139 @@
+package payment
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// TestPaymentEventGolden decodes each payload in testdata/events and compares the
+// decoded event, encoded again, with its .golden file. Payloads are Omise test mode webhooks
+// or events of the events API as Omise sent them, never written by hand
+func TestPaymentEventGolden(t *testing.T) {
+	payloads, err := filepath.Glob(filepath.Join("testdata", "events", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NotEmpty(t, payloads)
+
+	for _, payload := range payloads {
+		name := strings.TrimSuffix(filepath.Base(payload), ".json")
+
+		t.Run(name, func(t *testing.T) {
+			b, err := ioutil.ReadFile(payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var event PaymentEvent
+			if err := json.Unmarshal(b, &event); err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, strings.Join(strings.Split(name, ".")[:2], "."), event.Key)
+
+			actual, err := json.MarshalIndent(event, "", "  ")
+			if err != nil {
+				t.Fatal(err)
+			}
+			actual = append(actual, '\n')
+
+			golden := strings.TrimSuffix(payload, ".json") + ".golden"
+			if *update {
+				if err := ioutil.WriteFile(golden, actual, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			expected, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, string(expected), string(actual))
+		})
+	}
+}
+
+func TestPaymentEventNullable(t *testing.T) {
+	testCases := []struct {
+		name     string
+		payload  string
+		expected func(t *testing.T, e PaymentEvent)
+	}{
+		{
+			name:    "Pending charge has no paid, expired or reversed time",
+			payload: "charge.create.internet_banking_scb",
+			expected: func(t *testing.T, e PaymentEvent) {
+				assert.Nil(t, e.Data.PaidAt)
+				assert.Nil(t, e.Data.ExpiredAt)
+				assert.Nil(t, e.Data.ReversedAt)
+				assert.NotNil(t, e.Data.ExpiresAt)
+				assert.Nil(t, e.Data.FailureCode)
+				assert.Empty(t, e.Data.Transaction)
+				assert.Equal(t, "src_test_5r2fwrkmwjm8ww2bbjc", e.Data.SourceID())
+			},
+		},
+		{
+			name:    "Successful charge",
+			payload: "charge.complete.successful",
+			expected: func(t *testing.T, e PaymentEvent) {
+				if assert.NotNil(t, e.Data.PaidAt) {
+					assert.Equal(t, "2022-05-10T07:42:30Z", e.Data.PaidAt.Format("2006-01-02T15:04:05Z07:00"))
+				}
+				assert.Equal(t, "trxn_test_5r2fx0mxcmskmmqtbfc", e.Data.Transaction)
+			},
+		},
+		{
+			name:    "Failed charge",
+			payload: "charge.complete.failed",
+			expected: func(t *testing.T, e PaymentEvent) {
+				if assert.NotNil(t, e.Data.FailureCode) {
+					assert.Equal(t, "payment_rejected", *e.Data.FailureCode)
+				}
+				assert.Nil(t, e.Data.PaidAt)
+			},
+		},
+		{
+			name:    "Card charge has no source",
+			payload: "charge.create.card",
+			expected: func(t *testing.T, e PaymentEvent) {
+				assert.Nil(t, e.Data.Source)
+				assert.Empty(t, e.Data.SourceID())
+				if assert.NotNil(t, e.Data.Card) {
+					assert.Equal(t, "4242", e.Data.Card.LastDigits)
+				}
+			},
+		},
+		{
+			name:    "PromptPay charge has a QR code",
+			payload: "charge.create.promptpay",
+			expected: func(t *testing.T, e PaymentEvent) {
+				if assert.NotNil(t, e.Data.Source) && assert.NotNil(t, e.Data.Source.ScannableCode) {
+					assert.Equal(t, "qr", e.Data.Source.ScannableCode.Type)
+					assert.NotEmpty(t, e.Data.Source.ScannableCode.Image.DownloadURI)
+				}
+			},
+		},
+	}
+
+	t.Parallel()
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			b, err := ioutil.ReadFile(filepath.Join("testdata", "events", tc.payload+".json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var e PaymentEvent
+			assert.NoError(t, json.Unmarshal(b, &e))
+
+			tc.expected(t, e)
+		})
+	}
+}
@@ -0,0 +1,201 @@
+package payment
+
+import (
+	"context"
+	"exam-payment-service/pkg/logger"
+	"time"
+
+	"github.com/omise/omise-go"
+	"github.com/omise/omise-go/operations"
+)
+
+// PaymentExpiryInterval is how often overdue pending payments are checked with Omise
+var PaymentExpiryInterval = time.Minute
+
+// omiseExpiry reports whether Omise takes the expiry of charges of the source type
+func (s SourceType) omiseExpiry() bool {
+	return s == SourceTypeInternetBankSCB || s.wallet()
+}
+
+// validateExpiry checks the expiry of a request. Only charges Omise takes the expiry of can have
+// one, a payment is only expired once Omise expires its charge. Bill payments expire when Omise
+// says and authorizations expire on their own
+func (pr PaymentRequest) validateExpiry() error {
+	if pr.ExpiresInSeconds < 0 {
+		return ErrInvalidRequest
+	}
+
+	if pr.ExpiresInSeconds > 0 && (!pr.method().omiseExpiry() || !pr.capture()) {
+		return ErrInvalidRequest
+	}
+
+	return nil
+}
+
+// expiresAt returns when a payment created at now expires, nil when it does not
+func (pr PaymentRequest) expiresAt(now time.Time) *time.Time {
+	if pr.ExpiresInSeconds == 0 {
+		return nil
+	}
+
+	expiresAt := now.Add(time.Duration(pr.ExpiresInSeconds) * time.Second).UTC().Truncate(time.Second)
+	return &expiresAt
+}
+
+func (p Payment) recordExpiry(ctx context.Context, chargeID string, expiresAt time.Time) error {
+	_, err := p.db.ExecContext(ctx, "UPDATE payments SET expires_at = ? WHERE charge_id = ?", expiresAt.UTC(), chargeID)
+
+	return err
+}
+
+type overduePayment struct {
+	ChargeID   string
+	MerchantID string
+	Livemode   bool
+}
+
+// ExpirePayments checks with Omise the pending payments that expired before the given time, bill
+// payments are left to ExpireBillPayments. A payment gets the status of its charge once Omise
+// completes or expires it, one that fails or that Omise still has pending is checked again on
+// the next run
+func (p Payment) ExpirePayments(ctx context.Context, before time.Time) (int, error) {
+	return p.expireOverdue(ctx, before, false)
+}
+
+// expireOverdue checks with Omise the overdue pending payments, bill payments or the others,
+// and returns how many Omise expired
+func (p Payment) expireOverdue(ctx context.Context, before time.Time, billPayments bool) (int, error) {
+	// Bill payments are those with a barcode
+	barcode := "barcode = ''"
+	if billPayments {
+		barcode = "barcode != ''"
+	}
+
+	rows, err := p.db.QueryContext(
+		ctx,
+		`SELECT charge_id, merchant_id, COALESCE(livemode, false) FROM payments
+		WHERE status = ? AND `+barcode+` AND expires_at <= ? ORDER BY expires_at`,
+		string(omise.ChargePending), before.UTC(),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	var overdue []overduePayment
+	for rows.Next() {
+		var o overduePayment
+		if err := rows.Scan(&o.ChargeID, &o.MerchantID, &o.Livemode); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		overdue = append(overdue, o)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, o := range overdue {
+		ctx := ContextWithLivemode(ContextWithMerchant(ctx, o.MerchantID), o.Livemode)
+
+		expired, err := p.expirePayment(ctx, o.ChargeID)
+		if err != nil {
+			if ctx.Err() != nil {
+				return n, ctx.Err()
+			}
+			logger.For(ctx, p.log).Errorw("ExpirePayments error", "error", SafeError(err), "charge_id", o.ChargeID)
+			continue
+		}
+		if expired {
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+// expirePayment applies the charge of an overdue payment of the context's merchant as Omise has
+// it, as a charge.expire event when Omise expired it. A charge Omise still has pending can still
+// be paid, so it is left pending
+func (p Payment) expirePayment(ctx context.Context, chargeID string) (bool, error) {
+	oc, err := p.provider(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	charge, err := oc.RetrieveCharge(ctx, operations.RetrieveCharge{ChargeID: chargeID})
+	if err != nil {
+		return false, wrapOmiseError(err)
+	}
+
+	if charge.Status == omise.ChargePending {
+		logger.For(ctx, p.log).Infow("Overdue payment still pending at Omise", "charge_id", chargeID)
+		return false, nil
+	}
+
+	event := PaymentEvent{Key: "charge.complete", Livemode: charge.Live, Data: eventCharge(charge)}
+	if string(charge.Status) == StatusExpired {
+		event.Key = "charge.expire"
+		event.Data.Expired = true
+	}
+
+	status, _, err := p.applyChargeEvent(ctx, event)
+	if err != nil {
+		return false, err
+	}
+
+	logger.For(ctx, p.log).Infow("Overdue payment applied", "charge_id", chargeID, "key", event.Key, "status", status)
+
+	return status == StatusExpired, nil
+}
+
+// eventCharge returns the fields of a retrieved charge that events of it are applied with
+func eventCharge(charge omise.Charge) EventCharge {
+	c := EventCharge{
+		Object:         charge.Object,
+		ID:             charge.ID,
+		Livemode:       charge.Live,
+		Status:         string(charge.Status),
+		Amount:         charge.Amount,
+		Currency:       charge.Currency,
+		Authorized:     charge.Authorized,
+		Capture:        charge.Capture,
+		Paid:           charge.Paid,
+		Reversed:       charge.Reversed,
+		Transaction:    charge.Transaction,
+		FailureCode:    charge.FailureCode,
+		FailureMessage: charge.FailureMessage,
+		ReturnURI:      charge.ReturnURI,
+		AuthorizeURI:   charge.AuthorizeURI,
+		Card:           charge.Card,
+		CreatedAt:      charge.Created,
+	}
+	if charge.Source != nil {
+		c.Source = &ChargeSource{Source: *charge.Source}
+	}
+
+	return c
+}
+
+// RunPaymentExpiry expires overdue pending payments on every interval until ctx is done
+func (p Payment) RunPaymentExpiry(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := p.ExpirePayments(ctx, time.Now())
+		if err != nil && ctx.Err() == nil {
+			logger.For(ctx, p.log).Errorw("ExpirePayments error", "error", SafeError(err))
+		}
+		if n > 0 {
+			logger.For(ctx, p.log).Infow("Payments expired", "count", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package payment
+
+import (
+	"context"
+	"exam-payment-service/pkg/omiseprovider"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/omise/omise-go"
+	"github.com/omise/omise-go/operations"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPaymentRequestExpiry(t *testing.T) {
+	ctx := context.Background()
+	p, db, op, _ := newTestMockedPayment(t)
+
+	start := time.Now().UTC().Truncate(time.Second)
+	op.EXPECT().CreateSource(gomock.Any(), gomock.Any()).Return(omise.Source{ID: "src_test_a"}, nil)
+	op.EXPECT().CreateCharge(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, createCharge omiseprovider.CreateCharge) (omise.Charge, error) {
+			if assert.NotNil(t, createCharge.ExpiresAt) {
+				assert.WithinDuration(t, start.Add(10*time.Minute), *createCharge.ExpiresAt, 2*time.Second)
+			}
+			return omise.Charge{Base: omise.Base{ID: "chrg_test_a"}, Status: omise.ChargePending}, nil
+		},
+	)
+
+	_, err := p.CreatePaymentRequest(ctx, PaymentRequest{Amount: 20000, Currency: CurrencyTHB, SourceType: SourceTypeInternetBankSCB, ExpiresInSeconds: 600})
+	assert.NoError(t, err)
+
+	var expiresAt time.Time
+	assert.NoError(t, db.QueryRow("SELECT expires_at FROM payments WHERE charge_id = 'chrg_test_a'").Scan(&expiresAt))
+	assert.WithinDuration(t, start.Add(10*time.Minute), expiresAt, 2*time.Second)
+
+	capture := false
+	testCases := []struct {
+		name    string
+		request PaymentRequest
+	}{
+		{
+			name:    "Negative",
+			request: PaymentRequest{Amount: 20000, Currency: CurrencyTHB, SourceType: SourceTypeInternetBankSCB, ExpiresInSeconds: -1},
+		},
+		{
+			name:    "Bill payment",
+			request: PaymentRequest{Amount: 20000, Currency: CurrencyTHB, SourceType: SourceTypeBillPaymentTescoLotus, ExpiresInSeconds: 600},
+		},
+		{
+			name:    "Card, Omise does not expire it",
+			request: PaymentRequest{Amount: 20000, Currency: CurrencyTHB, CardToken: "tokn_test_b", ExpiresInSeconds: 600},
+		},
+		{
+			name:    "Customer card",
+			request: PaymentRequest{Amount: 20000, Currency: CurrencyTHB, CustomerID: "cust_test_b", ExpiresInSeconds: 600},
+		},
+		{
+			name:    "Installment",
+			request: PaymentRequest{Amount: 500000, Currency: CurrencyTHB, SourceType: SourceTypeInstallmentBAY, InstallmentTerm: 4, ExpiresInSeconds: 600},
+		},
+		{
+			name:    "Authorization",
+			request: PaymentRequest{Amount: 20000, Currency: CurrencyTHB, CardToken: "tokn_test_c", Capture: &capture, ExpiresInSeconds: 600},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := p.CreatePaymentRequest(ctx, tc.request)
+			assert.Equal(t, ErrInvalidRequest, err)
+		})
+	}
+}
+
+func TestExpirePayments(t *testing.T) {
+	ctx := context.Background()
+	p, db, op, _ := newTestMockedPayment(t)
+
+	now := time.Now().UTC()
+	for _, payment := range []struct {
+		chargeID  string
+		barcode   string
+		expiresAt time.Time
+	}{
+		{"chrg_test_pending", "", now.Add(-time.Minute)},
+		{"chrg_test_paid", "", now.Add(-time.Minute)},
+		{"chrg_test_unavailable", "", now.Add(-time.Minute)},
+		{"chrg_test_later", "", now.Add(time.Hour)},
+		{"chrg_test_bill", testBarcode, now.Add(-time.Minute)},
+	} {
+		_, err := db.Exec(
+			"INSERT INTO payments (charge_id, source_id, txn_id, status, amount, currency, livemode, barcode, expires_at) VALUES (?, 'src_test', '', 'pending', 20000, 'thb', false, ?, ?)",
+			payment.chargeID, payment.barcode, payment.expiresAt,
+		)
+		assert.NoError(t, err)
+	}
+
+	charge := func(id string, status omise.ChargeStatus, paid bool) omise.Charge {
+		return omise.Charge{Base: omise.Base{ID: id}, Status: status, Amount: 20000, Currency: "thb", Capture: true, Paid: paid, Transaction: "trxn_" + id}
+	}
+	op.EXPECT().RetrieveCharge(gomock.Any(), operations.RetrieveCharge{ChargeID: "chrg_test_pending"}).
+		Return(charge("chrg_test_pending", omise.ChargePending, false), nil)
+	op.EXPECT().RetrieveCharge(gomock.Any(), operations.RetrieveCharge{ChargeID: "chrg_test_paid"}).
+		Return(charge("chrg_test_paid", omise.ChargeSuccessful, true), nil)
+	op.EXPECT().RetrieveCharge(gomock.Any(), operations.RetrieveCharge{ChargeID: "chrg_test_unavailable"}).
+		Return(omise.Charge{}, &omise.ErrTransport{})
+
+	n, err := p.ExpirePayments(ctx, now)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	// Omise still has the charge pending, the customer can still pay it
+	for chargeID, expected := range map[string]string{
+		"chrg_test_pending":     string(omise.ChargePending),
+		"chrg_test_paid":        string(omise.ChargeSuccessful),
+		"chrg_test_unavailable": string(omise.ChargePending),
+		"chrg_test_later":       string(omise.ChargePending),
+		"chrg_test_bill":        string(omise.ChargePending),
+	} {
+		status, err := p.GetPaymentStatusWithChargeID(ctx, chargeID)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, status.Status, chargeID)
+	}
+
+	// The payments that failed or were pending are checked again, expired once Omise expires them
+	op.EXPECT().RetrieveCharge(gomock.Any(), operations.RetrieveCharge{ChargeID: "chrg_test_pending"}).
+		Return(charge("chrg_test_pending", omise.ChargeStatus(StatusExpired), false), nil)
+	op.EXPECT().RetrieveCharge(gomock.Any(), operations.RetrieveCharge{ChargeID: "chrg_test_unavailable"}).
+		Return(charge("chrg_test_unavailable", omise.ChargeStatus(StatusExpired), false), nil)
+
+	n, err = p.ExpirePayments(ctx, now)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	for _, chargeID := range []string{"chrg_test_pending", "chrg_test_unavailable"} {
+		status, err := p.GetPaymentStatusWithChargeID(ctx, chargeID)
+		assert.NoError(t, err)
+		assert.Equal(t, StatusExpired, status.Status, chargeID)
+	}
+}
+
+func TestHookChargeExpire(t *testing.T) {
+	ctx := context.Background()
+	p, db, _, _ := newTestMockedPayment(t)
+
+	_, err := db.Exec("INSERT INTO payments (charge_id, source_id, txn_id, status, amount, currency, livemode) VALUES ('chrg_test_a', 'src_test', '', 'pending', 20000, 'thb', false), ('chrg_test_b', 'src_test', 'trxn_test_b', 'successful', 20000, 'thb', false)")
+	assert.NoError(t, err)
+
+	for _, chargeID := range []string{"chrg_test_a", "chrg_test_b"} {
+		err := p.HookPaymentEvent(ctx, PaymentEvent{
+			ID:   "evnt_test_" + chargeID,
+			Key:  "charge.expire",
+			Data: EventCharge{ID: chargeID, Status: StatusExpired, Amount: 20000, Currency: "thb", Capture: true, Expired: true},
+		})
+		assert.NoError(t, err)
+	}
+
+	status, err := p.GetPaymentStatusWithChargeID(ctx, "chrg_test_a")
+	assert.NoError(t, err)
+	assert.Equal(t, StatusExpired, status.Status)
+
+	// A late expiry does not undo a completed payment
+	status, err = p.GetPaymentStatusWithChargeID(ctx, "chrg_test_b")
+	assert.NoError(t, err)
+	assert.Equal(t, string(omise.ChargeSuccessful), status.Status)
+}
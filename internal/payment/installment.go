@@ -0,0 +1,67 @@
+package payment
+
+var (
+	SourceTypeInstallmentBAY         SourceType = "installment_bay"
+	SourceTypeInstallmentBBL         SourceType = "installment_bbl"
+	SourceTypeInstallmentFirstChoice SourceType = "installment_first_choice"
+	SourceTypeInstallmentKBank       SourceType = "installment_kbank"
+	SourceTypeInstallmentKTC         SourceType = "installment_ktc"
+	SourceTypeInstallmentSCB         SourceType = "installment_scb"
+	SourceTypeInstallmentTTB         SourceType = "installment_ttb"
+	SourceTypeInstallmentUOB         SourceType = "installment_uob"
+)
+
+// InstallmentMinAmount is the smallest amount Omise pays in installments with any bank, in satang
+var InstallmentMinAmount int64 = 200000
+
+// installmentPlan is what a bank offers, MinMonthly is the smallest monthly payment in satang
+type installmentPlan struct {
+	Terms      []int64
+	MinMonthly int64
+}
+
+// installmentPlans by source type, as documented by Omise for THB
+var installmentPlans = map[SourceType]installmentPlan{
+	SourceTypeInstallmentBAY:         {Terms: []int64{3, 4, 6, 9, 10}, MinMonthly: 30000},
+	SourceTypeInstallmentBBL:         {Terms: []int64{4, 6, 8, 9, 10}, MinMonthly: 50000},
+	SourceTypeInstallmentFirstChoice: {Terms: []int64{3, 4, 6, 9, 10, 12, 18, 24, 36}, MinMonthly: 30000},
+	SourceTypeInstallmentKBank:       {Terms: []int64{3, 4, 6, 10}, MinMonthly: 30000},
+	SourceTypeInstallmentKTC:         {Terms: []int64{3, 4, 5, 6, 7, 8, 9, 10}, MinMonthly: 30000},
+	SourceTypeInstallmentSCB:         {Terms: []int64{3, 4, 6, 9, 10}, MinMonthly: 50000},
+	SourceTypeInstallmentTTB:         {Terms: []int64{3, 4, 6, 10, 12}, MinMonthly: 50000},
+	SourceTypeInstallmentUOB:         {Terms: []int64{3, 4, 6, 10}, MinMonthly: 50000},
+}
+
+func (s SourceType) installment() bool {
+	_, ok := installmentPlans[s]
+	return ok
+}
+
+// validateInstallment checks the term and amount of a request, only installment source types
+// take a term and they need one
+func (pr PaymentRequest) validateInstallment() error {
+	plan, ok := installmentPlans[pr.SourceType]
+	if !ok {
+		if pr.InstallmentTerm != 0 || pr.ZeroInterestInstallments {
+			return ErrInvalidInstallmentTerm
+		}
+		return nil
+	}
+
+	allowed := false
+	for _, term := range plan.Terms {
+		if term == pr.InstallmentTerm {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return ErrInvalidInstallmentTerm
+	}
+
+	if pr.Amount < InstallmentMinAmount || pr.Amount < plan.MinMonthly*pr.InstallmentTerm {
+		return ErrAmountLowerThanInstallmentMinimum
+	}
+
+	return nil
+}
@@ -0,0 +1,245 @@
+package payment
+
+import (
+	"context"
+	"database/sql"
+	"exam-payment-service/pkg/encryption"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMerchantID is the merchant of the API keys set in the environment and of webhooks
+// that name no merchant, payments made before merchants existed belong to it
+const DefaultMerchantID = "default"
+
+type merchantKey struct{}
+
+// ContextWithMerchant sets the merchant the payments of a request are made for
+func ContextWithMerchant(ctx context.Context, merchantID string) context.Context {
+	return context.WithValue(ctx, merchantKey{}, merchantID)
+}
+
+// MerchantFromContext returns the merchant of the request, the default merchant when none is set
+func MerchantFromContext(ctx context.Context) string {
+	merchantID, _ := ctx.Value(merchantKey{}).(string)
+	if merchantID == "" {
+		return DefaultMerchantID
+	}
+
+	return merchantID
+}
+
+// OmiseKeys of one mode of an Omise account, empty for a mode the merchant does not use
+type OmiseKeys struct {
+	PublicKey string
+	SecretKey string
+}
+
+// Merchant has its own Omise account, limits and payment methods
+type Merchant struct {
+	ID   string
+	Name string
+
+	TestKeys OmiseKeys
+	LiveKeys OmiseKeys
+	// WebhookSecret is the base64 secret Omise signs the account's webhooks with,
+	// webhooks are rejected when empty
+	WebhookSecret string
+
+	// Charge limits of the merchant, zero for the default limits
+	ChargeLimitMin int64
+	ChargeLimitMax int64
+	// SourceTypes enabled for the merchant, every supported source type when empty
+	SourceTypes []SourceType
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// chargeLimits returns the merchant limits, falling back to the limits of the currency
+func (m Merchant) chargeLimits(currency Currency) (min int64, max int64) {
+	switch currency {
+	case CurrencyTHB:
+		min, max = ChargeLimitTHBMin, ChargeLimitTHBMax
+	}
+
+	if m.ChargeLimitMin > 0 {
+		min = m.ChargeLimitMin
+	}
+	if m.ChargeLimitMax > 0 {
+		max = m.ChargeLimitMax
+	}
+
+	return min, max
+}
+
+func (m Merchant) sourceTypeEnabled(s SourceType) bool {
+	if len(m.SourceTypes) == 0 {
+		return true
+	}
+
+	for _, enabled := range m.SourceTypes {
+		if enabled == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// MerchantStore keeps merchants in the database with their secret keys and webhook secret encrypted
+type MerchantStore struct {
+	db     *sql.DB
+	cipher encryption.Cipher
+}
+
+func NewMerchantStore(db *sql.DB, cipher encryption.Cipher) *MerchantStore {
+	return &MerchantStore{db, cipher}
+}
+
+// Put creates the merchant or replaces every field of an existing one
+func (s *MerchantStore) Put(ctx context.Context, m Merchant) error {
+	secrets := []string{m.TestKeys.SecretKey, m.LiveKeys.SecretKey, m.WebhookSecret}
+	for i, secret := range secrets {
+		encrypted, err := encryptString(s.cipher, secret)
+		if err != nil {
+			return err
+		}
+		secrets[i] = encrypted
+	}
+
+	sourceTypes := make([]string, len(m.SourceTypes))
+	for i, st := range m.SourceTypes {
+		sourceTypes[i] = string(st)
+	}
+
+	now := time.Now().UTC()
+	_, err := s.db.ExecContext(
+		ctx,
+		`INSERT INTO merchants (id, name, test_public_key, test_secret_key, live_public_key, live_secret_key, webhook_secret,
+			charge_limit_min, charge_limit_max, source_types, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET name = excluded.name,
+			test_public_key = excluded.test_public_key, test_secret_key = excluded.test_secret_key,
+			live_public_key = excluded.live_public_key, live_secret_key = excluded.live_secret_key,
+			webhook_secret = excluded.webhook_secret, charge_limit_min = excluded.charge_limit_min,
+			charge_limit_max = excluded.charge_limit_max, source_types = excluded.source_types, updated_at = excluded.updated_at`,
+		m.ID, m.Name, m.TestKeys.PublicKey, secrets[0], m.LiveKeys.PublicKey, secrets[1], secrets[2],
+		m.ChargeLimitMin, m.ChargeLimitMax, strings.Join(sourceTypes, ","), now, now,
+	)
+
+	return err
+}
+
+const selectMerchants = `SELECT id, name, test_public_key, test_secret_key, live_public_key, live_secret_key, webhook_secret,
+	charge_limit_min, charge_limit_max, source_types, created_at, updated_at FROM merchants`
+
+// Get returns the merchant with its secrets decrypted
+func (s *MerchantStore) Get(ctx context.Context, merchantID string) (Merchant, error) {
+	m, err := s.scan(s.db.QueryRowContext(ctx, selectMerchants+" WHERE id = ?", merchantID))
+	if err == sql.ErrNoRows {
+		return Merchant{}, ErrMerchantNotFound
+	}
+
+	return m, err
+}
+
+// List returns every merchant ordered by ID
+func (s *MerchantStore) List(ctx context.Context) ([]Merchant, error) {
+	rows, err := s.db.QueryContext(ctx, selectMerchants+" ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var merchants []Merchant
+	for rows.Next() {
+		m, err := s.scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		merchants = append(merchants, m)
+	}
+
+	return merchants, rows.Err()
+}
+
+// scanner is a *sql.Row or *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func (s *MerchantStore) scan(row scanner) (Merchant, error) {
+	var (
+		m           Merchant
+		secrets     [3]string
+		sourceTypes string
+	)
+	err := row.Scan(
+		&m.ID, &m.Name, &m.TestKeys.PublicKey, &secrets[0], &m.LiveKeys.PublicKey, &secrets[1], &secrets[2],
+		&m.ChargeLimitMin, &m.ChargeLimitMax, &sourceTypes, &m.CreatedAt, &m.UpdatedAt,
+	)
+	if err != nil {
+		return Merchant{}, err
+	}
+
+	for i, secret := range secrets {
+		decrypted, err := decryptString(s.cipher, secret)
+		if err != nil {
+			return Merchant{}, err
+		}
+		secrets[i] = decrypted
+	}
+	m.TestKeys.SecretKey, m.LiveKeys.SecretKey, m.WebhookSecret = secrets[0], secrets[1], secrets[2]
+
+	if sourceTypes != "" {
+		for _, st := range strings.Split(sourceTypes, ",") {
+			m.SourceTypes = append(m.SourceTypes, SourceType(st))
+		}
+	}
+
+	return m, nil
+}
+
+// ProviderFactory builds the Omise providers of a merchant from its keys
+type ProviderFactory func(m Merchant) (Providers, error)
+
+// providerCache keeps the providers of each merchant until the merchant is updated
+type providerCache struct {
+	newProviders ProviderFactory
+
+	mu      sync.Mutex
+	entries map[string]cachedProviders
+}
+
+type cachedProviders struct {
+	updatedAt time.Time
+	providers Providers
+}
+
+func newProviderCache(newProviders ProviderFactory) *providerCache {
+	return &providerCache{
+		newProviders: newProviders,
+		entries:      map[string]cachedProviders{},
+	}
+}
+
+func (c *providerCache) get(m Merchant) (Providers, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[m.ID]; ok && e.updatedAt.Equal(m.UpdatedAt) {
+		return e.providers, nil
+	}
+
+	providers, err := c.newProviders(m)
+	if err != nil {
+		return Providers{}, err
+	}
+	providers = providers.instrumented()
+
+	c.entries[m.ID] = cachedProviders{m.UpdatedAt, providers}
+
+	return providers, nil
+}
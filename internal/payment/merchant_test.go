@@ -0,0 +1,151 @@
+package payment
+
+import (
+	"context"
+	"database/sql"
+	"exam-payment-service/pkg/encryption"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestKeyring(t *testing.T) *encryption.Keyring {
+	var f encryption.KeyFile
+	if _, err := f.Generate(); err != nil {
+		t.Fatal(err)
+	}
+
+	k, err := encryption.NewKeyring(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return k
+}
+
+func newTestMerchantStore(t *testing.T) (*MerchantStore, *sql.DB) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	if err := Migrate(context.Background(), db); err != nil {
+		t.Fatal(err)
+	}
+
+	return NewMerchantStore(db, newTestKeyring(t)), db
+}
+
+func TestMerchantStore(t *testing.T) {
+	ctx := context.Background()
+	s, db := newTestMerchantStore(t)
+
+	m := Merchant{
+		ID:             "brand_a",
+		Name:           "Brand A",
+		TestKeys:       OmiseKeys{PublicKey: "pkey_test_a", SecretKey: "skey_test_a"},
+		WebhookSecret:  "c2VjcmV0",
+		ChargeLimitMin: 5000,
+		SourceTypes:    []SourceType{SourceTypeInternetBankSCB},
+	}
+	assert.NoError(t, s.Put(ctx, m))
+	assert.NoError(t, s.Put(ctx, Merchant{ID: "brand_b", Name: "Brand B"}))
+
+	// Secrets are encrypted at rest, unused modes stay empty
+	var testSecretKey, liveSecretKey, webhookSecret string
+	err := db.QueryRow("SELECT test_secret_key, live_secret_key, webhook_secret FROM merchants WHERE id = ?", m.ID).
+		Scan(&testSecretKey, &liveSecretKey, &webhookSecret)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, testSecretKey)
+	assert.NotContains(t, testSecretKey, "skey_test_a")
+	assert.NotEqual(t, m.WebhookSecret, webhookSecret)
+	assert.Empty(t, liveSecretKey)
+
+	got, err := s.Get(ctx, m.ID)
+	assert.NoError(t, err)
+	assert.False(t, got.UpdatedAt.IsZero())
+	got.CreatedAt, got.UpdatedAt = time.Time{}, time.Time{}
+	assert.Equal(t, m, got)
+
+	// Put replaces every field and moves updated_at
+	before, _ := s.Get(ctx, m.ID)
+	m.SourceTypes = nil
+	m.LiveKeys = OmiseKeys{PublicKey: "pkey_a", SecretKey: "skey_a"}
+	assert.NoError(t, s.Put(ctx, m))
+
+	got, err = s.Get(ctx, m.ID)
+	assert.NoError(t, err)
+	assert.Nil(t, got.SourceTypes)
+	assert.Equal(t, "skey_a", got.LiveKeys.SecretKey)
+	assert.Equal(t, before.CreatedAt, got.CreatedAt)
+	assert.False(t, got.UpdatedAt.Before(before.UpdatedAt))
+
+	list, err := s.List(ctx)
+	assert.NoError(t, err)
+	if assert.Len(t, list, 2) {
+		assert.Equal(t, "brand_a", list[0].ID)
+		assert.Equal(t, "brand_b", list[1].ID)
+	}
+
+	_, err = s.Get(ctx, "unknown")
+	assert.Equal(t, ErrMerchantNotFound, err)
+}
+
+func TestMerchantLimits(t *testing.T) {
+	testCases := []struct {
+		name        string
+		merchant    Merchant
+		expectedMin int64
+		expectedMax int64
+	}{
+		{
+			name:        "Default limits",
+			expectedMin: ChargeLimitTHBMin,
+			expectedMax: ChargeLimitTHBMax,
+		},
+		{
+			name:        "Merchant minimum",
+			merchant:    Merchant{ChargeLimitMin: 50000},
+			expectedMin: 50000,
+			expectedMax: ChargeLimitTHBMax,
+		},
+		{
+			name:        "Merchant limits",
+			merchant:    Merchant{ChargeLimitMin: 50000, ChargeLimitMax: 100000},
+			expectedMin: 50000,
+			expectedMax: 100000,
+		},
+	}
+
+	t.Parallel()
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			min, max := tc.merchant.chargeLimits(CurrencyTHB)
+
+			assert.Equal(t, tc.expectedMin, min)
+			assert.Equal(t, tc.expectedMax, max)
+		})
+	}
+}
+
+func TestProviderCache(t *testing.T) {
+	calls := 0
+	c := newProviderCache(func(m Merchant) (Providers, error) {
+		calls++
+		return Providers{}, nil
+	})
+
+	m := Merchant{ID: "brand_a", UpdatedAt: time.Now()}
+	_, _ = c.get(m)
+	_, _ = c.get(m)
+	assert.Equal(t, 1, calls)
+
+	// Built again once the merchant is updated, e.g. with new keys
+	m.UpdatedAt = m.UpdatedAt.Add(time.Second)
+	_, _ = c.get(m)
+	assert.Equal(t, 2, calls)
+}
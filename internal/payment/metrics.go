@@ -0,0 +1,361 @@
+package payment
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"exam-payment-service/pkg/omiseprovider"
+	"time"
+
+	"github.com/omise/omise-go"
+	"github.com/omise/omise-go/operations"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const metricsNamespace = "payment"
+
+var (
+	paymentRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "requests_total",
+		Help:      "Payment requests by source type and error.",
+	}, []string{"source_type", "error"})
+
+	paymentRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "request_duration_seconds",
+		Help:      "Duration of payment requests by source type.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"source_type"})
+
+	omiseCallsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "omise_calls_total",
+		Help:      "Omise API calls by operation and error.",
+	}, []string{"operation", "error"})
+
+	omiseCallDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "omise_call_duration_seconds",
+		Help:      "Duration of Omise API calls by operation.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation"})
+
+	webhookEventsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "webhook_events_total",
+		Help:      "Webhook events by key and result.",
+	}, []string{"key", "result"})
+
+	queueEventsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "webhook_queue_events_total",
+		Help:      "Attempts of queued webhook events by result.",
+	}, []string{"result"})
+
+	statusTransitionsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "status_transitions_total",
+		Help:      "Payment status transitions.",
+	}, []string{"from", "to"})
+
+	discrepanciesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "discrepancies_total",
+		Help:      "Charges that do not match their payment request, by field.",
+	}, []string{"field"})
+
+	expiringAuthorizationsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "expiring_authorizations_total",
+		Help:      "Authorized payments flagged before their authorization expires.",
+	})
+)
+
+// Webhook event results
+const (
+	webhookResultProcessed   = "processed"
+	webhookResultIgnored     = "ignored"
+	webhookResultNeedsReview = "needs_review"
+	webhookResultRejected    = "rejected"
+	webhookResultError       = "error"
+)
+
+// Webhook event keys with their own label, keys come from the request body and any other key is
+// labelled webhookKeyOther to keep the number of series bounded
+var webhookKeys = map[string]bool{
+	"charge.create":     true,
+	"charge.update":     true,
+	"charge.complete":   true,
+	"charge.capture":    true,
+	"charge.reverse":    true,
+	"charge.expire":     true,
+	"refund.create":     true,
+	"schedule.create":   true,
+	"schedule.expiring": true,
+	"schedule.expire":   true,
+	"schedule.suspend":  true,
+	"schedule.destroy":  true,
+}
+
+const webhookKeyOther = "other"
+
+// Queued webhook event results
+const (
+	queueResultProcessed = "processed"
+	queueResultRetried   = "retried"
+	queueResultDead      = "dead"
+)
+
+// Payment statuses that will not change anymore
+var finalStatuses = []string{
+	string(omise.ChargeSuccessful),
+	string(omise.ChargeFailed),
+	string(omise.ChargeReversed),
+	"expired",
+}
+
+func errorLabel(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	var pErr *Error
+	if errors.As(err, &pErr) {
+		if pErr.UpstreamCode != "" {
+			return pErr.UpstreamCode
+		}
+		return pErr.Code
+	}
+
+	var oErr *omise.Error
+	if errors.As(err, &oErr) {
+		return oErr.Code
+	}
+
+	var tErr *omise.ErrTransport
+	if errors.As(err, &tErr) {
+		return "transport"
+	}
+
+	return "internal"
+}
+
+func webhookKeyLabel(key string) string {
+	if !webhookKeys[key] {
+		return webhookKeyOther
+	}
+
+	return key
+}
+
+func sourceTypeLabel(s SourceType) string {
+	if !s.Validate() && s != SourceTypeCard {
+		return "invalid"
+	}
+
+	return string(s)
+}
+
+func statusLabel(status string) string {
+	if status == "" {
+		return "none"
+	}
+
+	return status
+}
+
+func observePaymentRequest(sourceType SourceType, start time.Time, err error) {
+	label := sourceTypeLabel(sourceType)
+
+	paymentRequestsTotal.WithLabelValues(label, errorLabel(err)).Inc()
+	paymentRequestDuration.WithLabelValues(label).Observe(time.Since(start).Seconds())
+}
+
+func observeStatusTransition(from, to string) {
+	if from == to {
+		return
+	}
+
+	statusTransitionsTotal.WithLabelValues(statusLabel(from), statusLabel(to)).Inc()
+}
+
+// instrumentedProvider records call counts, errors and latency of the wrapped provider
+type instrumentedProvider struct {
+	next omiseProvider
+}
+
+func (i instrumentedProvider) CreateSource(ctx context.Context, createSource operations.CreateSource) (omise.Source, error) {
+	start := time.Now()
+	source, err := i.next.CreateSource(ctx, createSource)
+	observeOmiseCall("CreateSource", start, err)
+
+	return source, err
+}
+
+func (i instrumentedProvider) CreateCharge(ctx context.Context, createCharge omiseprovider.CreateCharge) (omise.Charge, error) {
+	start := time.Now()
+	charge, err := i.next.CreateCharge(ctx, createCharge)
+	observeOmiseCall("CreateCharge", start, err)
+
+	return charge, err
+}
+
+func (i instrumentedProvider) RetrieveCharge(ctx context.Context, retrieveCharge operations.RetrieveCharge) (omise.Charge, error) {
+	start := time.Now()
+	charge, err := i.next.RetrieveCharge(ctx, retrieveCharge)
+	observeOmiseCall("RetrieveCharge", start, err)
+
+	return charge, err
+}
+
+func (i instrumentedProvider) CreateBillPaymentCharge(ctx context.Context, createCharge operations.CreateCharge) (omiseprovider.BillPaymentCharge, error) {
+	start := time.Now()
+	charge, err := i.next.CreateBillPaymentCharge(ctx, createCharge)
+	observeOmiseCall("CreateBillPaymentCharge", start, err)
+
+	return charge, err
+}
+
+func (i instrumentedProvider) CaptureCharge(ctx context.Context, captureCharge omiseprovider.CaptureCharge) (omise.Charge, error) {
+	start := time.Now()
+	charge, err := i.next.CaptureCharge(ctx, captureCharge)
+	observeOmiseCall("CaptureCharge", start, err)
+
+	return charge, err
+}
+
+func (i instrumentedProvider) ReverseCharge(ctx context.Context, reverseCharge operations.ReverseCharge) (omise.Charge, error) {
+	start := time.Now()
+	charge, err := i.next.ReverseCharge(ctx, reverseCharge)
+	observeOmiseCall("ReverseCharge", start, err)
+
+	return charge, err
+}
+
+func (i instrumentedProvider) CreateCustomer(ctx context.Context, createCustomer operations.CreateCustomer) (omise.Customer, error) {
+	start := time.Now()
+	customer, err := i.next.CreateCustomer(ctx, createCustomer)
+	observeOmiseCall("CreateCustomer", start, err)
+
+	return customer, err
+}
+
+func (i instrumentedProvider) UpdateCustomer(ctx context.Context, updateCustomer operations.UpdateCustomer) (omise.Customer, error) {
+	start := time.Now()
+	customer, err := i.next.UpdateCustomer(ctx, updateCustomer)
+	observeOmiseCall("UpdateCustomer", start, err)
+
+	return customer, err
+}
+
+func (i instrumentedProvider) RetrieveCustomer(ctx context.Context, retrieveCustomer operations.RetrieveCustomer) (omise.Customer, error) {
+	start := time.Now()
+	customer, err := i.next.RetrieveCustomer(ctx, retrieveCustomer)
+	observeOmiseCall("RetrieveCustomer", start, err)
+
+	return customer, err
+}
+
+func (i instrumentedProvider) CreateChargeSchedule(ctx context.Context, createSchedule operations.CreateChargeSchedule) (omise.Schedule, error) {
+	start := time.Now()
+	schedule, err := i.next.CreateChargeSchedule(ctx, createSchedule)
+	observeOmiseCall("CreateChargeSchedule", start, err)
+
+	return schedule, err
+}
+
+func (i instrumentedProvider) DestroySchedule(ctx context.Context, destroySchedule operations.DestroySchedule) (omise.Schedule, error) {
+	start := time.Now()
+	schedule, err := i.next.DestroySchedule(ctx, destroySchedule)
+	observeOmiseCall("DestroySchedule", start, err)
+
+	return schedule, err
+}
+
+func (i instrumentedProvider) CreateLink(ctx context.Context, createLink operations.CreateLink) (omise.Link, error) {
+	start := time.Now()
+	link, err := i.next.CreateLink(ctx, createLink)
+	observeOmiseCall("CreateLink", start, err)
+
+	return link, err
+}
+
+func (i instrumentedProvider) RetrieveCapability(ctx context.Context, retrieveCapability operations.RetrieveCapability) (omise.Capability, error) {
+	start := time.Now()
+	capability, err := i.next.RetrieveCapability(ctx, retrieveCapability)
+	observeOmiseCall("RetrieveCapability", start, err)
+
+	return capability, err
+}
+
+func observeOmiseCall(operation string, start time.Time, err error) {
+	omiseCallsTotal.WithLabelValues(operation, errorLabel(err)).Inc()
+	omiseCallDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+// StatusCollector exposes the number of payments in a non-final state
+type StatusCollector struct {
+	db   *sql.DB
+	desc *prometheus.Desc
+}
+
+func NewStatusCollector(db *sql.DB) *StatusCollector {
+	return &StatusCollector{
+		db: db,
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "", "non_final"),
+			"Payments in a non-final state by status.",
+			[]string{"status"},
+			nil,
+		),
+	}
+}
+
+func (s *StatusCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- s.desc
+}
+
+func (s *StatusCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	args := make([]interface{}, len(finalStatuses))
+	placeholders := ""
+	for i, status := range finalStatuses {
+		if i > 0 {
+			placeholders += ", "
+		}
+		placeholders += "?"
+		args[i] = status
+	}
+
+	rows, err := s.db.QueryContext(
+		ctx,
+		"SELECT status, COUNT(*) FROM payments WHERE status NOT IN ("+placeholders+") GROUP BY status",
+		args...,
+	)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(s.desc, err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			status string
+			count  float64
+		)
+		if err := rows.Scan(&status, &count); err != nil {
+			ch <- prometheus.NewInvalidMetric(s.desc, err)
+			return
+		}
+
+		ch <- prometheus.MustNewConstMetric(s.desc, prometheus.GaugeValue, count, status)
+	}
+
+	if err := rows.Err(); err != nil {
+		ch <- prometheus.NewInvalidMetric(s.desc, err)
+	}
+}
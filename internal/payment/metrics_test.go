@@ -0,0 +1,77 @@
+package payment
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/omise/omise-go"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorLabel(t *testing.T) {
+	testCases := []struct {
+		name     string
+		err      error
+		expected string
+	}{
+		{
+			name:     "No error",
+			err:      nil,
+			expected: "",
+		},
+		{
+			name:     "Validation error",
+			err:      ErrInvalidCurrency,
+			expected: "invalid_currency",
+		},
+		{
+			name:     "Omise error",
+			err:      &omise.Error{Code: "invalid_charge"},
+			expected: "invalid_charge",
+		},
+		{
+			name:     "Unknown error",
+			err:      errors.New("something"),
+			expected: "internal",
+		},
+	}
+
+	t.Parallel()
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, errorLabel(tc.err))
+		})
+	}
+}
+
+func TestWebhookKeyLabel(t *testing.T) {
+	assert.Equal(t, "charge.complete", webhookKeyLabel("charge.complete"))
+	assert.Equal(t, "schedule.suspend", webhookKeyLabel("schedule.suspend"))
+	assert.Equal(t, "other", webhookKeyLabel("customer.create"))
+	assert.Equal(t, "other", webhookKeyLabel(strings.Repeat("x", 1000)))
+}
+
+func TestStatusCollector(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Error(err)
+	}
+	defer db.Close()
+
+	rows := sqlmock.NewRows([]string{"status", "count"}).AddRow("pending", 3)
+	mock.ExpectQuery("SELECT status, COUNT\\(\\*\\) FROM payments WHERE status NOT IN").WillReturnRows(rows)
+
+	expected := `
+		# HELP payment_non_final Payments in a non-final state by status.
+		# TYPE payment_non_final gauge
+		payment_non_final{status="pending"} 3
+	`
+
+	err = testutil.CollectAndCompare(NewStatusCollector(db), strings.NewReader(expected))
+
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -5,6 +5,8 @@
 package mock_payment
 
 import (
+	context "context"
+	omiseprovider "exam-payment-service/pkg/omiseprovider"
 	reflect "reflect"
 
 	gomock "github.com/golang/mock/gomock"
@@ -35,32 +37,197 @@ func (m *MockOmiseProvider) EXPECT() *MockOmiseProviderMockRecorder {
 	return m.recorder
 }
 
+// CaptureCharge mocks base method.
+func (m *MockOmiseProvider) CaptureCharge(ctx context.Context, captureCharge omiseprovider.CaptureCharge) (omise.Charge, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CaptureCharge", ctx, captureCharge)
+	ret0, _ := ret[0].(omise.Charge)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CaptureCharge indicates an expected call of CaptureCharge.
+func (mr *MockOmiseProviderMockRecorder) CaptureCharge(ctx, captureCharge interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CaptureCharge", reflect.TypeOf((*MockOmiseProvider)(nil).CaptureCharge), ctx, captureCharge)
+}
+
+// CreateBillPaymentCharge mocks base method.
+func (m *MockOmiseProvider) CreateBillPaymentCharge(ctx context.Context, createCharge operations.CreateCharge) (omiseprovider.BillPaymentCharge, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateBillPaymentCharge", ctx, createCharge)
+	ret0, _ := ret[0].(omiseprovider.BillPaymentCharge)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateBillPaymentCharge indicates an expected call of CreateBillPaymentCharge.
+func (mr *MockOmiseProviderMockRecorder) CreateBillPaymentCharge(ctx, createCharge interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateBillPaymentCharge", reflect.TypeOf((*MockOmiseProvider)(nil).CreateBillPaymentCharge), ctx, createCharge)
+}
+
 // CreateCharge mocks base method.
-func (m *MockOmiseProvider) CreateCharge(createCharge operations.CreateCharge) (omise.Charge, error) {
+func (m *MockOmiseProvider) CreateCharge(ctx context.Context, createCharge omiseprovider.CreateCharge) (omise.Charge, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "CreateCharge", createCharge)
+	ret := m.ctrl.Call(m, "CreateCharge", ctx, createCharge)
 	ret0, _ := ret[0].(omise.Charge)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // CreateCharge indicates an expected call of CreateCharge.
-func (mr *MockOmiseProviderMockRecorder) CreateCharge(createCharge interface{}) *gomock.Call {
+func (mr *MockOmiseProviderMockRecorder) CreateCharge(ctx, createCharge interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateCharge", reflect.TypeOf((*MockOmiseProvider)(nil).CreateCharge), ctx, createCharge)
+}
+
+// CreateChargeSchedule mocks base method.
+func (m *MockOmiseProvider) CreateChargeSchedule(ctx context.Context, createSchedule operations.CreateChargeSchedule) (omise.Schedule, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateChargeSchedule", ctx, createSchedule)
+	ret0, _ := ret[0].(omise.Schedule)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateChargeSchedule indicates an expected call of CreateChargeSchedule.
+func (mr *MockOmiseProviderMockRecorder) CreateChargeSchedule(ctx, createSchedule interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateChargeSchedule", reflect.TypeOf((*MockOmiseProvider)(nil).CreateChargeSchedule), ctx, createSchedule)
+}
+
+// CreateCustomer mocks base method.
+func (m *MockOmiseProvider) CreateCustomer(ctx context.Context, createCustomer operations.CreateCustomer) (omise.Customer, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateCustomer", ctx, createCustomer)
+	ret0, _ := ret[0].(omise.Customer)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateCustomer indicates an expected call of CreateCustomer.
+func (mr *MockOmiseProviderMockRecorder) CreateCustomer(ctx, createCustomer interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateCharge", reflect.TypeOf((*MockOmiseProvider)(nil).CreateCharge), createCharge)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateCustomer", reflect.TypeOf((*MockOmiseProvider)(nil).CreateCustomer), ctx, createCustomer)
+}
+
+// CreateLink mocks base method.
+func (m *MockOmiseProvider) CreateLink(ctx context.Context, createLink operations.CreateLink) (omise.Link, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateLink", ctx, createLink)
+	ret0, _ := ret[0].(omise.Link)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateLink indicates an expected call of CreateLink.
+func (mr *MockOmiseProviderMockRecorder) CreateLink(ctx, createLink interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateLink", reflect.TypeOf((*MockOmiseProvider)(nil).CreateLink), ctx, createLink)
 }
 
 // CreateSource mocks base method.
-func (m *MockOmiseProvider) CreateSource(createSource operations.CreateSource) (omise.Source, error) {
+func (m *MockOmiseProvider) CreateSource(ctx context.Context, createSource operations.CreateSource) (omise.Source, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "CreateSource", createSource)
+	ret := m.ctrl.Call(m, "CreateSource", ctx, createSource)
 	ret0, _ := ret[0].(omise.Source)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // CreateSource indicates an expected call of CreateSource.
-func (mr *MockOmiseProviderMockRecorder) CreateSource(createSource interface{}) *gomock.Call {
+func (mr *MockOmiseProviderMockRecorder) CreateSource(ctx, createSource interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateSource", reflect.TypeOf((*MockOmiseProvider)(nil).CreateSource), ctx, createSource)
+}
+
+// DestroySchedule mocks base method.
+func (m *MockOmiseProvider) DestroySchedule(ctx context.Context, destroySchedule operations.DestroySchedule) (omise.Schedule, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DestroySchedule", ctx, destroySchedule)
+	ret0, _ := ret[0].(omise.Schedule)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DestroySchedule indicates an expected call of DestroySchedule.
+func (mr *MockOmiseProviderMockRecorder) DestroySchedule(ctx, destroySchedule interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DestroySchedule", reflect.TypeOf((*MockOmiseProvider)(nil).DestroySchedule), ctx, destroySchedule)
+}
+
+// RetrieveCapability mocks base method.
+func (m *MockOmiseProvider) RetrieveCapability(ctx context.Context, retrieveCapability operations.RetrieveCapability) (omise.Capability, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RetrieveCapability", ctx, retrieveCapability)
+	ret0, _ := ret[0].(omise.Capability)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RetrieveCapability indicates an expected call of RetrieveCapability.
+func (mr *MockOmiseProviderMockRecorder) RetrieveCapability(ctx, retrieveCapability interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RetrieveCapability", reflect.TypeOf((*MockOmiseProvider)(nil).RetrieveCapability), ctx, retrieveCapability)
+}
+
+// RetrieveCharge mocks base method.
+func (m *MockOmiseProvider) RetrieveCharge(ctx context.Context, retrieveCharge operations.RetrieveCharge) (omise.Charge, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RetrieveCharge", ctx, retrieveCharge)
+	ret0, _ := ret[0].(omise.Charge)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RetrieveCharge indicates an expected call of RetrieveCharge.
+func (mr *MockOmiseProviderMockRecorder) RetrieveCharge(ctx, retrieveCharge interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RetrieveCharge", reflect.TypeOf((*MockOmiseProvider)(nil).RetrieveCharge), ctx, retrieveCharge)
+}
+
+// RetrieveCustomer mocks base method.
+func (m *MockOmiseProvider) RetrieveCustomer(ctx context.Context, retrieveCustomer operations.RetrieveCustomer) (omise.Customer, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RetrieveCustomer", ctx, retrieveCustomer)
+	ret0, _ := ret[0].(omise.Customer)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RetrieveCustomer indicates an expected call of RetrieveCustomer.
+func (mr *MockOmiseProviderMockRecorder) RetrieveCustomer(ctx, retrieveCustomer interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RetrieveCustomer", reflect.TypeOf((*MockOmiseProvider)(nil).RetrieveCustomer), ctx, retrieveCustomer)
+}
+
+// ReverseCharge mocks base method.
+func (m *MockOmiseProvider) ReverseCharge(ctx context.Context, reverseCharge operations.ReverseCharge) (omise.Charge, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReverseCharge", ctx, reverseCharge)
+	ret0, _ := ret[0].(omise.Charge)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReverseCharge indicates an expected call of ReverseCharge.
+func (mr *MockOmiseProviderMockRecorder) ReverseCharge(ctx, reverseCharge interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReverseCharge", reflect.TypeOf((*MockOmiseProvider)(nil).ReverseCharge), ctx, reverseCharge)
+}
+
+// UpdateCustomer mocks base method.
+func (m *MockOmiseProvider) UpdateCustomer(ctx context.Context, updateCustomer operations.UpdateCustomer) (omise.Customer, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateCustomer", ctx, updateCustomer)
+	ret0, _ := ret[0].(omise.Customer)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UpdateCustomer indicates an expected call of UpdateCustomer.
+func (mr *MockOmiseProviderMockRecorder) UpdateCustomer(ctx, updateCustomer interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateSource", reflect.TypeOf((*MockOmiseProvider)(nil).CreateSource), createSource)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateCustomer", reflect.TypeOf((*MockOmiseProvider)(nil).UpdateCustomer), ctx, updateCustomer)
 }
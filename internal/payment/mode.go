@@ -0,0 +1,64 @@
+package payment
+
+import "context"
+
+// Modes of the Omise account a payment is made with
+const (
+	ModeTest = "test"
+	ModeLive = "live"
+)
+
+type livemodeKey struct{}
+
+// ContextWithLivemode sets the mode the payments of a request are made in
+func ContextWithLivemode(ctx context.Context, livemode bool) context.Context {
+	return context.WithValue(ctx, livemodeKey{}, livemode)
+}
+
+// LivemodeFromContext returns the mode of the request, test mode when none is set
+func LivemodeFromContext(ctx context.Context) bool {
+	livemode, _ := ctx.Value(livemodeKey{}).(bool)
+	return livemode
+}
+
+// ParseMode returns the livemode of a mode name
+func ParseMode(mode string) (bool, error) {
+	switch mode {
+	case ModeTest:
+		return false, nil
+	case ModeLive:
+		return true, nil
+	default:
+		return false, ErrInvalidMode
+	}
+}
+
+// Providers are the Omise providers by mode, requests in a mode without provider are rejected
+type Providers struct {
+	Test omiseProvider
+	Live omiseProvider
+}
+
+func (p Providers) instrumented() Providers {
+	wrap := func(oc omiseProvider) omiseProvider {
+		if oc == nil {
+			return nil
+		}
+		return instrumentedProvider{tracedProvider{oc}}
+	}
+
+	return Providers{wrap(p.Test), wrap(p.Live)}
+}
+
+func (p Providers) forMode(livemode bool) (omiseProvider, error) {
+	oc := p.Test
+	if livemode {
+		oc = p.Live
+	}
+
+	if oc == nil {
+		return nil, ErrModeNotConfigured
+	}
+
+	return oc, nil
+}
@@ -0,0 +1,112 @@
+package payment
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"exam-payment-service/pkg/logger"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Notifier alerts operators about payments that need a manual review or action
+type Notifier interface {
+	NotifyDiscrepancy(ctx context.Context, d Discrepancy) error
+	NotifyExpiringAuthorization(ctx context.Context, a ExpiringAuthorization) error
+}
+
+// LogNotifier writes the alert to the log, it is the default notifier
+type LogNotifier struct {
+	log *zap.SugaredLogger
+}
+
+func NewLogNotifier(log *zap.SugaredLogger) LogNotifier {
+	return LogNotifier{log}
+}
+
+func (n LogNotifier) NotifyDiscrepancy(ctx context.Context, d Discrepancy) error {
+	logger.For(ctx, n.log).Warnw("Payment needs review",
+		"charge_id", d.ChargeID,
+		"event_id", d.EventID,
+		"key", d.Key,
+		"mismatches", d.Mismatches,
+	)
+
+	return nil
+}
+
+func (n LogNotifier) NotifyExpiringAuthorization(ctx context.Context, a ExpiringAuthorization) error {
+	logger.For(ctx, n.log).Warnw("Payment authorization expires soon",
+		"charge_id", a.ChargeID,
+		"merchant_id", a.MerchantID,
+		"livemode", a.Livemode,
+		"expires_at", a.ExpiresAt,
+	)
+
+	return nil
+}
+
+// WebhookNotifier posts the alert as JSON to a URL, e.g. a Slack incoming webhook.
+// text is a human readable summary, the other fields are the discrepancy or expiring authorization
+type WebhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+func NewWebhookNotifier(url string) WebhookNotifier {
+	return WebhookNotifier{url, &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (n WebhookNotifier) NotifyDiscrepancy(ctx context.Context, d Discrepancy) error {
+	fields := make([]string, len(d.Mismatches))
+	for i, m := range d.Mismatches {
+		fields[i] = fmt.Sprintf("%s expected %s got %s", m.Field, m.Expected, m.Actual)
+	}
+
+	return n.post(ctx, struct {
+		Text string `json:"text"`
+		Discrepancy
+	}{
+		Text:        fmt.Sprintf("Payment %s needs review after %s: %s", d.ChargeID, d.Key, strings.Join(fields, ", ")),
+		Discrepancy: d,
+	})
+}
+
+func (n WebhookNotifier) NotifyExpiringAuthorization(ctx context.Context, a ExpiringAuthorization) error {
+	return n.post(ctx, struct {
+		Text string `json:"text"`
+		ExpiringAuthorization
+	}{
+		Text:                  fmt.Sprintf("Authorization of payment %s expires at %s, capture or reverse it", a.ChargeID, a.ExpiresAt.Format(time.RFC3339)),
+		ExpiringAuthorization: a,
+	})
+}
+
+func (n WebhookNotifier) post(ctx context.Context, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("notifier webhook responded %d", resp.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,74 @@
+package payment
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhookNotifier(t *testing.T) {
+	d := Discrepancy{
+		ChargeID:   "charge_xxx",
+		EventID:    "event_xxx",
+		Key:        "charge.complete",
+		Mismatches: []Mismatch{{Field: "amount", Expected: "20000", Actual: "10000"}},
+	}
+
+	testCases := []struct {
+		name          string
+		status        int
+		expectedError bool
+	}{
+		{
+			name:   "Delivered",
+			status: http.StatusOK,
+		},
+		{
+			name:          "Rejected",
+			status:        http.StatusInternalServerError,
+			expectedError: true,
+		},
+	}
+
+	t.Parallel()
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var received map[string]interface{}
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_ = json.NewDecoder(r.Body).Decode(&received)
+				w.WriteHeader(tc.status)
+			}))
+			defer srv.Close()
+
+			err := NewWebhookNotifier(srv.URL).NotifyDiscrepancy(context.Background(), d)
+
+			assert.Equal(t, tc.expectedError, err != nil)
+			assert.Equal(t, "Payment charge_xxx needs review after charge.complete: amount expected 20000 got 10000", received["text"])
+			assert.Equal(t, "charge_xxx", received["chargeId"])
+		})
+	}
+}
+
+func TestWebhookNotifierExpiringAuthorization(t *testing.T) {
+	var received map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&received)
+	}))
+	defer srv.Close()
+
+	err := NewWebhookNotifier(srv.URL).NotifyExpiringAuthorization(context.Background(), ExpiringAuthorization{
+		ChargeID:  "charge_xxx",
+		Amount:    20000,
+		Currency:  "thb",
+		ExpiresAt: time.Date(2026, 10, 20, 9, 0, 0, 0, time.UTC),
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "Authorization of payment charge_xxx expires at 2026-10-20T09:00:00Z, capture or reverse it", received["text"])
+	assert.Equal(t, "charge_xxx", received["chargeId"])
+}
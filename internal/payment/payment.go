@@ -3,78 +3,253 @@ package payment
 import (
 	"context"
 	"database/sql"
-	"log"
+	"errors"
+	"exam-payment-service/pkg/encryption"
+	"exam-payment-service/pkg/logger"
+	"exam-payment-service/pkg/omiseprovider"
+	"strings"
 	"time"
 
 	"github.com/omise/omise-go"
 	"github.com/omise/omise-go/operations"
+	"go.uber.org/zap"
 )
 
 type omiseProvider interface {
-	CreateSource(createSource operations.CreateSource) (omise.Source, error)
-	CreateCharge(createCharge operations.CreateCharge) (omise.Charge, error)
+	CreateSource(ctx context.Context, createSource operations.CreateSource) (omise.Source, error)
+	CreateCharge(ctx context.Context, createCharge omiseprovider.CreateCharge) (omise.Charge, error)
+	RetrieveCharge(ctx context.Context, retrieveCharge operations.RetrieveCharge) (omise.Charge, error)
+	CreateBillPaymentCharge(ctx context.Context, createCharge operations.CreateCharge) (omiseprovider.BillPaymentCharge, error)
+	CaptureCharge(ctx context.Context, captureCharge omiseprovider.CaptureCharge) (omise.Charge, error)
+	ReverseCharge(ctx context.Context, reverseCharge operations.ReverseCharge) (omise.Charge, error)
+	CreateCustomer(ctx context.Context, createCustomer operations.CreateCustomer) (omise.Customer, error)
+	UpdateCustomer(ctx context.Context, updateCustomer operations.UpdateCustomer) (omise.Customer, error)
+	RetrieveCustomer(ctx context.Context, retrieveCustomer operations.RetrieveCustomer) (omise.Customer, error)
+	CreateChargeSchedule(ctx context.Context, createSchedule operations.CreateChargeSchedule) (omise.Schedule, error)
+	DestroySchedule(ctx context.Context, destroySchedule operations.DestroySchedule) (omise.Schedule, error)
+	CreateLink(ctx context.Context, createLink operations.CreateLink) (omise.Link, error)
+	RetrieveCapability(ctx context.Context, retrieveCapability operations.RetrieveCapability) (omise.Capability, error)
 }
 
 type Payment struct {
-	oc omiseProvider
-	db *sql.DB
+	merchants *MerchantStore
+	providers *providerCache
+	cipher    encryption.Cipher
+	db        *sql.DB
+	log       *zap.SugaredLogger
+	notifier  Notifier
+	// queued wakes the event workers
+	queued       chan struct{}
+	capabilities *capabilityCache
 }
 
-func New(oc omiseProvider, db *sql.DB) *Payment {
-	return &Payment{
-		oc,
+type Option func(*Payment)
+
+// WithNotifier sets where payments that need a review are reported, the log by default
+func WithNotifier(n Notifier) Option {
+	return func(p *Payment) {
+		p.notifier = n
+	}
+}
+
+// New returns the payment service, cipher encrypts the sensitive payment fields
+func New(merchants *MerchantStore, newProviders ProviderFactory, cipher encryption.Cipher, db *sql.DB, log *zap.SugaredLogger, opts ...Option) *Payment {
+	p := &Payment{
+		merchants,
+		newProviderCache(newProviders),
+		cipher,
 		db,
+		log,
+		NewLogNotifier(log),
+		make(chan struct{}, 1),
+		newCapabilityCache(),
+	}
+	for _, opt := range opts {
+		opt(p)
 	}
+
+	return p
 }
 
-func (p Payment) CreatePaymentRequest(ctx context.Context, pr PaymentRequest) (PaymentRequestResult, error) {
+func (p Payment) CreatePaymentRequest(ctx context.Context, pr PaymentRequest) (rs PaymentRequestResult, err error) {
+	start := time.Now()
+	defer func() {
+		observePaymentRequest(pr.method(), start, err)
+	}()
 
 	currencyS := string(pr.Currency)
 	amount := pr.Amount
 
+	m, err := p.merchants.Get(ctx, MerchantFromContext(ctx))
+	if err != nil {
+		return PaymentRequestResult{}, err
+	}
+
 	// Validation
-	switch pr.Currency {
-	case CurrencyTHB:
-		if amount < ChargeLimitTHBMin {
-			return PaymentRequestResult{}, ErrAmountLowerThanChargeLimit
-		}
+	min, max := m.chargeLimits(pr.Currency)
+	if min > 0 && amount < min {
+		return PaymentRequestResult{}, ErrAmountLowerThanChargeLimit
+	}
 
-		if amount > ChargeLimitTHBMax {
-			return PaymentRequestResult{}, ErrChargeLimitExceeded
-		}
+	if max > 0 && amount > max {
+		return PaymentRequestResult{}, ErrChargeLimitExceeded
 	}
 
 	if !pr.Currency.Validate() {
 		return PaymentRequestResult{}, ErrInvalidCurrency
 	}
 
-	if !pr.SourceType.Validate() {
+	// A card token or a customer's default card replaces the source
+	method := pr.method()
+	switch {
+	case pr.CardToken != "":
+		if pr.SourceType != "" || pr.CustomerID != "" {
+			return PaymentRequestResult{}, ErrInvalidRequest
+		}
+		if !strings.HasPrefix(pr.CardToken, "tokn_") {
+			return PaymentRequestResult{}, ErrInvalidCardToken
+		}
+	case pr.CustomerID != "":
+		if pr.SourceType != "" {
+			return PaymentRequestResult{}, ErrInvalidRequest
+		}
+	case !pr.SourceType.Validate():
 		return PaymentRequestResult{}, ErrInvalidSourceType
 	}
 
-	source, err := p.oc.CreateSource(operations.CreateSource{
-		Amount:   amount,
-		Currency: currencyS,
-		Type:     string(pr.SourceType),
-	})
+	if err := pr.validateInstallment(); err != nil {
+		return PaymentRequestResult{}, err
+	}
+
+	phoneNumber, err := pr.validatePhoneNumber()
 	if err != nil {
 		return PaymentRequestResult{}, err
 	}
 
-	charge, err := p.oc.CreateCharge(operations.CreateCharge{
-		Amount:    amount,
-		Currency:  currencyS,
-		ReturnURI: pr.ReturnURI,
-		Source:    source.ID,
-	})
+	// Only card charges can be authorized without capture
+	if !pr.capture() && method != SourceTypeCard {
+		return PaymentRequestResult{}, ErrInvalidRequest
+	}
+
+	if err := pr.validateExpiry(); err != nil {
+		return PaymentRequestResult{}, err
+	}
+
+	if !m.sourceTypeEnabled(method) {
+		return PaymentRequestResult{}, ErrSourceTypeNotEnabled
+	}
+
+	// Customers of other merchants or modes are not found
+	if pr.CustomerID != "" {
+		exists, err := p.customerExists(ctx, pr.CustomerID)
+		if err != nil {
+			return PaymentRequestResult{}, ErrInternal.Wrap(err)
+		}
+		if !exists {
+			return PaymentRequestResult{}, ErrCustomerNotFound
+		}
+	}
+
+	providers, err := p.providers.get(m)
+	if err != nil {
+		return PaymentRequestResult{}, ErrInternal.Wrap(err)
+	}
+
+	livemode := LivemodeFromContext(ctx)
+	oc, err := providers.forMode(livemode)
 	if err != nil {
 		return PaymentRequestResult{}, err
 	}
 
-	rs := PaymentRequestResult{
+	createCharge := omiseprovider.CreateCharge{CreateCharge: operations.CreateCharge{
+		Amount:      amount,
+		Currency:    currencyS,
+		ReturnURI:   pr.ReturnURI,
+		DontCapture: !pr.capture(),
+	}}
+	// Only source types Omise expires have an expiry, see validateExpiry
+	expiresAt := pr.expiresAt(time.Now())
+	createCharge.ExpiresAt = expiresAt
+	switch {
+	case pr.CardToken != "":
+		createCharge.Card = pr.CardToken
+	case pr.CustomerID != "":
+		createCharge.Customer = pr.CustomerID
+	default:
+		source, err := oc.CreateSource(ctx, operations.CreateSource{
+			Amount:                   amount,
+			Currency:                 currencyS,
+			Type:                     string(pr.SourceType),
+			InstallmentTerm:          pr.InstallmentTerm,
+			ZeroInterestInstallments: pr.ZeroInterestInstallments,
+			PhoneNumber:              phoneNumber,
+		})
+		if err != nil {
+			return PaymentRequestResult{}, wrapOmiseError(err)
+		}
+		createCharge.Source = source.ID
+	}
+
+	// Only the charge of a bill payment has the references of the bill
+	var (
+		charge      omise.Charge
+		billPayment *BillPayment
+	)
+	if pr.SourceType.billPayment() {
+		var bc omiseprovider.BillPaymentCharge
+		bc, err = oc.CreateBillPaymentCharge(ctx, createCharge.CreateCharge)
+		charge, billPayment = bc.Charge, newBillPayment(bc.Source)
+	} else {
+		charge, err = oc.CreateCharge(ctx, createCharge)
+	}
+	if err != nil {
+		return PaymentRequestResult{}, wrapOmiseError(err)
+	}
+
+	returnURI, err := encryptString(p.cipher, pr.ReturnURI)
+	if err != nil {
+		return PaymentRequestResult{}, ErrInternal.Wrap(err)
+	}
+
+	// Keep the intent to check the charges Omise reports against it,
+	// the charge.create webhook may have recorded the payment already
+	status := chargeStatus(string(charge.Status), charge.Capture, charge.Authorized, charge.Paid)
+	cardBrand, cardLastDigits := cardDetails(charge.Card)
+	_, err = p.db.ExecContext(
+		ctx,
+		`INSERT INTO payments (charge_id, source_id, txn_id, status, amount, currency, livemode, merchant_id, return_uri, card_brand, card_last_digits, customer_id, installment_term) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (charge_id) DO UPDATE SET amount = excluded.amount, currency = excluded.currency, livemode = excluded.livemode, return_uri = excluded.return_uri, customer_id = excluded.customer_id, installment_term = excluded.installment_term`,
+		charge.ID, createCharge.Source, charge.Transaction, status, amount, currencyS, livemode, m.ID, returnURI, cardBrand, cardLastDigits, pr.CustomerID, pr.InstallmentTerm,
+	)
+	if err != nil {
+		return PaymentRequestResult{}, ErrInternal.Wrap(err)
+	}
+
+	if billPayment != nil {
+		if err := p.recordBillPayment(ctx, charge.ID, billPayment); err != nil {
+			return PaymentRequestResult{}, ErrInternal.Wrap(err)
+		}
+	}
+
+	if expiresAt != nil {
+		if err := p.recordExpiry(ctx, charge.ID, *expiresAt); err != nil {
+			return PaymentRequestResult{}, ErrInternal.Wrap(err)
+		}
+	}
+
+	if status == StatusAuthorized {
+		if err := p.recordAuthorization(ctx, charge.ID, time.Now().Add(authorizationPeriod)); err != nil {
+			return PaymentRequestResult{}, ErrInternal.Wrap(err)
+		}
+	}
+
+	// Card charges without 3-D Secure are already final, or authorized without capture
+	rs = PaymentRequestResult{
 		ChargeID:     charge.ID,
-		SourceID:     source.ID,
+		SourceID:     createCharge.Source,
 		AuthorizeURI: charge.AuthorizeURI,
+		Status:       status,
+		BillPayment:  billPayment,
 	}
 
 	return rs, nil
@@ -82,47 +257,253 @@ func (p Payment) CreatePaymentRequest(ctx context.Context, pr PaymentRequest) (P
 
 func (p Payment) GetPaymentStatusWithChargeID(ctx context.Context, chargeID string) (PaymentStatus, error) {
 	var q PaymentStatus
-	err := p.db.QueryRowContext(ctx, "SELECT status FROM payments WHERE charge_id = ?", chargeID).Scan(&q.Status)
+	// A payment is only visible to its merchant, in its own mode
+	err := p.db.QueryRowContext(
+		ctx,
+		"SELECT status, failure_code, failure_message FROM payments WHERE charge_id = ? AND merchant_id = ? AND livemode = ?",
+		chargeID, MerchantFromContext(ctx), LivemodeFromContext(ctx),
+	).Scan(&q.Status, &q.FailureCode, &q.FailureMessage)
+	if err == sql.ErrNoRows {
+		return PaymentStatus{}, ErrPaymentNotFound
+	}
 	if err != nil {
-		return PaymentStatus{}, err
+		return PaymentStatus{}, ErrInternal.Wrap(err)
 	}
 
 	return q, nil
 }
 
-func (p Payment) HookPaymentEvent(ctx context.Context, event PaymentEvent) error {
+// GetPayment returns the payment of the context's merchant and mode
+func (p Payment) GetPayment(ctx context.Context, chargeID string) (PaymentDetail, error) {
+	d := PaymentDetail{ChargeID: chargeID, Livemode: LivemodeFromContext(ctx)}
+	var (
+		cardBrand, cardLastDigits string
+		reportedStatus            string
+		expiresAt                 sql.NullTime
+		subscriptionID            sql.NullInt64
+	)
+	err := p.db.QueryRowContext(
+		ctx,
+		`SELECT source_id, status, reported_status, COALESCE(amount, 0), COALESCE(currency, ''), card_brand, card_last_digits, captured_amount, authorization_expires_at, customer_id, subscription_id, installment_term
+		FROM payments WHERE charge_id = ? AND merchant_id = ? AND livemode = ?`,
+		chargeID, MerchantFromContext(ctx), d.Livemode,
+	).Scan(&d.SourceID, &d.Status, &reportedStatus, &d.Amount, &d.Currency, &cardBrand, &cardLastDigits, &d.CapturedAmount, &expiresAt, &d.CustomerID, &subscriptionID, &d.InstallmentTerm)
+	if err == sql.ErrNoRows {
+		return PaymentDetail{}, ErrPaymentNotFound
+	}
+	if err != nil {
+		return PaymentDetail{}, ErrInternal.Wrap(err)
+	}
+
+	if cardBrand != "" || cardLastDigits != "" {
+		d.Card = &PaymentCard{Brand: cardBrand, LastDigits: cardLastDigits}
+	}
+	if expiresAt.Valid {
+		d.AuthorizationExpiresAt = &expiresAt.Time
+	}
+	d.SubscriptionID = subscriptionID.Int64
+	if d.Status == StatusNeedsReview {
+		d.ReportedStatus = reportedStatus
+	}
+
+	return d, nil
+}
+
+// HookPaymentEvent applies an event of the context's merchant, webhooks are applied through the queue,
+// see EnqueueEvent
+func (p Payment) HookPaymentEvent(ctx context.Context, event PaymentEvent) (err error) {
+	merchantID := MerchantFromContext(ctx)
 	chargeID := event.Data.ID
-	sourceID := event.Data.Source.ID
-	txnID := event.Data.Transaction
-	status := event.Data.Status
+	status := chargeStatus(event.Data.Status, event.Data.Capture, event.Data.Authorized, event.Data.Paid)
+
+	result := webhookResultProcessed
+	defer func() {
+		switch {
+		case errors.Is(err, ErrLivemodeMismatch), errors.Is(err, ErrMerchantMismatch):
+			result = webhookResultRejected
+		case err != nil:
+			result = webhookResultError
+		}
+		webhookEventsTotal.WithLabelValues(webhookKeyLabel(event.Key), result).Inc()
+	}()
 
 	switch event.Key {
-	case "charge.create":
-
-		_, err := p.db.ExecContext(
-			ctx,
-			"INSERT INTO payments (charge_id, source_id, txn_id, status) VALUES (?, ?, ?, ?)",
-			chargeID, sourceID, txnID, status,
-		)
+	case "charge.create", "charge.complete", "charge.capture", "charge.reverse", "charge.expire":
+		status, result, err = p.applyChargeEvent(ctx, event)
 		if err != nil {
-			log.Println("HookPaymentEvent err", err)
 			return err
 		}
-	case "charge.complete":
+	case "schedule.create", "schedule.expiring", "schedule.expire", "schedule.suspend", "schedule.destroy":
+		// Data is the schedule, not a charge
+		var changed bool
+		changed, err = p.hookScheduleEvent(ctx, event)
+		if err != nil {
+			logger.For(ctx, p.log).Errorw("HookPaymentEvent error", "error", SafeError(err), "event_id", event.ID, "key", event.Key, "schedule_id", event.Data.ID)
+			return err
+		}
+		if !changed {
+			result = webhookResultIgnored
+		}
+	default:
+		result = webhookResultIgnored
+	}
+
+	logger.For(ctx, p.log).Infow("HookPaymentEvent",
+		"merchant_id", merchantID,
+		"event_id", event.ID,
+		"key", event.Key,
+		"charge_id", chargeID,
+		"status", status,
+		"result", result,
+	)
+
+	return nil
+}
+
+// applyChargeEvent applies an event whose data is a charge to its payment, returning the status
+// of the payment and the result of the event
+func (p Payment) applyChargeEvent(ctx context.Context, event PaymentEvent) (status string, result string, err error) {
+	merchantID := MerchantFromContext(ctx)
+	chargeID := event.Data.ID
+	sourceID := event.Data.SourceID()
+	txnID := event.Data.Transaction
+	status = chargeStatus(event.Data.Status, event.Data.Capture, event.Data.Authorized, event.Data.Paid)
+
+	var (
+		prevStatus       string
+		reportedStatus   string
+		stored           intent
+		storedMerchantID string
+	)
+	err = p.db.QueryRowContext(
+		ctx,
+		"SELECT status, reported_status, amount, currency, livemode, merchant_id FROM payments WHERE charge_id = ?",
+		chargeID,
+	).Scan(&prevStatus, &reportedStatus, &stored.Amount, &stored.Currency, &stored.Livemode, &storedMerchantID)
+	found := err == nil
+	if err != nil && err != sql.ErrNoRows {
+		logger.For(ctx, p.log).Errorw("HookPaymentEvent error", "error", SafeError(err), "event_id", event.ID, "key", event.Key, "charge_id", chargeID)
+		return status, webhookResultError, err
+	}
+
+	// Events from another merchant's account or the other mode must never touch the payment
+	if found && storedMerchantID != merchantID {
+		logger.For(ctx, p.log).Warnw("HookPaymentEvent merchant mismatch", "event_id", event.ID, "key", event.Key, "charge_id", chargeID, "merchant_id", merchantID)
+		return status, webhookResultRejected, ErrMerchantMismatch
+	}
+
+	mismatches := stored.compare(event.Data)
+	if livemodeMismatch(mismatches) {
+		err := p.recordRejected(ctx, Discrepancy{
+			ChargeID:   chargeID,
+			EventID:    event.ID,
+			Key:        event.Key,
+			Mismatches: mismatches,
+		})
+		if err != nil {
+			logger.For(ctx, p.log).Errorw("HookPaymentEvent error", "error", SafeError(err), "event_id", event.ID, "key", event.Key, "charge_id", chargeID)
+			return status, webhookResultError, err
+		}
+
+		logger.For(ctx, p.log).Warnw("HookPaymentEvent livemode mismatch", "event_id", event.ID, "key", event.Key, "charge_id", chargeID, "livemode", event.Data.Livemode)
+		return status, webhookResultRejected, ErrLivemodeMismatch
+	}
+
+	if len(mismatches) > 0 {
+		err := p.flagForReview(ctx, Discrepancy{
+			ChargeID:   chargeID,
+			EventID:    event.ID,
+			Key:        event.Key,
+			Mismatches: mismatches,
+		}, status, txnID)
+		if err != nil {
+			logger.For(ctx, p.log).Errorw("HookPaymentEvent error", "error", SafeError(err), "event_id", event.ID, "key", event.Key, "charge_id", chargeID)
+			return status, webhookResultError, err
+		}
+
+		observeStatusTransition(prevStatus, StatusNeedsReview)
+		return StatusNeedsReview, webhookResultNeedsReview, nil
+	}
+
+	// A late expiry must not undo a payment that completed in the meantime, in review it is the
+	// status Omise reported that completed
+	lastStatus := prevStatus
+	if prevStatus == StatusNeedsReview {
+		lastStatus = reportedStatus
+	}
+	if event.Key == "charge.expire" && found && lastStatus != string(omise.ChargePending) && lastStatus != "" {
+		return prevStatus, webhookResultIgnored, nil
+	}
+
+	// Only a review moves a payment out of needs_review, what Omise reports is kept for it
+	if prevStatus == StatusNeedsReview {
+		if err := p.recordReported(ctx, chargeID, status, txnID); err != nil {
+			logger.For(ctx, p.log).Errorw("HookPaymentEvent error", "error", SafeError(err), "event_id", event.ID, "key", event.Key, "charge_id", chargeID)
+			return status, webhookResultError, err
+		}
+		return StatusNeedsReview, webhookResultNeedsReview, nil
+	}
 
-		_, err := p.db.ExecContext(
+	cardBrand, cardLastDigits := cardDetails(event.Data.Card)
+	if event.Key == "charge.create" && !found {
+		var returnURI string
+		returnURI, err = encryptString(p.cipher, event.Data.ReturnURI)
+		if err == nil {
+			_, err = p.db.ExecContext(
+				ctx,
+				"INSERT INTO payments (charge_id, source_id, txn_id, status, livemode, merchant_id, return_uri, card_brand, card_last_digits) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+				chargeID, sourceID, txnID, status, event.Livemode, merchantID, returnURI, cardBrand, cardLastDigits,
+			)
+		}
+	} else {
+		// Events of source charges have no card
+		_, err = p.db.ExecContext(
 			ctx,
-			"UPDATE payments SET txn_id = ?, status = ? WHERE charge_id = ?",
-			txnID, status, chargeID,
+			"UPDATE payments SET txn_id = ?, status = ?, card_brand = COALESCE(NULLIF(?, ''), card_brand), card_last_digits = COALESCE(NULLIF(?, ''), card_last_digits) WHERE charge_id = ?",
+			txnID, status, cardBrand, cardLastDigits, chargeID,
 		)
-		if err != nil {
-			log.Println("HookPaymentEvent err", err)
-			return err
+	}
+	// Charges made by a schedule belong to its subscription, charges made through a link to the link
+	if err == nil && event.Data.Schedule != nil {
+		err = p.linkSubscription(ctx, chargeID, *event.Data.Schedule, event.Livemode)
+	}
+	if err == nil && event.Data.Link != nil {
+		err = p.linkPaymentLink(ctx, chargeID, *event.Data.Link, status)
+	}
+	if err == nil && event.Data.FailureCode != nil {
+		err = p.recordFailure(ctx, chargeID, event.Data)
+	}
+	if err == nil && status == StatusAuthorized {
+		expiresAt := time.Now().Add(authorizationPeriod)
+		if event.Data.ExpiresAt != nil {
+			expiresAt = *event.Data.ExpiresAt
 		}
+		err = p.recordAuthorization(ctx, chargeID, expiresAt)
+	}
+	if err != nil {
+		logger.For(ctx, p.log).Errorw("HookPaymentEvent error", "error", SafeError(err), "event_id", event.ID, "key", event.Key, "charge_id", chargeID)
+		return status, webhookResultError, err
+	}
+
+	observeStatusTransition(prevStatus, status)
+
+	return status, webhookResultProcessed, nil
+}
 
+// recordFailure keeps why Omise failed a charge
+func (p Payment) recordFailure(ctx context.Context, chargeID string, charge EventCharge) error {
+	var message string
+	if charge.FailureMessage != nil {
+		message = *charge.FailureMessage
 	}
 
-	return nil
+	_, err := p.db.ExecContext(
+		ctx,
+		"UPDATE payments SET failure_code = ?, failure_message = ? WHERE charge_id = ?",
+		*charge.FailureCode, message, chargeID,
+	)
+
+	return err
 }
 
 type SourceType string
@@ -131,13 +512,36 @@ var (
 	SourceTypeInternetBankSCB SourceType = "internet_banking_scb"
 )
 
+// SourceTypeCard enables card payments for a merchant, cards are charged with a token instead of a source
+var SourceTypeCard SourceType = "card"
+
+// sourceTypes is the registry of the source types payment requests take, payment methods are
+// listed in its order
+var sourceTypes = []SourceType{
+	SourceTypeInternetBankSCB,
+	SourceTypeInstallmentBAY,
+	SourceTypeInstallmentBBL,
+	SourceTypeInstallmentFirstChoice,
+	SourceTypeInstallmentKBank,
+	SourceTypeInstallmentKTC,
+	SourceTypeInstallmentSCB,
+	SourceTypeInstallmentTTB,
+	SourceTypeInstallmentUOB,
+	SourceTypeTrueMoney,
+	SourceTypeRabbitLINEPay,
+	SourceTypeShopeePay,
+	SourceTypeAlipay,
+	SourceTypeBillPaymentTescoLotus,
+}
+
 func (s SourceType) Validate() bool {
-	switch s {
-	case SourceTypeInternetBankSCB:
-		return true
-	default:
-		return false
+	for _, registered := range sourceTypes {
+		if s == registered {
+			return true
+		}
 	}
+
+	return false
 }
 
 type Currency string
@@ -160,117 +564,84 @@ type PaymentRequest struct {
 	Currency   Currency   `json:"currency"`
 	ReturnURI  string     `json:"returnUri"`
 	SourceType SourceType `json:"sourceType"`
+	// CardToken is an Omise.js token charged instead of a source
+	CardToken string `json:"cardToken"`
+	// Capture false only authorizes the card, the payment is captured or reversed later
+	Capture *bool `json:"capture"`
+	// CustomerID is an Omise customer of the merchant whose default card is charged, see CreateCustomer
+	CustomerID string `json:"customerId"`
+	// InstallmentTerm is the number of monthly payments of installment source types
+	InstallmentTerm int64 `json:"installmentTerm"`
+	// ZeroInterestInstallments makes the merchant pay the interest of the installments
+	ZeroInterestInstallments bool `json:"zeroInterestInstallments"`
+	// PhoneNumber is the Thai mobile number of the TrueMoney wallet
+	PhoneNumber string `json:"phoneNumber"`
+	// ExpiresInSeconds expires the payment if it is still pending that long after it was created
+	ExpiresInSeconds int64 `json:"expiresInSeconds"`
 }
 
+func (pr PaymentRequest) capture() bool {
+	return pr.Capture == nil || *pr.Capture
+}
+
+// method returns the source type of the request, SourceTypeCard for card tokens and customers
+func (pr PaymentRequest) method() SourceType {
+	if pr.CardToken != "" || pr.CustomerID != "" {
+		return SourceTypeCard
+	}
+
+	return pr.SourceType
+}
+
+// PaymentRequestResult redirects the customer to AuthorizeURI if set, e.g. for 3-D Secure,
+// bill payments are paid at the counter with BillPayment instead
 type PaymentRequestResult struct {
-	ChargeID     string `json:"chargeId"`
-	SourceID     string `json:"sourceId"`
-	AuthorizeURI string `json:"authorizeUri"`
+	ChargeID     string       `json:"chargeId"`
+	SourceID     string       `json:"sourceId"`
+	AuthorizeURI string       `json:"authorizeUri"`
+	Status       string       `json:"status"`
+	BillPayment  *BillPayment `json:"billPayment,omitempty"`
+}
+
+// PaymentDetail is a payment as stored, Card is only set for card payments
+type PaymentDetail struct {
+	ChargeID string       `json:"chargeId"`
+	SourceID string       `json:"sourceId"`
+	Status   string       `json:"status"`
+	Amount   int64        `json:"amount"`
+	Currency string       `json:"currency"`
+	Livemode bool         `json:"livemode"`
+	Card     *PaymentCard `json:"card,omitempty"`
+	// Set for payments in review, the status Omise last reported
+	ReportedStatus string `json:"reportedStatus,omitempty"`
+	// Set for payments with a customer's card
+	CustomerID string `json:"customerId,omitempty"`
+	// Set for payments made by a subscription
+	SubscriptionID int64 `json:"subscriptionId,omitempty"`
+	// Set for installment payments
+	InstallmentTerm int64 `json:"installmentTerm,omitempty"`
+	// Set for authorize-only payments
+	CapturedAmount         int64      `json:"capturedAmount,omitempty"`
+	AuthorizationExpiresAt *time.Time `json:"authorizationExpiresAt,omitempty"`
 }
 
-// Capture from Omise hook payload
-type PaymentEvent struct {
-	CreatedAt time.Time `json:"created_at"`
-	Data      struct {
-		Amount          int         `json:"amount"`
-		AuthorizeURI    string      `json:"authorize_uri"`
-		Authorized      bool        `json:"authorized"`
-		Branch          interface{} `json:"branch"` // TODO: Unknown data type
-		Capturable      bool        `json:"capturable"`
-		Capture         bool        `json:"capture"`
-		Card            interface{} `json:"card"` // TODO: Unknown data type
-		CreatedAt       time.Time   `json:"created_at"`
-		Currency        string      `json:"currency"`
-		Customer        interface{} `json:"customer"`    // TODO: Unknown data type
-		Description     interface{} `json:"description"` // TODO: Unknown data type
-		Device          interface{} `json:"device"`      // TODO: Unknown data type
-		Disputable      bool        `json:"disputable"`
-		Dispute         interface{} `json:"dispute"` // TODO: Unknown data type
-		Expired         bool        `json:"expired"`
-		ExpiredAt       time.Time   `json:"expired_at"`
-		ExpiresAt       time.Time   `json:"expires_at"`
-		FailureCode     interface{} `json:"failure_code"`    // TODO: Unknown data type
-		FailureMessage  interface{} `json:"failure_message"` // TODO: Unknown data type
-		Fee             int         `json:"fee"`
-		FeeVat          int         `json:"fee_vat"`
-		FundingAmount   int         `json:"funding_amount"`
-		FundingCurrency string      `json:"funding_currency"`
-		ID              string      `json:"id"`
-		Interest        int         `json:"interest"`
-		InterestVat     int         `json:"interest_vat"`
-		IP              interface{} `json:"ip"`   // TODO: Unknown data type
-		Link            interface{} `json:"link"` // TODO: Unknown data type
-		Livemode        bool        `json:"livemode"`
-		Location        string      `json:"location"`
-		Metadata        struct{}    `json:"metadata"`
-		Net             int         `json:"net"`
-		Object          string      `json:"object"`
-		Paid            bool        `json:"paid"`
-		PaidAt          time.Time   `json:"paid_at"`
-		PlatformFee     struct {
-			Amount     interface{} `json:"amount"`     // TODO: Unknown data type
-			Fixed      interface{} `json:"fixed"`      // TODO: Unknown data type
-			Percentage interface{} `json:"percentage"` // TODO: Unknown data type
-		} `json:"platform_fee"`
-		Refundable     bool `json:"refundable"`
-		RefundedAmount int  `json:"refunded_amount"`
-		Refunds        struct {
-			Data     []interface{} `json:"data"` // TODO: Unknown data type
-			From     time.Time     `json:"from"`
-			Limit    int           `json:"limit"`
-			Location string        `json:"location"`
-			Object   string        `json:"object"`
-			Offset   int           `json:"offset"`
-			Order    string        `json:"order"`
-			To       time.Time     `json:"to"`
-			Total    int           `json:"total"`
-		} `json:"refunds"`
-		ReturnURI  string      `json:"return_uri"`
-		Reversed   bool        `json:"reversed"`
-		ReversedAt interface{} `json:"reversed_at"` // TODO: Unknown data type
-		Reversible bool        `json:"reversible"`
-		Schedule   interface{} `json:"schedule"` // TODO: Unknown data type
-		Source     struct {
-			Amount                   int           `json:"amount"`
-			Bank                     interface{}   `json:"bank"`    // TODO: Unknown data type
-			Barcode                  interface{}   `json:"barcode"` // TODO: Unknown data type
-			ChargeStatus             string        `json:"charge_status"`
-			CreatedAt                time.Time     `json:"created_at"`
-			Currency                 string        `json:"currency"`
-			Discounts                []interface{} `json:"discounts"` // TODO: Unknown data type
-			Email                    interface{}   `json:"email"`     // TODO: Unknown data type
-			Flow                     string        `json:"flow"`
-			ID                       string        `json:"id"`
-			InstallmentTerm          interface{}   `json:"installment_term"` // TODO: Unknown data type
-			Livemode                 bool          `json:"livemode"`
-			Location                 string        `json:"location"`
-			MobileNumber             interface{}   `json:"mobile_number"` // TODO: Unknown data type
-			Name                     interface{}   `json:"name"`          // TODO: Unknown data type
-			Object                   string        `json:"object"`
-			PhoneNumber              interface{}   `json:"phone_number"`   // TODO: Unknown data type
-			ReceiptAmount            interface{}   `json:"receipt_amount"` // TODO: Unknown data type
-			References               interface{}   `json:"references"`     // TODO: Unknown data type
-			ScannableCode            interface{}   `json:"scannable_code"` // TODO: Unknown data type
-			StoreID                  interface{}   `json:"store_id"`       // TODO: Unknown data type
-			StoreName                interface{}   `json:"store_name"`     // TODO: Unknown data type
-			TerminalID               interface{}   `json:"terminal_id"`    // TODO: Unknown data type
-			Type                     string        `json:"type"`
-			ZeroInterestInstallments interface{}   `json:"zero_interest_installments"` // TODO: Unknown data type
-		} `json:"source"`
-		Status                   string      `json:"status"`
-		Terminal                 interface{} `json:"terminal"` // TODO: Unknown data type
-		Transaction              string      `json:"transaction"`
-		Voided                   bool        `json:"voided"`
-		ZeroInterestInstallments bool        `json:"zero_interest_installments"`
-	} `json:"data"`
-	ID                string        `json:"id"`
-	Key               string        `json:"key"`
-	Livemode          bool          `json:"livemode"`
-	Location          string        `json:"location"`
-	Object            string        `json:"object"`
-	WebhookDeliveries []interface{} `json:"webhook_deliveries"` // TODO: Unknown data type
+// PaymentCard is what is kept of the card, never the full number
+type PaymentCard struct {
+	Brand      string `json:"brand"`
+	LastDigits string `json:"lastDigits"`
+}
+
+func cardDetails(c *omise.Card) (brand string, lastDigits string) {
+	if c == nil {
+		return "", ""
+	}
+
+	return c.Brand, c.LastDigits
 }
 
+// PaymentStatus has the reason Omise gave for failed payments, e.g. a wallet with too little balance
 type PaymentStatus struct {
-	Status string `json:"status"`
+	Status         string `json:"status"`
+	FailureCode    string `json:"failureCode,omitempty"`
+	FailureMessage string `json:"failureMessage,omitempty"`
 }
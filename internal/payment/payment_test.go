@@ -3,28 +3,64 @@ package payment
 import (
 	"context"
 	"database/sql"
+	"errors"
 	mockOmiseProvider "exam-payment-service/internal/payment/mocks/omiseprovider"
+	"exam-payment-service/pkg/omiseprovider"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/golang/mock/gomock"
 	"github.com/omise/omise-go"
 	"github.com/omise/omise-go/operations"
 	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
 )
 
+var merchantColumns = []string{
+	"id", "name", "test_public_key", "test_secret_key", "live_public_key", "live_secret_key", "webhook_secret",
+	"charge_limit_min", "charge_limit_max", "source_types", "created_at", "updated_at",
+}
+
+// expectMerchant expects the lookup of a merchant without secrets, so no cipher is needed
+func expectMerchant(mock sqlmock.Sqlmock, m Merchant) {
+	sourceTypes := make([]string, len(m.SourceTypes))
+	for i, st := range m.SourceTypes {
+		sourceTypes[i] = string(st)
+	}
+
+	mock.ExpectQuery("SELECT (.+) FROM merchants WHERE id = ?").WithArgs(m.ID).WillReturnRows(
+		sqlmock.NewRows(merchantColumns).AddRow(
+			m.ID, m.Name, m.TestKeys.PublicKey, "", m.LiveKeys.PublicKey, "", "",
+			m.ChargeLimitMin, m.ChargeLimitMax, strings.Join(sourceTypes, ","), m.CreatedAt, m.UpdatedAt,
+		),
+	)
+}
+
 func TestCreatePaymentRequest(t *testing.T) {
 	ctx := context.Background()
 
 	testCases := []struct {
 		name            string
+		merchant        Merchant
 		amount          int64
 		currency        Currency
 		sourceType      SourceType
+		cardToken       string
 		returnURI       string
 		sourceID        string
 		chargeID        string
 		authorizeURI    string
+		card            *omise.Card
+		status          omise.ChargeStatus
+		dontCapture     bool
+		authorized      bool
+		customerID      string
+		customerFound   bool
+		installmentTerm int64
+		zeroInterest    bool
+		phoneNumber     string
 		expectedError   error
 		expectedResult  PaymentRequestResult
 		errorValidation bool
@@ -71,6 +107,116 @@ func TestCreatePaymentRequest(t *testing.T) {
 				AuthorizeURI: "https://example.com/pay",
 			},
 		},
+		{
+			name:         "Card requiring 3-D Secure",
+			amount:       20000,
+			currency:     CurrencyTHB,
+			cardToken:    "tokn_test_xxx",
+			returnURI:    "https://example.com",
+			chargeID:     "charge_xxx",
+			authorizeURI: "https://api.omise.co/payments/paym_test_xxx/authorize",
+			card:         &omise.Card{Brand: "Visa", LastDigits: "4242"},
+			status:       omise.ChargePending,
+			expectedResult: PaymentRequestResult{
+				ChargeID:     "charge_xxx",
+				AuthorizeURI: "https://api.omise.co/payments/paym_test_xxx/authorize",
+				Status:       "pending",
+			},
+		},
+		{
+			name:      "Card without 3-D Secure",
+			amount:    20000,
+			currency:  CurrencyTHB,
+			cardToken: "tokn_test_xxx",
+			chargeID:  "charge_xxx",
+			card:      &omise.Card{Brand: "MasterCard", LastDigits: "5454"},
+			status:    omise.ChargeSuccessful,
+			expectedResult: PaymentRequestResult{
+				ChargeID: "charge_xxx",
+				Status:   "successful",
+			},
+		},
+		{
+			name:        "Card authorized without capture",
+			amount:      20000,
+			currency:    CurrencyTHB,
+			cardToken:   "tokn_test_xxx",
+			chargeID:    "charge_xxx",
+			card:        &omise.Card{Brand: "Visa", LastDigits: "4242"},
+			status:      omise.ChargePending,
+			dontCapture: true,
+			authorized:  true,
+			expectedResult: PaymentRequestResult{
+				ChargeID: "charge_xxx",
+				Status:   StatusAuthorized,
+			},
+		},
+		{
+			name:          "Default card of a customer",
+			amount:        20000,
+			currency:      CurrencyTHB,
+			customerID:    "cust_test_xxx",
+			customerFound: true,
+			chargeID:      "charge_xxx",
+			card:          &omise.Card{Brand: "Visa", LastDigits: "4242"},
+			status:        omise.ChargeSuccessful,
+			expectedResult: PaymentRequestResult{
+				ChargeID: "charge_xxx",
+				Status:   "successful",
+			},
+		},
+		{
+			name:            "Customer of another merchant",
+			amount:          20000,
+			currency:        CurrencyTHB,
+			customerID:      "cust_test_yyy",
+			expectedError:   ErrCustomerNotFound,
+			errorValidation: true,
+		},
+		{
+			name:            "Customer and card token",
+			amount:          20000,
+			currency:        CurrencyTHB,
+			customerID:      "cust_test_xxx",
+			cardToken:       "tokn_test_xxx",
+			expectedError:   ErrInvalidRequest,
+			errorValidation: true,
+		},
+		{
+			name:            "Source without capture",
+			amount:          20000,
+			currency:        CurrencyTHB,
+			sourceType:      SourceTypeInternetBankSCB,
+			dontCapture:     true,
+			expectedError:   ErrInvalidRequest,
+			errorValidation: true,
+		},
+		{
+			name:            "Card token and source type",
+			amount:          20000,
+			currency:        CurrencyTHB,
+			sourceType:      SourceTypeInternetBankSCB,
+			cardToken:       "tokn_test_xxx",
+			expectedError:   ErrInvalidRequest,
+			errorValidation: true,
+		},
+		{
+			name:            "Not a card token",
+			amount:          20000,
+			currency:        CurrencyTHB,
+			cardToken:       "card_test_xxx",
+			expectedError:   ErrInvalidCardToken,
+			errorValidation: true,
+		},
+		{
+			name:            "Cards not enabled for the merchant",
+			merchant:        Merchant{SourceTypes: []SourceType{SourceTypeInternetBankSCB}},
+			amount:          20000,
+			currency:        CurrencyTHB,
+			cardToken:       "tokn_test_xxx",
+			expectedError:   ErrSourceTypeNotEnabled,
+			errorValidation: true,
+		},
 		{
 			name:            "Invalid currency value",
 			amount:          20000,
@@ -84,6 +230,137 @@ func TestCreatePaymentRequest(t *testing.T) {
 			expectedResult:  PaymentRequestResult{},
 			errorValidation: true,
 		},
+		{
+			name:            "Amount lower than merchant charge limit",
+			merchant:        Merchant{ChargeLimitMin: 50000},
+			amount:          20000,
+			currency:        CurrencyTHB,
+			sourceType:      SourceTypeInternetBankSCB,
+			returnURI:       "https://example.com",
+			expectedError:   ErrAmountLowerThanChargeLimit,
+			expectedResult:  PaymentRequestResult{},
+			errorValidation: true,
+		},
+		{
+			name:            "Source type not enabled for the merchant",
+			merchant:        Merchant{SourceTypes: []SourceType{"promptpay"}},
+			amount:          20000,
+			currency:        CurrencyTHB,
+			sourceType:      SourceTypeInternetBankSCB,
+			returnURI:       "https://example.com",
+			expectedError:   ErrSourceTypeNotEnabled,
+			expectedResult:  PaymentRequestResult{},
+			errorValidation: true,
+		},
+		{
+			name:            "Installments",
+			amount:          300000,
+			currency:        CurrencyTHB,
+			sourceType:      SourceTypeInstallmentKBank,
+			installmentTerm: 6,
+			zeroInterest:    true,
+			returnURI:       "https://example.com",
+			sourceID:        "source_xxx",
+			chargeID:        "charge_xxx",
+			authorizeURI:    "https://example.com/pay",
+			expectedResult: PaymentRequestResult{
+				SourceID:     "source_xxx",
+				ChargeID:     "charge_xxx",
+				AuthorizeURI: "https://example.com/pay",
+			},
+		},
+		{
+			name:            "Installment term not offered by the bank",
+			amount:          300000,
+			currency:        CurrencyTHB,
+			sourceType:      SourceTypeInstallmentKBank,
+			installmentTerm: 9,
+			expectedError:   ErrInvalidInstallmentTerm,
+			errorValidation: true,
+		},
+		{
+			name:            "Installments without term",
+			amount:          300000,
+			currency:        CurrencyTHB,
+			sourceType:      SourceTypeInstallmentBAY,
+			expectedError:   ErrInvalidInstallmentTerm,
+			errorValidation: true,
+		},
+		{
+			name:            "Installment term without installment source type",
+			amount:          300000,
+			currency:        CurrencyTHB,
+			sourceType:      SourceTypeInternetBankSCB,
+			installmentTerm: 6,
+			expectedError:   ErrInvalidInstallmentTerm,
+			errorValidation: true,
+		},
+		{
+			name:            "Lower than the installment minimum",
+			amount:          150000,
+			currency:        CurrencyTHB,
+			sourceType:      SourceTypeInstallmentKBank,
+			installmentTerm: 3,
+			expectedError:   ErrAmountLowerThanInstallmentMinimum,
+			errorValidation: true,
+		},
+		{
+			name:            "Lower than the monthly minimum of the bank",
+			amount:          400000,
+			currency:        CurrencyTHB,
+			sourceType:      SourceTypeInstallmentSCB,
+			installmentTerm: 10,
+			expectedError:   ErrAmountLowerThanInstallmentMinimum,
+			errorValidation: true,
+		},
+		{
+			name:         "TrueMoney wallet",
+			amount:       20000,
+			currency:     CurrencyTHB,
+			sourceType:   SourceTypeTrueMoney,
+			phoneNumber:  "0812345678",
+			returnURI:    "https://example.com",
+			sourceID:     "source_xxx",
+			chargeID:     "charge_xxx",
+			authorizeURI: "https://example.com/pay",
+			expectedResult: PaymentRequestResult{
+				SourceID:     "source_xxx",
+				ChargeID:     "charge_xxx",
+				AuthorizeURI: "https://example.com/pay",
+			},
+		},
+		{
+			name:         "Redirect wallet",
+			amount:       20000,
+			currency:     CurrencyTHB,
+			sourceType:   SourceTypeRabbitLINEPay,
+			returnURI:    "https://example.com",
+			sourceID:     "source_xxx",
+			chargeID:     "charge_xxx",
+			authorizeURI: "https://example.com/pay",
+			expectedResult: PaymentRequestResult{
+				SourceID:     "source_xxx",
+				ChargeID:     "charge_xxx",
+				AuthorizeURI: "https://example.com/pay",
+			},
+		},
+		{
+			name:            "TrueMoney without phone number",
+			amount:          20000,
+			currency:        CurrencyTHB,
+			sourceType:      SourceTypeTrueMoney,
+			expectedError:   ErrInvalidPhoneNumber,
+			errorValidation: true,
+		},
+		{
+			name:            "Phone number of another source type",
+			amount:          20000,
+			currency:        CurrencyTHB,
+			sourceType:      SourceTypeShopeePay,
+			phoneNumber:     "0812345678",
+			expectedError:   ErrInvalidPhoneNumber,
+			errorValidation: true,
+		},
 		{
 			name:            "Invalid source type value",
 			amount:          20000,
@@ -107,37 +384,92 @@ func TestCreatePaymentRequest(t *testing.T) {
 			op := mockOmiseProvider.NewMockOmiseProvider(mockCtl)
 
 			if !tc.errorValidation {
-				op.EXPECT().CreateSource(operations.CreateSource{
-					Amount:   tc.amount,
-					Currency: string(tc.currency),
-					Type:     string(tc.sourceType),
-				}).Return(omise.Source{
-					ID: tc.sourceID,
-				}, nil)
+				if tc.cardToken == "" && tc.customerID == "" {
+					op.EXPECT().CreateSource(gomock.Any(), operations.CreateSource{
+						Amount:                   tc.amount,
+						Currency:                 string(tc.currency),
+						Type:                     string(tc.sourceType),
+						InstallmentTerm:          tc.installmentTerm,
+						ZeroInterestInstallments: tc.zeroInterest,
+						PhoneNumber:              tc.phoneNumber,
+					}).Return(omise.Source{
+						ID: tc.sourceID,
+					}, nil)
+				}
 
 				returnCharge := omise.Charge{
 					AuthorizeURI: tc.authorizeURI,
+					Card:         tc.card,
+					Status:       tc.status,
+					Capture:      !tc.dontCapture,
+					Authorized:   tc.authorized,
 				}
 				returnCharge.ID = tc.chargeID
-				op.EXPECT().CreateCharge(operations.CreateCharge{
-					Amount:    tc.amount,
-					Currency:  string(tc.currency),
-					ReturnURI: tc.returnURI,
-					Source:    tc.sourceID,
-				}).Return(returnCharge, nil)
+				op.EXPECT().CreateCharge(gomock.Any(), omiseprovider.CreateCharge{CreateCharge: operations.CreateCharge{
+					Amount:      tc.amount,
+					Currency:    string(tc.currency),
+					ReturnURI:   tc.returnURI,
+					Source:      tc.sourceID,
+					Card:        tc.cardToken,
+					Customer:    tc.customerID,
+					DontCapture: tc.dontCapture,
+				}}).Return(returnCharge, nil)
 			}
 
-			p := New(op, nil)
+			db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherRegexp))
+			if err != nil {
+				t.Error(err)
+			}
+			defer db.Close()
+
+			tc.merchant.ID = DefaultMerchantID
+			expectMerchant(mock, tc.merchant)
+
+			if tc.customerID != "" && tc.cardToken == "" {
+				count := 0
+				if tc.customerFound {
+					count = 1
+				}
+				mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM customers").
+					WithArgs(DefaultMerchantID, false, tc.customerID).
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(count))
+			}
 
+			if tc.expectedError == nil {
+				cardBrand, cardLastDigits := cardDetails(tc.card)
+				mock.ExpectExec("INSERT INTO payments").
+					WithArgs(tc.chargeID, tc.sourceID, "", tc.expectedResult.Status, tc.amount, string(tc.currency), false, DefaultMerchantID, sqlmock.AnyArg(), cardBrand, cardLastDigits, tc.customerID, tc.installmentTerm).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+			}
+			if tc.authorized {
+				mock.ExpectExec("UPDATE payments SET authorization_expires_at").
+					WithArgs(sqlmock.AnyArg(), tc.chargeID).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+			}
+
+			newProviders := func(m Merchant) (Providers, error) {
+				return Providers{Test: op}, nil
+			}
+			p := New(NewMerchantStore(db, nil), newProviders, newTestKeyring(t), db, zap.NewNop().Sugar())
+
+			capture := !tc.dontCapture
 			result, err := p.CreatePaymentRequest(ctx, PaymentRequest{
 				Amount:     tc.amount,
 				Currency:   tc.currency,
 				ReturnURI:  tc.returnURI,
 				SourceType: tc.sourceType,
+				CardToken:  tc.cardToken,
+				Capture:    &capture,
+				CustomerID: tc.customerID,
+
+				InstallmentTerm:          tc.installmentTerm,
+				ZeroInterestInstallments: tc.zeroInterest,
+				PhoneNumber:              tc.phoneNumber,
 			})
 
 			assert.Equal(t, tc.expectedError, err)
 			assert.Equal(t, tc.expectedResult, result)
+			assert.NoError(t, mock.ExpectationsWereMet())
 
 		})
 	}
@@ -153,6 +485,7 @@ func TestGetTxnStatusWithChargeID(t *testing.T) {
 		expectedError  error
 		expectedResult PaymentStatus
 		addRow         bool
+		queryError     error
 	}{
 		{
 			name:     "Found",
@@ -162,11 +495,29 @@ func TestGetTxnStatusWithChargeID(t *testing.T) {
 			},
 			addRow: true,
 		},
+		{
+			name:     "Failed wallet payment",
+			chargeID: "charge_xxx",
+			expectedResult: PaymentStatus{
+				Status:         "failed",
+				FailureCode:    "insufficient_balance",
+				FailureMessage: "insufficient balance in the wallet",
+			},
+			addRow: true,
+		},
 		{
 			name:           "Not found",
 			chargeID:       "charge_xxx",
 			expectedResult: PaymentStatus{},
-			expectedError:  sql.ErrNoRows,
+			expectedError:  ErrPaymentNotFound,
+			queryError:     sql.ErrNoRows,
+		},
+		{
+			name:           "Database error",
+			chargeID:       "charge_xxx",
+			expectedResult: PaymentStatus{},
+			expectedError:  ErrInternal,
+			queryError:     errors.New("database is locked"),
 		},
 	}
 
@@ -174,24 +525,28 @@ func TestGetTxnStatusWithChargeID(t *testing.T) {
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 
-			db, mock, err := sqlmock.New()
+			db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
 			if err != nil {
 				t.Error(err)
 			}
 			defer db.Close()
 
 			if tc.addRow {
-				rows := sqlmock.NewRows([]string{"status"}).AddRow(tc.expectedResult.Status)
-				mock.ExpectQuery("SELECT status FROM payments WHERE charge_id = ?").WithArgs(tc.chargeID).WillReturnRows(rows)
+				rows := sqlmock.NewRows([]string{"status", "failure_code", "failure_message"}).AddRow(tc.expectedResult.Status, tc.expectedResult.FailureCode, tc.expectedResult.FailureMessage)
+				mock.ExpectQuery("SELECT status, failure_code, failure_message FROM payments WHERE charge_id = ? AND merchant_id = ? AND livemode = ?").WithArgs(tc.chargeID, DefaultMerchantID, false).WillReturnRows(rows)
 			} else {
-				mock.ExpectQuery("SELECT status FROM payments WHERE charge_id = ?").WithArgs(tc.chargeID).WillReturnError(tc.expectedError)
+				mock.ExpectQuery("SELECT status, failure_code, failure_message FROM payments WHERE charge_id = ? AND merchant_id = ? AND livemode = ?").WithArgs(tc.chargeID, DefaultMerchantID, false).WillReturnError(tc.queryError)
 			}
 
-			p := New(nil, db)
+			p := New(NewMerchantStore(db, nil), nil, nil, db, zap.NewNop().Sugar())
 
 			result, err := p.GetPaymentStatusWithChargeID(ctx, tc.chargeID)
 
-			assert.Equal(t, tc.expectedError, err)
+			if tc.expectedError != nil {
+				assert.True(t, errors.Is(err, tc.expectedError), err)
+			} else {
+				assert.NoError(t, err)
+			}
 			assert.Equal(t, tc.expectedResult, result)
 
 		})
@@ -199,97 +554,407 @@ func TestGetTxnStatusWithChargeID(t *testing.T) {
 
 }
 
+type recordingNotifier struct {
+	discrepancies []Discrepancy
+	expiring      []ExpiringAuthorization
+}
+
+func (n *recordingNotifier) NotifyDiscrepancy(ctx context.Context, d Discrepancy) error {
+	n.discrepancies = append(n.discrepancies, d)
+	return nil
+}
+
+func (n *recordingNotifier) NotifyExpiringAuthorization(ctx context.Context, a ExpiringAuthorization) error {
+	n.expiring = append(n.expiring, a)
+	return nil
+}
+
 func TestHookPaymentEvent(t *testing.T) {
 	ctx := context.Background()
+	authorizationExpiresAt := time.Date(2026, 10, 26, 9, 0, 0, 0, time.UTC)
+
+	chargeEvent := func(key string, status string, txnID string, amount int64) PaymentEvent {
+		p := PaymentEvent{
+			ID:  "event_xxx",
+			Key: key,
+		}
+		p.Data.ID = "charge_xxx"
+		p.Data.Source = &ChargeSource{Source: omise.Source{ID: "source_xxx"}}
+		p.Data.Transaction = txnID
+		p.Data.Status = status
+		p.Data.Amount = amount
+		p.Data.Currency = "THB"
+		return p
+	}
+
+	const selectPayment = "SELECT status, reported_status, amount, currency, livemode, merchant_id FROM payments WHERE charge_id = ?"
+	paymentColumns := []string{"status", "reported_status", "amount", "currency", "livemode", "merchant_id"}
+	paymentRows := func(status string) *sqlmock.Rows {
+		return sqlmock.NewRows(paymentColumns).AddRow(status, "", 20000, "thb", false, DefaultMerchantID)
+	}
 
 	testCases := []struct {
-		name          string
-		event         PaymentEvent
-		expectedError error
+		name                  string
+		event                 PaymentEvent
+		mock                  func(mock sqlmock.Sqlmock)
+		expectedError         error
+		expectedDiscrepancies []Discrepancy
 	}{
 		{
-			name: "Created",
+			name:  "Created",
+			event: chargeEvent("charge.create", "pending", "", 20000),
+			mock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(selectPayment).WithArgs("charge_xxx").WillReturnError(sql.ErrNoRows)
+				mock.ExpectExec("INSERT INTO payments (charge_id, source_id, txn_id, status, livemode, merchant_id, return_uri, card_brand, card_last_digits) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)").
+					WithArgs("charge_xxx", "source_xxx", "", "pending", false, DefaultMerchantID, "", "", "").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+			},
+		},
+		{
+			name:  "Created after the payment request",
+			event: chargeEvent("charge.create", "pending", "", 20000),
+			mock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(selectPayment).WithArgs("charge_xxx").WillReturnRows(paymentRows("pending"))
+				mock.ExpectExec("UPDATE payments SET txn_id = ?, status = ?, card_brand = COALESCE(NULLIF(?, ''), card_brand), card_last_digits = COALESCE(NULLIF(?, ''), card_last_digits) WHERE charge_id = ?").
+					WithArgs("", "pending", "", "", "charge_xxx").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+			},
+		},
+		{
+			name:  "Success",
+			event: chargeEvent("charge.complete", "successful", "transaction_xxx", 20000),
+			mock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(selectPayment).WithArgs("charge_xxx").WillReturnRows(paymentRows("pending"))
+				mock.ExpectExec("UPDATE payments SET txn_id = ?, status = ?, card_brand = COALESCE(NULLIF(?, ''), card_brand), card_last_digits = COALESCE(NULLIF(?, ''), card_last_digits) WHERE charge_id = ?").
+					WithArgs("transaction_xxx", "successful", "", "", "charge_xxx").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+			},
+		},
+		{
+			name:  "Failed",
+			event: chargeEvent("charge.complete", "failed", "", 20000),
+			mock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(selectPayment).WithArgs("charge_xxx").WillReturnRows(paymentRows("pending"))
+				mock.ExpectExec("UPDATE payments SET txn_id = ?, status = ?, card_brand = COALESCE(NULLIF(?, ''), card_brand), card_last_digits = COALESCE(NULLIF(?, ''), card_last_digits) WHERE charge_id = ?").
+					WithArgs("", "failed", "", "", "charge_xxx").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+			},
+		},
+		{
+			name:  "Payment recorded before intents were stored",
+			event: chargeEvent("charge.complete", "successful", "transaction_xxx", 99999),
+			mock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(selectPayment).WithArgs("charge_xxx").WillReturnRows(
+					sqlmock.NewRows(paymentColumns).AddRow("pending", "", nil, nil, nil, DefaultMerchantID),
+				)
+				mock.ExpectExec("UPDATE payments SET txn_id = ?, status = ?, card_brand = COALESCE(NULLIF(?, ''), card_brand), card_last_digits = COALESCE(NULLIF(?, ''), card_last_digits) WHERE charge_id = ?").
+					WithArgs("transaction_xxx", "successful", "", "", "charge_xxx").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+			},
+		},
+		{
+			name:  "Amount mismatch",
+			event: chargeEvent("charge.complete", "successful", "transaction_xxx", 10000),
+			mock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(selectPayment).WithArgs("charge_xxx").WillReturnRows(paymentRows("pending"))
+				mock.ExpectBegin()
+				mock.ExpectExec("INSERT INTO payment_discrepancies (charge_id, event_id, field, expected, actual, created_at) VALUES (?, ?, ?, ?, ?, ?)").
+					WithArgs("charge_xxx", "event_xxx", "amount", "20000", "10000", sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec("UPDATE payments SET status = ?, reported_status = ?, txn_id = ? WHERE charge_id = ?").
+					WithArgs(StatusNeedsReview, "successful", "transaction_xxx", "charge_xxx").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectCommit()
+			},
+			expectedDiscrepancies: []Discrepancy{{
+				ChargeID:   "charge_xxx",
+				EventID:    "event_xxx",
+				Key:        "charge.complete",
+				Mismatches: []Mismatch{{Field: "amount", Expected: "20000", Actual: "10000"}},
+			}},
+		},
+		{
+			name: "Currency mismatch",
 			event: func() PaymentEvent {
-				p := PaymentEvent{
-					Key: "charge.create",
-				}
-				p.Data.ID = "charge_xxx"
-				p.Data.Source.ID = "source_xxx"
-				p.Data.Status = "pending"
+				p := chargeEvent("charge.create", "pending", "", 20000)
+				p.Data.Currency = "USD"
 				return p
 			}(),
+			mock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(selectPayment).WithArgs("charge_xxx").WillReturnRows(paymentRows("pending"))
+				mock.ExpectBegin()
+				mock.ExpectExec("INSERT INTO payment_discrepancies (charge_id, event_id, field, expected, actual, created_at) VALUES (?, ?, ?, ?, ?, ?)").
+					WithArgs("charge_xxx", "event_xxx", "currency", "thb", "usd", sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec("UPDATE payments SET status = ?, reported_status = ?, txn_id = ? WHERE charge_id = ?").
+					WithArgs(StatusNeedsReview, "pending", "", "charge_xxx").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectCommit()
+			},
+			expectedDiscrepancies: []Discrepancy{{
+				ChargeID:   "charge_xxx",
+				EventID:    "event_xxx",
+				Key:        "charge.create",
+				Mismatches: []Mismatch{{Field: "currency", Expected: "thb", Actual: "usd"}},
+			}},
 		},
 		{
-			name: "Success",
+			name: "Livemode mismatch",
 			event: func() PaymentEvent {
-				p := PaymentEvent{
-					Key: "charge.complete",
-				}
-				p.Data.ID = "charge_xxx"
-				p.Data.Source.ID = "source_xxx"
-				p.Data.Transaction = "transaction_xxx"
-				p.Data.Status = "successful"
+				p := chargeEvent("charge.complete", "successful", "transaction_xxx", 10000)
+				p.Livemode, p.Data.Livemode = true, true
 				return p
 			}(),
+			mock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(selectPayment).WithArgs("charge_xxx").WillReturnRows(paymentRows("pending"))
+				// Recorded and alerted, the payment is left as it is
+				mock.ExpectBegin()
+				mock.ExpectExec("INSERT INTO payment_discrepancies (charge_id, event_id, field, expected, actual, created_at) VALUES (?, ?, ?, ?, ?, ?)").
+					WithArgs("charge_xxx", "event_xxx", "amount", "20000", "10000", sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec("INSERT INTO payment_discrepancies (charge_id, event_id, field, expected, actual, created_at) VALUES (?, ?, ?, ?, ?, ?)").
+					WithArgs("charge_xxx", "event_xxx", "livemode", "false", "true", sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(2, 1))
+				mock.ExpectCommit()
+			},
+			expectedDiscrepancies: []Discrepancy{{
+				ChargeID: "charge_xxx",
+				EventID:  "event_xxx",
+				Key:      "charge.complete",
+				Mismatches: []Mismatch{
+					{Field: "amount", Expected: "20000", Actual: "10000"},
+					{Field: "livemode", Expected: "false", Actual: "true"},
+				},
+			}},
+			expectedError: ErrLivemodeMismatch,
 		},
 		{
-			name: "Failed",
+			name: "Mode of the charge decides, not the envelope",
 			event: func() PaymentEvent {
-				p := PaymentEvent{
-					Key: "charge.complete",
-				}
-				p.Data.ID = "charge_xxx"
-				p.Data.Source.ID = "source_xxx"
-				p.Data.Status = "failed"
+				p := chargeEvent("charge.complete", "successful", "transaction_xxx", 20000)
+				p.Livemode = true
 				return p
 			}(),
+			mock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(selectPayment).WithArgs("charge_xxx").WillReturnRows(paymentRows("pending"))
+				mock.ExpectExec("UPDATE payments SET txn_id = ?, status = ?, card_brand = COALESCE(NULLIF(?, ''), card_brand), card_last_digits = COALESCE(NULLIF(?, ''), card_last_digits) WHERE charge_id = ?").
+					WithArgs("transaction_xxx", "successful", "", "", "charge_xxx").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+			},
 		},
 		{
-			name: "Not matched key",
+			name:  "Payment of another merchant",
+			event: chargeEvent("charge.complete", "successful", "transaction_xxx", 20000),
+			mock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(selectPayment).WithArgs("charge_xxx").WillReturnRows(
+					sqlmock.NewRows(paymentColumns).AddRow("pending", "", 20000, "thb", false, "brand_a"),
+				)
+			},
+			expectedError: ErrMerchantMismatch,
+		},
+		{
+			name: "Card charge authorized with 3-D Secure",
 			event: func() PaymentEvent {
-				p := PaymentEvent{
-					Key: "charge.something",
-				}
+				p := chargeEvent("charge.complete", "successful", "transaction_xxx", 20000)
+				p.Data.Source = nil
+				p.Data.Card = &omise.Card{Brand: "Visa", LastDigits: "4242"}
 				return p
 			}(),
+			mock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(selectPayment).WithArgs("charge_xxx").WillReturnRows(paymentRows("pending"))
+				mock.ExpectExec("UPDATE payments SET txn_id = ?, status = ?, card_brand = COALESCE(NULLIF(?, ''), card_brand), card_last_digits = COALESCE(NULLIF(?, ''), card_last_digits) WHERE charge_id = ?").
+					WithArgs("transaction_xxx", "successful", "Visa", "4242", "charge_xxx").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+			},
+		},
+		{
+			name: "Card authorized without capture",
+			event: func() PaymentEvent {
+				p := chargeEvent("charge.complete", "pending", "", 20000)
+				p.Data.Source = nil
+				p.Data.Card = &omise.Card{Brand: "Visa", LastDigits: "4242"}
+				p.Data.Authorized = true
+				p.Data.ExpiresAt = &authorizationExpiresAt
+				return p
+			}(),
+			mock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(selectPayment).WithArgs("charge_xxx").WillReturnRows(paymentRows("pending"))
+				mock.ExpectExec("UPDATE payments SET txn_id = ?, status = ?, card_brand = COALESCE(NULLIF(?, ''), card_brand), card_last_digits = COALESCE(NULLIF(?, ''), card_last_digits) WHERE charge_id = ?").
+					WithArgs("", StatusAuthorized, "Visa", "4242", "charge_xxx").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec("UPDATE payments SET authorization_expires_at = ? WHERE charge_id = ? AND authorization_expires_at IS NULL").
+					WithArgs(authorizationExpiresAt, "charge_xxx").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+			},
+		},
+		{
+			name: "Failed wallet payment",
+			event: func() PaymentEvent {
+				p := chargeEvent("charge.complete", "failed", "", 20000)
+				code, message := "insufficient_balance", "insufficient balance in the wallet"
+				p.Data.FailureCode = &code
+				p.Data.FailureMessage = &message
+				return p
+			}(),
+			mock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(selectPayment).WithArgs("charge_xxx").WillReturnRows(paymentRows("pending"))
+				mock.ExpectExec("UPDATE payments SET txn_id = ?, status = ?, card_brand = COALESCE(NULLIF(?, ''), card_brand), card_last_digits = COALESCE(NULLIF(?, ''), card_last_digits) WHERE charge_id = ?").
+					WithArgs("", "failed", "", "", "charge_xxx").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec("UPDATE payments SET failure_code = ?, failure_message = ? WHERE charge_id = ?").
+					WithArgs("insufficient_balance", "insufficient balance in the wallet", "charge_xxx").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+			},
+		},
+		{
+			name:  "Needs review is kept with the reported status",
+			event: chargeEvent("charge.complete", "successful", "transaction_xxx", 20000),
+			mock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(selectPayment).WithArgs("charge_xxx").WillReturnRows(paymentRows(StatusNeedsReview))
+				mock.ExpectExec("UPDATE payments SET reported_status = ?, txn_id = ? WHERE charge_id = ? AND status = ?").
+					WithArgs("successful", "transaction_xxx", "charge_xxx", StatusNeedsReview).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+			},
+		},
+		{
+			name:  "Late expiry of a payment in review reported successful",
+			event: chargeEvent("charge.expire", "expired", "", 20000),
+			mock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(selectPayment).WithArgs("charge_xxx").WillReturnRows(
+					sqlmock.NewRows(paymentColumns).AddRow(StatusNeedsReview, "successful", 20000, "thb", false, DefaultMerchantID),
+				)
+			},
+		},
+		{
+			name: "Not matched key",
+			event: PaymentEvent{
+				Key: "charge.something",
+			},
+			mock: func(mock sqlmock.Sqlmock) {},
 		},
 	}
 
 	t.Parallel()
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			var db *sql.DB
-
-			if tc.event.Key == "charge.create" || tc.event.Key == "charge.complete" {
-				var (
-					mock sqlmock.Sqlmock
-					err  error
-				)
-				db, mock, err = sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
-				if err != nil {
-					t.Error(err)
-				}
-				defer db.Close()
-
-				switch tc.event.Key {
-				case "charge.create":
-					mock.ExpectExec("INSERT INTO payments (charge_id, source_id, txn_id, status) VALUES (?, ?, ?, ?)").
-						WithArgs(tc.event.Data.ID, tc.event.Data.Source.ID, tc.event.Data.Transaction, tc.event.Data.Status).
-						WillReturnResult(sqlmock.NewResult(1, 1))
-				case "charge.complete":
-					mock.ExpectExec("UPDATE payments SET txn_id = ?, status = ? WHERE charge_id = ?").
-						WithArgs(tc.event.Data.Transaction, tc.event.Data.Status, tc.event.Data.ID).
-						WillReturnResult(sqlmock.NewResult(1, 1))
-				}
+			db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Error(err)
 			}
+			defer db.Close()
 
-			p := New(nil, db)
+			tc.mock(mock)
 
-			err := p.HookPaymentEvent(ctx, tc.event)
+			notifier := &recordingNotifier{}
+			p := New(NewMerchantStore(db, nil), nil, nil, db, zap.NewNop().Sugar(), WithNotifier(notifier))
+
+			err = p.HookPaymentEvent(ctx, tc.event)
 
 			assert.Equal(t, tc.expectedError, err)
+			assert.NoError(t, mock.ExpectationsWereMet())
+			assert.Equal(t, tc.expectedDiscrepancies, notifier.discrepancies)
+
+		})
+	}
+
+}
+
+func TestResolveReview(t *testing.T) {
+	ctx := context.Background()
+	p, db, _, notifier := newTestMockedPayment(t)
+
+	_, err := db.Exec(
+		`INSERT INTO payments (charge_id, source_id, txn_id, status, amount, currency, livemode) VALUES
+		('chrg_test_a', 'src_test', '', 'pending', 20000, 'thb', false),
+		('chrg_test_old', 'src_test', '', 'needs_review', 20000, 'thb', false)`,
+	)
+	assert.NoError(t, err)
+
+	err = p.HookPaymentEvent(ctx, PaymentEvent{
+		ID:   "evnt_test_a",
+		Key:  "charge.complete",
+		Data: EventCharge{ID: "chrg_test_a", Status: "successful", Amount: 10000, Currency: "thb", Capture: true, Paid: true, Transaction: "trxn_test_a"},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, notifier.discrepancies, 1)
+
+	detail, err := p.GetPayment(ctx, "chrg_test_a")
+	assert.NoError(t, err)
+	assert.Equal(t, StatusNeedsReview, detail.Status)
+	assert.Equal(t, "successful", detail.ReportedStatus)
+
+	testCases := []struct {
+		name           string
+		chargeID       string
+		req            ReviewRequest
+		expectedStatus string
+		expectedError  error
+	}{
+		{
+			name:          "Unknown status",
+			chargeID:      "chrg_test_a",
+			req:           ReviewRequest{Status: "paid"},
+			expectedError: ErrInvalidRequest,
+		},
+		{
+			name:          "Unknown payment",
+			chargeID:      "chrg_test_unknown",
+			expectedError: ErrPaymentNotFound,
+		},
+		{
+			name:           "Resolved to the reported status",
+			chargeID:       "chrg_test_a",
+			expectedStatus: "successful",
+		},
+		{
+			name:          "Resolved already",
+			chargeID:      "chrg_test_a",
+			expectedError: ErrPaymentNotInReview,
+		},
+		{
+			name:          "Flagged before reported statuses were kept",
+			chargeID:      "chrg_test_old",
+			expectedError: ErrReviewStatusRequired,
+		},
+		{
+			name:           "Resolved to the status of the reviewer",
+			chargeID:       "chrg_test_old",
+			req:            ReviewRequest{Status: "failed"},
+			expectedStatus: "failed",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := p.ResolveReview(ctx, tc.chargeID, tc.req)
+			if tc.expectedError != nil {
+				assert.True(t, errors.Is(err, tc.expectedError), "%v", err)
+				return
+			}
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, tc.expectedStatus, r.Status)
+			assert.Equal(t, DefaultMerchantID, r.MerchantID)
 
+			status, err := p.GetPaymentStatusWithChargeID(ctx, tc.chargeID)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedStatus, status.Status)
 		})
 	}
 
+	var unresolved int
+	assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM payment_discrepancies WHERE resolved_at IS NULL").Scan(&unresolved))
+	assert.Zero(t, unresolved)
+
+	// Events apply again once resolved
+	err = p.HookPaymentEvent(ctx, PaymentEvent{
+		ID:   "evnt_test_old",
+		Key:  "charge.complete",
+		Data: EventCharge{ID: "chrg_test_old", Status: "successful", Amount: 20000, Currency: "thb", Capture: true, Paid: true, Transaction: "trxn_test_old"},
+	})
+	assert.NoError(t, err)
+	status, err := p.GetPaymentStatusWithChargeID(ctx, "chrg_test_old")
+	assert.NoError(t, err)
+	assert.Equal(t, "successful", status.Status)
 }
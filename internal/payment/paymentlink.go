@@ -0,0 +1,178 @@
+package payment
+
+import (
+	"context"
+	"database/sql"
+	"exam-payment-service/pkg/logger"
+	"time"
+
+	"github.com/omise/omise-go"
+	"github.com/omise/omise-go/operations"
+)
+
+// PaymentLinkRequest creates an Omise hosted payment page, Multiple links can be paid more than once
+type PaymentLinkRequest struct {
+	Amount      int64    `json:"amount"`
+	Currency    Currency `json:"currency"`
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	Multiple    bool     `json:"multiple"`
+}
+
+// PaymentLink is a payment link as stored, PaymentURI is what customers are sent
+type PaymentLink struct {
+	LinkID      string    `json:"linkId"`
+	Amount      int64     `json:"amount"`
+	Currency    string    `json:"currency"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	Multiple    bool      `json:"multiple"`
+	PaymentURI  string    `json:"paymentUri"`
+	Used        bool      `json:"used"`
+	Livemode    bool      `json:"livemode"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// PaymentLinkUsage reports the charges made through a payment link, Collected is the amount
+// of its successful charges
+type PaymentLinkUsage struct {
+	PaymentLink
+	Charges   int            `json:"charges"`
+	ByStatus  map[string]int `json:"byStatus"`
+	Collected int64          `json:"collected"`
+}
+
+// CreatePaymentLink creates a payment link of the context's merchant
+func (p Payment) CreatePaymentLink(ctx context.Context, lr PaymentLinkRequest) (PaymentLink, error) {
+	m, err := p.merchants.Get(ctx, MerchantFromContext(ctx))
+	if err != nil {
+		return PaymentLink{}, err
+	}
+
+	// Validation
+	min, max := m.chargeLimits(lr.Currency)
+	if min > 0 && lr.Amount < min {
+		return PaymentLink{}, ErrAmountLowerThanChargeLimit
+	}
+
+	if max > 0 && lr.Amount > max {
+		return PaymentLink{}, ErrChargeLimitExceeded
+	}
+
+	if !lr.Currency.Validate() {
+		return PaymentLink{}, ErrInvalidCurrency
+	}
+
+	// Both are shown on the payment page and required by Omise
+	if lr.Title == "" || lr.Description == "" {
+		return PaymentLink{}, ErrInvalidRequest
+	}
+
+	oc, err := p.provider(ctx)
+	if err != nil {
+		return PaymentLink{}, err
+	}
+
+	link, err := oc.CreateLink(ctx, operations.CreateLink{
+		Amount:      lr.Amount,
+		Currency:    string(lr.Currency),
+		Title:       lr.Title,
+		Description: lr.Description,
+		Multiple:    lr.Multiple,
+	})
+	if err != nil {
+		return PaymentLink{}, wrapOmiseError(err)
+	}
+
+	_, err = p.db.ExecContext(
+		ctx,
+		`INSERT INTO payment_links (link_id, merchant_id, livemode, amount, currency, title, description, multiple, payment_uri, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		link.ID, m.ID, LivemodeFromContext(ctx), lr.Amount, string(lr.Currency), lr.Title, lr.Description, lr.Multiple, link.PaymentURI, time.Now().UTC(),
+	)
+	if err != nil {
+		logger.For(ctx, p.log).Errorw("CreatePaymentLink error", "error", SafeError(err), "link_id", link.ID)
+		return PaymentLink{}, ErrInternal.Wrap(err)
+	}
+
+	logger.For(ctx, p.log).Infow("Payment link created", "link_id", link.ID, "multiple", lr.Multiple)
+
+	return p.GetPaymentLink(ctx, link.ID)
+}
+
+// GetPaymentLink returns a payment link of the context's merchant and mode
+func (p Payment) GetPaymentLink(ctx context.Context, linkID string) (PaymentLink, error) {
+	l := PaymentLink{LinkID: linkID, Livemode: LivemodeFromContext(ctx)}
+	err := p.db.QueryRowContext(
+		ctx,
+		`SELECT amount, currency, title, description, multiple, payment_uri, used, created_at
+		FROM payment_links WHERE link_id = ? AND merchant_id = ? AND livemode = ?`,
+		linkID, MerchantFromContext(ctx), l.Livemode,
+	).Scan(&l.Amount, &l.Currency, &l.Title, &l.Description, &l.Multiple, &l.PaymentURI, &l.Used, &l.CreatedAt)
+	if err == sql.ErrNoRows {
+		return PaymentLink{}, ErrPaymentLinkNotFound
+	}
+	if err != nil {
+		return PaymentLink{}, ErrInternal.Wrap(err)
+	}
+
+	return l, nil
+}
+
+// GetPaymentLinkUsage returns a payment link of the context's merchant and mode with the
+// charges made through it by status
+func (p Payment) GetPaymentLinkUsage(ctx context.Context, linkID string) (PaymentLinkUsage, error) {
+	l, err := p.GetPaymentLink(ctx, linkID)
+	if err != nil {
+		return PaymentLinkUsage{}, err
+	}
+
+	u := PaymentLinkUsage{PaymentLink: l, ByStatus: map[string]int{}}
+	rows, err := p.db.QueryContext(ctx, "SELECT status, COUNT(*) FROM payments WHERE link_id = ? GROUP BY status", linkID)
+	if err != nil {
+		return PaymentLinkUsage{}, ErrInternal.Wrap(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			status string
+			n      int
+		)
+		if err := rows.Scan(&status, &n); err != nil {
+			return PaymentLinkUsage{}, ErrInternal.Wrap(err)
+		}
+		u.ByStatus[status] = n
+		u.Charges += n
+	}
+	if err := rows.Err(); err != nil {
+		return PaymentLinkUsage{}, ErrInternal.Wrap(err)
+	}
+
+	// Charges of a link are always of its amount
+	u.Collected = int64(u.ByStatus[string(omise.ChargeSuccessful)]) * l.Amount
+
+	return u, nil
+}
+
+// linkPaymentLink records a charge made through a payment link of the context's merchant against
+// the link, a successful charge uses up a single-use link
+func (p Payment) linkPaymentLink(ctx context.Context, chargeID string, linkID string, status string) error {
+	_, err := p.db.ExecContext(
+		ctx,
+		`UPDATE payments SET link_id = ?
+		WHERE charge_id = ? AND link_id = '' AND EXISTS (SELECT 1 FROM payment_links WHERE link_id = ? AND merchant_id = ?)`,
+		linkID, chargeID, linkID, MerchantFromContext(ctx),
+	)
+	if err != nil || status != string(omise.ChargeSuccessful) {
+		return err
+	}
+
+	_, err = p.db.ExecContext(
+		ctx,
+		"UPDATE payment_links SET used = true WHERE link_id = ? AND merchant_id = ? AND multiple = false",
+		linkID, MerchantFromContext(ctx),
+	)
+
+	return err
+}
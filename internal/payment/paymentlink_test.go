@@ -0,0 +1,139 @@
+package payment
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/omise/omise-go"
+	"github.com/omise/omise-go/operations"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreatePaymentLink(t *testing.T) {
+	ctx := context.Background()
+	p, _, op, _ := newTestMockedPayment(t)
+
+	op.EXPECT().CreateLink(gomock.Any(), operations.CreateLink{
+		Amount:      49900,
+		Currency:    "thb",
+		Title:       "Premium",
+		Description: "One year of Premium",
+	}).Return(omise.Link{Base: omise.Base{ID: "link_test_a"}, PaymentURI: "https://link.omise.co/a"}, nil)
+
+	l, err := p.CreatePaymentLink(ctx, PaymentLinkRequest{Amount: 49900, Currency: CurrencyTHB, Title: "Premium", Description: "One year of Premium"})
+	assert.NoError(t, err)
+	assert.Equal(t, "link_test_a", l.LinkID)
+	assert.Equal(t, "https://link.omise.co/a", l.PaymentURI)
+	assert.False(t, l.Multiple)
+
+	testCases := []struct {
+		name          string
+		ctx           context.Context
+		request       PaymentLinkRequest
+		expectedError error
+	}{
+		{
+			name:          "Without description",
+			ctx:           ctx,
+			request:       PaymentLinkRequest{Amount: 49900, Currency: CurrencyTHB, Title: "Premium"},
+			expectedError: ErrInvalidRequest,
+		},
+		{
+			name:          "Lower than charge limit",
+			ctx:           ctx,
+			request:       PaymentLinkRequest{Amount: 1, Currency: CurrencyTHB, Title: "Premium", Description: "Premium"},
+			expectedError: ErrAmountLowerThanChargeLimit,
+		},
+		{
+			name:          "Invalid currency",
+			ctx:           ctx,
+			request:       PaymentLinkRequest{Amount: 49900, Currency: "usd", Title: "Premium", Description: "Premium"},
+			expectedError: ErrInvalidCurrency,
+		},
+		{
+			name:          "Link of another mode",
+			ctx:           ContextWithLivemode(ctx, true),
+			request:       PaymentLinkRequest{Amount: 49900, Currency: CurrencyTHB, Title: "Premium", Description: "Premium"},
+			expectedError: ErrModeNotConfigured,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := p.CreatePaymentLink(tc.ctx, tc.request)
+
+			assert.Equal(t, tc.expectedError, err)
+		})
+	}
+
+	_, err = p.GetPaymentLink(ContextWithLivemode(ctx, true), "link_test_a")
+	assert.Equal(t, ErrPaymentLinkNotFound, err)
+}
+
+func TestPaymentLinkUsage(t *testing.T) {
+	ctx := context.Background()
+	p, _, op, _ := newTestMockedPayment(t)
+
+	op.EXPECT().CreateLink(gomock.Any(), gomock.Any()).Return(omise.Link{Base: omise.Base{ID: "link_test_a"}}, nil)
+	_, err := p.CreatePaymentLink(ctx, PaymentLinkRequest{Amount: 49900, Currency: CurrencyTHB, Title: "Premium", Description: "Premium", Multiple: true})
+	assert.NoError(t, err)
+
+	linkID := "link_test_a"
+	otherLinkID := "link_test_unknown"
+	charges := []struct {
+		chargeID string
+		linkID   *string
+		key      string
+		status   string
+	}{
+		{"chrg_test_a", &linkID, "charge.create", "pending"},
+		{"chrg_test_a", &linkID, "charge.complete", "successful"},
+		{"chrg_test_b", &linkID, "charge.create", "failed"},
+		{"chrg_test_c", &linkID, "charge.create", "successful"},
+		{"chrg_test_d", &otherLinkID, "charge.create", "successful"},
+		{"chrg_test_e", nil, "charge.create", "successful"},
+	}
+	for _, c := range charges {
+		event := PaymentEvent{ID: "evnt_" + c.chargeID + c.status, Key: c.key}
+		event.Data.ID = c.chargeID
+		event.Data.Status = c.status
+		event.Data.Link = c.linkID
+		assert.NoError(t, p.HookPaymentEvent(ctx, event))
+	}
+
+	u, err := p.GetPaymentLinkUsage(ctx, linkID)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, u.Charges)
+	assert.Equal(t, map[string]int{"successful": 2, "failed": 1}, u.ByStatus)
+	assert.Equal(t, int64(99800), u.Collected)
+	// Multi-use links are never used up
+	assert.False(t, u.Used)
+}
+
+func TestSingleUsePaymentLink(t *testing.T) {
+	ctx := context.Background()
+	p, _, op, _ := newTestMockedPayment(t)
+
+	op.EXPECT().CreateLink(gomock.Any(), gomock.Any()).Return(omise.Link{Base: omise.Base{ID: "link_test_a"}}, nil)
+	_, err := p.CreatePaymentLink(ctx, PaymentLinkRequest{Amount: 49900, Currency: CurrencyTHB, Title: "Premium", Description: "Premium"})
+	assert.NoError(t, err)
+
+	linkID := "link_test_a"
+	event := PaymentEvent{ID: "evnt_test_a", Key: "charge.create"}
+	event.Data.ID = "chrg_test_a"
+	event.Data.Status = "failed"
+	event.Data.Link = &linkID
+	assert.NoError(t, p.HookPaymentEvent(ctx, event))
+
+	l, err := p.GetPaymentLink(ctx, linkID)
+	assert.NoError(t, err)
+	assert.False(t, l.Used)
+
+	event.ID, event.Data.ID, event.Data.Status = "evnt_test_b", "chrg_test_b", "successful"
+	assert.NoError(t, p.HookPaymentEvent(ctx, event))
+
+	l, err = p.GetPaymentLink(ctx, linkID)
+	assert.NoError(t, err)
+	assert.True(t, l.Used)
+}
@@ -0,0 +1,344 @@
+package payment
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"exam-payment-service/pkg/logger"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Webhook event states in the queue, processed events are deleted
+const (
+	EventStatePending = "pending"
+	EventStateDead    = "dead"
+)
+
+// QueueConfig of the webhook event workers
+type QueueConfig struct {
+	// Workers is the number of events processed at once
+	Workers int
+	// MaxAttempts before an event is dead-lettered
+	MaxAttempts int
+	// Retries wait MinBackoff, doubled on each attempt up to MaxBackoff
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// PollInterval is how often the queue is checked for retries that are due
+	PollInterval time.Duration
+}
+
+var DefaultQueueConfig = QueueConfig{
+	Workers:      4,
+	MaxAttempts:  8,
+	MinBackoff:   5 * time.Second,
+	MaxBackoff:   30 * time.Minute,
+	PollInterval: time.Second,
+}
+
+// backoff returns the wait before the retry following the attempt
+func (c QueueConfig) backoff(attempts int) time.Duration {
+	d := c.MinBackoff
+	for i := 1; i < attempts && d < c.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > c.MaxBackoff {
+		d = c.MaxBackoff
+	}
+
+	return d
+}
+
+// QueuedEvent is a webhook event waiting in the queue, Payload is only set by GetEvent
+type QueuedEvent struct {
+	ID            int64           `json:"id"`
+	EventID       string          `json:"eventId"`
+	MerchantID    string          `json:"merchantId"`
+	ChargeID      string          `json:"chargeId"`
+	Key           string          `json:"key"`
+	State         string          `json:"state"`
+	Attempts      int             `json:"attempts"`
+	LastError     string          `json:"lastError"`
+	NextAttemptAt time.Time       `json:"nextAttemptAt"`
+	CreatedAt     time.Time       `json:"createdAt"`
+	UpdatedAt     time.Time       `json:"updatedAt"`
+	Payload       json.RawMessage `json:"payload,omitempty"`
+
+	// payload is the encrypted raw event
+	payload string
+}
+
+const eventColumns = "id, event_id, merchant_id, charge_id, key, payload, state, attempts, last_error, next_attempt_at, created_at, updated_at"
+
+func scanEvent(row scanner) (QueuedEvent, error) {
+	var e QueuedEvent
+	err := row.Scan(
+		&e.ID, &e.EventID, &e.MerchantID, &e.ChargeID, &e.Key, &e.payload, &e.State,
+		&e.Attempts, &e.LastError, &e.NextAttemptAt, &e.CreatedAt, &e.UpdatedAt,
+	)
+
+	return e, err
+}
+
+// EnqueueEvent stores the raw event of the request's merchant for the workers, verify it first with VerifyEvent.
+// An event already in the queue of the merchant is not queued again
+func (p Payment) EnqueueEvent(ctx context.Context, payload []byte) error {
+	var event PaymentEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return ErrInvalidRequest.Wrap(err)
+	}
+	if event.ID == "" || event.Key == "" {
+		return ErrInvalidRequest
+	}
+
+	// Events of a charge are ordered by it
+	var chargeID string
+	if strings.HasPrefix(event.Key, "charge.") {
+		chargeID = event.Data.ID
+	}
+
+	encrypted, err := encryptString(p.cipher, string(payload))
+	if err != nil {
+		return ErrInternal.Wrap(err)
+	}
+
+	now := time.Now().UTC()
+	_, err = p.db.ExecContext(
+		ctx,
+		`INSERT INTO webhook_events (event_id, merchant_id, charge_id, key, payload, state, next_attempt_at, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (merchant_id, event_id) DO NOTHING`,
+		event.ID, MerchantFromContext(ctx), chargeID, event.Key, encrypted, EventStatePending, now, now, now,
+	)
+	if err != nil {
+		return ErrInternal.Wrap(err)
+	}
+
+	// Wakes the workers if they are idle
+	select {
+	case p.queued <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// RunEventWorkers processes queued events until ctx is done, then waits for the events in progress.
+// Events of a charge are processed one at a time in the order they were received, events left in
+// progress by a crash are still pending and processed again
+func (p Payment) RunEventWorkers(ctx context.Context, cfg QueueConfig) {
+	var (
+		inProgress = map[int64]bool{}
+		finished   = make(chan int64)
+		ticker     = time.NewTicker(cfg.PollInterval)
+	)
+	defer ticker.Stop()
+
+	for {
+		if free := cfg.Workers - len(inProgress); free > 0 && ctx.Err() == nil {
+			// Events in progress are still pending, they are fetched again and skipped
+			events, err := p.dueEvents(ctx, free+len(inProgress))
+			if err != nil {
+				logger.For(ctx, p.log).Errorw("RunEventWorkers error", "error", SafeError(err))
+			}
+
+			for _, e := range events {
+				if inProgress[e.ID] || len(inProgress) == cfg.Workers {
+					continue
+				}
+
+				inProgress[e.ID] = true
+				go func(e QueuedEvent) {
+					// Not canceled with ctx, an event in progress is finished on shutdown
+					p.processEvent(context.Background(), cfg, e)
+					finished <- e.ID
+				}(e)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			for len(inProgress) > 0 {
+				delete(inProgress, <-finished)
+			}
+			return
+		case id := <-finished:
+			delete(inProgress, id)
+		case <-p.queued:
+		case <-ticker.C:
+		}
+	}
+}
+
+// dueEvents returns pending events due for an attempt, oldest first. An event waits for the
+// earlier pending events of its charge, dead-lettered ones do not hold the charge back
+func (p Payment) dueEvents(ctx context.Context, limit int) ([]QueuedEvent, error) {
+	rows, err := p.db.QueryContext(
+		ctx,
+		`SELECT `+eventColumns+` FROM webhook_events e
+		WHERE state = ? AND next_attempt_at <= ? AND (charge_id = '' OR NOT EXISTS (
+			SELECT 1 FROM webhook_events b WHERE b.charge_id = e.charge_id AND b.state = ? AND b.id < e.id
+		))
+		ORDER BY id LIMIT ?`,
+		EventStatePending, time.Now().UTC(), EventStatePending, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []QueuedEvent
+	for rows.Next() {
+		e, err := scanEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
+
+// processEvent applies the event and deletes it, or schedules its retry. Events that fail
+// with a client error, e.g. of the other mode, are dead-lettered without retry
+func (p Payment) processEvent(ctx context.Context, cfg QueueConfig, e QueuedEvent) {
+	ctx = ContextWithMerchant(ctx, e.MerchantID)
+	log := logger.For(ctx, p.log).With("id", e.ID, "event_id", e.EventID, "key", e.Key, "charge_id", e.ChargeID, "merchant_id", e.MerchantID)
+
+	err := p.applyEvent(ctx, e)
+	if err == nil {
+		queueEventsTotal.WithLabelValues(queueResultProcessed).Inc()
+		if _, err := p.db.ExecContext(ctx, "DELETE FROM webhook_events WHERE id = ?", e.ID); err != nil {
+			log.Errorw("processEvent error", "error", SafeError(err))
+		}
+		return
+	}
+
+	now := time.Now().UTC()
+	attempts := e.Attempts + 1
+
+	var pErr *Error
+	if (errors.As(err, &pErr) && pErr.Status < http.StatusInternalServerError) || attempts >= cfg.MaxAttempts {
+		queueEventsTotal.WithLabelValues(queueResultDead).Inc()
+		log.Errorw("Webhook event dead-lettered", "error", SafeError(err), "attempts", attempts)
+
+		_, err = p.db.ExecContext(
+			ctx,
+			"UPDATE webhook_events SET state = ?, attempts = ?, last_error = ?, updated_at = ? WHERE id = ?",
+			EventStateDead, attempts, SafeError(err), now, e.ID,
+		)
+	} else {
+		queueEventsTotal.WithLabelValues(queueResultRetried).Inc()
+		retryAt := now.Add(cfg.backoff(attempts))
+		log.Warnw("Webhook event retry", "error", SafeError(err), "attempts", attempts, "retry_at", retryAt)
+
+		_, err = p.db.ExecContext(
+			ctx,
+			"UPDATE webhook_events SET attempts = ?, last_error = ?, next_attempt_at = ?, updated_at = ? WHERE id = ?",
+			attempts, SafeError(err), retryAt, now, e.ID,
+		)
+	}
+	if err != nil {
+		log.Errorw("processEvent error", "error", SafeError(err))
+	}
+}
+
+func (p Payment) applyEvent(ctx context.Context, e QueuedEvent) error {
+	payload, err := decryptString(p.cipher, e.payload)
+	if err != nil {
+		return err
+	}
+
+	var event PaymentEvent
+	if err := json.Unmarshal([]byte(payload), &event); err != nil {
+		return ErrInvalidRequest.Wrap(err)
+	}
+
+	return p.HookPaymentEvent(ctx, event)
+}
+
+// ListEvents returns up to limit queued events in the state, oldest first
+func (p Payment) ListEvents(ctx context.Context, state string, limit int) ([]QueuedEvent, error) {
+	if state != EventStatePending && state != EventStateDead {
+		return nil, ErrInvalidRequest
+	}
+
+	rows, err := p.db.QueryContext(
+		ctx,
+		"SELECT "+eventColumns+" FROM webhook_events WHERE state = ? ORDER BY id LIMIT ?",
+		state, limit,
+	)
+	if err != nil {
+		return nil, ErrInternal.Wrap(err)
+	}
+	defer rows.Close()
+
+	events := []QueuedEvent{}
+	for rows.Next() {
+		e, err := scanEvent(rows)
+		if err != nil {
+			return nil, ErrInternal.Wrap(err)
+		}
+		events = append(events, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, ErrInternal.Wrap(err)
+	}
+
+	return events, nil
+}
+
+// GetEvent returns the queued event with its payload
+func (p Payment) GetEvent(ctx context.Context, id int64) (QueuedEvent, error) {
+	e, err := scanEvent(p.db.QueryRowContext(ctx, "SELECT "+eventColumns+" FROM webhook_events WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return QueuedEvent{}, ErrEventNotFound
+	}
+	if err != nil {
+		return QueuedEvent{}, ErrInternal.Wrap(err)
+	}
+
+	payload, err := decryptString(p.cipher, e.payload)
+	if err != nil {
+		return QueuedEvent{}, ErrInternal.Wrap(err)
+	}
+	e.Payload = json.RawMessage(payload)
+
+	return e, nil
+}
+
+// RequeueEvent moves a dead-lettered event back to the queue with its attempts reset
+func (p Payment) RequeueEvent(ctx context.Context, id int64) (QueuedEvent, error) {
+	e, err := p.GetEvent(ctx, id)
+	if err != nil {
+		return QueuedEvent{}, err
+	}
+	if e.State != EventStateDead {
+		return QueuedEvent{}, ErrEventNotDead
+	}
+
+	now := time.Now().UTC()
+	res, err := p.db.ExecContext(
+		ctx,
+		"UPDATE webhook_events SET state = ?, attempts = 0, next_attempt_at = ?, updated_at = ? WHERE id = ? AND state = ?",
+		EventStatePending, now, now, id, EventStateDead,
+	)
+	if err != nil {
+		return QueuedEvent{}, ErrInternal.Wrap(err)
+	}
+
+	// Requeued concurrently
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return QueuedEvent{}, ErrEventNotDead
+	}
+
+	select {
+	case p.queued <- struct{}{}:
+	default:
+	}
+
+	e.State, e.Attempts, e.NextAttemptAt, e.UpdatedAt = EventStatePending, 0, now, now
+
+	return e, nil
+}
@@ -0,0 +1,300 @@
+package payment
+
+import (
+	"context"
+	"database/sql"
+	"exam-payment-service/internal/webhooksim"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+var testQueueConfig = QueueConfig{
+	Workers:      2,
+	MaxAttempts:  3,
+	MinBackoff:   time.Minute,
+	MaxBackoff:   time.Hour,
+	PollInterval: 10 * time.Millisecond,
+}
+
+func newTestQueue(t *testing.T) (*Payment, *sql.DB) {
+	s, db := newTestMerchantStore(t)
+
+	return New(s, nil, newTestKeyring(t), db, zap.NewNop().Sugar()), db
+}
+
+func enqueue(t *testing.T, p *Payment, params webhooksim.Params) QueuedEvent {
+	payload, err := webhooksim.Build(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.EnqueueEvent(context.Background(), payload); err != nil {
+		t.Fatal(err)
+	}
+
+	var e QueuedEvent
+	err = p.db.QueryRow("SELECT id, event_id, charge_id FROM webhook_events ORDER BY id DESC LIMIT 1").Scan(&e.ID, &e.EventID, &e.ChargeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return e
+}
+
+func eventIDs(events []QueuedEvent) []int64 {
+	ids := []int64{}
+	for _, e := range events {
+		ids = append(ids, e.ID)
+	}
+	return ids
+}
+
+func TestEnqueueEvent(t *testing.T) {
+	ctx := context.Background()
+	p, db := newTestQueue(t)
+
+	payload, err := webhooksim.Build(webhooksim.Params{Key: "charge.create", ChargeID: "chrg_test_a", ReturnURI: "https://example.com/orders/1?token=xxx"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Delivered twice
+	assert.NoError(t, p.EnqueueEvent(ctx, payload))
+	assert.NoError(t, p.EnqueueEvent(ctx, payload))
+
+	events, err := p.ListEvents(ctx, EventStatePending, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, "chrg_test_a", events[0].ChargeID)
+		assert.Equal(t, "charge.create", events[0].Key)
+		assert.Equal(t, DefaultMerchantID, events[0].MerchantID)
+	}
+
+	// Encrypted at rest
+	var stored string
+	assert.NoError(t, db.QueryRow("SELECT payload FROM webhook_events").Scan(&stored))
+	assert.NotContains(t, stored, "token=xxx")
+
+	e, err := p.GetEvent(ctx, events[0].ID)
+	assert.NoError(t, err)
+	assert.JSONEq(t, string(payload), string(e.Payload))
+
+	testCases := []struct {
+		name    string
+		payload string
+	}{
+		{
+			name:    "Malformed",
+			payload: `{"key":`,
+		},
+		{
+			name:    "Without ID",
+			payload: `{"object":"event","key":"charge.create"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := p.EnqueueEvent(ctx, []byte(tc.payload))
+
+			assert.ErrorIs(t, err, ErrInvalidRequest)
+		})
+	}
+}
+
+func TestDueEvents(t *testing.T) {
+	ctx := context.Background()
+	p, db := newTestQueue(t)
+
+	a1 := enqueue(t, p, webhooksim.Params{Key: "charge.create", ChargeID: "chrg_test_a"})
+	a2 := enqueue(t, p, webhooksim.Params{Key: "charge.complete", ChargeID: "chrg_test_a"})
+	b1 := enqueue(t, p, webhooksim.Params{Key: "charge.create", ChargeID: "chrg_test_b"})
+	r1 := enqueue(t, p, webhooksim.Params{Key: "refund.create"})
+	assert.Empty(t, r1.ChargeID)
+
+	// The second event of a charge waits for the first
+	events, err := p.dueEvents(ctx, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{a1.ID, b1.ID, r1.ID}, eventIDs(events))
+
+	// Also while the first waits for its retry
+	_, err = db.Exec("UPDATE webhook_events SET next_attempt_at = ? WHERE id = ?", time.Now().UTC().Add(time.Hour), a1.ID)
+	assert.NoError(t, err)
+
+	events, err = p.dueEvents(ctx, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{b1.ID, r1.ID}, eventIDs(events))
+
+	// Not once it is dead-lettered
+	_, err = db.Exec("UPDATE webhook_events SET state = ? WHERE id = ?", EventStateDead, a1.ID)
+	assert.NoError(t, err)
+
+	events, err = p.dueEvents(ctx, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{a2.ID, b1.ID}, eventIDs(events))
+}
+
+func TestProcessEvent(t *testing.T) {
+	testCases := []struct {
+		name             string
+		attempts         int
+		breakDB          bool
+		livemode         bool
+		expectedState    string
+		expectedAttempts int
+		expectedRetry    bool
+	}{
+		{
+			name: "Processed",
+		},
+		{
+			name:             "Retried",
+			breakDB:          true,
+			expectedState:    EventStatePending,
+			expectedAttempts: 1,
+			expectedRetry:    true,
+		},
+		{
+			name:             "Last attempt",
+			attempts:         testQueueConfig.MaxAttempts - 1,
+			breakDB:          true,
+			expectedState:    EventStateDead,
+			expectedAttempts: testQueueConfig.MaxAttempts,
+		},
+		{
+			name:             "Rejected without retry",
+			livemode:         true,
+			expectedState:    EventStateDead,
+			expectedAttempts: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			p, db := newTestQueue(t)
+
+			_, err := db.Exec("INSERT INTO payments (charge_id, source_id, txn_id, status, livemode) VALUES ('chrg_test_a', '', '', 'pending', false)")
+			assert.NoError(t, err)
+
+			e := enqueue(t, p, webhooksim.Params{Key: "charge.complete", ChargeID: "chrg_test_a", Livemode: tc.livemode})
+			_, err = db.Exec("UPDATE webhook_events SET attempts = ? WHERE id = ?", tc.attempts, e.ID)
+			assert.NoError(t, err)
+
+			events, err := p.dueEvents(ctx, 1)
+			if err != nil || len(events) != 1 {
+				t.Fatal(err, events)
+			}
+
+			if tc.breakDB {
+				_, err = db.Exec("ALTER TABLE payments RENAME TO payments_moved")
+				assert.NoError(t, err)
+			}
+
+			start := time.Now().UTC()
+			p.processEvent(ctx, testQueueConfig, events[0])
+
+			got, err := p.GetEvent(ctx, e.ID)
+			if tc.expectedState == "" {
+				assert.Equal(t, ErrEventNotFound, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedState, got.State)
+			assert.Equal(t, tc.expectedAttempts, got.Attempts)
+			assert.NotEmpty(t, got.LastError)
+			if tc.expectedRetry {
+				assert.WithinDuration(t, start.Add(testQueueConfig.MinBackoff), got.NextAttemptAt, 5*time.Second)
+			}
+		})
+	}
+}
+
+func TestQueueBackoff(t *testing.T) {
+	testCases := []struct {
+		attempts int
+		expected time.Duration
+	}{
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{4, 8 * time.Minute},
+		{20, time.Hour},
+	}
+
+	t.Parallel()
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expected, testQueueConfig.backoff(tc.attempts))
+	}
+}
+
+func TestRequeueEvent(t *testing.T) {
+	ctx := context.Background()
+	p, db := newTestQueue(t)
+
+	e := enqueue(t, p, webhooksim.Params{Key: "charge.create"})
+
+	_, err := p.RequeueEvent(ctx, e.ID)
+	assert.Equal(t, ErrEventNotDead, err)
+
+	_, err = p.RequeueEvent(ctx, e.ID+1)
+	assert.Equal(t, ErrEventNotFound, err)
+
+	_, err = db.Exec("UPDATE webhook_events SET state = ?, attempts = 3, last_error = 'boom', next_attempt_at = ? WHERE id = ?", EventStateDead, time.Now().UTC().Add(time.Hour), e.ID)
+	assert.NoError(t, err)
+
+	dead, err := p.ListEvents(ctx, EventStateDead, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{e.ID}, eventIDs(dead))
+
+	got, err := p.RequeueEvent(ctx, e.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, EventStatePending, got.State)
+	assert.Equal(t, 0, got.Attempts)
+
+	// Due right away
+	events, err := p.dueEvents(ctx, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{e.ID}, eventIDs(events))
+
+	_, err = p.ListEvents(ctx, "done", 10)
+	assert.Equal(t, ErrInvalidRequest, err)
+}
+
+func TestRunEventWorkers(t *testing.T) {
+	p, db := newTestQueue(t)
+
+	charges := []string{"chrg_test_a", "chrg_test_b", "chrg_test_c"}
+	for _, chargeID := range charges {
+		enqueue(t, p, webhooksim.Params{Key: "charge.create", ChargeID: chargeID})
+		enqueue(t, p, webhooksim.Params{Key: "charge.complete", ChargeID: chargeID, Status: webhooksim.StatusSuccessful})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		p.RunEventWorkers(ctx, testQueueConfig)
+		close(done)
+	}()
+
+	assert.Eventually(t, func() bool {
+		var n int
+		_ = db.QueryRow("SELECT COUNT(*) FROM webhook_events").Scan(&n)
+		return n == 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Completed after being created, in order
+	for _, chargeID := range charges {
+		var status string
+		assert.NoError(t, db.QueryRow("SELECT status FROM payments WHERE charge_id = ?", chargeID).Scan(&status))
+		assert.Equal(t, webhooksim.StatusSuccessful, status)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("workers did not stop")
+	}
+}
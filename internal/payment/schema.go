@@ -0,0 +1,188 @@
+package payment
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// migrations are applied in order and recorded in schema_migrations,
+// append new ones and never edit applied ones
+var migrations = []string{
+	`CREATE TABLE IF NOT EXISTS payments (
+		charge_id 	varchar(100) NOT NULL PRIMARY KEY,
+		source_id 	varchar(100),
+		txn_id 	varchar(100),
+		status 		varchar(20),
+		UNIQUE (charge_id)
+	)`,
+	// Intent of the payment request, compared with the charges Omise reports
+	`ALTER TABLE payments ADD COLUMN amount integer;
+	ALTER TABLE payments ADD COLUMN currency varchar(3);
+	ALTER TABLE payments ADD COLUMN livemode boolean;
+	CREATE TABLE IF NOT EXISTS payment_discrepancies (
+		id 		integer PRIMARY KEY AUTOINCREMENT,
+		charge_id 	varchar(100) NOT NULL,
+		event_id 	varchar(100) NOT NULL,
+		field 		varchar(20) NOT NULL,
+		expected 	varchar(100) NOT NULL,
+		actual 		varchar(100) NOT NULL,
+		created_at 	datetime NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS payment_discrepancies_charge_id ON payment_discrepancies (charge_id)`,
+	// Test mode charge IDs start with chrg_test_
+	`UPDATE payments SET livemode = (substr(charge_id, 1, 10) <> 'chrg_test_') WHERE livemode IS NULL;
+	CREATE INDEX IF NOT EXISTS payments_livemode ON payments (livemode)`,
+	// Merchants with their own Omise account, secrets are encrypted
+	`CREATE TABLE IF NOT EXISTS merchants (
+		id 			varchar(50) NOT NULL PRIMARY KEY,
+		name 			varchar(100) NOT NULL,
+		test_public_key 	varchar(100) NOT NULL DEFAULT '',
+		test_secret_key 	text NOT NULL DEFAULT '',
+		live_public_key 	varchar(100) NOT NULL DEFAULT '',
+		live_secret_key 	text NOT NULL DEFAULT '',
+		webhook_secret 		text NOT NULL DEFAULT '',
+		charge_limit_min 	integer NOT NULL DEFAULT 0,
+		charge_limit_max 	integer NOT NULL DEFAULT 0,
+		source_types 		text NOT NULL DEFAULT '',
+		created_at 		datetime NOT NULL,
+		updated_at 		datetime NOT NULL
+	);
+	ALTER TABLE payments ADD COLUMN merchant_id varchar(50) NOT NULL DEFAULT 'default';
+	CREATE INDEX IF NOT EXISTS payments_merchant_id ON payments (merchant_id)`,
+	// Encrypted, see encryptedColumns
+	`ALTER TABLE payments ADD COLUMN return_uri text NOT NULL DEFAULT ''`,
+	// Webhook events waiting to be processed or dead-lettered, processed events are deleted.
+	// The payload is encrypted, see encryptedColumns
+	`CREATE TABLE IF NOT EXISTS webhook_events (
+		id 			integer PRIMARY KEY AUTOINCREMENT,
+		event_id 		varchar(100) NOT NULL,
+		merchant_id 		varchar(50) NOT NULL,
+		charge_id 		varchar(100) NOT NULL DEFAULT '',
+		key 			varchar(50) NOT NULL,
+		payload 		text NOT NULL,
+		state 			varchar(20) NOT NULL,
+		attempts 		integer NOT NULL DEFAULT 0,
+		last_error 		text NOT NULL DEFAULT '',
+		next_attempt_at 	datetime NOT NULL,
+		created_at 		datetime NOT NULL,
+		updated_at 		datetime NOT NULL,
+		UNIQUE (merchant_id, event_id)
+	);
+	CREATE INDEX IF NOT EXISTS webhook_events_state ON webhook_events (state, next_attempt_at);
+	CREATE INDEX IF NOT EXISTS webhook_events_charge_id ON webhook_events (charge_id)`,
+	// Card of card payments, only what can be shown to the customer
+	`ALTER TABLE payments ADD COLUMN card_brand varchar(20) NOT NULL DEFAULT '';
+	ALTER TABLE payments ADD COLUMN card_last_digits varchar(4) NOT NULL DEFAULT ''`,
+	// Authorize-only charges, captured or reversed before the authorization expires
+	`ALTER TABLE payments ADD COLUMN captured_amount integer NOT NULL DEFAULT 0;
+	ALTER TABLE payments ADD COLUMN authorization_expires_at datetime;
+	ALTER TABLE payments ADD COLUMN expiry_flagged_at datetime;
+	CREATE INDEX IF NOT EXISTS payments_authorization_expires_at ON payments (status, authorization_expires_at)`,
+	// Omise customers of the merchants' users, by mode as each mode is its own Omise account
+	`CREATE TABLE IF NOT EXISTS customers (
+		merchant_id 		varchar(50) NOT NULL,
+		livemode 		boolean NOT NULL,
+		user_id 		varchar(100) NOT NULL,
+		omise_customer_id 	varchar(100) NOT NULL,
+		created_at 		datetime NOT NULL,
+		PRIMARY KEY (merchant_id, livemode, user_id),
+		UNIQUE (omise_customer_id)
+	);
+	ALTER TABLE payments ADD COLUMN customer_id varchar(100) NOT NULL DEFAULT ''`,
+	// Subscriptions charged by Omise schedules, a paused subscription has no schedule
+	`CREATE TABLE IF NOT EXISTS subscriptions (
+		id 			integer PRIMARY KEY AUTOINCREMENT,
+		merchant_id 		varchar(50) NOT NULL,
+		livemode 		boolean NOT NULL,
+		customer_id 		varchar(100) NOT NULL,
+		schedule_id 		varchar(100) NOT NULL DEFAULT '',
+		period 			varchar(20) NOT NULL,
+		amount 			integer NOT NULL,
+		currency 		varchar(3) NOT NULL,
+		description 		varchar(255) NOT NULL DEFAULT '',
+		start_date 		varchar(10) NOT NULL,
+		end_date 		varchar(10) NOT NULL,
+		status 			varchar(20) NOT NULL,
+		created_at 		datetime NOT NULL,
+		updated_at 		datetime NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS subscriptions_schedule_id ON subscriptions (schedule_id);
+	ALTER TABLE payments ADD COLUMN subscription_id integer;
+	CREATE INDEX IF NOT EXISTS payments_subscription_id ON payments (subscription_id)`,
+	// Payment links, their charges are linked from webhook events
+	`CREATE TABLE IF NOT EXISTS payment_links (
+		link_id 		varchar(100) PRIMARY KEY,
+		merchant_id 		varchar(50) NOT NULL,
+		livemode 		boolean NOT NULL,
+		amount 			integer NOT NULL,
+		currency 		varchar(3) NOT NULL,
+		title 			varchar(255) NOT NULL,
+		description 		varchar(255) NOT NULL,
+		multiple 		boolean NOT NULL,
+		payment_uri 		varchar(255) NOT NULL,
+		used 			boolean NOT NULL DEFAULT false,
+		created_at 		datetime NOT NULL
+	);
+	ALTER TABLE payments ADD COLUMN link_id varchar(100) NOT NULL DEFAULT '';
+	CREATE INDEX IF NOT EXISTS payments_link_id ON payments (link_id)`,
+	// Number of monthly payments of installment payments
+	`ALTER TABLE payments ADD COLUMN installment_term integer NOT NULL DEFAULT 0`,
+	// Why Omise failed the charge, e.g. insufficient wallet balance
+	`ALTER TABLE payments ADD COLUMN failure_code varchar(100) NOT NULL DEFAULT '';
+	ALTER TABLE payments ADD COLUMN failure_message varchar(255) NOT NULL DEFAULT ''`,
+	// References of bill payments, paid at the counter until they expire
+	`ALTER TABLE payments ADD COLUMN bill_reference_1 varchar(50) NOT NULL DEFAULT '';
+	ALTER TABLE payments ADD COLUMN bill_reference_2 varchar(50) NOT NULL DEFAULT '';
+	ALTER TABLE payments ADD COLUMN barcode varchar(255) NOT NULL DEFAULT '';
+	ALTER TABLE payments ADD COLUMN expires_at datetime;
+	CREATE INDEX IF NOT EXISTS payments_expires_at ON payments (status, expires_at)`,
+	// Status Omise last reported for payments in review, applied when the review is resolved
+	`ALTER TABLE payments ADD COLUMN reported_status varchar(20) NOT NULL DEFAULT '';
+	ALTER TABLE payment_discrepancies ADD COLUMN resolved_at datetime`,
+	// API keys of the merchants, only their SHA-256 is stored
+	`CREATE TABLE IF NOT EXISTS api_keys (
+		key_hash 		varchar(64) NOT NULL PRIMARY KEY,
+		merchant_id 		varchar(50) NOT NULL,
+		livemode 		boolean NOT NULL,
+		created_at 		datetime NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS api_keys_merchant_id ON api_keys (merchant_id)`,
+}
+
+// Migrate brings the database schema up to date
+func Migrate(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (version integer NOT NULL PRIMARY KEY)`)
+	if err != nil {
+		return err
+	}
+
+	var version int
+	err = db.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version)
+	if err != nil {
+		return err
+	}
+
+	for i := version; i < len(migrations); i++ {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, migrations[i]); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+
+		if _, err := tx.ExecContext(ctx, "INSERT INTO schema_migrations (version) VALUES (?)", i+1); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
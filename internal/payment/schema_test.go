@@ -0,0 +1,32 @@
+package payment
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	defer db.Close()
+
+	// Applying twice is a no-op
+	assert.NoError(t, Migrate(ctx, db))
+	assert.NoError(t, Migrate(ctx, db))
+
+	var version int
+	assert.NoError(t, db.QueryRow("SELECT MAX(version) FROM schema_migrations").Scan(&version))
+	assert.Equal(t, len(migrations), version)
+
+	_, err = db.Exec("INSERT INTO payments (charge_id, source_id, txn_id, status) VALUES ('chrg_test_xxx', '', '', 'pending')")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,57 @@
+package payment
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// signatureTolerance bounds the age of a signed webhook, older deliveries are replays
+const signatureTolerance = 5 * time.Minute
+
+// VerifyEvent checks that the webhook payload was signed by the Omise account of the request's merchant.
+// signature is the Omise-Signature header, a comma separated list while the secret is rotated,
+// timestamp is the Omise-Signature-Timestamp header in Unix seconds
+func (p Payment) VerifyEvent(ctx context.Context, payload []byte, signature string, timestamp string) error {
+	m, err := p.merchants.Get(ctx, MerchantFromContext(ctx))
+	if err != nil {
+		return err
+	}
+
+	// Without a secret nothing proves the webhook comes from Omise
+	if m.WebhookSecret == "" {
+		return ErrWebhookSecretNotSet
+	}
+
+	secret, err := base64.StdEncoding.DecodeString(m.WebhookSecret)
+	if err != nil {
+		return ErrInternal.Wrap(err)
+	}
+
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if age := time.Since(time.Unix(ts, 0)); age > signatureTolerance || age < -signatureTolerance {
+		return ErrInvalidSignature
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	expected := mac.Sum(nil)
+
+	for _, s := range strings.Split(signature, ",") {
+		actual, err := hex.DecodeString(strings.TrimSpace(s))
+		if err == nil && hmac.Equal(expected, actual) {
+			return nil
+		}
+	}
+
+	return ErrInvalidSignature
+}
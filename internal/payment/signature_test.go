@@ -0,0 +1,106 @@
+package payment
+
+import (
+	"context"
+	"exam-payment-service/internal/webhooksim"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestVerifyEvent(t *testing.T) {
+	s, db := newTestMerchantStore(t)
+	ctx := context.Background()
+
+	// c2VjcmV0 is "secret"
+	assert.NoError(t, s.Put(ctx, Merchant{ID: "signed", Name: "Signed", WebhookSecret: "c2VjcmV0"}))
+	assert.NoError(t, s.Put(ctx, Merchant{ID: "unsigned", Name: "Unsigned"}))
+
+	p := New(s, nil, nil, db, zap.NewNop().Sugar())
+
+	payload := []byte(`{"object":"event"}`)
+	now := time.Now()
+	ts := strconv.FormatInt(now.Unix(), 10)
+	signature := webhooksim.Sign([]byte("secret"), now, payload)
+
+	testCases := []struct {
+		name          string
+		merchantID    string
+		payload       []byte
+		signature     string
+		timestamp     string
+		expectedError error
+	}{
+		{
+			name:       "Signed",
+			merchantID: "signed",
+			payload:    payload,
+			signature:  signature,
+			timestamp:  ts,
+		},
+		{
+			name:       "One of the signatures while rotating the secret",
+			merchantID: "signed",
+			payload:    payload,
+			signature:  webhooksim.Sign([]byte("old"), now, payload) + "," + signature,
+			timestamp:  ts,
+		},
+		{
+			name:          "Unsigned",
+			merchantID:    "signed",
+			payload:       payload,
+			expectedError: ErrInvalidSignature,
+		},
+		{
+			name:          "Altered payload",
+			merchantID:    "signed",
+			payload:       []byte(`{"object":"event","livemode":true}`),
+			signature:     signature,
+			timestamp:     ts,
+			expectedError: ErrInvalidSignature,
+		},
+		{
+			name:          "Signed by another account",
+			merchantID:    "signed",
+			payload:       payload,
+			signature:     webhooksim.Sign([]byte("other"), now, payload),
+			timestamp:     ts,
+			expectedError: ErrInvalidSignature,
+		},
+		{
+			name:          "Replayed",
+			merchantID:    "signed",
+			payload:       payload,
+			signature:     webhooksim.Sign([]byte("secret"), now.Add(-time.Hour), payload),
+			timestamp:     strconv.FormatInt(now.Add(-time.Hour).Unix(), 10),
+			expectedError: ErrInvalidSignature,
+		},
+		{
+			name:          "Merchant without webhook secret",
+			merchantID:    "unsigned",
+			payload:       payload,
+			signature:     signature,
+			timestamp:     ts,
+			expectedError: ErrWebhookSecretNotSet,
+		},
+		{
+			name:          "Unknown merchant",
+			merchantID:    "unknown",
+			payload:       payload,
+			signature:     signature,
+			timestamp:     ts,
+			expectedError: ErrMerchantNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := p.VerifyEvent(ContextWithMerchant(ctx, tc.merchantID), tc.payload, tc.signature, tc.timestamp)
+
+			assert.Equal(t, tc.expectedError, err)
+		})
+	}
+}
@@ -0,0 +1,424 @@
+package payment
+
+import (
+	"context"
+	"database/sql"
+	"exam-payment-service/pkg/logger"
+	"strings"
+	"time"
+
+	"github.com/omise/omise-go/operations"
+	"github.com/omise/omise-go/schedule"
+)
+
+// Statuses of a subscription, expired and suspended are set by Omise through schedule events
+const (
+	SubscriptionActive    = "active"
+	SubscriptionPaused    = "paused"
+	SubscriptionCanceled  = "canceled"
+	SubscriptionExpired   = "expired"
+	SubscriptionSuspended = "suspended"
+)
+
+// SubscriptionPeriod is how often a subscription is charged
+type SubscriptionPeriod string
+
+var (
+	SubscriptionDaily   SubscriptionPeriod = "daily"
+	SubscriptionWeekly  SubscriptionPeriod = "weekly"
+	SubscriptionMonthly SubscriptionPeriod = "monthly"
+)
+
+func (s SubscriptionPeriod) schedule() (schedule.Period, bool) {
+	switch s {
+	case SubscriptionDaily:
+		return schedule.PeriodDay, true
+	case SubscriptionWeekly:
+		return schedule.PeriodWeek, true
+	case SubscriptionMonthly:
+		return schedule.PeriodMonth, true
+	default:
+		return "", false
+	}
+}
+
+// dateLayout is the layout of the start and end dates of subscriptions, as Omise takes them
+const dateLayout = "2006-01-02"
+
+// scheduleEventStatuses are the subscription statuses set by schedule events, other
+// schedule events change nothing
+var scheduleEventStatuses = map[string]string{
+	"schedule.expire":  SubscriptionExpired,
+	"schedule.suspend": SubscriptionSuspended,
+	"schedule.destroy": SubscriptionCanceled,
+}
+
+// SubscriptionRequest charges the default card of a customer every period from StartDate,
+// today without it, until EndDate
+type SubscriptionRequest struct {
+	CustomerID  string             `json:"customerId"`
+	Amount      int64              `json:"amount"`
+	Currency    Currency           `json:"currency"`
+	Period      SubscriptionPeriod `json:"period"`
+	StartDate   string             `json:"startDate"`
+	EndDate     string             `json:"endDate"`
+	Description string             `json:"description"`
+}
+
+// Subscription is a subscription as stored, ScheduleID is empty while it is paused.
+// Weekly subscriptions are charged on the weekday of StartDate, monthly ones on its day,
+// the 28th at the latest
+type Subscription struct {
+	ID          int64              `json:"id"`
+	CustomerID  string             `json:"customerId"`
+	ScheduleID  string             `json:"scheduleId"`
+	Amount      int64              `json:"amount"`
+	Currency    string             `json:"currency"`
+	Period      SubscriptionPeriod `json:"period"`
+	StartDate   string             `json:"startDate"`
+	EndDate     string             `json:"endDate"`
+	Description string             `json:"description"`
+	Status      string             `json:"status"`
+	Livemode    bool               `json:"livemode"`
+	// Charges made by the subscription's schedules, oldest first
+	ChargeIDs []string  `json:"chargeIds"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// createSchedule returns the schedule charging the subscription from start
+func (s Subscription) createSchedule(start string) operations.CreateChargeSchedule {
+	period, _ := s.Period.schedule()
+	op := operations.CreateChargeSchedule{
+		Every:       1,
+		Period:      period,
+		StartDate:   start,
+		EndDate:     s.EndDate,
+		Customer:    s.CustomerID,
+		Amount:      int(s.Amount),
+		Currency:    s.Currency,
+		Description: s.Description,
+	}
+
+	// Omise needs the day to charge on, resumed schedules keep the day of the first one
+	first, _ := time.Parse(dateLayout, s.StartDate)
+	switch s.Period {
+	case SubscriptionWeekly:
+		op.Weekdays = schedule.Weekdays{schedule.Weekday(strings.ToLower(first.Weekday().String()))}
+	case SubscriptionMonthly:
+		day := first.Day()
+		if day > 28 {
+			day = 28
+		}
+		op.DaysOfMonth = schedule.DaysOfMonth{day}
+	}
+
+	return op
+}
+
+// CreateSubscription creates the Omise schedule of a subscription of the context's merchant
+func (p Payment) CreateSubscription(ctx context.Context, sr SubscriptionRequest) (Subscription, error) {
+	m, err := p.merchants.Get(ctx, MerchantFromContext(ctx))
+	if err != nil {
+		return Subscription{}, err
+	}
+
+	// Validation
+	min, max := m.chargeLimits(sr.Currency)
+	if min > 0 && sr.Amount < min {
+		return Subscription{}, ErrAmountLowerThanChargeLimit
+	}
+
+	if max > 0 && sr.Amount > max {
+		return Subscription{}, ErrChargeLimitExceeded
+	}
+
+	if !sr.Currency.Validate() {
+		return Subscription{}, ErrInvalidCurrency
+	}
+
+	if sr.CustomerID == "" {
+		return Subscription{}, ErrInvalidRequest
+	}
+
+	today := time.Now().UTC().Format(dateLayout)
+	if sr.StartDate == "" {
+		sr.StartDate = today
+	}
+	if _, ok := sr.Period.schedule(); !ok || !validDates(today, sr.StartDate, sr.EndDate) {
+		return Subscription{}, ErrInvalidSchedule
+	}
+
+	// Subscriptions charge the customer's default card
+	if !m.sourceTypeEnabled(SourceTypeCard) {
+		return Subscription{}, ErrSourceTypeNotEnabled
+	}
+
+	exists, err := p.customerExists(ctx, sr.CustomerID)
+	if err != nil {
+		return Subscription{}, ErrInternal.Wrap(err)
+	}
+	if !exists {
+		return Subscription{}, ErrCustomerNotFound
+	}
+
+	oc, err := p.provider(ctx)
+	if err != nil {
+		return Subscription{}, err
+	}
+
+	s := Subscription{
+		CustomerID:  sr.CustomerID,
+		Amount:      sr.Amount,
+		Currency:    string(sr.Currency),
+		Period:      sr.Period,
+		StartDate:   sr.StartDate,
+		EndDate:     sr.EndDate,
+		Description: sr.Description,
+	}
+	sched, err := oc.CreateChargeSchedule(ctx, s.createSchedule(s.StartDate))
+	if err != nil {
+		return Subscription{}, wrapOmiseError(err)
+	}
+
+	now := time.Now().UTC()
+	res, err := p.db.ExecContext(
+		ctx,
+		`INSERT INTO subscriptions (merchant_id, livemode, customer_id, schedule_id, period, amount, currency, description, start_date, end_date, status, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		m.ID, LivemodeFromContext(ctx), s.CustomerID, sched.ID, string(s.Period), s.Amount, s.Currency, s.Description, s.StartDate, s.EndDate, SubscriptionActive, now, now,
+	)
+	if err != nil {
+		logger.For(ctx, p.log).Errorw("CreateSubscription error", "error", SafeError(err), "schedule_id", sched.ID)
+		return Subscription{}, ErrInternal.Wrap(err)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return Subscription{}, ErrInternal.Wrap(err)
+	}
+
+	logger.For(ctx, p.log).Infow("Subscription created", "subscription_id", id, "schedule_id", sched.ID, "customer_id", s.CustomerID)
+
+	return p.GetSubscription(ctx, id)
+}
+
+// validDates reports whether a subscription from start until end can be scheduled today
+func validDates(today, start, end string) bool {
+	s, err := time.Parse(dateLayout, start)
+	if err != nil {
+		return false
+	}
+	e, err := time.Parse(dateLayout, end)
+	if err != nil {
+		return false
+	}
+
+	// The layout sorts as it reads
+	return start >= today && e.After(s)
+}
+
+// GetSubscription returns a subscription of the context's merchant and mode with its charges
+func (p Payment) GetSubscription(ctx context.Context, id int64) (Subscription, error) {
+	s := Subscription{ID: id, Livemode: LivemodeFromContext(ctx), ChargeIDs: []string{}}
+	err := p.db.QueryRowContext(
+		ctx,
+		`SELECT customer_id, schedule_id, period, amount, currency, description, start_date, end_date, status, created_at, updated_at
+		FROM subscriptions WHERE id = ? AND merchant_id = ? AND livemode = ?`,
+		id, MerchantFromContext(ctx), s.Livemode,
+	).Scan(&s.CustomerID, &s.ScheduleID, &s.Period, &s.Amount, &s.Currency, &s.Description, &s.StartDate, &s.EndDate, &s.Status, &s.CreatedAt, &s.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return Subscription{}, ErrSubscriptionNotFound
+	}
+	if err != nil {
+		return Subscription{}, ErrInternal.Wrap(err)
+	}
+
+	rows, err := p.db.QueryContext(ctx, "SELECT charge_id FROM payments WHERE subscription_id = ? ORDER BY rowid", id)
+	if err != nil {
+		return Subscription{}, ErrInternal.Wrap(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var chargeID string
+		if err := rows.Scan(&chargeID); err != nil {
+			return Subscription{}, ErrInternal.Wrap(err)
+		}
+		s.ChargeIDs = append(s.ChargeIDs, chargeID)
+	}
+	if err := rows.Err(); err != nil {
+		return Subscription{}, ErrInternal.Wrap(err)
+	}
+
+	return s, nil
+}
+
+// PauseSubscription stops charging an active subscription of the context's merchant. Omise
+// schedules cannot be paused, the schedule is destroyed and a new one is created on resume
+func (p Payment) PauseSubscription(ctx context.Context, id int64) (Subscription, error) {
+	return p.endSchedule(ctx, id, SubscriptionPaused, SubscriptionActive)
+}
+
+// CancelSubscription stops charging a subscription of the context's merchant for good
+func (p Payment) CancelSubscription(ctx context.Context, id int64) (Subscription, error) {
+	return p.endSchedule(ctx, id, SubscriptionCanceled, SubscriptionActive, SubscriptionPaused, SubscriptionSuspended)
+}
+
+// endSchedule destroys the schedule of a subscription in one of the from statuses and sets status
+func (p Payment) endSchedule(ctx context.Context, id int64, status string, from ...string) (Subscription, error) {
+	s, err := p.GetSubscription(ctx, id)
+	if err != nil {
+		return Subscription{}, err
+	}
+
+	if !hasStatus(s.Status, from) {
+		return Subscription{}, ErrInvalidSubscriptionStatus
+	}
+
+	// Paused subscriptions have no schedule left
+	if s.ScheduleID != "" {
+		oc, err := p.provider(ctx)
+		if err != nil {
+			return Subscription{}, err
+		}
+
+		if _, err := oc.DestroySchedule(ctx, operations.DestroySchedule{ScheduleID: s.ScheduleID}); err != nil {
+			return Subscription{}, wrapOmiseError(err)
+		}
+	}
+
+	updated, err := p.setSubscriptionSchedule(ctx, id, "", status, from...)
+	if err != nil {
+		logger.For(ctx, p.log).Errorw("Subscription update error", "error", SafeError(err), "subscription_id", id, "schedule_id", s.ScheduleID)
+		return Subscription{}, ErrInternal.Wrap(err)
+	}
+	// Changed by a concurrent request in the meantime, which keeps its status
+	if !updated {
+		return Subscription{}, ErrInvalidSubscriptionStatus
+	}
+
+	logger.For(ctx, p.log).Infow("Subscription schedule destroyed", "subscription_id", id, "schedule_id", s.ScheduleID, "status", status)
+
+	return p.GetSubscription(ctx, id)
+}
+
+// ResumeSubscription creates a new schedule for a paused subscription of the context's merchant,
+// charging from tomorrow so a charge of today is not made twice
+func (p Payment) ResumeSubscription(ctx context.Context, id int64) (Subscription, error) {
+	s, err := p.GetSubscription(ctx, id)
+	if err != nil {
+		return Subscription{}, err
+	}
+
+	if s.Status != SubscriptionPaused {
+		return Subscription{}, ErrInvalidSubscriptionStatus
+	}
+
+	start := time.Now().UTC().AddDate(0, 0, 1).Format(dateLayout)
+	if s.StartDate > start {
+		start = s.StartDate
+	}
+	if start >= s.EndDate {
+		return Subscription{}, ErrInvalidSchedule
+	}
+
+	oc, err := p.provider(ctx)
+	if err != nil {
+		return Subscription{}, err
+	}
+
+	sched, err := oc.CreateChargeSchedule(ctx, s.createSchedule(start))
+	if err != nil {
+		return Subscription{}, wrapOmiseError(err)
+	}
+
+	updated, err := p.setSubscriptionSchedule(ctx, id, sched.ID, SubscriptionActive, SubscriptionPaused)
+	if err != nil {
+		logger.For(ctx, p.log).Errorw("Subscription update error", "error", SafeError(err), "subscription_id", id, "schedule_id", sched.ID)
+		return Subscription{}, ErrInternal.Wrap(err)
+	}
+	// Resumed or canceled by a concurrent request, the new schedule would charge the customer again
+	if !updated {
+		if _, err := oc.DestroySchedule(ctx, operations.DestroySchedule{ScheduleID: sched.ID}); err != nil {
+			logger.For(ctx, p.log).Errorw("DestroySchedule error", "error", SafeError(err), "subscription_id", id, "schedule_id", sched.ID)
+		}
+		return Subscription{}, ErrInvalidSubscriptionStatus
+	}
+
+	logger.For(ctx, p.log).Infow("Subscription resumed", "subscription_id", id, "schedule_id", sched.ID)
+
+	return p.GetSubscription(ctx, id)
+}
+
+// setSubscriptionSchedule sets the schedule and status of a subscription still in one of the from
+// statuses, it reports whether the subscription was updated
+func (p Payment) setSubscriptionSchedule(ctx context.Context, id int64, scheduleID string, status string, from ...string) (bool, error) {
+	args := []interface{}{scheduleID, status, time.Now().UTC(), id}
+	placeholders := ""
+	for i, s := range from {
+		if i > 0 {
+			placeholders += ", "
+		}
+		placeholders += "?"
+		args = append(args, s)
+	}
+
+	res, err := p.db.ExecContext(
+		ctx,
+		"UPDATE subscriptions SET schedule_id = ?, status = ?, updated_at = ? WHERE id = ? AND status IN ("+placeholders+")",
+		args...,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+
+	return n > 0, err
+}
+
+func hasStatus(status string, statuses []string) bool {
+	for _, s := range statuses {
+		if s == status {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hookScheduleEvent sets the status of the active subscription of an event's schedule, schedules
+// destroyed by pausing or cancelling have no active subscription left. It reports whether a
+// subscription changed
+func (p Payment) hookScheduleEvent(ctx context.Context, event PaymentEvent) (bool, error) {
+	status, ok := scheduleEventStatuses[event.Key]
+	if !ok {
+		return false, nil
+	}
+
+	res, err := p.db.ExecContext(
+		ctx,
+		"UPDATE subscriptions SET status = ?, updated_at = ? WHERE schedule_id = ? AND merchant_id = ? AND livemode = ? AND status = ?",
+		status, time.Now().UTC(), event.Data.ID, MerchantFromContext(ctx), event.Livemode, SubscriptionActive,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+
+	return n > 0, err
+}
+
+// linkSubscription links a charge made by a schedule to its subscription of the same merchant and mode, once
+func (p Payment) linkSubscription(ctx context.Context, chargeID string, scheduleID string, livemode bool) error {
+	_, err := p.db.ExecContext(
+		ctx,
+		`UPDATE payments SET subscription_id = (SELECT id FROM subscriptions WHERE schedule_id = ? AND merchant_id = ? AND livemode = ?)
+		WHERE charge_id = ? AND subscription_id IS NULL`,
+		scheduleID, MerchantFromContext(ctx), livemode, chargeID,
+	)
+
+	return err
+}
@@ -0,0 +1,298 @@
+package payment
+
+import (
+	"context"
+	"database/sql"
+	mockOmiseProvider "exam-payment-service/internal/payment/mocks/omiseprovider"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/omise/omise-go"
+	"github.com/omise/omise-go/operations"
+	"github.com/omise/omise-go/schedule"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestSubscription also adds the customer cust_test_a of the default merchant
+func newTestSubscription(t *testing.T) (*Payment, *sql.DB, *mockOmiseProvider.MockOmiseProvider) {
+	p, db, op, _ := newTestMockedPayment(t)
+
+	_, err := db.Exec(
+		"INSERT INTO customers (merchant_id, livemode, user_id, omise_customer_id, created_at) VALUES (?, false, 'user_1', 'cust_test_a', ?)",
+		DefaultMerchantID, time.Now().UTC(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return p, db, op
+}
+
+func TestCreateSubscription(t *testing.T) {
+	ctx := context.Background()
+	p, _, op := newTestSubscription(t)
+
+	// Charged on the 28th in shorter months
+	op.EXPECT().CreateChargeSchedule(gomock.Any(), operations.CreateChargeSchedule{
+		Every:       1,
+		Period:      schedule.PeriodMonth,
+		StartDate:   "2030-01-31",
+		EndDate:     "2031-01-31",
+		DaysOfMonth: schedule.DaysOfMonth{28},
+		Customer:    "cust_test_a",
+		Amount:      49900,
+		Currency:    "thb",
+		Description: "Premium",
+	}).Return(omise.Schedule{Base: omise.Base{ID: "schd_test_a"}}, nil)
+
+	s, err := p.CreateSubscription(ctx, SubscriptionRequest{
+		CustomerID:  "cust_test_a",
+		Amount:      49900,
+		Currency:    CurrencyTHB,
+		Period:      SubscriptionMonthly,
+		StartDate:   "2030-01-31",
+		EndDate:     "2031-01-31",
+		Description: "Premium",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "schd_test_a", s.ScheduleID)
+	assert.Equal(t, SubscriptionActive, s.Status)
+	assert.Equal(t, []string{}, s.ChargeIDs)
+
+	valid := SubscriptionRequest{CustomerID: "cust_test_a", Amount: 49900, Currency: CurrencyTHB, Period: SubscriptionDaily, StartDate: "2030-01-01", EndDate: "2030-02-01"}
+	with := func(f func(sr *SubscriptionRequest)) SubscriptionRequest {
+		sr := valid
+		f(&sr)
+		return sr
+	}
+
+	testCases := []struct {
+		name          string
+		request       SubscriptionRequest
+		expectedError error
+	}{
+		{
+			name:          "Unknown period",
+			request:       with(func(sr *SubscriptionRequest) { sr.Period = "yearly" }),
+			expectedError: ErrInvalidSchedule,
+		},
+		{
+			name:          "Without end date",
+			request:       with(func(sr *SubscriptionRequest) { sr.EndDate = "" }),
+			expectedError: ErrInvalidSchedule,
+		},
+		{
+			name:          "End before start",
+			request:       with(func(sr *SubscriptionRequest) { sr.EndDate = "2029-12-31" }),
+			expectedError: ErrInvalidSchedule,
+		},
+		{
+			name:          "Start in the past",
+			request:       with(func(sr *SubscriptionRequest) { sr.StartDate = "2020-01-01" }),
+			expectedError: ErrInvalidSchedule,
+		},
+		{
+			name:          "Invalid currency",
+			request:       with(func(sr *SubscriptionRequest) { sr.Currency = "usd" }),
+			expectedError: ErrInvalidCurrency,
+		},
+		{
+			name:          "Lower than charge limit",
+			request:       with(func(sr *SubscriptionRequest) { sr.Amount = 1 }),
+			expectedError: ErrAmountLowerThanChargeLimit,
+		},
+		{
+			name:          "Unknown customer",
+			request:       with(func(sr *SubscriptionRequest) { sr.CustomerID = "cust_test_unknown" }),
+			expectedError: ErrCustomerNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := p.CreateSubscription(ctx, tc.request)
+
+			assert.Equal(t, tc.expectedError, err)
+		})
+	}
+}
+
+func TestSubscriptionLifecycle(t *testing.T) {
+	ctx := context.Background()
+	p, _, op := newTestSubscription(t)
+
+	// 2030-01-07 is a Monday, resumed schedules are charged on Mondays as well
+	weekly := operations.CreateChargeSchedule{
+		Every:     1,
+		Period:    schedule.PeriodWeek,
+		StartDate: "2030-01-07",
+		EndDate:   "2030-06-30",
+		Weekdays:  schedule.Weekdays{schedule.Monday},
+		Customer:  "cust_test_a",
+		Amount:    9900,
+		Currency:  "thb",
+	}
+	gomock.InOrder(
+		op.EXPECT().CreateChargeSchedule(gomock.Any(), weekly).Return(omise.Schedule{Base: omise.Base{ID: "schd_test_a"}}, nil),
+		op.EXPECT().DestroySchedule(gomock.Any(), operations.DestroySchedule{ScheduleID: "schd_test_a"}).Return(omise.Schedule{}, nil),
+		op.EXPECT().CreateChargeSchedule(gomock.Any(), weekly).Return(omise.Schedule{Base: omise.Base{ID: "schd_test_b"}}, nil),
+		op.EXPECT().DestroySchedule(gomock.Any(), operations.DestroySchedule{ScheduleID: "schd_test_b"}).Return(omise.Schedule{}, nil),
+	)
+
+	s, err := p.CreateSubscription(ctx, SubscriptionRequest{
+		CustomerID: "cust_test_a",
+		Amount:     9900,
+		Currency:   CurrencyTHB,
+		Period:     SubscriptionWeekly,
+		StartDate:  "2030-01-07",
+		EndDate:    "2030-06-30",
+	})
+	assert.NoError(t, err)
+
+	s, err = p.PauseSubscription(ctx, s.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, SubscriptionPaused, s.Status)
+	assert.Equal(t, "", s.ScheduleID)
+
+	_, err = p.PauseSubscription(ctx, s.ID)
+	assert.Equal(t, ErrInvalidSubscriptionStatus, err)
+
+	s, err = p.ResumeSubscription(ctx, s.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, SubscriptionActive, s.Status)
+	assert.Equal(t, "schd_test_b", s.ScheduleID)
+
+	s, err = p.CancelSubscription(ctx, s.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, SubscriptionCanceled, s.Status)
+
+	_, err = p.ResumeSubscription(ctx, s.ID)
+	assert.Equal(t, ErrInvalidSubscriptionStatus, err)
+
+	_, err = p.CancelSubscription(ctx, s.ID)
+	assert.Equal(t, ErrInvalidSubscriptionStatus, err)
+
+	// Subscriptions of other modes are not found
+	_, err = p.GetSubscription(ContextWithLivemode(ctx, true), s.ID)
+	assert.Equal(t, ErrSubscriptionNotFound, err)
+}
+
+func TestSubscriptionConcurrentChange(t *testing.T) {
+	ctx := context.Background()
+	p, db, op := newTestSubscription(t)
+
+	now := time.Now().UTC()
+	res, err := db.Exec(
+		`INSERT INTO subscriptions (merchant_id, livemode, customer_id, schedule_id, period, amount, currency, start_date, end_date, status, created_at, updated_at)
+		VALUES (?, false, 'cust_test_a', 'schd_test_a', 'daily', 9900, 'thb', '2030-01-01', '2030-02-01', ?, ?, ?)`,
+		DefaultMerchantID, SubscriptionActive, now, now,
+	)
+	assert.NoError(t, err)
+	id, err := res.LastInsertId()
+	assert.NoError(t, err)
+
+	setStatus := func(status string) {
+		_, err := db.Exec("UPDATE subscriptions SET status = ?, schedule_id = '' WHERE id = ?", status, id)
+		assert.NoError(t, err)
+	}
+
+	// Canceled while the schedule of the pause is destroyed
+	op.EXPECT().DestroySchedule(gomock.Any(), operations.DestroySchedule{ScheduleID: "schd_test_a"}).
+		DoAndReturn(func(context.Context, operations.DestroySchedule) (omise.Schedule, error) {
+			setStatus(SubscriptionCanceled)
+			return omise.Schedule{}, nil
+		})
+
+	_, err = p.PauseSubscription(ctx, id)
+	assert.Equal(t, ErrInvalidSubscriptionStatus, err)
+
+	s, err := p.GetSubscription(ctx, id)
+	assert.NoError(t, err)
+	assert.Equal(t, SubscriptionCanceled, s.Status)
+
+	// Canceled while the schedule of the resume is created, the new schedule is destroyed
+	setStatus(SubscriptionPaused)
+	gomock.InOrder(
+		op.EXPECT().CreateChargeSchedule(gomock.Any(), gomock.Any()).
+			DoAndReturn(func(context.Context, operations.CreateChargeSchedule) (omise.Schedule, error) {
+				setStatus(SubscriptionCanceled)
+				return omise.Schedule{Base: omise.Base{ID: "schd_test_b"}}, nil
+			}),
+		op.EXPECT().DestroySchedule(gomock.Any(), operations.DestroySchedule{ScheduleID: "schd_test_b"}).Return(omise.Schedule{}, nil),
+	)
+
+	_, err = p.ResumeSubscription(ctx, id)
+	assert.Equal(t, ErrInvalidSubscriptionStatus, err)
+
+	s, err = p.GetSubscription(ctx, id)
+	assert.NoError(t, err)
+	assert.Equal(t, SubscriptionCanceled, s.Status)
+	assert.Equal(t, "", s.ScheduleID)
+}
+
+func TestHookScheduleEvent(t *testing.T) {
+	ctx := context.Background()
+	p, db, _ := newTestSubscription(t)
+
+	now := time.Now().UTC()
+	res, err := db.Exec(
+		`INSERT INTO subscriptions (merchant_id, livemode, customer_id, schedule_id, period, amount, currency, start_date, end_date, status, created_at, updated_at)
+		VALUES (?, false, 'cust_test_a', 'schd_test_a', 'daily', 9900, 'thb', '2030-01-01', '2030-02-01', ?, ?, ?)`,
+		DefaultMerchantID, SubscriptionActive, now, now,
+	)
+	assert.NoError(t, err)
+	id, err := res.LastInsertId()
+	assert.NoError(t, err)
+
+	scheduleID := "schd_test_a"
+	charge := PaymentEvent{ID: "evnt_test_a", Key: "charge.create"}
+	charge.Data.ID = "chrg_test_a"
+	charge.Data.Status = "successful"
+	charge.Data.Schedule = &scheduleID
+	assert.NoError(t, p.HookPaymentEvent(ctx, charge))
+
+	charge.ID, charge.Key = "evnt_test_b", "charge.complete"
+	assert.NoError(t, p.HookPaymentEvent(ctx, charge))
+
+	s, err := p.GetSubscription(ctx, id)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"chrg_test_a"}, s.ChargeIDs)
+
+	d, err := p.GetPayment(ctx, "chrg_test_a")
+	assert.NoError(t, err)
+	assert.Equal(t, id, d.SubscriptionID)
+
+	// Charges of the other mode are not linked to the subscription
+	live := charge
+	live.ID, live.Key, live.Livemode = "evnt_test_c", "charge.create", true
+	live.Data.ID = "chrg_a"
+	assert.NoError(t, p.HookPaymentEvent(ctx, live))
+
+	var subscriptionID sql.NullInt64
+	assert.NoError(t, db.QueryRow("SELECT subscription_id FROM payments WHERE charge_id = 'chrg_a'").Scan(&subscriptionID))
+	assert.False(t, subscriptionID.Valid)
+
+	testCases := []struct {
+		name           string
+		key            string
+		livemode       bool
+		expectedStatus string
+	}{
+		{"Expiring", "schedule.expiring", false, SubscriptionActive},
+		{"Other mode", "schedule.suspend", true, SubscriptionActive},
+		{"Suspended", "schedule.suspend", false, SubscriptionSuspended},
+		{"Destroyed after suspended", "schedule.destroy", false, SubscriptionSuspended},
+	}
+
+	for _, tc := range testCases {
+		event := PaymentEvent{ID: "evnt_test_" + tc.key, Key: tc.key, Livemode: tc.livemode}
+		event.Data.Object = "schedule"
+		event.Data.ID = scheduleID
+		assert.NoError(t, p.HookPaymentEvent(ctx, event), tc.name)
+
+		s, err := p.GetSubscription(ctx, id)
+		assert.NoError(t, err, tc.name)
+		assert.Equal(t, tc.expectedStatus, s.Status, tc.name)
+	}
+}
@@ -0,0 +1,237 @@
+package payment
+
+import (
+	"context"
+	"exam-payment-service/pkg/omiseprovider"
+
+	"github.com/omise/omise-go"
+	"github.com/omise/omise-go/operations"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "exam-payment-service/internal/payment"
+
+func tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// tracedProvider starts a span for each call to the wrapped provider
+type tracedProvider struct {
+	next omiseProvider
+}
+
+func (t tracedProvider) CreateSource(ctx context.Context, createSource operations.CreateSource) (omise.Source, error) {
+	ctx, span := tracer().Start(ctx, "omise.CreateSource", trace.WithAttributes(
+		attribute.String("omise.source_type", createSource.Type),
+		attribute.String("omise.currency", createSource.Currency),
+		attribute.Int64("omise.amount", createSource.Amount),
+	))
+	defer span.End()
+
+	source, err := t.next.CreateSource(ctx, createSource)
+	if err != nil {
+		recordSpanError(span, err)
+		return source, err
+	}
+
+	span.SetAttributes(attribute.String("omise.source_id", source.ID))
+
+	return source, nil
+}
+
+func (t tracedProvider) CreateCharge(ctx context.Context, createCharge omiseprovider.CreateCharge) (omise.Charge, error) {
+	ctx, span := tracer().Start(ctx, "omise.CreateCharge", trace.WithAttributes(
+		attribute.String("omise.source_id", createCharge.Source),
+		attribute.String("omise.currency", createCharge.Currency),
+		attribute.Int64("omise.amount", createCharge.Amount),
+	))
+	defer span.End()
+
+	charge, err := t.next.CreateCharge(ctx, createCharge)
+	if err != nil {
+		recordSpanError(span, err)
+		return charge, err
+	}
+
+	span.SetAttributes(attribute.String("omise.charge_id", charge.ID))
+
+	return charge, nil
+}
+
+func (t tracedProvider) RetrieveCharge(ctx context.Context, retrieveCharge operations.RetrieveCharge) (omise.Charge, error) {
+	ctx, span := tracer().Start(ctx, "omise.RetrieveCharge", trace.WithAttributes(
+		attribute.String("omise.charge_id", retrieveCharge.ChargeID),
+	))
+	defer span.End()
+
+	charge, err := t.next.RetrieveCharge(ctx, retrieveCharge)
+	if err != nil {
+		recordSpanError(span, err)
+	}
+
+	return charge, err
+}
+
+func (t tracedProvider) CreateBillPaymentCharge(ctx context.Context, createCharge operations.CreateCharge) (omiseprovider.BillPaymentCharge, error) {
+	ctx, span := tracer().Start(ctx, "omise.CreateBillPaymentCharge", trace.WithAttributes(
+		attribute.String("omise.source_id", createCharge.Source),
+		attribute.String("omise.currency", createCharge.Currency),
+		attribute.Int64("omise.amount", createCharge.Amount),
+	))
+	defer span.End()
+
+	charge, err := t.next.CreateBillPaymentCharge(ctx, createCharge)
+	if err != nil {
+		recordSpanError(span, err)
+		return charge, err
+	}
+
+	span.SetAttributes(attribute.String("omise.charge_id", charge.ID))
+
+	return charge, nil
+}
+
+func (t tracedProvider) CaptureCharge(ctx context.Context, captureCharge omiseprovider.CaptureCharge) (omise.Charge, error) {
+	ctx, span := tracer().Start(ctx, "omise.CaptureCharge", trace.WithAttributes(
+		attribute.String("omise.charge_id", captureCharge.ChargeID),
+		attribute.Int64("omise.capture_amount", captureCharge.CaptureAmount),
+	))
+	defer span.End()
+
+	charge, err := t.next.CaptureCharge(ctx, captureCharge)
+	if err != nil {
+		recordSpanError(span, err)
+	}
+
+	return charge, err
+}
+
+func (t tracedProvider) ReverseCharge(ctx context.Context, reverseCharge operations.ReverseCharge) (omise.Charge, error) {
+	ctx, span := tracer().Start(ctx, "omise.ReverseCharge", trace.WithAttributes(
+		attribute.String("omise.charge_id", reverseCharge.ChargeID),
+	))
+	defer span.End()
+
+	charge, err := t.next.ReverseCharge(ctx, reverseCharge)
+	if err != nil {
+		recordSpanError(span, err)
+	}
+
+	return charge, err
+}
+
+func (t tracedProvider) CreateCustomer(ctx context.Context, createCustomer operations.CreateCustomer) (omise.Customer, error) {
+	ctx, span := tracer().Start(ctx, "omise.CreateCustomer")
+	defer span.End()
+
+	customer, err := t.next.CreateCustomer(ctx, createCustomer)
+	if err != nil {
+		recordSpanError(span, err)
+		return customer, err
+	}
+
+	span.SetAttributes(attribute.String("omise.customer_id", customer.ID))
+
+	return customer, nil
+}
+
+func (t tracedProvider) UpdateCustomer(ctx context.Context, updateCustomer operations.UpdateCustomer) (omise.Customer, error) {
+	ctx, span := tracer().Start(ctx, "omise.UpdateCustomer", trace.WithAttributes(
+		attribute.String("omise.customer_id", updateCustomer.CustomerID),
+	))
+	defer span.End()
+
+	customer, err := t.next.UpdateCustomer(ctx, updateCustomer)
+	if err != nil {
+		recordSpanError(span, err)
+	}
+
+	return customer, err
+}
+
+func (t tracedProvider) RetrieveCustomer(ctx context.Context, retrieveCustomer operations.RetrieveCustomer) (omise.Customer, error) {
+	ctx, span := tracer().Start(ctx, "omise.RetrieveCustomer", trace.WithAttributes(
+		attribute.String("omise.customer_id", retrieveCustomer.CustomerID),
+	))
+	defer span.End()
+
+	customer, err := t.next.RetrieveCustomer(ctx, retrieveCustomer)
+	if err != nil {
+		recordSpanError(span, err)
+	}
+
+	return customer, err
+}
+
+func (t tracedProvider) CreateChargeSchedule(ctx context.Context, createSchedule operations.CreateChargeSchedule) (omise.Schedule, error) {
+	ctx, span := tracer().Start(ctx, "omise.CreateChargeSchedule", trace.WithAttributes(
+		attribute.String("omise.customer_id", createSchedule.Customer),
+		attribute.String("omise.period", string(createSchedule.Period)),
+		attribute.String("omise.currency", createSchedule.Currency),
+		attribute.Int("omise.amount", createSchedule.Amount),
+	))
+	defer span.End()
+
+	schedule, err := t.next.CreateChargeSchedule(ctx, createSchedule)
+	if err != nil {
+		recordSpanError(span, err)
+		return schedule, err
+	}
+
+	span.SetAttributes(attribute.String("omise.schedule_id", schedule.ID))
+
+	return schedule, nil
+}
+
+func (t tracedProvider) DestroySchedule(ctx context.Context, destroySchedule operations.DestroySchedule) (omise.Schedule, error) {
+	ctx, span := tracer().Start(ctx, "omise.DestroySchedule", trace.WithAttributes(
+		attribute.String("omise.schedule_id", destroySchedule.ScheduleID),
+	))
+	defer span.End()
+
+	schedule, err := t.next.DestroySchedule(ctx, destroySchedule)
+	if err != nil {
+		recordSpanError(span, err)
+	}
+
+	return schedule, err
+}
+
+func (t tracedProvider) CreateLink(ctx context.Context, createLink operations.CreateLink) (omise.Link, error) {
+	ctx, span := tracer().Start(ctx, "omise.CreateLink", trace.WithAttributes(
+		attribute.Int64("omise.amount", createLink.Amount),
+		attribute.String("omise.currency", createLink.Currency),
+		attribute.Bool("omise.multiple", createLink.Multiple),
+	))
+	defer span.End()
+
+	link, err := t.next.CreateLink(ctx, createLink)
+	if err != nil {
+		recordSpanError(span, err)
+		return link, err
+	}
+
+	span.SetAttributes(attribute.String("omise.link_id", link.ID))
+
+	return link, nil
+}
+
+func (t tracedProvider) RetrieveCapability(ctx context.Context, retrieveCapability operations.RetrieveCapability) (omise.Capability, error) {
+	ctx, span := tracer().Start(ctx, "omise.RetrieveCapability")
+	defer span.End()
+
+	capability, err := t.next.RetrieveCapability(ctx, retrieveCapability)
+	if err != nil {
+		recordSpanError(span, err)
+	}
+
+	return capability, err
+}
+
+func recordSpanError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, errorLabel(err))
+}
@@ -0,0 +1,58 @@
+package payment
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Wallets redirect the customer to AuthorizeURI like internet banking, TrueMoney also needs the phone number
+// of the wallet
+var (
+	SourceTypeTrueMoney     SourceType = "truemoney"
+	SourceTypeRabbitLINEPay SourceType = "rabbit_linepay"
+	SourceTypeShopeePay     SourceType = "shopeepay"
+	SourceTypeAlipay        SourceType = "alipay"
+)
+
+// thaiMobile matches Thai mobile numbers, either local or with the country code
+var thaiMobile = regexp.MustCompile(`^(?:\+66|0)([689][0-9]{8})$`)
+
+func (s SourceType) wallet() bool {
+	switch s {
+	case SourceTypeTrueMoney, SourceTypeRabbitLINEPay, SourceTypeShopeePay, SourceTypeAlipay:
+		return true
+	default:
+		return false
+	}
+}
+
+// normalizePhoneNumber returns a Thai mobile number in the local format Omise takes,
+// spaces and dashes are ignored
+func normalizePhoneNumber(phoneNumber string) (string, bool) {
+	phoneNumber = strings.NewReplacer(" ", "", "-", "").Replace(phoneNumber)
+
+	m := thaiMobile.FindStringSubmatch(phoneNumber)
+	if m == nil {
+		return "", false
+	}
+
+	return "0" + m[1], true
+}
+
+// validatePhoneNumber checks the phone number of a request and returns it normalized, only
+// TrueMoney takes one and it needs one
+func (pr PaymentRequest) validatePhoneNumber() (string, error) {
+	if pr.SourceType != SourceTypeTrueMoney {
+		if pr.PhoneNumber != "" {
+			return "", ErrInvalidPhoneNumber
+		}
+		return "", nil
+	}
+
+	phoneNumber, ok := normalizePhoneNumber(pr.PhoneNumber)
+	if !ok {
+		return "", ErrInvalidPhoneNumber
+	}
+
+	return phoneNumber, nil
+}
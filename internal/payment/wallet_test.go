@@ -0,0 +1,32 @@
+package payment
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizePhoneNumber(t *testing.T) {
+	testCases := []struct {
+		name        string
+		phoneNumber string
+		expected    string
+		expectedOK  bool
+	}{
+		{"Local", "0812345678", "0812345678", true},
+		{"Country code", "+66912345678", "0912345678", true},
+		{"Dashes and spaces", "081-234 5678", "0812345678", true},
+		{"Landline", "021234567", "", false},
+		{"Too long", "08123456789", "", false},
+		{"Not a mobile prefix", "0712345678", "", false},
+		{"Empty", "", "", false},
+	}
+
+	t.Parallel()
+	for _, tc := range testCases {
+		phoneNumber, ok := normalizePhoneNumber(tc.phoneNumber)
+
+		assert.Equal(t, tc.expectedOK, ok, tc.name)
+		assert.Equal(t, tc.expected, phoneNumber, tc.name)
+	}
+}
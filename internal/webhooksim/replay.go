@@ -0,0 +1,86 @@
+package webhooksim
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ReadJSONL reads one event per line, blank lines are skipped
+func ReadJSONL(r io.Reader) ([][]byte, error) {
+	var events [][]byte
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 10*1024*1024)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+
+		b := bytes.TrimSpace(scanner.Bytes())
+		if len(b) == 0 {
+			continue
+		}
+		if !json.Valid(b) {
+			return nil, fmt.Errorf("line %d is not valid JSON", line)
+		}
+
+		events = append(events, append([]byte{}, b...))
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// Shuffle reorders events in place, e.g. to check out of order delivery
+func Shuffle(events [][]byte, rnd *rand.Rand) {
+	rnd.Shuffle(len(events), func(i, j int) {
+		events[i], events[j] = events[j], events[i]
+	})
+}
+
+type Sender struct {
+	Client *http.Client
+	URL    string
+	// Secret signs the payloads when set
+	Secret []byte
+}
+
+// Send posts payload to the webhook URL and returns the response status code
+func (s Sender) Send(payload []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, s.URL, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	if len(s.Secret) > 0 {
+		now := time.Now()
+		req.Header.Set(HeaderSignatureTimestamp, strconv.FormatInt(now.Unix(), 10))
+		req.Header.Set(HeaderSignature, Sign(s.Secret, now, payload))
+	}
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,26 @@
+package webhooksim
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"time"
+)
+
+// Headers of a signed Omise webhook
+const (
+	HeaderSignature          = "Omise-Signature"
+	HeaderSignatureTimestamp = "Omise-Signature-Timestamp"
+)
+
+// Sign returns the Omise-Signature of payload sent at timestamp,
+// a hex encoded HMAC-SHA256 of "<timestamp>.<payload>" keyed with the decoded webhook secret
+func Sign(secret []byte, timestamp time.Time, payload []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strconv.FormatInt(timestamp.Unix(), 10)))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
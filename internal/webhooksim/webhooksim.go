@@ -0,0 +1,264 @@
+package webhooksim
+
+import (
+	"bytes"
+	"crypto/rand"
+	"embed"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"text/template"
+	"time"
+)
+
+var (
+	ErrUnknownKey    = errors.New("unknown event key")
+	ErrInvalidStatus = errors.New("invalid charge status for event key")
+)
+
+// Charge statuses as sent by Omise
+const (
+	StatusPending    = "pending"
+	StatusSuccessful = "successful"
+	StatusFailed     = "failed"
+	StatusExpired    = "expired"
+	StatusReversed   = "reversed"
+)
+
+// Schedule statuses as sent by Omise, expired schedules have StatusExpired
+const (
+	ScheduleActive    = "active"
+	ScheduleExpiring  = "expiring"
+	ScheduleSuspended = "suspended"
+	ScheduleDeleted   = "deleted"
+)
+
+//go:embed templates/*.json
+var templateFS embed.FS
+
+var templates = template.Must(template.New("").Funcs(template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}).ParseFS(templateFS, "templates/*.json"))
+
+type eventKind struct {
+	template string
+	// statuses allowed for the charge or schedule, the first one is the default
+	statuses []string
+}
+
+var kinds = map[string]eventKind{
+	"charge.create":   {"charge.json", []string{StatusPending, StatusSuccessful, StatusFailed}},
+	"charge.update":   {"charge.json", []string{StatusPending}},
+	"charge.complete": {"charge.json", []string{StatusSuccessful, StatusFailed}},
+	"charge.capture":  {"charge.json", []string{StatusSuccessful}},
+	"charge.reverse":  {"charge.json", []string{StatusReversed}},
+	"charge.expire":   {"charge.json", []string{StatusExpired}},
+	"refund.create":   {"refund.json", []string{StatusSuccessful}},
+
+	"schedule.create":   {"schedule.json", []string{ScheduleActive}},
+	"schedule.expiring": {"schedule.json", []string{ScheduleExpiring}},
+	"schedule.expire":   {"schedule.json", []string{StatusExpired}},
+	"schedule.suspend":  {"schedule.json", []string{ScheduleSuspended}},
+	"schedule.destroy":  {"schedule.json", []string{ScheduleDeleted}},
+}
+
+// Keys returns every event key that can be built
+func Keys() []string {
+	keys := make([]string, 0, len(kinds))
+	for k := range kinds {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// Params of a simulated event, zero values are filled with defaults
+type Params struct {
+	Key          string
+	ChargeID     string
+	ScheduleID   string
+	CustomerID   string
+	Status       string
+	Amount       int64
+	Currency     string
+	SourceType   string
+	ReturnURI    string
+	FailureCode  string
+	RefundAmount int64
+	Livemode     bool
+	CreatedAt    time.Time
+}
+
+// templateData is what the templates render, nullable fields are pointers
+type templateData struct {
+	Params
+	EventID             string
+	SourceID            string
+	TransactionID       *string
+	RefundID            string
+	RefundTransactionID string
+	Net                 int64
+	Fee                 int64
+	FeeVat              int64
+	Paid                bool
+	Expired             bool
+	Reversed            bool
+	FailureCode         *string
+	FailureMessage      *string
+	ChargeCreatedAt     time.Time
+	PaidAt              *time.Time
+	ExpiresAt           time.Time
+	ExpiredAt           *time.Time
+	ReversedAt          *time.Time
+	StartDate           string
+	EndDate             string
+	NextOccurrences     []string
+}
+
+type event struct {
+	Object            string          `json:"object"`
+	ID                string          `json:"id"`
+	Livemode          bool            `json:"livemode"`
+	Location          string          `json:"location"`
+	WebhookDeliveries []interface{}   `json:"webhook_deliveries"`
+	Data              json.RawMessage `json:"data"`
+	Key               string          `json:"key"`
+	CreatedAt         time.Time       `json:"created_at"`
+}
+
+const dateLayout = "2006-01-02"
+
+func newID(prefix string, livemode bool) string {
+	b := make([]byte, 10)
+	_, _ = rand.Read(b)
+
+	mode := "_test_"
+	if livemode {
+		mode = "_"
+	}
+
+	return prefix + mode + hex.EncodeToString(b)
+}
+
+// Build renders the event for p as a single line of JSON
+func Build(p Params) ([]byte, error) {
+	kind, ok := kinds[p.Key]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, p.Key)
+	}
+
+	if p.Status == "" {
+		p.Status = kind.statuses[0]
+	}
+	if !contains(kind.statuses, p.Status) {
+		return nil, fmt.Errorf("%w: %s cannot be %q", ErrInvalidStatus, p.Key, p.Status)
+	}
+
+	if p.ChargeID == "" {
+		p.ChargeID = newID("chrg", p.Livemode)
+	}
+	if p.ScheduleID == "" {
+		p.ScheduleID = newID("schd", p.Livemode)
+	}
+	if p.CustomerID == "" {
+		p.CustomerID = newID("cust", p.Livemode)
+	}
+	if p.Amount == 0 {
+		p.Amount = 2000
+	}
+	if p.Currency == "" {
+		p.Currency = "THB"
+	}
+	if p.SourceType == "" {
+		p.SourceType = "internet_banking_scb"
+	}
+	if p.RefundAmount == 0 {
+		p.RefundAmount = p.Amount
+	}
+	if p.CreatedAt.IsZero() {
+		p.CreatedAt = time.Now()
+	}
+	p.CreatedAt = p.CreatedAt.UTC().Truncate(time.Second)
+
+	d := templateData{
+		Params:              p,
+		EventID:             newID("evnt", p.Livemode),
+		SourceID:            newID("src", p.Livemode),
+		RefundID:            newID("rfnd", p.Livemode),
+		RefundTransactionID: newID("trxn", p.Livemode),
+		ChargeCreatedAt:     p.CreatedAt.Add(-time.Minute),
+		ExpiresAt:           p.CreatedAt.Add(24 * time.Hour),
+		StartDate:           p.CreatedAt.Format(dateLayout),
+		EndDate:             p.CreatedAt.AddDate(1, 0, 0).Format(dateLayout),
+		NextOccurrences:     []string{},
+	}
+	if p.Status == ScheduleActive || p.Status == ScheduleExpiring {
+		d.NextOccurrences = []string{p.CreatedAt.AddDate(0, 1, 0).Format(dateLayout)}
+	}
+
+	switch p.Status {
+	case StatusSuccessful, StatusReversed:
+		txn := newID("trxn", p.Livemode)
+		d.TransactionID = &txn
+		d.Paid = p.Status == StatusSuccessful
+		d.PaidAt = &p.CreatedAt
+		// 3.65% fee plus 7% VAT
+		d.Fee = p.Amount * 365 / 10000
+		d.FeeVat = d.Fee * 7 / 100
+		d.Net = p.Amount - d.Fee - d.FeeVat
+		if p.Status == StatusReversed {
+			d.Reversed = true
+			d.ReversedAt = &p.CreatedAt
+		}
+	case StatusFailed:
+		code := p.FailureCode
+		if code == "" {
+			code = "payment_rejected"
+		}
+		message := "the payment was rejected"
+		d.FailureCode = &code
+		d.FailureMessage = &message
+	case StatusExpired:
+		// Also the status of expired schedules, which have none of these fields
+		d.Expired = true
+		d.ExpiredAt = &p.CreatedAt
+		d.ExpiresAt = p.CreatedAt
+	}
+
+	var data bytes.Buffer
+	if err := templates.ExecuteTemplate(&data, kind.template, d); err != nil {
+		return nil, err
+	}
+
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, data.Bytes()); err != nil {
+		return nil, fmt.Errorf("template %s rendered invalid JSON: %w", kind.template, err)
+	}
+
+	return json.Marshal(event{
+		Object:            "event",
+		ID:                d.EventID,
+		Livemode:          p.Livemode,
+		Location:          "/events/" + d.EventID,
+		WebhookDeliveries: []interface{}{},
+		Data:              compact.Bytes(),
+		Key:               p.Key,
+		CreatedAt:         p.CreatedAt,
+	})
+}
+
+func contains(values []string, v string) bool {
+	for _, s := range values {
+		if s == v {
+			return true
+		}
+	}
+
+	return false
+}
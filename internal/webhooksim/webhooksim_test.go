@@ -0,0 +1,150 @@
+package webhooksim
+
+import (
+	"encoding/json"
+	"errors"
+	"exam-payment-service/internal/payment"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuild(t *testing.T) {
+	testCases := []struct {
+		name           string
+		params         Params
+		expectedStatus string
+		expectedObject string
+		expectedError  error
+	}{
+		{
+			name:           "Charge create defaults to pending",
+			params:         Params{Key: "charge.create", ChargeID: "chrg_test_xxx"},
+			expectedStatus: StatusPending,
+			expectedObject: "charge",
+		},
+		{
+			name:           "Charge complete successful",
+			params:         Params{Key: "charge.complete", ChargeID: "chrg_test_xxx", Status: StatusSuccessful},
+			expectedStatus: StatusSuccessful,
+			expectedObject: "charge",
+		},
+		{
+			name:           "Charge complete failed",
+			params:         Params{Key: "charge.complete", ChargeID: "chrg_test_xxx", Status: StatusFailed},
+			expectedStatus: StatusFailed,
+			expectedObject: "charge",
+		},
+		{
+			name:           "Charge expire",
+			params:         Params{Key: "charge.expire", ChargeID: "chrg_test_xxx"},
+			expectedStatus: StatusExpired,
+			expectedObject: "charge",
+		},
+		{
+			name:           "Refund create",
+			params:         Params{Key: "refund.create", ChargeID: "chrg_test_xxx"},
+			expectedStatus: "closed",
+			expectedObject: "refund",
+		},
+		{
+			name:           "Schedule suspend",
+			params:         Params{Key: "schedule.suspend", ScheduleID: "schd_test_xxx"},
+			expectedStatus: ScheduleSuspended,
+			expectedObject: "schedule",
+		},
+		{
+			name:           "Schedule expire",
+			params:         Params{Key: "schedule.expire", ScheduleID: "schd_test_xxx"},
+			expectedStatus: StatusExpired,
+			expectedObject: "schedule",
+		},
+		{
+			name:          "Unknown key",
+			params:        Params{Key: "charge.unknown"},
+			expectedError: ErrUnknownKey,
+		},
+		{
+			name:          "Status not allowed for key",
+			params:        Params{Key: "charge.complete", Status: StatusPending},
+			expectedError: ErrInvalidStatus,
+		},
+	}
+
+	t.Parallel()
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			b, err := Build(tc.params)
+
+			if tc.expectedError != nil {
+				assert.True(t, errors.Is(err, tc.expectedError))
+				return
+			}
+			assert.NoError(t, err)
+			assert.NotContains(t, string(b), "\n")
+
+			var e payment.PaymentEvent
+			assert.NoError(t, json.Unmarshal(b, &e))
+			assert.Equal(t, tc.params.Key, e.Key)
+			assert.Equal(t, tc.expectedObject, e.Data.Object)
+			assert.Equal(t, tc.expectedStatus, e.Data.Status)
+			if tc.expectedObject == "charge" {
+				assert.Equal(t, tc.params.ChargeID, e.Data.ID)
+				assert.Equal(t, int64(2000), e.Data.Amount)
+			}
+			if tc.expectedObject == "schedule" {
+				assert.Equal(t, tc.params.ScheduleID, e.Data.ID)
+			}
+		})
+	}
+}
+
+func TestBuildEveryKey(t *testing.T) {
+	for _, key := range Keys() {
+		b, err := Build(Params{Key: key})
+		assert.NoError(t, err, key)
+		assert.True(t, json.Valid(b), key)
+	}
+}
+
+func TestReadJSONL(t *testing.T) {
+	events, err := ReadJSONL(strings.NewReader("{\"id\":1}\n\n{\"id\":2}\n"))
+
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte(`{"id":1}`), []byte(`{"id":2}`)}, events)
+
+	_, err = ReadJSONL(strings.NewReader("{\"id\":1}\nnot json\n"))
+
+	assert.EqualError(t, err, "line 2 is not valid JSON")
+}
+
+func TestSenderSigns(t *testing.T) {
+	secret := []byte("webhook-secret")
+	payload := []byte(`{"object":"event"}`)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		ts, _ := strconv.ParseInt(r.Header.Get(HeaderSignatureTimestamp), 10, 64)
+
+		if r.Header.Get(HeaderSignature) != Sign(secret, time.Unix(ts, 0), body) {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer srv.Close()
+
+	code, err := Sender{URL: srv.URL, Secret: secret}.Send(payload)
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, code)
+
+	code, err = Sender{URL: srv.URL, Secret: []byte("other")}.Send(payload)
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, code)
+}
@@ -0,0 +1,87 @@
+package barcode
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPatterns(t *testing.T) {
+	for v, p := range patterns {
+		width := 0
+		for _, w := range p {
+			width += int(w - '0')
+		}
+
+		expected := 11
+		if v == stop {
+			expected = 13
+		}
+		assert.Equal(t, expected, width, "symbol %d", v)
+	}
+}
+
+func TestValues(t *testing.T) {
+	testCases := []struct {
+		name          string
+		data          string
+		expected      []int
+		expectedError error
+	}{
+		{
+			name:     "Code set B",
+			data:     "AB",
+			expected: []int{startB, 33, 34, 102},
+		},
+		{
+			name:     "Control character first",
+			data:     "\r1",
+			expected: []int{startA, 77, 17, 8},
+		},
+		{
+			name:     "Switching code sets",
+			data:     "a\rb",
+			expected: []int{startB, 65, codeA, 77, codeB, 66, 96},
+		},
+		{
+			name:          "Not ASCII",
+			data:          "บาท",
+			expectedError: ErrUnencodable,
+		},
+	}
+
+	t.Parallel()
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			vs, err := values(tc.data)
+
+			assert.Equal(t, tc.expectedError, err)
+			assert.Equal(t, tc.expected, vs)
+		})
+	}
+}
+
+func TestRender(t *testing.T) {
+	modules, err := Code128("AB")
+	assert.NoError(t, err)
+	// Start, 2 characters and checksum of 11 modules, stop of 13
+	assert.Len(t, modules, 57)
+
+	var b bytes.Buffer
+	assert.NoError(t, WritePNG(&b, modules, 2, 50))
+
+	img, err := png.Decode(&b)
+	assert.NoError(t, err)
+	assert.Equal(t, (57+2*QuietZone)*2, img.Bounds().Dx())
+	assert.Equal(t, 50, img.Bounds().Dy())
+
+	b.Reset()
+	assert.NoError(t, WriteSVG(&b, modules, 2, 50))
+
+	// Every symbol has 3 bars, the stop symbol 4
+	assert.True(t, strings.HasPrefix(b.String(), "<svg"))
+	assert.Equal(t, 4*3+4+1, strings.Count(b.String(), "<rect"))
+}
@@ -0,0 +1,118 @@
+// Package barcode encodes Code 128 barcodes, as printed on bill payment slips, and renders them as PNG or SVG
+package barcode
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrUnencodable is returned for data with characters outside of ASCII
+var ErrUnencodable = errors.New("barcode: data must be ASCII")
+
+// Widths of the bar, space, bar... of each Code 128 symbol value, in modules
+var patterns = [...]string{
+	"212222", "222122", "222221", "121223", "121322", "131222", "122213", "122312", "132212", "221213",
+	"221312", "231212", "112232", "122132", "122231", "113222", "123122", "123221", "223211", "221132",
+	"221231", "213212", "223112", "312131", "311222", "321122", "321221", "312212", "322112", "322211",
+	"212123", "212321", "232121", "111323", "131123", "131321", "112313", "132113", "132311", "211313",
+	"231113", "231311", "112133", "112331", "132131", "113123", "113321", "133121", "313121", "211331",
+	"231131", "213113", "213311", "213131", "311123", "311321", "331121", "312113", "312311", "332111",
+	"314111", "221411", "431111", "111224", "111422", "121124", "121421", "141122", "141221", "112214",
+	"112412", "122114", "122411", "142112", "142211", "241211", "221114", "413111", "241112", "134111",
+	"111242", "121142", "121241", "114212", "124112", "124211", "411212", "421112", "421211", "212141",
+	"214121", "412121", "111143", "111341", "131141", "114113", "114311", "411113", "411311", "113141",
+	"114131", "311141", "411131", "211412", "211214", "211232", "2331112",
+}
+
+const (
+	codeB  = 100
+	codeA  = 101
+	startA = 103
+	startB = 104
+	stop   = 106
+)
+
+// QuietZone is the blank space renderers leave on each side of the bars, in modules
+const QuietZone = 10
+
+// needsA reports whether the character only exists in code set A, control characters
+func needsA(c byte) bool {
+	return c < 32
+}
+
+// needsB reports whether the character only exists in code set B, lower case and a few symbols
+func needsB(c byte) bool {
+	return c >= 96
+}
+
+// values returns the symbol values of data with its start symbol and checksum, switching
+// between code sets A and B as needed
+func values(data string) ([]int, error) {
+	for i := 0; i < len(data); i++ {
+		if data[i] > 127 {
+			return nil, ErrUnencodable
+		}
+	}
+
+	// Start in the set of the first character that needs one
+	setA := false
+	for i := 0; i < len(data); i++ {
+		if needsA(data[i]) || needsB(data[i]) {
+			setA = needsA(data[i])
+			break
+		}
+	}
+
+	vs := []int{startB}
+	if setA {
+		vs[0] = startA
+	}
+
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case setA && needsB(c):
+			vs = append(vs, codeB)
+			setA = false
+		case !setA && needsA(c):
+			vs = append(vs, codeA)
+			setA = true
+		}
+
+		if setA && c < 32 {
+			vs = append(vs, int(c)+64)
+		} else {
+			vs = append(vs, int(c)-32)
+		}
+	}
+
+	checksum := vs[0]
+	for i, v := range vs[1:] {
+		checksum += (i + 1) * v
+	}
+
+	return append(vs, checksum%103), nil
+}
+
+// Code128 returns the modules of the barcode of data, true for bars, without quiet zone
+func Code128(data string) ([]bool, error) {
+	vs, err := values(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var b strings.Builder
+	for _, v := range append(vs, stop) {
+		b.WriteString(patterns[v])
+	}
+
+	var modules []bool
+	for i, w := range b.String() {
+		bar := i%2 == 0
+		for n := 0; n < int(w-'0'); n++ {
+			modules = append(modules, bar)
+		}
+	}
+
+	return modules, nil
+}
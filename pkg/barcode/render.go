@@ -0,0 +1,55 @@
+package barcode
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+)
+
+// WritePNG renders the modules as a grayscale PNG, moduleWidth pixels per module and height pixels high
+func WritePNG(w io.Writer, modules []bool, moduleWidth int, height int) error {
+	width := (len(modules) + 2*QuietZone) * moduleWidth
+	img := image.NewGray(image.Rect(0, 0, width, height))
+
+	for x := 0; x < width; x++ {
+		c := color.Gray{Y: 0xff}
+		if m := x/moduleWidth - QuietZone; m >= 0 && m < len(modules) && modules[m] {
+			c = color.Gray{Y: 0}
+		}
+		for y := 0; y < height; y++ {
+			img.SetGray(x, y, c)
+		}
+	}
+
+	return png.Encode(w, img)
+}
+
+// WriteSVG renders the modules as an SVG of one rectangle per bar, sized as WritePNG
+func WriteSVG(w io.Writer, modules []bool, moduleWidth int, height int) error {
+	width := (len(modules) + 2*QuietZone) * moduleWidth
+	if _, err := fmt.Fprintf(w, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d"><rect width="100%%" height="100%%" fill="#fff"/>`, width, height, width, height); err != nil {
+		return err
+	}
+
+	for start := 0; start < len(modules); {
+		if !modules[start] {
+			start++
+			continue
+		}
+
+		end := start
+		for end < len(modules) && modules[end] {
+			end++
+		}
+		if _, err := fmt.Fprintf(w, `<rect x="%d" width="%d" height="%d"/>`, (start+QuietZone)*moduleWidth, (end-start)*moduleWidth, height); err != nil {
+			return err
+		}
+		start = end
+	}
+
+	_, err := io.WriteString(w, "</svg>")
+
+	return err
+}
@@ -0,0 +1,79 @@
+// Package encryption encrypts secrets stored in the database
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrMalformed is returned for ciphertexts that were not produced by the cipher or were altered
+var ErrMalformed = errors.New("encryption: malformed ciphertext")
+
+// Cipher encrypts and decrypts values, ciphertexts are base64 text safe to store in any column
+type Cipher interface {
+	Encrypt(plaintext []byte) (string, error)
+	Decrypt(ciphertext string) ([]byte, error)
+}
+
+type aesGCM struct {
+	aead cipher.AEAD
+}
+
+// NewAESGCM returns an AES-GCM cipher, the key is 16, 24 or 32 bytes
+func NewAESGCM(key []byte) (Cipher, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return aesGCM{aead}, nil
+}
+
+// ParseKey decodes a base64 key, e.g. generated with `openssl rand -base64 32`
+func ParseKey(s string) ([]byte, error) {
+	if s == "" {
+		return nil, errors.New("encryption: empty key")
+	}
+
+	key, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("encryption: invalid key: %w", err)
+	}
+
+	return key, nil
+}
+
+// Encrypt returns the base64 of a random nonce followed by the sealed plaintext
+func (c aesGCM) Encrypt(plaintext []byte) (string, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(c.aead.Seal(nonce, nonce, plaintext, nil)), nil
+}
+
+func (c aesGCM) Decrypt(ciphertext string) ([]byte, error) {
+	b, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil || len(b) < c.aead.NonceSize() {
+		return nil, ErrMalformed
+	}
+
+	nonce, sealed := b[:c.aead.NonceSize()], b[c.aead.NonceSize():]
+	plaintext, err := c.aead.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	return plaintext, nil
+}
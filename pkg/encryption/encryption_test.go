@@ -0,0 +1,74 @@
+package encryption
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAESGCM(t *testing.T) {
+	c, err := NewAESGCM(bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := c.Encrypt([]byte("skey_test_xxx"))
+	assert.NoError(t, err)
+	b, err := c.Encrypt([]byte("skey_test_xxx"))
+	assert.NoError(t, err)
+
+	// Random nonces, the same plaintext never gives the same ciphertext
+	assert.NotEqual(t, a, b)
+	assert.NotContains(t, a, "skey_test_xxx")
+
+	plaintext, err := c.Decrypt(a)
+	assert.NoError(t, err)
+	assert.Equal(t, "skey_test_xxx", string(plaintext))
+
+	other, err := NewAESGCM(bytes.Repeat([]byte{2}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name       string
+		cipher     Cipher
+		ciphertext string
+	}{
+		{
+			name:       "Other key",
+			cipher:     other,
+			ciphertext: a,
+		},
+		{
+			name:       "Not base64",
+			cipher:     c,
+			ciphertext: "skey_test_xxx",
+		},
+		{
+			name:       "Too short",
+			cipher:     c,
+			ciphertext: "AAAA",
+		},
+	}
+
+	t.Parallel()
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := tc.cipher.Decrypt(tc.ciphertext)
+			assert.Equal(t, ErrMalformed, err)
+		})
+	}
+}
+
+func TestNewAESGCMInvalidKey(t *testing.T) {
+	_, err := NewAESGCM([]byte("short"))
+	assert.Error(t, err)
+
+	_, err = ParseKey("not base64!")
+	assert.Error(t, err)
+
+	_, err = ParseKey("")
+	assert.Error(t, err)
+}
@@ -0,0 +1,210 @@
+package encryption
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// ErrUnknownKey is returned for ciphertexts encrypted with a master key missing from the keyring
+var ErrUnknownKey = errors.New("encryption: unknown master key")
+
+// envelopePrefix starts the ciphertexts of a Keyring, ciphertexts without it are legacy ones
+const envelopePrefix = "v1:"
+
+// dataKeySize is the size of the AES-256 data key generated for each value
+const dataKeySize = 32
+
+// MasterKey is a key of the master key file, Key is base64
+type MasterKey struct {
+	ID  string `json:"id"`
+	Key string `json:"key"`
+}
+
+// KeyFile is the master key file, values are encrypted with the primary key
+// and decrypted with the key whose ID they carry
+type KeyFile struct {
+	Primary string      `json:"primary"`
+	Keys    []MasterKey `json:"keys"`
+}
+
+// ReadKeyFile reads a master key file, errors.Is(err, os.ErrNotExist) for a missing file
+func ReadKeyFile(path string) (KeyFile, error) {
+	var f KeyFile
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return f, err
+	}
+
+	if err := json.Unmarshal(b, &f); err != nil {
+		return f, fmt.Errorf("encryption: %s: %w", path, err)
+	}
+
+	return f, nil
+}
+
+// Write saves the file readable by its owner only
+func (f KeyFile) Write(path string) error {
+	b, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, append(b, '\n'), 0600)
+}
+
+// Generate adds a random master key and makes it the primary one, older keys are kept
+// to decrypt values until they are re-encrypted
+func (f *KeyFile) Generate() (string, error) {
+	id := make([]byte, 4)
+	key := make([]byte, 32)
+	for _, b := range [][]byte{id, key} {
+		if _, err := io.ReadFull(rand.Reader, b); err != nil {
+			return "", err
+		}
+	}
+
+	mk := MasterKey{
+		ID:  "mk_" + hex.EncodeToString(id),
+		Key: base64.StdEncoding.EncodeToString(key),
+	}
+	f.Keys = append(f.Keys, mk)
+	f.Primary = mk.ID
+
+	return mk.ID, nil
+}
+
+// Keyring is a Cipher doing envelope encryption, each value is encrypted with its own data key
+// and the data key is encrypted with the primary master key.
+// Ciphertexts are "v1:<master key ID>:<base64 encrypted data key>:<base64 encrypted value>"
+type Keyring struct {
+	primary string
+	keys    map[string]Cipher
+	legacy  Cipher
+}
+
+type KeyringOption func(*Keyring)
+
+// WithLegacyCipher decrypts the ciphertexts written before key IDs, e.g. with NewAESGCM
+func WithLegacyCipher(c Cipher) KeyringOption {
+	return func(k *Keyring) {
+		k.legacy = c
+	}
+}
+
+func NewKeyring(f KeyFile, opts ...KeyringOption) (*Keyring, error) {
+	k := &Keyring{
+		f.Primary,
+		map[string]Cipher{},
+		nil,
+	}
+
+	for _, mk := range f.Keys {
+		if mk.ID == "" || strings.Contains(mk.ID, ":") {
+			return nil, fmt.Errorf("encryption: invalid master key ID %q", mk.ID)
+		}
+
+		key, err := ParseKey(mk.Key)
+		if err != nil {
+			return nil, fmt.Errorf("encryption: master key %s: %w", mk.ID, err)
+		}
+
+		c, err := NewAESGCM(key)
+		if err != nil {
+			return nil, fmt.Errorf("encryption: master key %s: %w", mk.ID, err)
+		}
+		k.keys[mk.ID] = c
+	}
+
+	if _, ok := k.keys[k.primary]; !ok {
+		return nil, fmt.Errorf("encryption: primary master key %q is not in the file", k.primary)
+	}
+
+	for _, opt := range opts {
+		opt(k)
+	}
+
+	return k, nil
+}
+
+// LoadKeyring reads the master key file at path
+func LoadKeyring(path string, opts ...KeyringOption) (*Keyring, error) {
+	f, err := ReadKeyFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewKeyring(f, opts...)
+}
+
+// Primary returns the ID of the master key new values are encrypted with
+func (k *Keyring) Primary() string {
+	return k.primary
+}
+
+// Current reports whether the ciphertext is encrypted with the primary master key
+func (k *Keyring) Current(ciphertext string) bool {
+	return strings.HasPrefix(ciphertext, envelopePrefix+k.primary+":")
+}
+
+func (k *Keyring) Encrypt(plaintext []byte) (string, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return "", err
+	}
+
+	data, err := NewAESGCM(dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	value, err := data.Encrypt(plaintext)
+	if err != nil {
+		return "", err
+	}
+
+	wrapped, err := k.keys[k.primary].Encrypt(dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	return envelopePrefix + k.primary + ":" + wrapped + ":" + value, nil
+}
+
+func (k *Keyring) Decrypt(ciphertext string) ([]byte, error) {
+	if !strings.HasPrefix(ciphertext, envelopePrefix) {
+		if k.legacy == nil {
+			return nil, ErrMalformed
+		}
+		return k.legacy.Decrypt(ciphertext)
+	}
+
+	parts := strings.Split(strings.TrimPrefix(ciphertext, envelopePrefix), ":")
+	if len(parts) != 3 {
+		return nil, ErrMalformed
+	}
+
+	master, ok := k.keys[parts[0]]
+	if !ok {
+		return nil, fmt.Errorf("%w %s", ErrUnknownKey, parts[0])
+	}
+
+	dataKey, err := master.Decrypt(parts[1])
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := NewAESGCM(dataKey)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	return data.Decrypt(parts[2])
+}
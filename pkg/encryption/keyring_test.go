@@ -0,0 +1,137 @@
+package encryption
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyring(t *testing.T) {
+	var f KeyFile
+	first, err := f.Generate()
+	assert.NoError(t, err)
+
+	k1, err := NewKeyring(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, err := k1.Encrypt([]byte("skey_test_xxx"))
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(old, "v1:"+first+":"))
+	assert.True(t, k1.Current(old))
+
+	// Rotate, values of the previous key are still readable
+	second, err := f.Generate()
+	assert.NoError(t, err)
+	assert.NotEqual(t, first, second)
+
+	k2, err := NewKeyring(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, second, k2.Primary())
+	assert.False(t, k2.Current(old))
+
+	plaintext, err := k2.Decrypt(old)
+	assert.NoError(t, err)
+	assert.Equal(t, "skey_test_xxx", string(plaintext))
+
+	current, err := k2.Encrypt(plaintext)
+	assert.NoError(t, err)
+	assert.True(t, k2.Current(current))
+
+	// Once the previous key is removed
+	f.Keys = f.Keys[1:]
+	k3, err := NewKeyring(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = k3.Decrypt(old)
+	assert.True(t, errors.Is(err, ErrUnknownKey))
+
+	plaintext, err = k3.Decrypt(current)
+	assert.NoError(t, err)
+	assert.Equal(t, "skey_test_xxx", string(plaintext))
+}
+
+func TestKeyringLegacy(t *testing.T) {
+	var f KeyFile
+	_, err := f.Generate()
+	assert.NoError(t, err)
+
+	legacy, err := NewAESGCM(bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext, err := legacy.Encrypt([]byte("skey_test_xxx"))
+	assert.NoError(t, err)
+
+	k, err := NewKeyring(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = k.Decrypt(ciphertext)
+	assert.Equal(t, ErrMalformed, err)
+
+	k, err = NewKeyring(f, WithLegacyCipher(legacy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err := k.Decrypt(ciphertext)
+	assert.NoError(t, err)
+	assert.Equal(t, "skey_test_xxx", string(plaintext))
+	assert.False(t, k.Current(ciphertext))
+}
+
+func TestKeyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "master.json")
+
+	_, err := ReadKeyFile(path)
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+
+	var f KeyFile
+	_, err = f.Generate()
+	assert.NoError(t, err)
+	assert.NoError(t, f.Write(path))
+
+	info, err := os.Stat(path)
+	if assert.NoError(t, err) {
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	}
+
+	k, err := LoadKeyring(path)
+	if assert.NoError(t, err) {
+		assert.Equal(t, f.Primary, k.Primary())
+	}
+
+	testCases := []struct {
+		name string
+		file KeyFile
+	}{
+		{
+			name: "Missing primary key",
+			file: KeyFile{Primary: "mk_unknown", Keys: f.Keys},
+		},
+		{
+			name: "Invalid key",
+			file: KeyFile{Primary: "mk_1", Keys: []MasterKey{{ID: "mk_1", Key: "c2hvcnQ="}}},
+		},
+		{
+			name: "Invalid key ID",
+			file: KeyFile{Primary: "mk:1", Keys: []MasterKey{{ID: "mk:1", Key: f.Keys[0].Key}}},
+		},
+	}
+
+	t.Parallel()
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewKeyring(tc.file)
+			assert.Error(t, err)
+		})
+	}
+}
@@ -1,13 +1,49 @@
 package fiberhelper
 
-import "github.com/gofiber/fiber/v2"
+import (
+	"encoding/json"
+	"exam-payment-service/pkg/logger"
+	"net/http"
 
-func HandleErrorJSONResp(c *fiber.Ctx, code int, message string) error {
-	return c.Status(code).JSON(errorJSONResp{
-		Message: message,
-	})
+	"github.com/gofiber/fiber/v2"
+)
+
+const MIMEApplicationProblemJSON = "application/problem+json"
+
+// Problem is an RFC 7807 problem details object
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+
+	// Extension members
+	Code         string `json:"code"`
+	UpstreamCode string `json:"upstreamCode,omitempty"`
+	RequestID    string `json:"requestId,omitempty"`
 }
 
-type errorJSONResp struct {
-	Message string `json:"message"`
+// NewProblem creates a problem typed by code, with the request path as instance
+func NewProblem(c *fiber.Ctx, status int, code string, detail string) Problem {
+	return Problem{
+		Type:     "/problems/" + code,
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   detail,
+		Instance: c.OriginalURL(),
+		Code:     code,
+	}
+}
+
+func HandleProblemJSONResp(c *fiber.Ctx, p Problem) error {
+	p.RequestID = logger.RequestIDFromContext(c.UserContext())
+
+	b, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	c.Set(fiber.HeaderContentType, MIMEApplicationProblemJSON)
+	return c.Status(p.Status).Send(b)
 }
@@ -0,0 +1,53 @@
+package fiberhelper
+
+import (
+	"exam-payment-service/pkg/logger"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"go.uber.org/zap"
+)
+
+const HeaderRequestID = "X-Request-ID"
+
+// RequestID reuses the incoming X-Request-ID header or generates a new ID,
+// echoes it in the response and stores it in c.UserContext() for loggers
+func RequestID() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		requestID := c.Get(HeaderRequestID)
+		if requestID == "" {
+			requestID = utils.UUIDv4()
+		}
+
+		c.Set(HeaderRequestID, requestID)
+		c.SetUserContext(logger.ContextWithRequestID(c.UserContext(), requestID))
+
+		return c.Next()
+	}
+}
+
+// AccessLog writes one log line per request
+func AccessLog(log *zap.SugaredLogger) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+
+		err := c.Next()
+		if err != nil {
+			if hErr := c.App().Config().ErrorHandler(c, err); hErr != nil {
+				_ = c.SendStatus(fiber.StatusInternalServerError)
+			}
+		}
+
+		logger.For(c.UserContext(), log).Infow("request",
+			"method", c.Method(),
+			"path", c.Path(),
+			"route", c.Route().Path,
+			"status", c.Response().StatusCode(),
+			"latency_ms", time.Since(start).Milliseconds(),
+			"ip", c.IP(),
+		)
+
+		return nil
+	}
+}
@@ -0,0 +1,61 @@
+package fiberhelper
+
+import (
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "exam-payment-service/pkg/fiberhelper"
+
+// Tracing starts a server span for each request, continuing the trace from the
+// incoming W3C trace-context headers. Handlers get the span through c.UserContext().
+func Tracing() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		carrier := propagation.HeaderCarrier{}
+		c.Request().Header.VisitAll(func(key, value []byte) {
+			carrier.Set(string(key), string(value))
+		})
+
+		ctx := otel.GetTextMapPropagator().Extract(c.UserContext(), carrier)
+		ctx, span := otel.Tracer(tracerName).Start(
+			ctx,
+			c.Method()+" "+c.Path(),
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPMethodKey.String(c.Method()),
+				semconv.HTTPTargetKey.String(string(c.Request().RequestURI())),
+				semconv.HTTPUserAgentKey.String(string(c.Request().Header.UserAgent())),
+				semconv.NetPeerIPKey.String(c.IP()),
+			),
+		)
+		defer span.End()
+
+		c.SetUserContext(ctx)
+
+		err := c.Next()
+		if err != nil {
+			span.RecordError(err)
+			// Let the error handler write the response so the status is recorded
+			if hErr := c.App().Config().ErrorHandler(c, err); hErr != nil {
+				_ = c.SendStatus(http.StatusInternalServerError)
+			}
+		}
+
+		route := c.Route().Path
+		span.SetName(c.Method() + " " + route)
+
+		status := c.Response().StatusCode()
+		span.SetAttributes(
+			semconv.HTTPRouteKey.String(route),
+			semconv.HTTPStatusCodeKey.Int(status),
+		)
+		span.SetStatus(semconv.SpanStatusFromHTTPStatusCode(status))
+
+		return nil
+	}
+}
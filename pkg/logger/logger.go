@@ -0,0 +1,53 @@
+package logger
+
+import (
+	"context"
+	"os"
+
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+type requestIDKey struct{}
+
+// New creates a JSON logger writing to stdout with secrets redacted
+func New() *zap.SugaredLogger {
+	return NewWithWriter(zapcore.Lock(os.Stdout))
+}
+
+func NewWithWriter(w zapcore.WriteSyncer) *zap.SugaredLogger {
+	encoderConfig := zap.NewProductionEncoderConfig()
+	encoderConfig.TimeKey = "time"
+	encoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+
+	core := zapcore.NewCore(
+		zapcore.NewJSONEncoder(encoderConfig),
+		w,
+		zap.InfoLevel,
+	)
+
+	return zap.New(redactCore{core}).Sugar()
+}
+
+func ContextWithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+func RequestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+// For returns l annotated with the request and trace IDs carried by ctx
+func For(ctx context.Context, l *zap.SugaredLogger) *zap.SugaredLogger {
+	if requestID := RequestIDFromContext(ctx); requestID != "" {
+		l = l.With("request_id", requestID)
+	}
+
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		l = l.With("trace_id", sc.TraceID().String(), "span_id", sc.SpanID().String())
+	}
+
+	return l
+}
@@ -0,0 +1,112 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestRedaction(t *testing.T) {
+	type card struct {
+		Brand      string `json:"brand"`
+		LastDigits string `json:"last_digits"`
+	}
+
+	type event struct {
+		ID   string `json:"id"`
+		Data struct {
+			AuthorizeURI string `json:"authorize_uri"`
+			Card         *card  `json:"card"`
+			Status       string `json:"status"`
+		} `json:"data"`
+	}
+
+	e := event{ID: "evnt_test_xxx"}
+	e.Data.AuthorizeURI = "https://pay.omise.co/offsites/ofsp_test_xxx/pay"
+	e.Data.Card = &card{Brand: "Visa", LastDigits: "4242"}
+	e.Data.Status = "successful"
+
+	testCases := []struct {
+		name     string
+		kv       []interface{}
+		expected map[string]interface{}
+	}{
+		{
+			name: "Sensitive key",
+			kv:   []interface{}{"secret_key", "anything", "authorizeUri", "https://example.com", "return_uri", "https://example.com/orders/1?token=xxx", "cardToken", "tokn_test_xxx", "email", "buyer@example.com", "phone_number", "0812345678"},
+			expected: map[string]interface{}{
+				"secret_key":   redacted,
+				"authorizeUri": redacted,
+				"return_uri":   redacted,
+				"cardToken":    redacted,
+				"email":        redacted,
+				"phone_number": redacted,
+			},
+		},
+		{
+			name: "Secret key value",
+			kv:   []interface{}{"value", "skey_test_xxx"},
+			expected: map[string]interface{}{
+				"value": redacted,
+			},
+		},
+		{
+			name: "Nested struct",
+			kv:   []interface{}{"event", e},
+			expected: map[string]interface{}{
+				"event": map[string]interface{}{
+					"id": "evnt_test_xxx",
+					"data": map[string]interface{}{
+						"authorize_uri": redacted,
+						"card":          redacted,
+						"status":        "successful",
+					},
+				},
+			},
+		},
+		{
+			name: "Plain value",
+			kv:   []interface{}{"charge_id", "chrg_test_xxx"},
+			expected: map[string]interface{}{
+				"charge_id": "chrg_test_xxx",
+			},
+		},
+	}
+
+	t.Parallel()
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+
+			log := NewWithWriter(zapcore.AddSync(&buf))
+			log.Infow("test", tc.kv...)
+
+			var line map[string]interface{}
+			if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+				t.Fatal(err)
+			}
+
+			for key, value := range tc.expected {
+				assert.Equal(t, value, line[key])
+			}
+		})
+	}
+}
+
+func TestForRequestID(t *testing.T) {
+	var buf bytes.Buffer
+
+	ctx := ContextWithRequestID(context.Background(), "req-1")
+	For(ctx, NewWithWriter(zapcore.AddSync(&buf))).Info("test")
+
+	var line map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, "req-1", line["request_id"])
+}
@@ -0,0 +1,133 @@
+package logger
+
+import (
+	"encoding/json"
+	"strings"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const redacted = "[REDACTED]"
+
+// Keys are compared lower-cased without "_" and "-"
+var redactedKeys = map[string]bool{
+	"authorizeuri": true,
+	"card":         true,
+	"cardtoken":    true,
+	"email":        true,
+	"password":     true,
+	"phonenumber":  true,
+	"publickey":    true,
+	"returnuri":    true,
+	"secret":       true,
+	"secretkey":    true,
+	"skey":         true,
+}
+
+// Omise secret keys are redacted wherever they appear
+var redactedPrefixes = []string{
+	"skey_",
+}
+
+// redactCore removes secrets from fields before they reach the encoder
+type redactCore struct {
+	zapcore.Core
+}
+
+func (c redactCore) With(fields []zapcore.Field) zapcore.Core {
+	return redactCore{c.Core.With(redactFields(fields))}
+}
+
+func (c redactCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+
+	return ce
+}
+
+func (c redactCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	return c.Core.Write(ent, redactFields(fields))
+}
+
+func redactFields(fields []zapcore.Field) []zapcore.Field {
+	rs := make([]zapcore.Field, len(fields))
+	for i, f := range fields {
+		rs[i] = redactField(f)
+	}
+
+	return rs
+}
+
+func redactField(f zapcore.Field) zapcore.Field {
+	if isRedactedKey(f.Key) {
+		return zap.String(f.Key, redacted)
+	}
+
+	switch f.Type {
+	case zapcore.StringType:
+		if isRedactedValue(f.String) {
+			return zap.String(f.Key, redacted)
+		}
+	case zapcore.ReflectType:
+		return zap.Any(f.Key, redactValue(f.Interface))
+	}
+
+	return f
+}
+
+// redactValue walks the JSON representation of v, so struct tags decide the key names
+func redactValue(v interface{}) interface{} {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return redacted
+	}
+
+	var generic interface{}
+	if err := json.Unmarshal(b, &generic); err != nil {
+		return redacted
+	}
+
+	return redactGeneric(generic)
+}
+
+func redactGeneric(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for key, value := range t {
+			if value != nil && isRedactedKey(key) {
+				t[key] = redacted
+				continue
+			}
+			t[key] = redactGeneric(value)
+		}
+	case []interface{}:
+		for i, value := range t {
+			t[i] = redactGeneric(value)
+		}
+	case string:
+		if isRedactedValue(t) {
+			return redacted
+		}
+	}
+
+	return v
+}
+
+func isRedactedKey(key string) bool {
+	key = strings.ToLower(key)
+	key = strings.NewReplacer("_", "", "-", "").Replace(key)
+
+	return redactedKeys[key]
+}
+
+func isRedactedValue(value string) bool {
+	for _, prefix := range redactedPrefixes {
+		if strings.HasPrefix(value, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
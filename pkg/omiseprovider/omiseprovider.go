@@ -1,36 +1,384 @@
 package omiseprovider
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/gofiber/fiber/v2/utils"
 	"github.com/omise/omise-go"
 	"github.com/omise/omise-go/operations"
+	"github.com/sony/gobreaker"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
+// ErrUnavailable is returned without calling Omise while the circuit breaker is open
+var ErrUnavailable = errors.New("omise is unavailable")
+
+const headerIdempotencyKey = "Idempotency-Key"
+
+// EndpointAPI is the key of omise.Client.Endpoints to override the Omise API URL
+const EndpointAPI = "https://api.omise.co"
+
 type provider struct {
-	oc *omise.Client
+	oc    *omise.Client
+	retry retryConfig
+	cb    *gobreaker.CircuitBreaker
+}
+
+type Option func(*config)
+
+type config struct {
+	timeout time.Duration
+	retry   retryConfig
+	breaker gobreaker.Settings
+}
+
+// WithTimeout bounds each HTTP request to Omise, including reading the response
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *config) {
+		c.timeout = timeout
+	}
+}
+
+// WithRetry sets how many times a failed call is retried and the base delay of
+// the exponential backoff
+func WithRetry(maxRetries int, baseDelay time.Duration) Option {
+	return func(c *config) {
+		c.retry.maxRetries = maxRetries
+		c.retry.baseDelay = baseDelay
+	}
+}
+
+// WithCircuitBreaker opens the breaker after consecutiveFailures failed calls and
+// tries Omise again after openTimeout
+func WithCircuitBreaker(consecutiveFailures uint32, openTimeout time.Duration) Option {
+	return func(c *config) {
+		c.breaker.ReadyToTrip = func(counts gobreaker.Counts) bool {
+			return counts.ConsecutiveFailures >= consecutiveFailures
+		}
+		c.breaker.Timeout = openTimeout
+	}
 }
 
-func New(oc *omise.Client) *provider {
+func New(oc *omise.Client, opts ...Option) *provider {
+	cfg := config{
+		timeout: 30 * time.Second,
+		retry: retryConfig{
+			maxRetries: 2,
+			baseDelay:  200 * time.Millisecond,
+			maxDelay:   2 * time.Second,
+		},
+		breaker: gobreaker.Settings{
+			Name: "omise",
+			ReadyToTrip: func(counts gobreaker.Counts) bool {
+				return counts.ConsecutiveFailures >= 5
+			},
+			Timeout: 30 * time.Second,
+		},
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	// Only network errors and server errors mean Omise is degraded, calls the caller gave up on do not
+	cfg.breaker.IsSuccessful = func(err error) bool {
+		return err == nil || !(isNetworkError(err) || isServerError(err))
+	}
+
+	oc.Client.Timeout = cfg.timeout
+
+	// Propagate trace context to Omise and record a span for each outgoing request
+	oc.Client.Transport = otelhttp.NewTransport(oc.Client.Transport)
+
 	return &provider{
 		oc,
+		cfg.retry,
+		gobreaker.NewCircuitBreaker(cfg.breaker),
 	}
 }
 
-func (p *provider) CreateSource(createSource operations.CreateSource) (omise.Source, error) {
+func (p *provider) CreateSource(ctx context.Context, createSource operations.CreateSource) (omise.Source, error) {
 	source := &omise.Source{}
 
-	if err := p.oc.Do(source, &createSource); err != nil {
+	if err := p.do(ctx, source, retryCreate, func() (*http.Request, error) {
+		return p.oc.Request(&createSource)
+	}); err != nil {
 		return *source, err
 	}
 
 	return *source, nil
 }
 
-func (p *provider) CreateCharge(createCharge operations.CreateCharge) (omise.Charge, error) {
+// CreateCharge is operations.CreateCharge with when the charge expires, which omise-go does not
+// send. Omise only takes it for some source types
+type CreateCharge struct {
+	operations.CreateCharge
+	ExpiresAt *time.Time `json:"-"`
+}
+
+func (c *CreateCharge) MarshalJSON() ([]byte, error) {
+	b, err := c.CreateCharge.MarshalJSON()
+	if err != nil || c.ExpiresAt == nil {
+		return b, err
+	}
+
+	params := map[string]interface{}{}
+	if err := json.Unmarshal(b, &params); err != nil {
+		return nil, err
+	}
+	params["expires_at"] = c.ExpiresAt.UTC().Format(time.RFC3339)
+
+	return json.Marshal(params)
+}
+
+func (p *provider) CreateCharge(ctx context.Context, createCharge CreateCharge) (omise.Charge, error) {
+	charge := &omise.Charge{}
+
+	if err := p.do(ctx, charge, retryCreate, func() (*http.Request, error) {
+		return p.oc.Request(&createCharge)
+	}); err != nil {
+		return *charge, err
+	}
+
+	return *charge, nil
+}
+
+func (p *provider) RetrieveCharge(ctx context.Context, retrieveCharge operations.RetrieveCharge) (omise.Charge, error) {
+	charge := &omise.Charge{}
+
+	if err := p.do(ctx, charge, retryRead, func() (*http.Request, error) {
+		return p.oc.Request(&retrieveCharge)
+	}); err != nil {
+		return *charge, err
+	}
+
+	return *charge, nil
+}
+
+// BillPaymentCharge is omise.Charge with the references of its bill payment source, which
+// omise.Source lacks. Source shadows the source of the embedded charge
+type BillPaymentCharge struct {
+	omise.Charge
+	Source *BillPaymentSource `json:"source"`
+}
+
+type BillPaymentSource struct {
+	omise.Source
+	References *BillPaymentReferences `json:"references"`
+}
+
+// BillPaymentReferences are printed on the bill the customer pays at the counter, Barcode is the
+// data of its Code 128 barcode
+type BillPaymentReferences struct {
+	ExpiresAt        time.Time `json:"expires_at"`
+	ReferenceNumber1 string    `json:"reference_number_1"`
+	ReferenceNumber2 string    `json:"reference_number_2"`
+	Barcode          string    `json:"barcode"`
+	OmiseTaxID       string    `json:"omise_tax_id"`
+}
+
+func (p *provider) CreateBillPaymentCharge(ctx context.Context, createCharge operations.CreateCharge) (BillPaymentCharge, error) {
+	charge := &BillPaymentCharge{}
+
+	if err := p.do(ctx, charge, retryCreate, func() (*http.Request, error) {
+		return p.oc.Request(&createCharge)
+	}); err != nil {
+		return *charge, err
+	}
+
+	return *charge, nil
+}
+
+// CaptureCharge is operations.CaptureCharge with the amount of a partial capture,
+// which omise-go does not send. The full amount is captured without it
+type CaptureCharge struct {
+	operations.CaptureCharge
+	CaptureAmount int64 `json:"capture_amount,omitempty"`
+}
+
+func (p *provider) CaptureCharge(ctx context.Context, captureCharge CaptureCharge) (omise.Charge, error) {
 	charge := &omise.Charge{}
 
-	if err := p.oc.Do(charge, &createCharge); err != nil {
+	if err := p.do(ctx, charge, retryCreate, func() (*http.Request, error) {
+		return p.oc.Request(&captureCharge)
+	}); err != nil {
 		return *charge, err
 	}
 
 	return *charge, nil
 }
+
+func (p *provider) ReverseCharge(ctx context.Context, reverseCharge operations.ReverseCharge) (omise.Charge, error) {
+	charge := &omise.Charge{}
+
+	if err := p.do(ctx, charge, retryCreate, func() (*http.Request, error) {
+		return p.oc.Request(&reverseCharge)
+	}); err != nil {
+		return *charge, err
+	}
+
+	return *charge, nil
+}
+
+func (p *provider) CreateCustomer(ctx context.Context, createCustomer operations.CreateCustomer) (omise.Customer, error) {
+	customer := &omise.Customer{}
+
+	if err := p.do(ctx, customer, retryCreate, func() (*http.Request, error) {
+		return p.oc.Request(&createCustomer)
+	}); err != nil {
+		return *customer, err
+	}
+
+	return *customer, nil
+}
+
+func (p *provider) UpdateCustomer(ctx context.Context, updateCustomer operations.UpdateCustomer) (omise.Customer, error) {
+	customer := &omise.Customer{}
+
+	if err := p.do(ctx, customer, retryCreate, func() (*http.Request, error) {
+		return p.oc.Request(&updateCustomer)
+	}); err != nil {
+		return *customer, err
+	}
+
+	return *customer, nil
+}
+
+func (p *provider) RetrieveCustomer(ctx context.Context, retrieveCustomer operations.RetrieveCustomer) (omise.Customer, error) {
+	customer := &omise.Customer{}
+
+	if err := p.do(ctx, customer, retryRead, func() (*http.Request, error) {
+		return p.oc.Request(&retrieveCustomer)
+	}); err != nil {
+		return *customer, err
+	}
+
+	return *customer, nil
+}
+
+func (p *provider) CreateChargeSchedule(ctx context.Context, createSchedule operations.CreateChargeSchedule) (omise.Schedule, error) {
+	schedule := &omise.Schedule{}
+
+	if err := p.do(ctx, schedule, retryCreate, func() (*http.Request, error) {
+		return p.oc.Request(&createSchedule)
+	}); err != nil {
+		return *schedule, err
+	}
+
+	return *schedule, nil
+}
+
+func (p *provider) DestroySchedule(ctx context.Context, destroySchedule operations.DestroySchedule) (omise.Schedule, error) {
+	schedule := &omise.Schedule{}
+
+	if err := p.do(ctx, schedule, retryCreate, func() (*http.Request, error) {
+		return p.oc.Request(&destroySchedule)
+	}); err != nil {
+		return *schedule, err
+	}
+
+	return *schedule, nil
+}
+
+func (p *provider) CreateLink(ctx context.Context, createLink operations.CreateLink) (omise.Link, error) {
+	link := &omise.Link{}
+
+	if err := p.do(ctx, link, retryCreate, func() (*http.Request, error) {
+		return p.oc.Request(&createLink)
+	}); err != nil {
+		return *link, err
+	}
+
+	return *link, nil
+}
+
+func (p *provider) RetrieveCapability(ctx context.Context, retrieveCapability operations.RetrieveCapability) (omise.Capability, error) {
+	capability := &omise.Capability{}
+
+	if err := p.do(ctx, capability, retryRead, func() (*http.Request, error) {
+		return p.oc.Request(&retrieveCapability)
+	}); err != nil {
+		return *capability, err
+	}
+
+	return *capability, nil
+}
+
+// do performs the request built by request through the circuit breaker,
+// retrying according to policy
+func (p *provider) do(ctx context.Context, result interface{}, policy retryPolicy, request func() (*http.Request, error)) error {
+	// Omise deduplicates requests with the same key, so a create that reached
+	// Omise before the connection dropped is not applied twice
+	idempotencyKey := utils.UUIDv4()
+
+	for attempt := 0; ; attempt++ {
+		_, err := p.cb.Execute(func() (interface{}, error) {
+			req, err := request()
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set(headerIdempotencyKey, idempotencyKey)
+
+			if err := p.send(ctx, result, req); err != nil {
+				if ctx.Err() != nil {
+					return nil, &callerDoneError{err}
+				}
+				return nil, err
+			}
+			return nil, nil
+		})
+		var dErr *callerDoneError
+		if errors.As(err, &dErr) {
+			return dErr.err
+		}
+		if err == gobreaker.ErrOpenState || err == gobreaker.ErrTooManyRequests {
+			return ErrUnavailable
+		}
+		if err == nil || attempt >= p.retry.maxRetries || ctx.Err() != nil || !policy(err) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(p.retry.delay(attempt)):
+		}
+	}
+}
+
+// send works like omise.Client.Do but binds the request to ctx
+func (p *provider) send(ctx context.Context, result interface{}, req *http.Request) error {
+	resp, err := p.oc.Client.Do(req.WithContext(ctx))
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		return err
+	}
+
+	buffer, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return &omise.ErrTransport{Err: err, Buffer: buffer}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		oErr := &omise.Error{StatusCode: resp.StatusCode}
+		if err := json.Unmarshal(buffer, oErr); err != nil {
+			return &omise.ErrTransport{Err: err, Buffer: buffer}
+		}
+
+		return oErr
+	}
+
+	if result != nil {
+		if err := json.Unmarshal(buffer, result); err != nil {
+			return &omise.ErrTransport{Err: err, Buffer: buffer}
+		}
+	}
+
+	return nil
+}
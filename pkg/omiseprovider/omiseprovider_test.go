@@ -0,0 +1,381 @@
+package omiseprovider
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/omise/omise-go"
+	"github.com/omise/omise-go/operations"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeOmise answers with the given handlers in order, repeating the last one
+type fakeOmise struct {
+	mu              sync.Mutex
+	handlers        []http.HandlerFunc
+	calls           int
+	idempotencyKeys []string
+	// Handlers still running, calls the caller gave up on included
+	inflight int
+	idle     *sync.Cond
+}
+
+func (f *fakeOmise) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		f.mu.Lock()
+		f.inflight--
+		f.cond().Broadcast()
+		f.mu.Unlock()
+	}()
+
+	f.mu.Lock()
+	f.inflight++
+	h := f.handlers[len(f.handlers)-1]
+	if f.calls < len(f.handlers) {
+		h = f.handlers[f.calls]
+	}
+	f.calls++
+	f.idempotencyKeys = append(f.idempotencyKeys, r.Header.Get(headerIdempotencyKey))
+	f.mu.Unlock()
+
+	h(w, r)
+}
+
+// snapshot returns the number of calls and their idempotency keys so far
+func (f *fakeOmise) snapshot() (int, []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.calls, append([]string(nil), f.idempotencyKeys...)
+}
+
+// wait waits for the handlers still running to return
+func (f *fakeOmise) wait() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for f.inflight > 0 {
+		f.cond().Wait()
+	}
+}
+
+// cond returns the condition signaled when a handler returns, f.mu must be held
+func (f *fakeOmise) cond() *sync.Cond {
+	if f.idle == nil {
+		f.idle = sync.NewCond(&f.mu)
+	}
+
+	return f.idle
+}
+
+func respond(status int, body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(body))
+	}
+}
+
+func dropConnection(w http.ResponseWriter, r *http.Request) {
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err == nil {
+		conn.Close()
+	}
+}
+
+func newTestProvider(t *testing.T, f *fakeOmise, opts ...Option) *provider {
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+
+	oc, err := omise.NewClient("pkey_test_xxx", "skey_test_xxx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	oc.Endpoints[EndpointAPI] = srv.URL
+
+	opts = append([]Option{WithRetry(2, time.Millisecond)}, opts...)
+	return New(oc, opts...)
+}
+
+const (
+	chargeBody = `{"object":"charge","id":"chrg_test_xxx","status":"pending"}`
+	sourceBody = `{"object":"source","id":"src_test_xxx"}`
+)
+
+func TestRetry(t *testing.T) {
+	ctx := context.Background()
+
+	testCases := []struct {
+		name          string
+		handlers      []http.HandlerFunc
+		call          func(p *provider) error
+		expectedCalls int
+		expectedError bool
+	}{
+		{
+			name: "Read retried on server error",
+			handlers: []http.HandlerFunc{
+				respond(http.StatusInternalServerError, `{"object":"error","code":"internal_error"}`),
+				respond(http.StatusOK, chargeBody),
+			},
+			call: func(p *provider) error {
+				_, err := p.RetrieveCharge(ctx, operations.RetrieveCharge{ChargeID: "chrg_test_xxx"})
+				return err
+			},
+			expectedCalls: 2,
+		},
+		{
+			name: "Read not retried on client error",
+			handlers: []http.HandlerFunc{
+				respond(http.StatusNotFound, `{"object":"error","code":"not_found"}`),
+			},
+			call: func(p *provider) error {
+				_, err := p.RetrieveCharge(ctx, operations.RetrieveCharge{ChargeID: "chrg_test_xxx"})
+				return err
+			},
+			expectedCalls: 1,
+			expectedError: true,
+		},
+		{
+			name: "Create retried on network error",
+			handlers: []http.HandlerFunc{
+				dropConnection,
+				respond(http.StatusOK, sourceBody),
+			},
+			call: func(p *provider) error {
+				_, err := p.CreateSource(ctx, operations.CreateSource{Amount: 2000, Currency: "thb"})
+				return err
+			},
+			expectedCalls: 2,
+		},
+		{
+			name: "Create not retried on server error",
+			handlers: []http.HandlerFunc{
+				respond(http.StatusInternalServerError, `{"object":"error","code":"internal_error"}`),
+			},
+			call: func(p *provider) error {
+				_, err := p.CreateCharge(ctx, CreateCharge{CreateCharge: operations.CreateCharge{Amount: 2000, Currency: "thb"}})
+				return err
+			},
+			expectedCalls: 1,
+			expectedError: true,
+		},
+		{
+			name: "Gives up after max retries",
+			handlers: []http.HandlerFunc{
+				dropConnection,
+			},
+			call: func(p *provider) error {
+				_, err := p.CreateSource(ctx, operations.CreateSource{Amount: 2000, Currency: "thb"})
+				return err
+			},
+			expectedCalls: 3,
+			expectedError: true,
+		},
+	}
+
+	t.Parallel()
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			f := &fakeOmise{handlers: tc.handlers}
+			p := newTestProvider(t, f)
+
+			err := tc.call(p)
+
+			assert.Equal(t, tc.expectedError, err != nil)
+			f.wait()
+			calls, keys := f.snapshot()
+			assert.Equal(t, tc.expectedCalls, calls)
+
+			// Every attempt of a call shares the idempotency key
+			for _, key := range keys {
+				assert.Equal(t, keys[0], key)
+			}
+		})
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	ctx := context.Background()
+
+	f := &fakeOmise{handlers: []http.HandlerFunc{
+		respond(http.StatusServiceUnavailable, `{"object":"error","code":"service_unavailable"}`),
+	}}
+	p := newTestProvider(t, f, WithRetry(0, 0), WithCircuitBreaker(2, time.Minute))
+
+	for i := 0; i < 2; i++ {
+		_, err := p.RetrieveCharge(ctx, operations.RetrieveCharge{ChargeID: "chrg_test_xxx"})
+		assert.IsType(t, &omise.Error{}, err)
+	}
+
+	_, err := p.RetrieveCharge(ctx, operations.RetrieveCharge{ChargeID: "chrg_test_xxx"})
+
+	assert.Equal(t, ErrUnavailable, err)
+	calls, _ := f.snapshot()
+	assert.Equal(t, 2, calls)
+}
+
+func TestCallerGaveUp(t *testing.T) {
+	f := &fakeOmise{handlers: []http.HandlerFunc{
+		func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(100 * time.Millisecond)
+		},
+	}}
+	p := newTestProvider(t, f, WithRetry(2, time.Millisecond), WithCircuitBreaker(1, time.Minute))
+
+	// Neither retried nor counted against Omise
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := p.RetrieveCharge(ctx, operations.RetrieveCharge{ChargeID: "chrg_test_xxx"})
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), err)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	_, err = p.RetrieveCharge(ctx, operations.RetrieveCharge{ChargeID: "chrg_test_xxx"})
+	assert.True(t, errors.Is(err, context.Canceled), err)
+	assert.False(t, isNetworkError(err))
+
+	// The sleeping handlers still run after the caller gave up
+	f.wait()
+	calls, _ := f.snapshot()
+	assert.Equal(t, 2, calls)
+	assert.NotEqual(t, ErrUnavailable, err)
+}
+
+func TestTimeout(t *testing.T) {
+	f := &fakeOmise{handlers: []http.HandlerFunc{
+		func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(100 * time.Millisecond)
+		},
+	}}
+	p := newTestProvider(t, f, WithRetry(0, 0), WithTimeout(10*time.Millisecond))
+
+	_, err := p.RetrieveCharge(context.Background(), operations.RetrieveCharge{ChargeID: "chrg_test_xxx"})
+
+	assert.True(t, isNetworkError(err))
+}
+
+func TestCreateCharge(t *testing.T) {
+	expiresAt := time.Date(2026, 10, 19, 9, 10, 0, 0, time.UTC)
+	testCases := []struct {
+		name         string
+		expiresAt    *time.Time
+		expectedBody string
+	}{
+		{
+			name:         "Without expiry",
+			expectedBody: `{"amount":2000,"currency":"thb","source":"src_test_xxx"}`,
+		},
+		{
+			name:         "With expiry",
+			expiresAt:    &expiresAt,
+			expectedBody: `{"amount":2000,"currency":"thb","source":"src_test_xxx","expires_at":"2026-10-19T09:10:00Z"}`,
+		},
+	}
+
+	t.Parallel()
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var body string
+			f := &fakeOmise{handlers: []http.HandlerFunc{
+				func(w http.ResponseWriter, r *http.Request) {
+					b, _ := ioutil.ReadAll(r.Body)
+					body = string(b)
+					respond(http.StatusOK, `{"object":"charge","id":"chrg_test_xxx","status":"pending"}`)(w, r)
+				},
+			}}
+			p := newTestProvider(t, f)
+
+			charge, err := p.CreateCharge(context.Background(), CreateCharge{
+				CreateCharge: operations.CreateCharge{Amount: 2000, Currency: "thb", Source: "src_test_xxx"},
+				ExpiresAt:    tc.expiresAt,
+			})
+
+			assert.NoError(t, err)
+			assert.Equal(t, "chrg_test_xxx", charge.ID)
+			assert.JSONEq(t, tc.expectedBody, body)
+		})
+	}
+}
+
+func TestCaptureCharge(t *testing.T) {
+	testCases := []struct {
+		name         string
+		amount       int64
+		expectedBody string
+	}{
+		{
+			name:         "Full",
+			expectedBody: `{}`,
+		},
+		{
+			name:         "Partial",
+			amount:       1500,
+			expectedBody: `{"capture_amount":1500}`,
+		},
+	}
+
+	t.Parallel()
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var path, body string
+			f := &fakeOmise{handlers: []http.HandlerFunc{
+				func(w http.ResponseWriter, r *http.Request) {
+					b, _ := ioutil.ReadAll(r.Body)
+					path, body = r.URL.Path, string(b)
+					respond(http.StatusOK, `{"object":"charge","id":"chrg_test_xxx","status":"successful","paid":true}`)(w, r)
+				},
+			}}
+			p := newTestProvider(t, f)
+
+			charge, err := p.CaptureCharge(context.Background(), CaptureCharge{
+				CaptureCharge: operations.CaptureCharge{ChargeID: "chrg_test_xxx"},
+				CaptureAmount: tc.amount,
+			})
+
+			assert.NoError(t, err)
+			assert.Equal(t, omise.ChargeSuccessful, charge.Status)
+			assert.Equal(t, "/charges/chrg_test_xxx/capture", path)
+			assert.JSONEq(t, tc.expectedBody, body)
+		})
+	}
+}
+
+func TestCreateBillPaymentCharge(t *testing.T) {
+	f := &fakeOmise{handlers: []http.HandlerFunc{
+		respond(http.StatusOK, `{
+			"object": "charge",
+			"id": "chrg_test_xxx",
+			"status": "pending",
+			"source": {
+				"object": "source",
+				"id": "src_test_xxx",
+				"type": "bill_payment_tesco_lotus",
+				"references": {
+					"expires_at": "2026-10-20T09:00:00Z",
+					"reference_number_1": "062111123456789",
+					"reference_number_2": "59201700000000",
+					"barcode": "|010554614953100\r062111123456789\r59201700000000\r2000000",
+					"omise_tax_id": "0105546149531"
+				}
+			}
+		}`),
+	}}
+	p := newTestProvider(t, f)
+
+	charge, err := p.CreateBillPaymentCharge(context.Background(), operations.CreateCharge{Amount: 2000000, Currency: "thb", Source: "src_test_xxx"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "chrg_test_xxx", charge.ID)
+	if assert.NotNil(t, charge.Source) && assert.NotNil(t, charge.Source.References) {
+		assert.Equal(t, "src_test_xxx", charge.Source.ID)
+		assert.Equal(t, "062111123456789", charge.Source.References.ReferenceNumber1)
+		assert.Equal(t, "|010554614953100\r062111123456789\r59201700000000\r2000000", charge.Source.References.Barcode)
+		assert.Equal(t, time.Date(2026, 10, 20, 9, 0, 0, 0, time.UTC), charge.Source.References.ExpiresAt)
+	}
+}
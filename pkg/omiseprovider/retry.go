@@ -0,0 +1,84 @@
+package omiseprovider
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/omise/omise-go"
+)
+
+// retryPolicy reports whether a call that failed with err may be sent again
+type retryPolicy func(err error) bool
+
+// retryRead retries idempotent reads on network errors and server errors
+func retryRead(err error) bool {
+	return isNetworkError(err) || isServerError(err)
+}
+
+// retryCreate retries creates on network errors only, they are sent with an
+// idempotency key so Omise does not apply them twice
+func retryCreate(err error) bool {
+	return isNetworkError(err)
+}
+
+type retryConfig struct {
+	maxRetries int
+	baseDelay  time.Duration
+	maxDelay   time.Duration
+}
+
+// delay is an exponential backoff with full jitter
+func (r retryConfig) delay(attempt int) time.Duration {
+	backoff := r.baseDelay << uint(attempt)
+	if backoff <= 0 || (r.maxDelay > 0 && backoff > r.maxDelay) {
+		backoff = r.maxDelay
+	}
+	if backoff <= 0 {
+		return 0
+	}
+
+	return time.Duration(rand.Int63n(int64(backoff)))
+}
+
+// callerDoneError is the error of a call whose context was done, the caller gave up on it so it
+// says nothing about Omise
+type callerDoneError struct {
+	err error
+}
+
+func (e *callerDoneError) Error() string {
+	return e.err.Error()
+}
+
+func (e *callerDoneError) Unwrap() error {
+	return e.err
+}
+
+func isNetworkError(err error) bool {
+	var dErr *callerDoneError
+	if errors.Is(err, context.Canceled) || errors.As(err, &dErr) {
+		return false
+	}
+
+	var uErr *url.Error
+	if errors.As(err, &uErr) {
+		return true
+	}
+
+	var nErr net.Error
+	return errors.As(err, &nErr)
+}
+
+func isServerError(err error) bool {
+	var oErr *omise.Error
+	if !errors.As(err, &oErr) {
+		return false
+	}
+
+	return oErr.StatusCode >= http.StatusInternalServerError || oErr.StatusCode == http.StatusTooManyRequests
+}
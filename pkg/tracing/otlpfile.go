@@ -0,0 +1,45 @@
+package tracing
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// fileClient writes OTLP export requests to w as JSON lines,
+// the same format the collector file exporter produces
+type fileClient struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func newFileClient(w io.Writer) *fileClient {
+	return &fileClient{w: w}
+}
+
+func (c *fileClient) Start(ctx context.Context) error {
+	return nil
+}
+
+func (c *fileClient) Stop(ctx context.Context) error {
+	return nil
+}
+
+func (c *fileClient) UploadTraces(ctx context.Context, protoSpans []*tracepb.ResourceSpans) error {
+	b, err := protojson.Marshal(&coltracepb.ExportTraceServiceRequest{
+		ResourceSpans: protoSpans,
+	})
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, err = c.w.Write(append(b, '\n'))
+	return err
+}
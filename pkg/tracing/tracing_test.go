@@ -0,0 +1,80 @@
+package tracing
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+func TestSetup(t *testing.T) {
+	ctx := context.Background()
+
+	testCases := []struct {
+		name     string
+		exporter string
+		contains string
+	}{
+		{
+			name:     "OTLP",
+			exporter: ExporterOTLP,
+			contains: `"resourceSpans"`,
+		},
+		{
+			name:     "JSON",
+			exporter: ExporterJSON,
+			contains: `"SpanContext"`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "traces.json")
+
+			shutdown, err := Setup(ctx, Config{
+				ServiceName: "test",
+				Exporter:    tc.exporter,
+				File:        file,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Continue a trace received from upstream
+			parent := otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier{
+				"Traceparent": []string{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+			})
+			_, span := otel.Tracer("test").Start(parent, "test-span")
+			span.End()
+
+			assert.NoError(t, shutdown(ctx))
+
+			b, err := ioutil.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Contains(t, string(b), tc.contains)
+			assert.Contains(t, string(b), "test-span")
+			if tc.exporter == ExporterOTLP {
+				// Trace IDs are base64 encoded in OTLP JSON
+				assert.Contains(t, string(b), "S/kvNXezTaajzpKdDg5HNg==")
+			} else {
+				assert.Contains(t, string(b), "4bf92f3577b34da6a3ce929d0e0e4736")
+			}
+		})
+	}
+}
+
+func TestSetupUnknownExporter(t *testing.T) {
+	_, err := Setup(context.Background(), Config{
+		Exporter: "zipkin",
+		File:     filepath.Join(t.TempDir(), "traces.json"),
+	})
+
+	assert.Equal(t, ErrUnknownExporter, err)
+}
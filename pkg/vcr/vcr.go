@@ -0,0 +1,299 @@
+// Package vcr records HTTP interactions to cassette files and replays them,
+// so tests against an external API can run offline
+package vcr
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ErrNoInteraction is returned in replay mode when no recorded interaction matches a request
+var ErrNoInteraction = errors.New("vcr: no recorded interaction matches the request")
+
+type Mode int
+
+const (
+	// ModeReplay answers from the cassette and never calls the network
+	ModeReplay Mode = iota
+	// ModeRecord sends requests to the network and saves them to the cassette
+	ModeRecord
+)
+
+// Headers kept in cassettes, credentials and per-call values such as idempotency keys are left out
+var (
+	requestHeaders  = []string{"Content-Type", "Omise-Version"}
+	responseHeaders = []string{"Content-Type"}
+)
+
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+type Interaction struct {
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+}
+
+// Request is matched on method, path with query and body, JSON bodies are compared semantically
+type Request struct {
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    json.RawMessage   `json:"body,omitempty"`
+}
+
+type Response struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    json.RawMessage   `json:"body,omitempty"`
+}
+
+// Recorder is an http.RoundTripper that records to or replays from a cassette file
+type Recorder struct {
+	path string
+	mode Mode
+	next http.RoundTripper
+
+	mu       sync.Mutex
+	cassette Cassette
+	used     []bool
+}
+
+// New loads the cassette at path in replay mode, in record mode requests go through next,
+// http.DefaultTransport when nil
+func New(path string, mode Mode, next http.RoundTripper) (*Recorder, error) {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	r := &Recorder{path: path, mode: mode, next: next}
+
+	if mode == ModeReplay {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(b, &r.cassette); err != nil {
+			return nil, fmt.Errorf("vcr: %s: %w", path, err)
+		}
+		r.used = make([]bool, len(r.cassette.Interactions))
+	}
+
+	return r, nil
+}
+
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	recorded := Request{
+		Method:  req.Method,
+		URL:     req.URL.RequestURI(),
+		Headers: pickHeaders(req.Header, requestHeaders),
+		Body:    encodeBody(body),
+	}
+
+	if r.mode == ModeRecord {
+		return r.record(req, recorded)
+	}
+
+	return r.replay(req, recorded)
+}
+
+func (r *Recorder) record(req *http.Request, recorded Request) (*http.Response, error) {
+	resp, err := r.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	r.cassette.Interactions = append(r.cassette.Interactions, Interaction{
+		Request: recorded,
+		Response: Response{
+			Status:  resp.StatusCode,
+			Headers: pickHeaders(resp.Header, responseHeaders),
+			Body:    encodeBody(body),
+		},
+	})
+	r.mu.Unlock()
+
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
+
+// replay answers with the first unused interaction matching the request, so repeated
+// identical requests get the recorded responses in order
+func (r *Recorder) replay(req *http.Request, recorded Request) (*http.Response, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, in := range r.cassette.Interactions {
+		if r.used[i] || !matches(in.Request, recorded) {
+			continue
+		}
+		r.used[i] = true
+
+		header := http.Header{}
+		for k, v := range in.Response.Headers {
+			header.Set(k, v)
+		}
+		body := decodeBody(in.Response.Body)
+
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", in.Response.Status, http.StatusText(in.Response.Status)),
+			StatusCode:    in.Response.Status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        header,
+			Body:          ioutil.NopCloser(bytes.NewReader(body)),
+			ContentLength: int64(len(body)),
+			Request:       req,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("%w: %s %s %s", ErrNoInteraction, recorded.Method, recorded.URL, decodeBody(recorded.Body))
+}
+
+// Unused returns the recorded interactions no request matched in replay mode
+func (r *Recorder) Unused() []Interaction {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var unused []Interaction
+	for i, in := range r.cassette.Interactions {
+		if !r.used[i] {
+			unused = append(unused, in)
+		}
+	}
+
+	return unused
+}
+
+// Save writes the recorded interactions to the cassette file, it does nothing in replay mode
+func (r *Recorder) Save() error {
+	if r.mode != ModeRecord {
+		return nil
+	}
+
+	r.mu.Lock()
+	b, err := json.MarshalIndent(r.cassette, "", "  ")
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(r.path), 0755); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(r.path, append(b, '\n'), 0644)
+}
+
+func matches(recorded Request, req Request) bool {
+	if recorded.Method != req.Method || recorded.URL != req.URL {
+		return false
+	}
+
+	return bodyEqual(decodeBody(recorded.Body), decodeBody(req.Body))
+}
+
+func bodyEqual(a []byte, b []byte) bool {
+	var va, vb interface{}
+	if json.Unmarshal(a, &va) == nil && json.Unmarshal(b, &vb) == nil {
+		ja, _ := json.Marshal(va)
+		jb, _ := json.Marshal(vb)
+		return bytes.Equal(ja, jb)
+	}
+
+	return bytes.Equal(bytes.TrimSpace(a), bytes.TrimSpace(b))
+}
+
+func readBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil {
+		return nil, nil
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	req.Body.Close()
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	return body, nil
+}
+
+// encodeBody keeps JSON bodies as is so cassettes stay readable, anything else is stored as a JSON string
+func encodeBody(body []byte) json.RawMessage {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 {
+		return nil
+	}
+
+	if json.Valid(body) {
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, body); err == nil {
+			return buf.Bytes()
+		}
+	}
+
+	s, _ := json.Marshal(string(body))
+	return s
+}
+
+func decodeBody(raw json.RawMessage) []byte {
+	if len(raw) == 0 {
+		return nil
+	}
+
+	if raw[0] == '"' {
+		var s string
+		if err := json.Unmarshal(raw, &s); err == nil {
+			return []byte(s)
+		}
+	}
+
+	return raw
+}
+
+func pickHeaders(h http.Header, keys []string) map[string]string {
+	picked := map[string]string{}
+	for _, k := range keys {
+		if v := h.Get(k); v != "" {
+			picked[k] = v
+		}
+	}
+
+	if len(picked) == 0 {
+		return nil
+	}
+
+	return picked
+}
+
+// ModeFromEnv returns ModeRecord when the variable is set to a true value, e.g. VCR_RECORD=1
+func ModeFromEnv(name string) Mode {
+	switch strings.ToLower(os.Getenv(name)) {
+	case "1", "true", "yes":
+		return ModeRecord
+	default:
+		return ModeReplay
+	}
+}
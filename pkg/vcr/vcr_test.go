@@ -0,0 +1,125 @@
+package vcr
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func post(t *testing.T, client *http.Client, url string, body string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth("skey_test_xxx", "")
+
+	return client.Do(req)
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"object":"charge","id":"chrg_test_xxx"}`))
+	}))
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "cassette.json")
+
+	// Record
+	rec, err := New(path, ModeRecord, nil)
+	assert.NoError(t, err)
+
+	resp, err := post(t, &http.Client{Transport: rec}, srv.URL+"/charges", `{"amount": 2000, "currency": "thb"}`)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.NoError(t, rec.Save())
+
+	cassette, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(cassette), "Authorization")
+
+	// Replay, the server is never called and the JSON body matches whatever its key order
+	srv.Close()
+	rep, err := New(path, ModeReplay, nil)
+	assert.NoError(t, err)
+	client := &http.Client{Transport: rep}
+
+	resp, err = post(t, client, srv.URL+"/charges", `{"currency":"thb","amount":2000}`)
+	if assert.NoError(t, err) {
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+		assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+		assert.JSONEq(t, `{"object":"charge","id":"chrg_test_xxx"}`, string(body))
+	}
+	assert.Equal(t, 1, calls)
+	assert.Empty(t, rep.Unused())
+
+	// Each interaction is replayed once
+	_, err = post(t, client, srv.URL+"/charges", `{"currency":"thb","amount":2000}`)
+	assert.True(t, errors.Is(err, ErrNoInteraction))
+}
+
+func TestReplayMismatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	assert.NoError(t, ioutil.WriteFile(path, []byte(`{
+  "interactions": [
+    {
+      "request": {"method": "POST", "url": "/charges", "body": {"amount": 2000}},
+      "response": {"status": 200, "body": {"object": "charge"}}
+    }
+  ]
+}`), 0644))
+
+	testCases := []struct {
+		name   string
+		method string
+		url    string
+		body   string
+	}{
+		{
+			name:   "Different body",
+			method: http.MethodPost,
+			url:    "http://api.test/charges",
+			body:   `{"amount":3000}`,
+		},
+		{
+			name:   "Different path",
+			method: http.MethodPost,
+			url:    "http://api.test/sources",
+			body:   `{"amount":2000}`,
+		},
+		{
+			name:   "Different method",
+			method: http.MethodPut,
+			url:    "http://api.test/charges",
+			body:   `{"amount":2000}`,
+		},
+	}
+
+	t.Parallel()
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rep, err := New(path, ModeReplay, nil)
+			assert.NoError(t, err)
+
+			req, _ := http.NewRequest(tc.method, tc.url, strings.NewReader(tc.body))
+			_, err = rep.RoundTrip(req)
+
+			assert.True(t, errors.Is(err, ErrNoInteraction))
+			assert.Len(t, rep.Unused(), 1)
+		})
+	}
+}